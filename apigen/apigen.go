@@ -0,0 +1,356 @@
+// Package apigen turns api/api.yaml into the route tables of the lambda
+// package and the serverless functions which route to them.  api.yaml is
+// checked against the package first, so that a route which would collide,
+// name a missing handler or use a path parameter it doesn't have fails here
+// rather than at compile time or, worse, at run time.
+package apigen
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Spec is api.yaml
+type Spec struct {
+	Public  Section `yaml:"public_endpoints"`
+	Private Section `yaml:"private_endpoints"`
+}
+
+// Section is the routes of one lambda function
+type Section struct {
+	Handler    string     `yaml:"handler"`
+	Authorizer string     `yaml:"authorizer"`
+	Endpoints  []Endpoint `yaml:"endpoints"`
+}
+
+type Endpoint struct {
+	// the handler func
+	Func   string `yaml:"endpoint"`
+	Method string `yaml:"method"`
+	Path   string `yaml:"path"`
+
+	// the API key right the route needs.  Keys can't use routes without one.
+	Right string `yaml:"right"`
+
+	// schema of a 200 response, opResult if empty
+	Response string `yaml:"response"`
+
+	// every path parameter, and the query and body parameters the handler
+	// takes.  With any, the handler gets them parsed as a struct.
+	Params []Param `yaml:"params"`
+
+	// a private route API gateway lets through without a JWT
+	NoAuthorizer bool `yaml:"no_authorizer"`
+
+	// the uuid path parameter of the one counter the route is about, which
+	// keys scoped to counters are checked against
+	Counter string `yaml:"counter"`
+}
+
+type Param struct {
+	Name string `yaml:"name"`
+
+	// path, query or body, a field of a JSON object.  A parameter in the
+	// path is a path parameter, any other is query unless it says.
+	In string `yaml:"in"`
+
+	Type     string `yaml:"type"`
+	Required bool   `yaml:"required"`
+
+	// a comma separated list in a path or query, an array in the body
+	List bool `yaml:"list"`
+
+	// limits of an integer, or of a string's length
+	Min *int `yaml:"min"`
+	Max *int `yaml:"max"`
+
+	// what an enum can be
+	Values []string `yaml:"values"`
+
+	Description string `yaml:"description"`
+}
+
+var (
+	methods    = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "ANY"}
+	paramTypes = []string{"string", "integer", "uuid", "enum"}
+	paramIns   = []string{"path", "query", "body"}
+)
+
+// the route's key in the handler maps, which is also API gateway's route key
+func (e Endpoint) Route() string {
+	return e.Method + " " + e.Path
+}
+
+// the names of the path's {parameters}, with the + of a greedy one removed
+func (e Endpoint) PathParams() []string {
+	var params []string
+
+	for _, seg := range strings.Split(e.Path, "/") {
+		if name, isParam := strings.CutPrefix(seg, "{"); isParam {
+			params = append(params, strings.TrimSuffix(strings.TrimSuffix(name, "}"), "+"))
+		}
+	}
+
+	return params
+}
+
+// true if the counter parameter is a uuid in the path
+func (e Endpoint) counter_param() bool {
+	for _, p := range e.Params {
+		if p.Name == e.Counter {
+			return p.Type == "uuid" && slices.Contains(e.PathParams(), p.Name)
+		}
+	}
+
+	return false
+}
+
+// Load reads api.yaml.  Keys it doesn't know are an error, so a misspelt
+// no_authorizer isn't quietly dropped.
+func Load(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+
+	var spec Spec
+
+	if err := dec.Decode(&spec); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for _, section := range []*Section{&spec.Public, &spec.Private} {
+		for i := range section.Endpoints {
+			for j := range section.Endpoints[i].Params {
+				section.Endpoints[i].Params[j].set_in(section.Endpoints[i])
+			}
+		}
+	}
+
+	return &spec, nil
+}
+
+func (p *Param) set_in(e Endpoint) {
+	if p.In == "" && slices.Contains(e.PathParams(), p.Name) {
+		p.In = "path"
+	} else if p.In == "" {
+		p.In = "query"
+	}
+
+	// there is no route without them
+	if p.In == "path" {
+		p.Required = true
+	}
+}
+
+// Validate checks the spec on its own and against pkg, the package the
+// handlers are in.  It gives back every problem, not just the first.
+func (spec *Spec) Validate(pkg *Package) error {
+	var errs []error
+
+	fail := func(e Endpoint, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s (%s): %s", e.Route(), e.Func, fmt.Sprintf(format, args...)))
+	}
+
+	routes := map[string]bool{}
+
+	// handlers with more than one route get the same parameters from each
+	funcParams := map[string]Endpoint{}
+
+	for _, section := range []struct {
+		name    string
+		params  int
+		private bool
+		Section
+	}{{"public_endpoints", 3, false, spec.Public}, {"private_endpoints", 4, true, spec.Private}} {
+		if section.Handler == "" {
+			errs = append(errs, fmt.Errorf("%s has no handler", section.name))
+		}
+
+		for _, e := range section.Endpoints {
+			if routes[e.Route()] {
+				fail(e, "route is in api.yaml more than once")
+			}
+
+			routes[e.Route()] = true
+
+			if !slices.Contains(methods, e.Method) {
+				fail(e, "method must be one of %s", strings.Join(methods, ", "))
+			}
+
+			for _, err := range check_path(e) {
+				fail(e, "%s", err)
+			}
+
+			for _, err := range check_params(e) {
+				fail(e, "%s", err)
+			}
+
+			if other, seen := funcParams[e.Func]; seen && !reflect.DeepEqual(other.Params, e.Params) {
+				fail(e, "parameters aren't the same as %s's", other.Route())
+			}
+
+			funcParams[e.Func] = e
+
+			if e.NoAuthorizer && !section.private {
+				fail(e, "no_authorizer is only for private endpoints")
+			}
+
+			if e.Right != "" && !section.private {
+				fail(e, "public endpoints can't have a right")
+			}
+
+			if e.Counter != "" && !e.counter_param() {
+				fail(e, "counter %s isn't a uuid path parameter", e.Counter)
+			}
+
+			if e.Counter != "" && e.Right == "" {
+				fail(e, "counter is only for routes with a right")
+			}
+
+			if pkg == nil {
+				continue
+			}
+
+			h, found := pkg.Handlers[e.Func]
+
+			if !found {
+				fail(e, "there is no handler func %s", e.Func)
+				continue
+			}
+
+			if want := section.params + min(len(e.Params), 1); h.Params != want {
+				fail(e, "%s takes %d parameters, not %d", e.Func, h.Params, want)
+			}
+
+			for _, p := range h.PathParams {
+				if !slices.Contains(e.PathParams(), p) {
+					fail(e, "%s uses path parameter %s, which isn't in the path", e.Func, p)
+				}
+			}
+
+			if e.Right != "" && !slices.Contains(pkg.Rights, e.Right) {
+				fail(e, "right %s isn't one of %s", e.Right, strings.Join(pkg.Rights, ", "))
+			}
+
+			if e.Response != "" && !slices.Contains(pkg.Schemas, strings.TrimPrefix(e.Response, "[]")) {
+				fail(e, "response %s isn't in api_schemas", e.Response)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// {name} parameters, each a whole segment and only once.  A greedy {name+}
+// has to be the last.
+func check_path(e Endpoint) []string {
+	var errs []string
+
+	if !strings.HasPrefix(e.Path, "/") {
+		errs = append(errs, "path must start with /")
+	}
+
+	segs := strings.Split(e.Path, "/")
+	seen := map[string]bool{}
+
+	for i, seg := range segs {
+		inner, isParam := strings.CutPrefix(seg, "{")
+
+		if !isParam {
+			if strings.ContainsAny(seg, "{}") {
+				errs = append(errs, fmt.Sprintf("segment %s has a parameter in part of it", seg))
+			}
+			continue
+		}
+
+		name, closed := strings.CutSuffix(inner, "}")
+		name, greedy := strings.CutSuffix(name, "+")
+
+		switch {
+		case !closed || name == "" || strings.ContainsAny(name, "{}+"):
+			errs = append(errs, fmt.Sprintf("segment %s isn't a {parameter}", seg))
+		case seen[name]:
+			errs = append(errs, fmt.Sprintf("path parameter %s is in the path more than once", name))
+		case greedy && i != len(segs)-1:
+			errs = append(errs, fmt.Sprintf("greedy parameter %s isn't at the end", name))
+		}
+
+		seen[name] = true
+	}
+
+	return errs
+}
+
+// every path parameter declared, and each parameter's type with what goes with it
+func check_params(e Endpoint) []string {
+	var errs []string
+
+	seen := map[string]bool{}
+	fields := map[string]bool{}
+
+	for _, p := range e.Params {
+		switch {
+		case p.Name == "":
+			errs = append(errs, "parameter without a name")
+		case seen[p.Name]:
+			errs = append(errs, fmt.Sprintf("parameter %s is there more than once", p.Name))
+		case fields[p.Field()]:
+			errs = append(errs, fmt.Sprintf("parameter %s is field %s, like another", p.Name, p.Field()))
+		}
+
+		seen[p.Name] = true
+		fields[p.Field()] = true
+
+		if !slices.Contains(paramIns, p.In) {
+			errs = append(errs, fmt.Sprintf("parameter %s is in %q, not one of %s", p.Name, p.In, strings.Join(paramIns, ", ")))
+		}
+
+		if p.In == "path" && !slices.Contains(e.PathParams(), p.Name) {
+			errs = append(errs, fmt.Sprintf("path parameter %s isn't in the path", p.Name))
+		}
+
+		if p.In != "path" && slices.Contains(e.PathParams(), p.Name) {
+			errs = append(errs, fmt.Sprintf("parameter %s is a path parameter too", p.Name))
+		}
+
+		if p.In == "body" && e.Method == "GET" {
+			errs = append(errs, fmt.Sprintf("body parameter %s on a GET", p.Name))
+		}
+
+		if !slices.Contains(paramTypes, p.Type) {
+			errs = append(errs, fmt.Sprintf("parameter %s has type %q, not one of %s", p.Name, p.Type, strings.Join(paramTypes, ", ")))
+		}
+
+		if (p.Type == "enum") != (len(p.Values) > 0) {
+			errs = append(errs, fmt.Sprintf("parameter %s needs values if, and only if, it is an enum", p.Name))
+		}
+
+		if (p.Min != nil || p.Max != nil) && p.Type != "integer" && p.Type != "string" {
+			errs = append(errs, fmt.Sprintf("parameter %s is a %s, which can't have a min or max", p.Name, p.Type))
+		}
+
+		if p.Min != nil && p.Max != nil && *p.Min > *p.Max {
+			errs = append(errs, fmt.Sprintf("parameter %s has min %d over max %d", p.Name, *p.Min, *p.Max))
+		}
+	}
+
+	for _, name := range e.PathParams() {
+		if !seen[name] {
+			errs = append(errs, fmt.Sprintf("path parameter %s has no type, it needs to be in params", name))
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,180 @@
+package apigen
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const handlersSource = `package main
+
+var perm_read = "read"
+
+var api_schemas = map[string]any{"CountData": CountData{}}
+
+func login(ctx context.Context, req Request, dbo DataOperator) (Response, error) { return Response{}, nil }
+
+func getCounter(ctx context.Context, req Request, dbo DataOperator, s Session, p getCounterParams) (Response, error) {
+	return dbo.CounterRead(ctx, s, req.PathParameters["id"])
+}
+`
+
+var one = 1
+
+func testPackage(t *testing.T) *Package {
+	dir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(dir, "handlers.go"), []byte(handlersSource), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// not valid Go, as it may not be while it is being regenerated
+	os.WriteFile(filepath.Join(dir, "api_handlers.go"), []byte("package main\nvar x = {"), 0644)
+
+	pkg, err := ScanPackage(dir, "api_handlers.go")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pkg
+}
+
+func spec(public []Endpoint, private []Endpoint) *Spec {
+	return &Spec{
+		Public:  Section{Handler: "apipublic", Endpoints: public},
+		Private: Section{Handler: "apiprivate", Authorizer: "APIAUTH", Endpoints: private},
+	}
+}
+
+var (
+	loginRoute   = Endpoint{Func: "login", Method: "GET", Path: "/login"}
+	counterRoute = Endpoint{Func: "getCounter", Method: "GET", Path: "/group/{group}/counter/{id}", Right: "read", Response: "CountData", Params: []Param{
+		{Name: "group", In: "path", Type: "uuid", Required: true},
+		{Name: "id", In: "path", Type: "uuid", Required: true},
+	}}
+)
+
+func TestScanPackage(t *testing.T) {
+	pkg := testPackage(t)
+
+	if h := pkg.Handlers["getCounter"]; h.Params != 5 || len(h.PathParams) != 1 || h.PathParams[0] != "id" {
+		t.Errorf("getCounter is %v", h)
+	}
+
+	if len(pkg.Rights) != 1 || pkg.Rights[0] != "read" || len(pkg.Schemas) != 1 || pkg.Schemas[0] != "CountData" {
+		t.Errorf("Package is %v", pkg)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	pkg := testPackage(t)
+
+	if err := spec([]Endpoint{loginRoute}, []Endpoint{counterRoute}).Validate(pkg); err != nil {
+		t.Fatal(err)
+	}
+
+	mistyped := counterRoute
+	mistyped.Func = "getCountr"
+
+	noId := counterRoute
+	noId.Path = "/group/{group}/counter/{name}"
+	noId.Params = []Param{counterRoute.Params[0], {Name: "name", In: "path", Type: "string", Required: true}}
+
+	badPath := counterRoute
+	badPath.Path = "/group/{group}/x{id}/{rest+}/{group}"
+
+	badParams := counterRoute
+	badParams.Params = append(slices.Clone(counterRoute.Params),
+		Param{Name: "id", In: "query", Type: "string"},
+		Param{Name: "n", In: "query", Type: "int"},
+		Param{Name: "N", In: "query", Type: "uuid", Min: &one},
+		Param{Name: "kind", In: "body", Type: "enum"},
+	)
+
+	undeclared := counterRoute
+	undeclared.Params = counterRoute.Params[:1]
+
+	sharedHandler := counterRoute
+	sharedHandler.Path = "/counter/{group}/{id}"
+	sharedHandler.Params = []Param{counterRoute.Params[1], counterRoute.Params[0]}
+
+	wrongSection := loginRoute
+	wrongSection.Method = "POST"
+
+	noRight := counterRoute
+	noRight.Path = "/other/{id}"
+	noRight.Right = "write"
+	noRight.Response = "[]Nope"
+
+	badCounter := counterRoute
+	badCounter.Path = "/counter/{group}/{id}/scoped"
+	badCounter.Counter = "group2"
+
+	unrightedCounter := counterRoute
+	unrightedCounter.Path = "/counter/{group}/{id}/unrighted"
+	unrightedCounter.Counter = "id"
+	unrightedCounter.Right = ""
+
+	for name, tc := range map[string]struct {
+		spec *Spec
+		errs []string
+	}{
+		"handler":    {spec([]Endpoint{loginRoute}, []Endpoint{mistyped}), []string{"no handler func getCountr"}},
+		"duplicate":  {spec([]Endpoint{loginRoute}, []Endpoint{counterRoute, loginRoute}), []string{"GET /login (login): route is in api.yaml more than once"}},
+		"path param": {spec(nil, []Endpoint{noId}), []string{"getCounter uses path parameter id, which isn't in the path"}},
+		"path":       {spec(nil, []Endpoint{badPath}), []string{"segment x{id}", "greedy parameter rest", "group is in the path more than once"}},
+		"params": {spec(nil, []Endpoint{badParams}), []string{"parameter id is there more than once", `parameter n has type "int"`,
+			"parameter N is field N, like another", "N is a uuid, which can't have a min", "kind needs values", "body parameter kind on a GET"}},
+		"undeclared": {spec(nil, []Endpoint{undeclared}), []string{"path parameter id has no type"}},
+		"shared":     {spec(nil, []Endpoint{counterRoute, sharedHandler}), []string{"parameters aren't the same as GET /group/{group}/counter/{id}'s"}},
+		"section":    {spec(nil, []Endpoint{wrongSection}), []string{"login takes 3 parameters, not 4"}},
+		"right":      {spec(nil, []Endpoint{noRight}), []string{"right write isn't one of read", "response []Nope isn't in api_schemas"}},
+		"counter":    {spec(nil, []Endpoint{badCounter, unrightedCounter}), []string{"counter group2 isn't a uuid path parameter", "counter is only for routes with a right"}},
+	} {
+		err := tc.spec.Validate(pkg)
+
+		if err == nil {
+			t.Errorf("%s passed", name)
+			continue
+		}
+
+		for _, want := range tc.errs {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s gave %q, without %q", name, err, want)
+			}
+		}
+	}
+}
+
+func TestLoadUnknownKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.yaml")
+
+	os.WriteFile(path, []byte("private_endpoints:\n  endpoints:\n  - endpoint: loop\n    no_authoriser: true\n"), 0644)
+
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "no_authoriser") {
+		t.Errorf("Load gave %v", err)
+	}
+}
+
+func TestServerlessYAML(t *testing.T) {
+	unauthorized := counterRoute
+	unauthorized.Path = "/open"
+	unauthorized.NoAuthorizer = true
+
+	sls, err := spec([]Endpoint{loginRoute}, []Endpoint{counterRoute, unauthorized}).ServerlessYAML("api/api.yaml")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Count(string(sls), "authorizer: APIAUTH"); got != 1 {
+		t.Errorf("%d routes have the authorizer in\n%s", got, sls)
+	}
+
+	if !strings.Contains(string(sls), "apipublic:\n    handler: apipublic\n    events:\n      - httpApi:\n          method: GET\n          path: /login\n") {
+		t.Errorf("Public functions are\n%s", sls)
+	}
+}
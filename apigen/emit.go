@@ -0,0 +1,319 @@
+package apigen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+var goTemplate = template.Must(template.New("go").Funcs(template.FuncMap{"quote": strconv.Quote}).Parse(`// Code generated by apigen from {{.Source}}. DO NOT EDIT.
+
+package main
+
+import (
+	"context"
+)
+
+var public_handlers = map[string]func(ctx context.Context, req Request, dbo DataOperator) (Response, error){
+{{- range .Public.Endpoints}}
+	{{quote .Route}}: {{.Handler "with_public_params"}},
+{{- end}}
+}
+
+var private_handlers = map[string]func(ctx context.Context, req Request, dbo DataOperator, s Session) (Response, error){
+{{- range .Private.Endpoints}}
+	{{quote .Route}}: {{.Handler "with_params"}},
+{{- end}}
+}
+
+// right an API key needs to use each private route.  Routes with no right can't be used with a key.
+var private_rights = map[string]string{
+{{- range .Private.Endpoints}}
+	{{quote .Route}}: {{quote .Right}},
+{{- end}}
+}
+
+// the path parameter with the counter of each route which is about one.
+// Keys scoped to counters can't use the others.
+var private_counters = map[string]string{
+{{- range .Private.Endpoints}}{{if .Counter}}
+	{{quote .Route}}: {{quote .Counter}},
+{{- end}}{{end}}
+}
+
+// every route with its parameters and response, for the OpenAPI document
+var api_routes = []apiRoute{
+{{- range .Public.Endpoints}}
+	{{template "route" .}}
+{{- end}}
+{{- range .Private.Endpoints}}
+	{{template "route" .}}
+{{- end}}
+}
+{{range .Parsers}}
+var {{.Func}}_params = []apiParam{
+{{- range .Params}}
+	{{.Literal}},
+{{- end}}
+}
+
+type {{.Func}}Params struct {
+{{- range .Params}}
+	{{.Field}} {{.GoType}}
+{{- end}}
+}
+
+func parse_{{.Func}}(req Request) ({{.Func}}Params, error) {
+	var p {{.Func}}Params
+	pr := new_param_reader(req)
+{{$fn := .Func}}{{range $i, $p := .Params}}
+	{{$p.Read $fn $i}}
+{{- end}}
+
+	return p, pr.err()
+}
+{{end}}
+
+{{- define "route"}}{method: {{quote .Method}}, path: {{quote .Path}}, endpoint: {{quote .Func}}, response: {{quote .Response}}, private: {{.Private}}, authorized: {{.Authorized}}, params: {{.ParamsVar}}},{{end}}
+`))
+
+// the Go name of the parameter, as a field of the handler's struct
+func (p Param) Field() string {
+	return strings.ToUpper(p.Name[:1]) + p.Name[1:]
+}
+
+// optional UUIDs and integers are pointers, nil if they aren't given
+func (p Param) GoType() string {
+	base := map[string]string{"uuid": "UUID", "integer": "int", "string": "string", "enum": "string"}[p.Type]
+
+	switch {
+	case p.List:
+		return "[]" + base
+	case !p.Required && (p.Type == "uuid" || p.Type == "integer"):
+		return "*" + base
+	}
+
+	return base
+}
+
+// the apiParam it is at run time
+func (p Param) Literal() string {
+	fields := []string{"name: " + strconv.Quote(p.Name), "in: " + strconv.Quote(p.In), "kind: " + strconv.Quote(p.Type)}
+
+	if p.Required {
+		fields = append(fields, "required: true")
+	}
+
+	if p.List {
+		fields = append(fields, "list: true")
+	}
+
+	if p.Min != nil {
+		fields = append(fields, fmt.Sprintf("min: limit(%d)", *p.Min))
+	}
+
+	if p.Max != nil {
+		fields = append(fields, fmt.Sprintf("max: limit(%d)", *p.Max))
+	}
+
+	if len(p.Values) > 0 {
+		var values []string
+
+		for _, v := range p.Values {
+			values = append(values, strconv.Quote(v))
+		}
+
+		fields = append(fields, "values: []string{"+strings.Join(values, ", ")+"}")
+	}
+
+	if p.Description != "" {
+		fields = append(fields, "description: "+strconv.Quote(p.Description))
+	}
+
+	return "{" + strings.Join(fields, ", ") + "}"
+}
+
+// the statement which reads the i'th parameter of fn into p
+func (p Param) Read(fn string, i int) string {
+	method := map[string]string{"uuid": "uuid", "integer": "integer", "string": "str", "enum": "str"}[p.Type]
+	spec := fmt.Sprintf("%s_params[%d]", fn, i)
+
+	switch {
+	case p.List:
+		return fmt.Sprintf("p.%s = pr.%ss(%s)", p.Field(), method, spec)
+	case strings.HasPrefix(p.GoType(), "*"):
+		return fmt.Sprintf("if v, found := pr.%s(%s); found {\n\t\tp.%s = &v\n\t}", method, spec, p.Field())
+	}
+
+	return fmt.Sprintf("p.%s, _ = pr.%s(%s)", p.Field(), method, spec)
+}
+
+var slsTemplate = template.Must(template.New("sls").Parse(`# Code generated by apigen from {{.Source}}. DO NOT EDIT.
+
+{{.Public.Handler}}:
+    handler: {{.Public.Handler}}
+    events:
+{{- range .Public.Endpoints}}
+      - httpApi:
+          method: {{.Method}}
+          path: {{.Path}}
+{{- end}}
+
+{{.Private.Handler}}:
+    handler: {{.Private.Handler}}
+    events:
+{{- range .Private.Endpoints}}
+      - httpApi:
+          method: {{.Method}}
+          path: {{.Path}}
+{{- if .Authorized}}
+          authorizer: {{$.Private.Authorizer}}
+{{- end}}
+{{- end}}
+`))
+
+// an endpoint with what its section says about it
+type route struct {
+	Endpoint
+	Private    bool
+	Authorized bool
+}
+
+// the handler func, or with parameters the adapter which parses them for it
+func (r route) Handler(adapter string) string {
+	if len(r.Params) == 0 {
+		return r.Func
+	}
+	return fmt.Sprintf("%s(parse_%s, %s)", adapter, r.Func, r.Func)
+}
+
+func (r route) ParamsVar() string {
+	if len(r.Params) == 0 {
+		return "nil"
+	}
+	return r.Func + "_params"
+}
+
+// a handler's parameters, once however many routes it has
+type paramParser struct {
+	Func   string
+	Params []Param
+}
+
+type section struct {
+	Handler    string
+	Authorizer string
+	Endpoints  []route
+}
+
+type templateData struct {
+	Source  string
+	Public  section
+	Private section
+	Parsers []paramParser
+}
+
+func (spec *Spec) template_data(source string) templateData {
+	td := templateData{
+		Source:  source,
+		Public:  section{Handler: spec.Public.Handler},
+		Private: section{Handler: spec.Private.Handler, Authorizer: spec.Private.Authorizer},
+	}
+
+	for _, e := range spec.Public.Endpoints {
+		td.Public.Endpoints = append(td.Public.Endpoints, route{Endpoint: e})
+	}
+
+	for _, e := range spec.Private.Endpoints {
+		td.Private.Endpoints = append(td.Private.Endpoints, route{Endpoint: e, Private: true, Authorized: !e.NoAuthorizer})
+	}
+
+	for _, e := range append(slices.Clone(spec.Public.Endpoints), spec.Private.Endpoints...) {
+		if len(e.Params) > 0 && !slices.ContainsFunc(td.Parsers, func(p paramParser) bool { return p.Func == e.Func }) {
+			td.Parsers = append(td.Parsers, paramParser{Func: e.Func, Params: e.Params})
+		}
+	}
+
+	return td
+}
+
+// GoSource is the route tables, gofmt'd.  source is the api.yaml it came
+// from, for the header.
+func (spec *Spec) GoSource(source string) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if err := goTemplate.Execute(&buf, spec.template_data(source)); err != nil {
+		return nil, err
+	}
+
+	src, err := format.Source(buf.Bytes())
+
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated Go: %w", err)
+	}
+
+	return src, nil
+}
+
+// ServerlessYAML is the functions block serverless.yaml includes
+func (spec *Spec) ServerlessYAML(source string) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if err := slsTemplate.Execute(&buf, spec.template_data(source)); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Generate checks the spec at apiPath against the package in pkgDir and
+// writes goOut, in pkgDir, and slsOut.  Nothing is written if there is a
+// problem with the spec.
+func Generate(apiPath string, pkgDir string, goOut string, slsOut string) error {
+	spec, err := Load(apiPath)
+
+	if err != nil {
+		return err
+	}
+
+	pkg, err := ScanPackage(pkgDir, goOut)
+
+	if err != nil {
+		return err
+	}
+
+	if err := spec.Validate(pkg); err != nil {
+		return fmt.Errorf("%s:\n%w", apiPath, err)
+	}
+
+	// api/api.yaml, wherever it is run from
+	source := filepath.ToSlash(filepath.Join(filepath.Base(filepath.Dir(apiPath)), filepath.Base(apiPath)))
+
+	src, err := spec.GoSource(source)
+
+	if err != nil {
+		return err
+	}
+
+	sls, err := spec.ServerlessYAML(source)
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(slsOut), 0755); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(filepath.Join(pkgDir, goOut), src, 0644); err != nil {
+		return err
+	}
+
+	return os.WriteFile(slsOut, sls, 0644)
+}
@@ -0,0 +1,151 @@
+package apigen
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Package is what the checks need to know about the handlers' package
+type Package struct {
+	Handlers map[string]Handler
+
+	// the values of the perm_* vars, which are the rights a key can have
+	Rights []string
+
+	// the keys of api_schemas, which are the responses a route can name
+	Schemas []string
+}
+
+type Handler struct {
+	Params int
+
+	// the req.PathParameters the handler reads
+	PathParams []string
+}
+
+// ScanPackage parses the Go files in dir, apart from tests and the files in
+// skip, which is for the generated one: it is about to be replaced and may
+// not be valid.
+func ScanPackage(dir string, skip ...string) (*Package, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	pkg := Package{Handlers: map[string]Handler{}}
+	fset := token.NewFileSet()
+
+	for _, path := range files {
+		if strings.HasSuffix(path, "_test.go") || slices.Contains(skip, filepath.Base(path)) {
+			continue
+		}
+
+		f, err := parser.ParseFile(fset, path, nil, 0)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, decl := range f.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if d.Recv == nil {
+					pkg.Handlers[d.Name.Name] = Handler{Params: param_count(d.Type), PathParams: path_params_used(d.Body)}
+				}
+			case *ast.GenDecl:
+				if d.Tok == token.VAR {
+					pkg.add_vars(d)
+				}
+			}
+		}
+	}
+
+	slices.Sort(pkg.Rights)
+	slices.Sort(pkg.Schemas)
+
+	return &pkg, nil
+}
+
+func (pkg *Package) add_vars(d *ast.GenDecl) {
+	for _, spec := range d.Specs {
+		vs := spec.(*ast.ValueSpec)
+
+		for i, name := range vs.Names {
+			if i >= len(vs.Values) {
+				break
+			}
+
+			switch {
+			case strings.HasPrefix(name.Name, "perm_"):
+				if s, isString := string_lit(vs.Values[i]); isString {
+					pkg.Rights = append(pkg.Rights, s)
+				}
+			case name.Name == "api_schemas":
+				if cl, isLit := vs.Values[i].(*ast.CompositeLit); isLit {
+					for _, elt := range cl.Elts {
+						if kv, isKV := elt.(*ast.KeyValueExpr); isKV {
+							if s, isString := string_lit(kv.Key); isString {
+								pkg.Schemas = append(pkg.Schemas, s)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func string_lit(e ast.Expr) (string, bool) {
+	lit, isLit := e.(*ast.BasicLit)
+
+	if !isLit || lit.Kind != token.STRING {
+		return "", false
+	}
+
+	s, err := strconv.Unquote(lit.Value)
+
+	return s, err == nil
+}
+
+func param_count(ft *ast.FuncType) int {
+	n := 0
+
+	for _, field := range ft.Params.List {
+		n += max(len(field.Names), 1)
+	}
+
+	return n
+}
+
+// the constant keys of any X.PathParameters["key"] in body
+func path_params_used(body *ast.BlockStmt) []string {
+	var params []string
+
+	if body == nil {
+		return nil
+	}
+
+	ast.Inspect(body, func(n ast.Node) bool {
+		ix, isIndex := n.(*ast.IndexExpr)
+
+		if !isIndex {
+			return true
+		}
+
+		if sel, isSel := ix.X.(*ast.SelectorExpr); isSel && sel.Sel.Name == "PathParameters" {
+			if key, isString := string_lit(ix.Index); isString && !slices.Contains(params, key) {
+				params = append(params, key)
+			}
+		}
+
+		return true
+	})
+
+	return params
+}
@@ -0,0 +1,358 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Every route the client uses.  The server's tests check that they are all
+// in api/api.yaml, so the client can't fall behind a renamed route.
+var Routes = []string{
+	"GET /login",
+	"GET /signup",
+	"GET /api/v1/group",
+	"GET /api/v1/group/{group}",
+	"POST /api/v1/group/{name}",
+	"DELETE /api/v1/group/{id}",
+	"GET /api/v1/group/{group}/counter",
+	"GET /api/v1/group/{group}/counter/{id}",
+	"POST /api/v1/group/{group}/counter/{name}",
+	"POST /api/v1/group/{group}/counter/{id}/increment",
+	"POST /api/v1/group/{group}/counter/{id}/decrement",
+	"POST /api/v1/group/{group}/counter/{id}/reset",
+	"POST /api/v1/group/{group}/counter/{id}/step",
+	"DELETE /api/v1/group/{group}/counter/{id}",
+	"PUT /api/v1/group/{group}/counter/{id}/labels",
+	"PUT /api/v1/group/{group}/counter/{id}/meta",
+	"PUT /api/v1/group/{group}/counter/{id}/expression",
+	"GET /api/v1/group/{group}/labels",
+}
+
+// Result is what the server says about a change or a list.
+type Result struct {
+	Success bool
+	Result  string
+	Id      string
+
+	// the server sends lists under this name
+	Items []string `json:"omitempty"`
+}
+
+type LoginResult struct {
+	Result string
+	Token  string
+}
+
+type SignupResult struct {
+	Result string
+	Id     string
+}
+
+type Group struct {
+	Id       string   `json:"objectUUID"`
+	Name     string   `json:"groupName"`
+	Counters []string `json:"counters"`
+	Version  int      `json:"version"`
+
+	ETag string `json:"-"`
+}
+
+func (gd *Group) set_etag(etag string) {
+	gd.ETag = etag
+}
+
+type Counter struct {
+	Id      string `json:"objectUUID"`
+	Name    string `json:"counterName"`
+	Group   string `json:"counterGroupUUID"`
+	Value   int    `json:"countVal"`
+	Step    int    `json:"stepVal"`
+	Version int    `json:"version"`
+	Shards  int    `json:"shards,omitempty"`
+
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// a derived counter's expression and its value when it was read, which
+	// Value has rounded, or why it has none
+	Expression   string   `json:"expression,omitempty"`
+	Derived      *float64 `json:"derived,omitempty"`
+	DerivedError string   `json:"derivedError,omitempty"`
+
+	Meta
+
+	// for If-Match on a later change
+	ETag string `json:"-"`
+}
+
+func (cd *Counter) set_etag(etag string) {
+	cd.ETag = etag
+}
+
+// CallOption changes one request.
+type CallOption func(*call)
+
+// fails the change with ErrPreconditionFailed if the counter or group has
+// moved on from this version
+func IfMatch(version int) CallOption {
+	return func(cl *call) { cl.ifMatch = &version }
+}
+
+// the key retries of this change are sent with.  The client makes one up if
+// not given one, this is for a change retried across runs.
+func IdempotencyKey(key string) CallOption {
+	return func(cl *call) { cl.idempotencyKey = key }
+}
+
+func with_options(cl call, opts []CallOption) call {
+	for _, opt := range opts {
+		opt(&cl)
+	}
+	return cl
+}
+
+// fills in the {placeholders} of a route's path, escaping each value
+func route_path(path string, params ...string) string {
+	for i := 0; i+1 < len(params); i += 2 {
+		path = strings.Replace(path, "{"+params[i]+"}", url.PathEscape(params[i+1]), 1)
+	}
+
+	return path
+}
+
+func route_call(method string, path string, params ...string) call {
+	return call{method: method, path: route_path(path, params...)}
+}
+
+// Signup makes an account and gives back its user ID.  It can be retried.
+func (c *Client) Signup(ctx context.Context, email string, password string) (string, error) {
+	var sr SignupResult
+
+	err := c.do(ctx, call{method: "GET", path: "/signup", query: url.Values{"email": {email}, "password": {password}}, public: true}, &sr)
+
+	return sr.Id, err
+}
+
+// the IDs of the caller's groups
+func (c *Client) ListGroups(ctx context.Context) ([]string, error) {
+	var r Result
+
+	err := c.do(ctx, route_call("GET", "/api/v1/group"), &r)
+
+	return r.Items, err
+}
+
+func (c *Client) GetGroup(ctx context.Context, group string) (*Group, error) {
+	var gd Group
+
+	if err := c.do(ctx, route_call("GET", "/api/v1/group/{group}", "group", group), &gd); err != nil {
+		return nil, err
+	}
+
+	return &gd, nil
+}
+
+// the new group's ID
+func (c *Client) CreateGroup(ctx context.Context, name string, opts ...CallOption) (string, error) {
+	var r Result
+
+	err := c.do(ctx, with_options(route_call("POST", "/api/v1/group/{name}", "name", name), opts), &r)
+
+	return r.Id, err
+}
+
+func (c *Client) DeleteGroup(ctx context.Context, group string, opts ...CallOption) error {
+	return c.do(ctx, with_options(route_call("DELETE", "/api/v1/group/{id}", "id", group), opts), nil)
+}
+
+// the IDs of the group's counters
+func (c *Client) ListCounters(ctx context.Context, group string) ([]string, error) {
+	var r Result
+
+	err := c.do(ctx, route_call("GET", "/api/v1/group/{group}/counter", "group", group), &r)
+
+	return r.Items, err
+}
+
+// the IDs of the group's counters which the selector matches, e.g.
+// env=prod,team!=infra,owner for those with an owner label
+func (c *Client) SelectCounters(ctx context.Context, group string, selector string) ([]string, error) {
+	var r Result
+
+	cl := route_call("GET", "/api/v1/group/{group}/counter", "group", group)
+	cl.query = url.Values{"label": {selector}}
+
+	err := c.do(ctx, cl, &r)
+
+	return r.Items, err
+}
+
+func (c *Client) GetCounter(ctx context.Context, group string, id string) (*Counter, error) {
+	var cd Counter
+
+	if err := c.do(ctx, route_call("GET", "/api/v1/group/{group}/counter/{id}", "group", group, "id", id), &cd); err != nil {
+		return nil, err
+	}
+
+	return &cd, nil
+}
+
+// Meta is what a counter counts and how it is shown.
+type Meta struct {
+	Description string `json:"description,omitempty"`
+	Unit        string `json:"unit,omitempty"`
+
+	// #rrggbb
+	Color string `json:"color,omitempty"`
+	Icon  string `json:"icon,omitempty"`
+
+	// plain, grouped, compact, percent or duration
+	NumberFormat string `json:"numberFormat,omitempty"`
+}
+
+// adds what is set to a query
+func (m Meta) add_to(query url.Values) {
+	for name, value := range map[string]string{
+		"description":  m.Description,
+		"unit":         m.Unit,
+		"color":        m.Color,
+		"icon":         m.Icon,
+		"numberFormat": m.NumberFormat,
+	} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+}
+
+type CounterOptions struct {
+	// spreads a busy counter's count over this many items, up to 32
+	Shards int `json:"-"`
+
+	// where the counter starts, the baseline if not set, and its step, 1
+	// if not set
+	Value *int `json:"value,omitempty"`
+	Step  *int `json:"step,omitempty"`
+
+	// what a reset goes back to, and the bounds increments and decrements
+	// keep the count in.  A sharded counter can't have bounds.
+	Baseline *int `json:"baseline,omitempty"`
+	Min      *int `json:"min,omitempty"`
+	Max      *int `json:"max,omitempty"`
+
+	// makes a derived counter, whose value is worked out from the group's
+	// other counters, e.g. passed / (passed + failed).  It can't have any of
+	// the above.
+	Expression string `json:"expression,omitempty"`
+
+	Meta `json:"-"`
+}
+
+// the new counter's ID
+func (c *Client) CreateCounter(ctx context.Context, group string, name string, co CounterOptions, opts ...CallOption) (string, error) {
+	var r Result
+
+	cl := route_call("POST", "/api/v1/group/{group}/counter/{name}", "group", group, "name", name)
+	cl.query = url.Values{}
+
+	if co.Shards > 0 {
+		cl.query.Set("shards", strconv.Itoa(co.Shards))
+	}
+
+	co.Meta.add_to(cl.query)
+
+	if co.Value != nil || co.Step != nil || co.Baseline != nil || co.Min != nil || co.Max != nil || co.Expression != "" {
+		var err error
+
+		if cl.body, err = json.Marshal(co); err != nil {
+			return "", err
+		}
+	}
+
+	err := c.do(ctx, with_options(cl, opts), &r)
+
+	return r.Id, err
+}
+
+func (c *Client) counter_op(ctx context.Context, group string, id string, op string, query url.Values, opts []CallOption) error {
+	cl := route_call("POST", "/api/v1/group/{group}/counter/{id}/"+op, "group", group, "id", id)
+	cl.query = query
+
+	return c.do(ctx, with_options(cl, opts), nil)
+}
+
+// adds the counter's step
+func (c *Client) Increment(ctx context.Context, group string, id string, opts ...CallOption) error {
+	return c.counter_op(ctx, group, id, "increment", nil, opts)
+}
+
+// takes away the counter's step
+func (c *Client) Decrement(ctx context.Context, group string, id string, opts ...CallOption) error {
+	return c.counter_op(ctx, group, id, "decrement", nil, opts)
+}
+
+func (c *Client) Reset(ctx context.Context, group string, id string, opts ...CallOption) error {
+	return c.counter_op(ctx, group, id, "reset", nil, opts)
+}
+
+func (c *Client) SetStep(ctx context.Context, group string, id string, step int, opts ...CallOption) error {
+	return c.counter_op(ctx, group, id, "step", url.Values{"stepVal": {strconv.Itoa(step)}}, opts)
+}
+
+func (c *Client) DeleteCounter(ctx context.Context, group string, id string, opts ...CallOption) error {
+	return c.do(ctx, with_options(route_call("DELETE", "/api/v1/group/{group}/counter/{id}", "group", group, "id", id), opts), nil)
+}
+
+// replaces all the counter's labels, and takes them off if there are none
+func (c *Client) SetLabels(ctx context.Context, group string, id string, labels map[string]string, opts ...CallOption) error {
+	var pairs []string
+
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+
+	sort.Strings(pairs)
+
+	cl := route_call("PUT", "/api/v1/group/{group}/counter/{id}/labels", "group", group, "id", id)
+	cl.query = url.Values{"labels": {strings.Join(pairs, ",")}}
+
+	return c.do(ctx, with_options(cl, opts), nil)
+}
+
+// replaces a derived counter's expression
+func (c *Client) SetExpression(ctx context.Context, group string, id string, expression string, opts ...CallOption) error {
+	body, err := json.Marshal(map[string]string{"expression": expression})
+
+	if err != nil {
+		return err
+	}
+
+	cl := route_call("PUT", "/api/v1/group/{group}/counter/{id}/expression", "group", group, "id", id)
+	cl.body = body
+
+	return c.do(ctx, with_options(cl, opts), nil)
+}
+
+// every label key of the group's counters, with the values they have
+func (c *Client) GroupLabels(ctx context.Context, group string) (map[string][]string, error) {
+	var index struct {
+		Labels map[string][]string `json:"labels"`
+	}
+
+	err := c.do(ctx, route_call("GET", "/api/v1/group/{group}/labels", "group", group), &index)
+
+	return index.Labels, err
+}
+
+// replaces the counter's metadata, taking off whatever isn't set
+func (c *Client) SetMeta(ctx context.Context, group string, id string, meta Meta, opts ...CallOption) error {
+	cl := route_call("PUT", "/api/v1/group/{group}/counter/{id}/meta", "group", group, "id", id)
+	cl.query = url.Values{}
+
+	meta.add_to(cl.query)
+
+	return c.do(ctx, with_options(cl, opts), nil)
+}
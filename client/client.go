@@ -0,0 +1,354 @@
+// Package client talks to the counter API.  It builds the URLs, keeps the
+// login token fresh, retries what is safe to retry and turns the server's
+// failures into errors which can be told apart with errors.Is.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAttempts = 4
+	retryBase       = 100 * time.Millisecond
+	retryCap        = 2 * time.Second
+
+	// a token this close to expiring is renewed before it is used
+	tokenSlack = time.Minute
+
+	apiKeyHeader = "X-Api-Key"
+
+	// API key requests go through the server's key route, e.g. /key/api/v1/group
+	apiKeyPrefix = "/key"
+)
+
+type Client struct {
+	baseURL  string
+	http     *http.Client
+	attempts int
+
+	// with an API key there is no login, the key goes with every request
+	apiKey string
+
+	mu       sync.Mutex
+	token    string
+	expires  time.Time
+	email    string
+	password string
+}
+
+type Option func(*Client)
+
+// the client requests go through.  http.DefaultClient otherwise.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// authenticates with an API key instead of logging in
+func WithAPIKey(key string) Option {
+	return func(c *Client) { c.apiKey = key }
+}
+
+// uses a token from an earlier login.  It is not renewed.
+func WithToken(token string) Option {
+	return func(c *Client) { c.set_token(token) }
+}
+
+// how many times a request is tried, at least 1
+func WithAttempts(n int) Option {
+	return func(c *Client) { c.attempts = max(n, 1) }
+}
+
+// baseURL is where the API is, e.g. https://abc.execute-api.eu-west-1.amazonaws.com
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		http:     http.DefaultClient,
+		attempts: defaultAttempts,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Login gets a token and keeps the credentials to get another when it expires.
+func (c *Client) Login(ctx context.Context, email string, password string) error {
+	c.mu.Lock()
+	c.email, c.password = email, password
+	c.mu.Unlock()
+
+	return c.login(ctx)
+}
+
+// the current token, e.g. for caching between runs
+func (c *Client) Token() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.token
+}
+
+func (c *Client) login(ctx context.Context) error {
+	c.mu.Lock()
+	query := url.Values{"email": {c.email}, "password": {c.password}}
+	c.mu.Unlock()
+
+	var lr LoginResult
+
+	if err := c.do(ctx, call{method: "GET", path: "/login", query: query, public: true}, &lr); err != nil {
+		return err
+	}
+
+	c.set_token(lr.Token)
+
+	return nil
+}
+
+func (c *Client) set_token(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.token = token
+	c.expires = token_expiry(token)
+}
+
+// the exp claim of a JWT, zero if it can't be read.  The server checks the
+// signature, the client only needs to know when to get a new one.
+func token_expiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+
+	if err != nil {
+		return time.Time{}
+	}
+
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+
+	if json.Unmarshal(payload, &claims) != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(claims.Exp, 0)
+}
+
+// a token which has time left, logging in again if there are credentials to
+func (c *Client) fresh_token(ctx context.Context, force bool) (string, error) {
+	c.mu.Lock()
+	token, expires, canLogin := c.token, c.expires, c.email != ""
+	c.mu.Unlock()
+
+	stale := force || (!expires.IsZero() && time.Until(expires) < tokenSlack)
+
+	if !stale || !canLogin {
+		return token, nil
+	}
+
+	if err := c.login(ctx); err != nil {
+		return "", err
+	}
+
+	return c.Token(), nil
+}
+
+type call struct {
+	method string
+	path   string
+	query  url.Values
+
+	// a JSON body, sent again with each retry
+	body []byte
+
+	// login and signup go without a token
+	public bool
+
+	// sent on changes so that retrying them is safe
+	idempotencyKey string
+	ifMatch        *int
+}
+
+// the request, with retries for anything which failed on the way or on the
+// server's side, as long as doing it again can't do it twice: reads, and
+// changes with an idempotency key.  An expired token is renewed once.
+func (c *Client) do(ctx context.Context, cl call, out any) error {
+	if cl.method != "GET" && !cl.public && cl.idempotencyKey == "" {
+		cl.idempotencyKey = new_idempotency_key()
+	}
+
+	renewed := false
+
+	for attempt := 0; ; attempt++ {
+		err := c.try(ctx, cl, out, false)
+
+		if errors.Is(err, ErrUnauthorized) && !renewed && !cl.public && c.apiKey == "" {
+			renewed = true
+			err = c.try(ctx, cl, out, true)
+		}
+
+		if err == nil || !retryable(err) || attempt+1 >= c.attempts {
+			return err
+		}
+
+		delay := retry_delay(attempt)
+
+		var ae *Error
+
+		// the server knows better when it will have room
+		if errors.As(err, &ae) && ae.RetryAfter > delay {
+			delay = ae.RetryAfter
+		}
+
+		if serr := sleep(ctx, delay); serr != nil {
+			return err
+		}
+	}
+}
+
+func (c *Client) try(ctx context.Context, cl call, out any, renew bool) error {
+	req, err := c.request(ctx, cl, renew)
+
+	if err != nil {
+		return err
+	}
+
+	res, err := c.http.Do(req)
+
+	if err != nil {
+		return &transportError{err: err}
+	}
+
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+
+	if err != nil {
+		return &transportError{err: err}
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return &Error{
+			StatusCode: res.StatusCode,
+			Message:    strings.TrimSpace(string(body)),
+			Replayed:   res.Header.Get("Idempotent-Replayed") == "true",
+			RetryAfter: retry_after(res.Header.Get("Retry-After")),
+		}
+	}
+
+	if etagged, isEtagged := out.(interface{ set_etag(string) }); isEtagged {
+		etagged.set_etag(res.Header.Get("ETag"))
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	if jerr := json.Unmarshal(body, out); jerr != nil {
+		return fmt.Errorf("decoding %s %s response: %w", cl.method, cl.path, jerr)
+	}
+
+	return nil
+}
+
+func (c *Client) request(ctx context.Context, cl call, renew bool) (*http.Request, error) {
+	path := cl.path
+
+	if c.apiKey != "" && !cl.public {
+		path = apiKeyPrefix + path
+	}
+
+	u := c.baseURL + path
+
+	if len(cl.query) > 0 {
+		u += "?" + cl.query.Encode()
+	}
+
+	var body io.Reader
+
+	if cl.body != nil {
+		body = bytes.NewReader(cl.body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, cl.method, u, body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if cl.body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	switch {
+	case cl.public:
+	case c.apiKey != "":
+		req.Header.Set(apiKeyHeader, c.apiKey)
+	default:
+		token, terr := c.fresh_token(ctx, renew)
+
+		if terr != nil {
+			return nil, terr
+		}
+
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+
+	if cl.idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", cl.idempotencyKey)
+	}
+
+	if cl.ifMatch != nil {
+		req.Header.Set("If-Match", fmt.Sprintf(`"%d"`, *cl.ifMatch))
+	}
+
+	return req, nil
+}
+
+func new_idempotency_key() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// "full jitter", as the server does for its own retries
+func retry_delay(attempt int) time.Duration {
+	ceiling := min(retryCap, retryBase<<attempt)
+	n, _ := rand.Int(rand.Reader, big.NewInt(int64(ceiling)+1))
+	return time.Duration(n.Int64())
+}
+
+// swapped out by tests
+var sleep = func(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
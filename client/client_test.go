@@ -0,0 +1,326 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// what the fake server was sent
+type seen struct {
+	method string
+	path   string
+	query  string
+	header http.Header
+	body   string
+}
+
+// a server which answers with respond and keeps every request
+func fakeServer(t *testing.T, respond func(n int, r *http.Request) (int, string)) (*httptest.Server, *[]seen) {
+	var requests []seen
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent, _ := io.ReadAll(r.Body)
+		requests = append(requests, seen{method: r.Method, path: r.URL.Path, query: r.URL.RawQuery, header: r.Header.Clone(), body: string(sent)})
+
+		status, body := respond(len(requests), r)
+
+		if status == 200 {
+			w.Header().Set("ETag", `"4"`)
+		}
+
+		// as the server does when it is too busy
+		if status == 503 {
+			w.Header().Set("Retry-After", "1")
+		}
+
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+
+	t.Cleanup(srv.Close)
+
+	return srv, &requests
+}
+
+// the delays asked for are kept instead
+func noSleep(t *testing.T) *[]time.Duration {
+	var delays []time.Duration
+
+	old := sleep
+	sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+	t.Cleanup(func() { sleep = old })
+
+	return &delays
+}
+
+func jwtExpiring(at time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp":%d}`, at.Unix())))
+	return "h." + payload + ".s"
+}
+
+func TestCounterCalls(t *testing.T) {
+	srv, requests := fakeServer(t, func(n int, r *http.Request) (int, string) {
+		if r.Method == "GET" {
+			return 200, `{"objectUUID":"c1","counterName":"coffee","counterGroupUUID":"g1","countVal":3,"stepVal":1,"version":4}`
+		}
+		return 200, `{"Success":true,"Result":"OK","Id":"c1"}`
+	})
+
+	c := New(srv.URL, WithToken("tok"))
+	ctx := context.Background()
+
+	cd, err := c.GetCounter(ctx, "g1", "c1")
+
+	if err != nil || cd.Name != "coffee" || cd.Value != 3 || cd.ETag != `"4"` {
+		t.Fatalf("Counter is %v %s", cd, err)
+	}
+
+	if err := c.SetStep(ctx, "g1", "c1", 5, IfMatch(4)); err != nil {
+		t.Fatal(err)
+	}
+
+	get, step := (*requests)[0], (*requests)[1]
+
+	if get.method != "GET" || get.path != "/api/v1/group/g1/counter/c1" || get.header.Get("Authorization") != "Bearer tok" {
+		t.Errorf("Read sent %v", get)
+	}
+
+	if get.header.Get("Idempotency-Key") != "" {
+		t.Error("Read sent an idempotency key")
+	}
+
+	if step.method != "POST" || step.path != "/api/v1/group/g1/counter/c1/step" || step.query != "stepVal=5" {
+		t.Errorf("Step sent %v", step)
+	}
+
+	if step.header.Get("If-Match") != `"4"` || len(step.header.Get("Idempotency-Key")) != 32 {
+		t.Errorf("Step headers are %v", step.header)
+	}
+
+	if id, err := c.CreateCounter(ctx, "g 1", "tea/pot", CounterOptions{Shards: 4, Meta: Meta{Unit: "cups"}}); err != nil || id != "c1" {
+		t.Errorf("Create gave %s %s", id, err)
+	}
+
+	if create := (*requests)[2]; create.path != "/api/v1/group/g 1/counter/tea/pot" || create.query != "shards=4&unit=cups" {
+		t.Errorf("Create sent %v", create)
+	}
+
+	low, high := 0, 10
+
+	if _, err := c.CreateCounter(ctx, "g1", "cups", CounterOptions{Min: &low, Max: &high}); err != nil {
+		t.Fatal(err)
+	}
+
+	if create := (*requests)[3]; create.body != `{"min":0,"max":10}` || create.header.Get("Content-Type") != "application/json" {
+		t.Errorf("Create with bounds sent %v", create)
+	}
+
+	if err := c.SetMeta(ctx, "g1", "c1", Meta{Color: "#ff0000", NumberFormat: "compact"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if meta := (*requests)[4]; meta.method != "PUT" || meta.path != "/api/v1/group/g1/counter/c1/meta" || meta.query != "color=%23ff0000&numberFormat=compact" {
+		t.Errorf("Meta sent %v", meta)
+	}
+
+	if _, err := c.CreateCounter(ctx, "g1", "ratio", CounterOptions{Expression: "passed / (passed + failed)"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if create := (*requests)[5]; create.body != `{"expression":"passed / (passed + failed)"}` {
+		t.Errorf("Create derived sent %v", create)
+	}
+
+	if err := c.SetExpression(ctx, "g1", "c1", "sum(env=prod)"); err != nil {
+		t.Fatal(err)
+	}
+
+	if expr := (*requests)[6]; expr.method != "PUT" || expr.path != "/api/v1/group/g1/counter/c1/expression" || expr.body != `{"expression":"sum(env=prod)"}` {
+		t.Errorf("Expression sent %v", expr)
+	}
+}
+
+func TestLabelCalls(t *testing.T) {
+	srv, requests := fakeServer(t, func(n int, r *http.Request) (int, string) {
+		if r.URL.Path == "/api/v1/group/g1/labels" {
+			return 200, `{"group":"g1","labels":{"env":["dev","prod"]}}`
+		}
+		return 200, `{"Success":true,"Result":"OK","Id":"c1","omitempty":["c1"]}`
+	})
+
+	c := New(srv.URL, WithToken("tok"))
+	ctx := context.Background()
+
+	if err := c.SetLabels(ctx, "g1", "c1", map[string]string{"team": "infra", "env": "prod"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if ids, err := c.SelectCounters(ctx, "g1", "env=prod"); err != nil || len(ids) != 1 {
+		t.Errorf("Select gave %v %s", ids, err)
+	}
+
+	if index, err := c.GroupLabels(ctx, "g1"); err != nil || len(index["env"]) != 2 {
+		t.Errorf("Labels are %v %s", index, err)
+	}
+
+	set, sel := (*requests)[0], (*requests)[1]
+
+	if set.method != "PUT" || set.path != "/api/v1/group/g1/counter/c1/labels" || set.query != "labels=env%3Dprod%2Cteam%3Dinfra" {
+		t.Errorf("Set sent %v", set)
+	}
+
+	if sel.path != "/api/v1/group/g1/counter" || sel.query != "label=env%3Dprod" {
+		t.Errorf("Select sent %v", sel)
+	}
+}
+
+func TestErrorClasses(t *testing.T) {
+	for status, class := range map[int]error{404: ErrNotFound, 412: ErrPreconditionFailed, 422: ErrKeyReused, 504: ErrTimeout, 503: ErrThrottled, 500: ErrServer, 401: ErrUnauthorized, 409: ErrConflict, 400: ErrBadRequest} {
+		srv, _ := fakeServer(t, func(n int, r *http.Request) (int, string) { return status, "nope\n" })
+
+		noSleep(t)
+
+		err := New(srv.URL, WithAttempts(1)).Increment(context.Background(), "g", "c")
+
+		var ae *Error
+
+		if !errors.Is(err, class) || !errors.As(err, &ae) || ae.Message != "nope" || ae.StatusCode != status {
+			t.Errorf("%d gave %v", status, err)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	for header, expected := range map[string]time.Duration{
+		"3":    3 * time.Second,
+		"":     0,
+		"-1":   0,
+		"soon": 0,
+		time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat): 0,
+	} {
+		if delay := retry_after(header); delay != expected {
+			t.Errorf("Retry-After %q is %s not %s", header, delay, expected)
+		}
+	}
+
+	if delay := retry_after(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)); delay < 58*time.Second || delay > time.Minute {
+		t.Errorf("Retry-After a minute from now is %s", delay)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	delays := noSleep(t)
+
+	srv, requests := fakeServer(t, func(n int, r *http.Request) (int, string) {
+		if n < 3 {
+			return 503, "busy"
+		}
+		return 200, `{"Success":true}`
+	})
+
+	if err := New(srv.URL).Increment(context.Background(), "g", "c"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(*requests) != 3 {
+		t.Fatalf("Made %d requests", len(*requests))
+	}
+
+	// at least as long as Retry-After asks
+	if len(*delays) != 2 || (*delays)[0] < time.Second || (*delays)[1] < time.Second {
+		t.Errorf("Waited %v between tries", *delays)
+	}
+
+	key := (*requests)[0].header.Get("Idempotency-Key")
+
+	for _, r := range *requests {
+		if r.header.Get("Idempotency-Key") != key {
+			t.Error("Retry sent with a different idempotency key")
+		}
+	}
+
+	// not found isn't going to change
+	srv, requests = fakeServer(t, func(n int, r *http.Request) (int, string) { return 404, "no" })
+
+	New(srv.URL).Increment(context.Background(), "g", "c")
+
+	if len(*requests) != 1 {
+		t.Errorf("404 tried %d times", len(*requests))
+	}
+
+	// nor is a failure after the last attempt
+	srv, requests = fakeServer(t, func(n int, r *http.Request) (int, string) { return 504, "slow" })
+
+	if err := New(srv.URL, WithAttempts(2)).Reset(context.Background(), "g", "c"); !errors.Is(err, ErrTimeout) || len(*requests) != 2 {
+		t.Errorf("Timeouts gave %s after %d", err, len(*requests))
+	}
+}
+
+func TestTokenRenewal(t *testing.T) {
+	fresh := jwtExpiring(time.Now().Add(time.Hour))
+	logins := 0
+
+	srv, requests := fakeServer(t, func(n int, r *http.Request) (int, string) {
+		if r.URL.Path == "/login" {
+			logins++
+			return 200, `{"Result":"OK","Token":"` + fresh + `"}`
+		}
+
+		if r.Header.Get("Authorization") != "Bearer "+fresh {
+			return 401, "Unauthorized"
+		}
+
+		return 200, `{"Success":true,"Items":[],"omitempty":["g1"]}`
+	})
+
+	c := New(srv.URL)
+	ctx := context.Background()
+
+	if err := c.Login(ctx, "foo@bar.com", "pw"); err != nil || c.Token() != fresh {
+		t.Fatalf("Login gave %s", err)
+	}
+
+	if login := (*requests)[0]; login.query != "email=foo%40bar.com&password=pw" || login.header.Get("Authorization") != "" {
+		t.Errorf("Login sent %v", login)
+	}
+
+	// about to expire, so renewed first
+	c.set_token(jwtExpiring(time.Now().Add(10 * time.Second)))
+
+	if groups, err := c.ListGroups(ctx); err != nil || len(groups) != 1 || groups[0] != "g1" {
+		t.Errorf("Groups are %v %s", groups, err)
+	}
+
+	// revoked but not expired, so renewed after the 401
+	c.set_token("revoked")
+
+	if _, err := c.ListGroups(ctx); err != nil {
+		t.Errorf("List after a 401 gave %s", err)
+	}
+
+	if logins != 3 {
+		t.Errorf("Logged in %d times", logins)
+	}
+}
+
+func TestAPIKey(t *testing.T) {
+	srv, requests := fakeServer(t, func(n int, r *http.Request) (int, string) { return 200, `{"Success":true}` })
+
+	New(srv.URL, WithAPIKey("ocd_key")).Decrement(context.Background(), "g", "c")
+
+	r := (*requests)[0]
+
+	if r.path != "/key/api/v1/group/g/counter/c/decrement" || r.header.Get("X-Api-Key") != "ocd_key" || r.header.Get("Authorization") != "" {
+		t.Errorf("Key request sent %v", r)
+	}
+}
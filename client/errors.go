@@ -0,0 +1,112 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The classes of failure the server has.  A parameter which isn't what the
+// route takes is ErrBadRequest, and a change which what is stored doesn't
+// allow, like going past a counter's bounds, is ErrConflict.  Most other
+// failures, including a counter or group which doesn't exist or isn't the
+// caller's, are ErrNotFound.
+var (
+	ErrBadRequest         = errors.New("bad request")
+	ErrConflict           = errors.New("conflict")
+	ErrUnauthorized       = errors.New("unauthorized")
+	ErrNotFound           = errors.New("not found")
+	ErrPreconditionFailed = errors.New("changed since it was read")
+	ErrKeyReused          = errors.New("idempotency key used for another request")
+	ErrThrottled          = errors.New("throttled")
+	ErrTimeout            = errors.New("timed out")
+	ErrServer             = errors.New("server error")
+)
+
+// Error is a response other than 200.  errors.Is matches it against the
+// class of its status.  A server too busy for the request answers 503, which
+// is ErrThrottled like 429.
+type Error struct {
+	StatusCode int
+	Message    string
+
+	// the response was the one recorded for an earlier request with the same idempotency key
+	Replayed bool
+
+	// how long the server asked to be left before the request is tried
+	// again, from Retry-After, or 0 if it didn't say
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return http.StatusText(e.StatusCode)
+	}
+	return e.Message
+}
+
+func (e *Error) Is(target error) bool {
+	return status_class(e.StatusCode) == target
+}
+
+func status_class(status int) error {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrUnauthorized
+	case status == http.StatusNotFound:
+		return ErrNotFound
+	case status == http.StatusConflict:
+		return ErrConflict
+	case status == http.StatusPreconditionFailed:
+		return ErrPreconditionFailed
+	case status == http.StatusUnprocessableEntity:
+		return ErrKeyReused
+	case status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable:
+		return ErrThrottled
+	case status == http.StatusGatewayTimeout:
+		return ErrTimeout
+	case status >= 500:
+		return ErrServer
+	case status >= 400:
+		return ErrBadRequest
+	}
+	return nil
+}
+
+// a Retry-After header, which is either a number of seconds or a date
+func retry_after(header string) time.Duration {
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if at, err := http.ParseTime(header); err == nil {
+		return max(0, time.Until(at))
+	}
+
+	return 0
+}
+
+// the request didn't get a response
+type transportError struct {
+	err error
+}
+
+func (te *transportError) Error() string {
+	return te.err.Error()
+}
+
+func (te *transportError) Unwrap() error {
+	return te.err
+}
+
+// failures which may go away if the request is made again
+func retryable(err error) bool {
+	var te *transportError
+
+	if errors.As(err, &te) {
+		return true
+	}
+
+	return errors.Is(err, ErrThrottled) || errors.Is(err, ErrTimeout) || errors.Is(err, ErrServer)
+}
@@ -0,0 +1,26 @@
+// Command apigen generates the route tables of the lambda package and the
+// serverless functions from api/api.yaml, after checking it.  It is run by
+// go generate in go/.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"myhello/apigen"
+)
+
+func main() {
+	api := flag.String("api", "../api/api.yaml", "the API spec")
+	pkg := flag.String("pkg", ".", "the directory of the package with the handlers")
+	goOut := flag.String("go", "api_handlers.go", "the Go file to write, in -pkg")
+	slsOut := flag.String("sls", "../serverless/sls_api_handlers.yaml", "the serverless functions file to write")
+
+	flag.Parse()
+
+	if err := apigen.Generate(*api, *pkg, *goOut, *slsOut); err != nil {
+		fmt.Fprintln(os.Stderr, "apigen:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,27 @@
+// Command ocdctl uses the counter API from the command line.  Run it with -h
+// for the commands.
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+
+	"myhello/ocdctl"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+
+	cli := ocdctl.CLI{
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		Getenv: os.Getenv,
+	}
+
+	status := cli.Run(ctx, os.Args[1:])
+
+	stop()
+	os.Exit(status)
+}
@@ -0,0 +1,1220 @@
+// Code generated by apigen from api/api.yaml. DO NOT EDIT.
+
+package main
+
+import (
+	"context"
+)
+
+var public_handlers = map[string]func(ctx context.Context, req Request, dbo DataOperator) (Response, error){
+	"GET /login":        with_public_params(parse_login, login),
+	"GET /signup":       with_public_params(parse_signup, signup),
+	"GET /openapi.json": openapi,
+}
+
+var private_handlers = map[string]func(ctx context.Context, req Request, dbo DataOperator, s Session) (Response, error){
+	"GET /loop":                                           loop,
+	"GET /loopua":                                         loop,
+	"ANY /key/{proxy+}":                                   with_params(parse_keyProxy, keyProxy),
+	"GET /api/v1/group":                                   listGroups,
+	"GET /api/v1/group/{group}":                           with_params(parse_getGroup, getGroup),
+	"POST /api/v1/group/{name}":                           with_params(parse_createGroup, createGroup),
+	"DELETE /api/v1/group/{id}":                           with_params(parse_deleteGroup, deleteGroup),
+	"GET /api/v1/group/{group}/counter":                   with_params(parse_listCounters, listCounters),
+	"GET /api/v1/group/{group}/counter/{id}":              with_params(parse_getCounter, getCounter),
+	"POST /api/v1/group/{group}/counter/{name}":           with_params(parse_createCounter, createCounter),
+	"POST /api/v1/group/{group}/counter/{id}/increment":   with_params(parse_incCounter, incCounter),
+	"POST /api/v1/group/{group}/counter/{id}/decrement":   with_params(parse_decCounter, decCounter),
+	"POST /api/v1/group/{group}/counter/{id}/reset":       with_params(parse_resetCounter, resetCounter),
+	"POST /api/v1/group/{group}/counter/{id}/step":        with_params(parse_setCounterStep, setCounterStep),
+	"DELETE /api/v1/group/{group}/counter/{id}":           with_params(parse_deleteCounter, deleteCounter),
+	"PUT /api/v1/group/{group}/counter/{id}/labels":       with_params(parse_setCounterLabels, setCounterLabels),
+	"PUT /api/v1/group/{group}/counter/{id}/meta":         with_params(parse_setCounterMeta, setCounterMeta),
+	"PUT /api/v1/group/{group}/counter/{id}/expression":   with_params(parse_setCounterExpression, setCounterExpression),
+	"GET /api/v1/group/{group}/labels":                    with_params(parse_listGroupLabels, listGroupLabels),
+	"GET /api/v1/group/{group}/export":                    with_params(parse_exportGroup, exportGroup),
+	"POST /api/v1/group/{group}/import":                   with_params(parse_importGroup, importGroup),
+	"POST /api/v1/group/{group}/clone":                    with_params(parse_cloneGroup, cloneGroup),
+	"POST /api/v1/group/{group}/template":                 with_params(parse_createTemplate, createTemplate),
+	"GET /api/v1/template":                                listTemplates,
+	"GET /api/v1/template/{id}":                           with_params(parse_getTemplate, getTemplate),
+	"DELETE /api/v1/template/{id}":                        with_params(parse_deleteTemplate, deleteTemplate),
+	"POST /api/v1/template/{id}/group":                    with_params(parse_createGroupFromTemplate, createGroupFromTemplate),
+	"GET /api/v1/group/{group}/webhook":                   with_params(parse_listWebhooks, listWebhooks),
+	"GET /api/v1/group/{group}/webhook/{id}":              with_params(parse_getWebhook, getWebhook),
+	"POST /api/v1/group/{group}/webhook":                  with_params(parse_createWebhook, createWebhook),
+	"DELETE /api/v1/group/{group}/webhook/{id}":           with_params(parse_deleteWebhook, deleteWebhook),
+	"GET /api/v1/group/{group}/webhook/{id}/delivery":     with_params(parse_listWebhookDeliveries, listWebhookDeliveries),
+	"GET /api/v2/groups":                                  with_params(parse_listGroupsV2, listGroupsV2),
+	"POST /api/v2/groups":                                 with_params(parse_createGroupV2, createGroupV2),
+	"GET /api/v2/groups/{group}":                          with_params(parse_getGroupV2, getGroupV2),
+	"GET /api/v2/groups/{group}/counters":                 with_params(parse_listCountersV2, listCountersV2),
+	"POST /api/v2/groups/{group}/counters":                with_params(parse_createCounterV2, createCounterV2),
+	"GET /api/v2/groups/{group}/counters/{id}":            with_params(parse_getCounterV2, getCounterV2),
+	"PATCH /api/v2/groups/{group}/counters/{id}":          with_params(parse_updateCounterV2, updateCounterV2),
+	"DELETE /api/v2/groups/{group}/counters/{id}":         with_params(parse_deleteCounterV2, deleteCounterV2),
+	"POST /api/v2/groups/{group}/counters/{id}/increment": with_params(parse_incCounterV2, incCounterV2),
+	"POST /api/v2/groups/{group}/counters/{id}/decrement": with_params(parse_decCounterV2, decCounterV2),
+	"POST /api/v2/groups/{group}/counters/{id}/reset":     with_params(parse_resetCounterV2, resetCounterV2),
+	"GET /api/v1/apikey":                                  listAPIKeys,
+	"GET /api/v1/apikey/{id}":                             with_params(parse_getAPIKey, getAPIKey),
+	"POST /api/v1/apikey/{name}":                          with_params(parse_createAPIKey, createAPIKey),
+	"POST /api/v1/apikey/{id}/rotate":                     with_params(parse_rotateAPIKey, rotateAPIKey),
+	"DELETE /api/v1/apikey/{id}":                          with_params(parse_revokeAPIKey, revokeAPIKey),
+}
+
+// right an API key needs to use each private route.  Routes with no right can't be used with a key.
+var private_rights = map[string]string{
+	"GET /loop":                                           "",
+	"GET /loopua":                                         "",
+	"ANY /key/{proxy+}":                                   "",
+	"GET /api/v1/group":                                   "",
+	"GET /api/v1/group/{group}":                           "read",
+	"POST /api/v1/group/{name}":                           "",
+	"DELETE /api/v1/group/{id}":                           "",
+	"GET /api/v1/group/{group}/counter":                   "read",
+	"GET /api/v1/group/{group}/counter/{id}":              "read",
+	"POST /api/v1/group/{group}/counter/{name}":           "create",
+	"POST /api/v1/group/{group}/counter/{id}/increment":   "inc",
+	"POST /api/v1/group/{group}/counter/{id}/decrement":   "dec",
+	"POST /api/v1/group/{group}/counter/{id}/reset":       "config",
+	"POST /api/v1/group/{group}/counter/{id}/step":        "config",
+	"DELETE /api/v1/group/{group}/counter/{id}":           "delete",
+	"PUT /api/v1/group/{group}/counter/{id}/labels":       "config",
+	"PUT /api/v1/group/{group}/counter/{id}/meta":         "config",
+	"PUT /api/v1/group/{group}/counter/{id}/expression":   "config",
+	"GET /api/v1/group/{group}/labels":                    "read",
+	"GET /api/v1/group/{group}/export":                    "read",
+	"POST /api/v1/group/{group}/import":                   "create",
+	"POST /api/v1/group/{group}/clone":                    "",
+	"POST /api/v1/group/{group}/template":                 "",
+	"GET /api/v1/template":                                "",
+	"GET /api/v1/template/{id}":                           "",
+	"DELETE /api/v1/template/{id}":                        "",
+	"POST /api/v1/template/{id}/group":                    "",
+	"GET /api/v1/group/{group}/webhook":                   "read",
+	"GET /api/v1/group/{group}/webhook/{id}":              "read",
+	"POST /api/v1/group/{group}/webhook":                  "config",
+	"DELETE /api/v1/group/{group}/webhook/{id}":           "config",
+	"GET /api/v1/group/{group}/webhook/{id}/delivery":     "read",
+	"GET /api/v2/groups":                                  "",
+	"POST /api/v2/groups":                                 "",
+	"GET /api/v2/groups/{group}":                          "read",
+	"GET /api/v2/groups/{group}/counters":                 "read",
+	"POST /api/v2/groups/{group}/counters":                "create",
+	"GET /api/v2/groups/{group}/counters/{id}":            "read",
+	"PATCH /api/v2/groups/{group}/counters/{id}":          "config",
+	"DELETE /api/v2/groups/{group}/counters/{id}":         "delete",
+	"POST /api/v2/groups/{group}/counters/{id}/increment": "inc",
+	"POST /api/v2/groups/{group}/counters/{id}/decrement": "dec",
+	"POST /api/v2/groups/{group}/counters/{id}/reset":     "config",
+	"GET /api/v1/apikey":                                  "",
+	"GET /api/v1/apikey/{id}":                             "",
+	"POST /api/v1/apikey/{name}":                          "",
+	"POST /api/v1/apikey/{id}/rotate":                     "",
+	"DELETE /api/v1/apikey/{id}":                          "",
+}
+
+// the path parameter with the counter of each route which is about one.
+// Keys scoped to counters can't use the others.
+var private_counters = map[string]string{
+	"GET /api/v1/group/{group}/counter/{id}":              "id",
+	"POST /api/v1/group/{group}/counter/{id}/increment":   "id",
+	"POST /api/v1/group/{group}/counter/{id}/decrement":   "id",
+	"POST /api/v1/group/{group}/counter/{id}/reset":       "id",
+	"POST /api/v1/group/{group}/counter/{id}/step":        "id",
+	"DELETE /api/v1/group/{group}/counter/{id}":           "id",
+	"PUT /api/v1/group/{group}/counter/{id}/labels":       "id",
+	"PUT /api/v1/group/{group}/counter/{id}/meta":         "id",
+	"PUT /api/v1/group/{group}/counter/{id}/expression":   "id",
+	"GET /api/v2/groups/{group}/counters/{id}":            "id",
+	"PATCH /api/v2/groups/{group}/counters/{id}":          "id",
+	"DELETE /api/v2/groups/{group}/counters/{id}":         "id",
+	"POST /api/v2/groups/{group}/counters/{id}/increment": "id",
+	"POST /api/v2/groups/{group}/counters/{id}/decrement": "id",
+	"POST /api/v2/groups/{group}/counters/{id}/reset":     "id",
+}
+
+// every route with its parameters and response, for the OpenAPI document
+var api_routes = []apiRoute{
+	{method: "GET", path: "/login", endpoint: "login", response: "loginResult", private: false, authorized: false, params: login_params},
+	{method: "GET", path: "/signup", endpoint: "signup", response: "signupResult", private: false, authorized: false, params: signup_params},
+	{method: "GET", path: "/openapi.json", endpoint: "openapi", response: "OpenAPI", private: false, authorized: false, params: nil},
+	{method: "GET", path: "/loop", endpoint: "loop", response: "", private: true, authorized: true, params: nil},
+	{method: "GET", path: "/loopua", endpoint: "loop", response: "", private: true, authorized: false, params: nil},
+	{method: "ANY", path: "/key/{proxy+}", endpoint: "keyProxy", response: "", private: true, authorized: false, params: keyProxy_params},
+	{method: "GET", path: "/api/v1/group", endpoint: "listGroups", response: "", private: true, authorized: true, params: nil},
+	{method: "GET", path: "/api/v1/group/{group}", endpoint: "getGroup", response: "GroupData", private: true, authorized: true, params: getGroup_params},
+	{method: "POST", path: "/api/v1/group/{name}", endpoint: "createGroup", response: "", private: true, authorized: true, params: createGroup_params},
+	{method: "DELETE", path: "/api/v1/group/{id}", endpoint: "deleteGroup", response: "", private: true, authorized: true, params: deleteGroup_params},
+	{method: "GET", path: "/api/v1/group/{group}/counter", endpoint: "listCounters", response: "", private: true, authorized: true, params: listCounters_params},
+	{method: "GET", path: "/api/v1/group/{group}/counter/{id}", endpoint: "getCounter", response: "CountData", private: true, authorized: true, params: getCounter_params},
+	{method: "POST", path: "/api/v1/group/{group}/counter/{name}", endpoint: "createCounter", response: "", private: true, authorized: true, params: createCounter_params},
+	{method: "POST", path: "/api/v1/group/{group}/counter/{id}/increment", endpoint: "incCounter", response: "", private: true, authorized: true, params: incCounter_params},
+	{method: "POST", path: "/api/v1/group/{group}/counter/{id}/decrement", endpoint: "decCounter", response: "", private: true, authorized: true, params: decCounter_params},
+	{method: "POST", path: "/api/v1/group/{group}/counter/{id}/reset", endpoint: "resetCounter", response: "", private: true, authorized: true, params: resetCounter_params},
+	{method: "POST", path: "/api/v1/group/{group}/counter/{id}/step", endpoint: "setCounterStep", response: "", private: true, authorized: true, params: setCounterStep_params},
+	{method: "DELETE", path: "/api/v1/group/{group}/counter/{id}", endpoint: "deleteCounter", response: "", private: true, authorized: true, params: deleteCounter_params},
+	{method: "PUT", path: "/api/v1/group/{group}/counter/{id}/labels", endpoint: "setCounterLabels", response: "", private: true, authorized: true, params: setCounterLabels_params},
+	{method: "PUT", path: "/api/v1/group/{group}/counter/{id}/meta", endpoint: "setCounterMeta", response: "", private: true, authorized: true, params: setCounterMeta_params},
+	{method: "PUT", path: "/api/v1/group/{group}/counter/{id}/expression", endpoint: "setCounterExpression", response: "", private: true, authorized: true, params: setCounterExpression_params},
+	{method: "GET", path: "/api/v1/group/{group}/labels", endpoint: "listGroupLabels", response: "LabelIndex", private: true, authorized: true, params: listGroupLabels_params},
+	{method: "GET", path: "/api/v1/group/{group}/export", endpoint: "exportGroup", response: "ExportData", private: true, authorized: true, params: exportGroup_params},
+	{method: "POST", path: "/api/v1/group/{group}/import", endpoint: "importGroup", response: "ImportResult", private: true, authorized: true, params: importGroup_params},
+	{method: "POST", path: "/api/v1/group/{group}/clone", endpoint: "cloneGroup", response: "", private: true, authorized: true, params: cloneGroup_params},
+	{method: "POST", path: "/api/v1/group/{group}/template", endpoint: "createTemplate", response: "", private: true, authorized: true, params: createTemplate_params},
+	{method: "GET", path: "/api/v1/template", endpoint: "listTemplates", response: "", private: true, authorized: true, params: nil},
+	{method: "GET", path: "/api/v1/template/{id}", endpoint: "getTemplate", response: "TemplateData", private: true, authorized: true, params: getTemplate_params},
+	{method: "DELETE", path: "/api/v1/template/{id}", endpoint: "deleteTemplate", response: "", private: true, authorized: true, params: deleteTemplate_params},
+	{method: "POST", path: "/api/v1/template/{id}/group", endpoint: "createGroupFromTemplate", response: "", private: true, authorized: true, params: createGroupFromTemplate_params},
+	{method: "GET", path: "/api/v1/group/{group}/webhook", endpoint: "listWebhooks", response: "", private: true, authorized: true, params: listWebhooks_params},
+	{method: "GET", path: "/api/v1/group/{group}/webhook/{id}", endpoint: "getWebhook", response: "WebhookData", private: true, authorized: true, params: getWebhook_params},
+	{method: "POST", path: "/api/v1/group/{group}/webhook", endpoint: "createWebhook", response: "", private: true, authorized: true, params: createWebhook_params},
+	{method: "DELETE", path: "/api/v1/group/{group}/webhook/{id}", endpoint: "deleteWebhook", response: "", private: true, authorized: true, params: deleteWebhook_params},
+	{method: "GET", path: "/api/v1/group/{group}/webhook/{id}/delivery", endpoint: "listWebhookDeliveries", response: "[]DeliveryData", private: true, authorized: true, params: listWebhookDeliveries_params},
+	{method: "GET", path: "/api/v2/groups", endpoint: "listGroupsV2", response: "GroupList", private: true, authorized: true, params: listGroupsV2_params},
+	{method: "POST", path: "/api/v2/groups", endpoint: "createGroupV2", response: "Group", private: true, authorized: true, params: createGroupV2_params},
+	{method: "GET", path: "/api/v2/groups/{group}", endpoint: "getGroupV2", response: "Group", private: true, authorized: true, params: getGroupV2_params},
+	{method: "GET", path: "/api/v2/groups/{group}/counters", endpoint: "listCountersV2", response: "CounterList", private: true, authorized: true, params: listCountersV2_params},
+	{method: "POST", path: "/api/v2/groups/{group}/counters", endpoint: "createCounterV2", response: "Counter", private: true, authorized: true, params: createCounterV2_params},
+	{method: "GET", path: "/api/v2/groups/{group}/counters/{id}", endpoint: "getCounterV2", response: "Counter", private: true, authorized: true, params: getCounterV2_params},
+	{method: "PATCH", path: "/api/v2/groups/{group}/counters/{id}", endpoint: "updateCounterV2", response: "Counter", private: true, authorized: true, params: updateCounterV2_params},
+	{method: "DELETE", path: "/api/v2/groups/{group}/counters/{id}", endpoint: "deleteCounterV2", response: "Counter", private: true, authorized: true, params: deleteCounterV2_params},
+	{method: "POST", path: "/api/v2/groups/{group}/counters/{id}/increment", endpoint: "incCounterV2", response: "Counter", private: true, authorized: true, params: incCounterV2_params},
+	{method: "POST", path: "/api/v2/groups/{group}/counters/{id}/decrement", endpoint: "decCounterV2", response: "Counter", private: true, authorized: true, params: decCounterV2_params},
+	{method: "POST", path: "/api/v2/groups/{group}/counters/{id}/reset", endpoint: "resetCounterV2", response: "Counter", private: true, authorized: true, params: resetCounterV2_params},
+	{method: "GET", path: "/api/v1/apikey", endpoint: "listAPIKeys", response: "", private: true, authorized: true, params: nil},
+	{method: "GET", path: "/api/v1/apikey/{id}", endpoint: "getAPIKey", response: "APIKeyData", private: true, authorized: true, params: getAPIKey_params},
+	{method: "POST", path: "/api/v1/apikey/{name}", endpoint: "createAPIKey", response: "apiKeyResult", private: true, authorized: true, params: createAPIKey_params},
+	{method: "POST", path: "/api/v1/apikey/{id}/rotate", endpoint: "rotateAPIKey", response: "apiKeyResult", private: true, authorized: true, params: rotateAPIKey_params},
+	{method: "DELETE", path: "/api/v1/apikey/{id}", endpoint: "revokeAPIKey", response: "", private: true, authorized: true, params: revokeAPIKey_params},
+}
+
+var login_params = []apiParam{
+	{name: "email", in: "query", kind: "string", required: true, max: limit(254)},
+	{name: "password", in: "query", kind: "string", required: true, max: limit(256)},
+}
+
+type loginParams struct {
+	Email    string
+	Password string
+}
+
+func parse_login(req Request) (loginParams, error) {
+	var p loginParams
+	pr := new_param_reader(req)
+
+	p.Email, _ = pr.str(login_params[0])
+	p.Password, _ = pr.str(login_params[1])
+
+	return p, pr.err()
+}
+
+var signup_params = []apiParam{
+	{name: "email", in: "query", kind: "string", required: true, max: limit(254)},
+	{name: "password", in: "query", kind: "string", required: true, max: limit(256)},
+}
+
+type signupParams struct {
+	Email    string
+	Password string
+}
+
+func parse_signup(req Request) (signupParams, error) {
+	var p signupParams
+	pr := new_param_reader(req)
+
+	p.Email, _ = pr.str(signup_params[0])
+	p.Password, _ = pr.str(signup_params[1])
+
+	return p, pr.err()
+}
+
+var keyProxy_params = []apiParam{
+	{name: "proxy", in: "path", kind: "string", required: true},
+}
+
+type keyProxyParams struct {
+	Proxy string
+}
+
+func parse_keyProxy(req Request) (keyProxyParams, error) {
+	var p keyProxyParams
+	pr := new_param_reader(req)
+
+	p.Proxy, _ = pr.str(keyProxy_params[0])
+
+	return p, pr.err()
+}
+
+var getGroup_params = []apiParam{
+	{name: "group", in: "path", kind: "uuid", required: true},
+}
+
+type getGroupParams struct {
+	Group UUID
+}
+
+func parse_getGroup(req Request) (getGroupParams, error) {
+	var p getGroupParams
+	pr := new_param_reader(req)
+
+	p.Group, _ = pr.uuid(getGroup_params[0])
+
+	return p, pr.err()
+}
+
+var createGroup_params = []apiParam{
+	{name: "name", in: "path", kind: "string", required: true, min: limit(1), max: limit(128)},
+}
+
+type createGroupParams struct {
+	Name string
+}
+
+func parse_createGroup(req Request) (createGroupParams, error) {
+	var p createGroupParams
+	pr := new_param_reader(req)
+
+	p.Name, _ = pr.str(createGroup_params[0])
+
+	return p, pr.err()
+}
+
+var deleteGroup_params = []apiParam{
+	{name: "id", in: "path", kind: "uuid", required: true},
+}
+
+type deleteGroupParams struct {
+	Id UUID
+}
+
+func parse_deleteGroup(req Request) (deleteGroupParams, error) {
+	var p deleteGroupParams
+	pr := new_param_reader(req)
+
+	p.Id, _ = pr.uuid(deleteGroup_params[0])
+
+	return p, pr.err()
+}
+
+var listCounters_params = []apiParam{
+	{name: "group", in: "path", kind: "uuid", required: true},
+	{name: "label", in: "query", kind: "string", list: true, description: "only the counters with these labels, e.g. env=prod,team!=infra,owner"},
+}
+
+type listCountersParams struct {
+	Group UUID
+	Label []string
+}
+
+func parse_listCounters(req Request) (listCountersParams, error) {
+	var p listCountersParams
+	pr := new_param_reader(req)
+
+	p.Group, _ = pr.uuid(listCounters_params[0])
+	p.Label = pr.strs(listCounters_params[1])
+
+	return p, pr.err()
+}
+
+var getCounter_params = []apiParam{
+	{name: "group", in: "path", kind: "uuid", required: true},
+	{name: "id", in: "path", kind: "uuid", required: true},
+}
+
+type getCounterParams struct {
+	Group UUID
+	Id    UUID
+}
+
+func parse_getCounter(req Request) (getCounterParams, error) {
+	var p getCounterParams
+	pr := new_param_reader(req)
+
+	p.Group, _ = pr.uuid(getCounter_params[0])
+	p.Id, _ = pr.uuid(getCounter_params[1])
+
+	return p, pr.err()
+}
+
+var createCounter_params = []apiParam{
+	{name: "group", in: "path", kind: "uuid", required: true},
+	{name: "name", in: "path", kind: "string", required: true, min: limit(1), max: limit(128)},
+	{name: "shards", in: "query", kind: "integer", min: limit(0), max: limit(32), description: "number of items to spread the count over, up to 32"},
+	{name: "description", in: "query", kind: "string", max: limit(1024), description: "what the counter counts"},
+	{name: "unit", in: "query", kind: "string", max: limit(32), description: "e.g. cups, incidents or ms"},
+	{name: "color", in: "query", kind: "string", max: limit(7), description: "#rrggbb to show the counter in"},
+	{name: "icon", in: "query", kind: "string", max: limit(64), description: "name of an icon to show the counter with"},
+	{name: "numberFormat", in: "query", kind: "enum", values: []string{"plain", "grouped", "compact", "percent", "duration"}, description: "how to write the count"},
+	{name: "value", in: "body", kind: "integer", description: "the count to start at, the baseline if not given"},
+	{name: "step", in: "body", kind: "integer", description: "what an increment adds, 1 if not given"},
+	{name: "baseline", in: "body", kind: "integer", description: "what a reset goes back to, 0 if not given"},
+	{name: "min", in: "body", kind: "integer", description: "the least the count can be taken down to"},
+	{name: "max", in: "body", kind: "integer", description: "the most the count can be taken up to"},
+	{name: "expression", in: "body", kind: "string", max: limit(1024), description: "makes a derived counter, e.g. passed / (passed + failed) or sum(env=prod)"},
+}
+
+type createCounterParams struct {
+	Group        UUID
+	Name         string
+	Shards       *int
+	Description  string
+	Unit         string
+	Color        string
+	Icon         string
+	NumberFormat string
+	Value        *int
+	Step         *int
+	Baseline     *int
+	Min          *int
+	Max          *int
+	Expression   string
+}
+
+func parse_createCounter(req Request) (createCounterParams, error) {
+	var p createCounterParams
+	pr := new_param_reader(req)
+
+	p.Group, _ = pr.uuid(createCounter_params[0])
+	p.Name, _ = pr.str(createCounter_params[1])
+	if v, found := pr.integer(createCounter_params[2]); found {
+		p.Shards = &v
+	}
+	p.Description, _ = pr.str(createCounter_params[3])
+	p.Unit, _ = pr.str(createCounter_params[4])
+	p.Color, _ = pr.str(createCounter_params[5])
+	p.Icon, _ = pr.str(createCounter_params[6])
+	p.NumberFormat, _ = pr.str(createCounter_params[7])
+	if v, found := pr.integer(createCounter_params[8]); found {
+		p.Value = &v
+	}
+	if v, found := pr.integer(createCounter_params[9]); found {
+		p.Step = &v
+	}
+	if v, found := pr.integer(createCounter_params[10]); found {
+		p.Baseline = &v
+	}
+	if v, found := pr.integer(createCounter_params[11]); found {
+		p.Min = &v
+	}
+	if v, found := pr.integer(createCounter_params[12]); found {
+		p.Max = &v
+	}
+	p.Expression, _ = pr.str(createCounter_params[13])
+
+	return p, pr.err()
+}
+
+var incCounter_params = []apiParam{
+	{name: "group", in: "path", kind: "uuid", required: true},
+	{name: "id", in: "path", kind: "uuid", required: true},
+}
+
+type incCounterParams struct {
+	Group UUID
+	Id    UUID
+}
+
+func parse_incCounter(req Request) (incCounterParams, error) {
+	var p incCounterParams
+	pr := new_param_reader(req)
+
+	p.Group, _ = pr.uuid(incCounter_params[0])
+	p.Id, _ = pr.uuid(incCounter_params[1])
+
+	return p, pr.err()
+}
+
+var decCounter_params = []apiParam{
+	{name: "group", in: "path", kind: "uuid", required: true},
+	{name: "id", in: "path", kind: "uuid", required: true},
+}
+
+type decCounterParams struct {
+	Group UUID
+	Id    UUID
+}
+
+func parse_decCounter(req Request) (decCounterParams, error) {
+	var p decCounterParams
+	pr := new_param_reader(req)
+
+	p.Group, _ = pr.uuid(decCounter_params[0])
+	p.Id, _ = pr.uuid(decCounter_params[1])
+
+	return p, pr.err()
+}
+
+var resetCounter_params = []apiParam{
+	{name: "group", in: "path", kind: "uuid", required: true},
+	{name: "id", in: "path", kind: "uuid", required: true},
+}
+
+type resetCounterParams struct {
+	Group UUID
+	Id    UUID
+}
+
+func parse_resetCounter(req Request) (resetCounterParams, error) {
+	var p resetCounterParams
+	pr := new_param_reader(req)
+
+	p.Group, _ = pr.uuid(resetCounter_params[0])
+	p.Id, _ = pr.uuid(resetCounter_params[1])
+
+	return p, pr.err()
+}
+
+var setCounterStep_params = []apiParam{
+	{name: "group", in: "path", kind: "uuid", required: true},
+	{name: "id", in: "path", kind: "uuid", required: true},
+	{name: "stepVal", in: "query", kind: "integer", required: true, description: "the new step"},
+}
+
+type setCounterStepParams struct {
+	Group   UUID
+	Id      UUID
+	StepVal int
+}
+
+func parse_setCounterStep(req Request) (setCounterStepParams, error) {
+	var p setCounterStepParams
+	pr := new_param_reader(req)
+
+	p.Group, _ = pr.uuid(setCounterStep_params[0])
+	p.Id, _ = pr.uuid(setCounterStep_params[1])
+	p.StepVal, _ = pr.integer(setCounterStep_params[2])
+
+	return p, pr.err()
+}
+
+var deleteCounter_params = []apiParam{
+	{name: "group", in: "path", kind: "uuid", required: true},
+	{name: "id", in: "path", kind: "uuid", required: true},
+}
+
+type deleteCounterParams struct {
+	Group UUID
+	Id    UUID
+}
+
+func parse_deleteCounter(req Request) (deleteCounterParams, error) {
+	var p deleteCounterParams
+	pr := new_param_reader(req)
+
+	p.Group, _ = pr.uuid(deleteCounter_params[0])
+	p.Id, _ = pr.uuid(deleteCounter_params[1])
+
+	return p, pr.err()
+}
+
+var setCounterLabels_params = []apiParam{
+	{name: "group", in: "path", kind: "uuid", required: true},
+	{name: "id", in: "path", kind: "uuid", required: true},
+	{name: "labels", in: "query", kind: "string", list: true, description: "the counter's labels, e.g. env=prod,team=infra.  None takes them all off"},
+}
+
+type setCounterLabelsParams struct {
+	Group  UUID
+	Id     UUID
+	Labels []string
+}
+
+func parse_setCounterLabels(req Request) (setCounterLabelsParams, error) {
+	var p setCounterLabelsParams
+	pr := new_param_reader(req)
+
+	p.Group, _ = pr.uuid(setCounterLabels_params[0])
+	p.Id, _ = pr.uuid(setCounterLabels_params[1])
+	p.Labels = pr.strs(setCounterLabels_params[2])
+
+	return p, pr.err()
+}
+
+var setCounterMeta_params = []apiParam{
+	{name: "group", in: "path", kind: "uuid", required: true},
+	{name: "id", in: "path", kind: "uuid", required: true},
+	{name: "description", in: "query", kind: "string", max: limit(1024), description: "what the counter counts"},
+	{name: "unit", in: "query", kind: "string", max: limit(32), description: "e.g. cups, incidents or ms"},
+	{name: "color", in: "query", kind: "string", max: limit(7), description: "#rrggbb to show the counter in"},
+	{name: "icon", in: "query", kind: "string", max: limit(64), description: "name of an icon to show the counter with"},
+	{name: "numberFormat", in: "query", kind: "enum", values: []string{"plain", "grouped", "compact", "percent", "duration"}, description: "how to write the count"},
+}
+
+type setCounterMetaParams struct {
+	Group        UUID
+	Id           UUID
+	Description  string
+	Unit         string
+	Color        string
+	Icon         string
+	NumberFormat string
+}
+
+func parse_setCounterMeta(req Request) (setCounterMetaParams, error) {
+	var p setCounterMetaParams
+	pr := new_param_reader(req)
+
+	p.Group, _ = pr.uuid(setCounterMeta_params[0])
+	p.Id, _ = pr.uuid(setCounterMeta_params[1])
+	p.Description, _ = pr.str(setCounterMeta_params[2])
+	p.Unit, _ = pr.str(setCounterMeta_params[3])
+	p.Color, _ = pr.str(setCounterMeta_params[4])
+	p.Icon, _ = pr.str(setCounterMeta_params[5])
+	p.NumberFormat, _ = pr.str(setCounterMeta_params[6])
+
+	return p, pr.err()
+}
+
+var setCounterExpression_params = []apiParam{
+	{name: "group", in: "path", kind: "uuid", required: true},
+	{name: "id", in: "path", kind: "uuid", required: true},
+	{name: "expression", in: "body", kind: "string", required: true, min: limit(1), max: limit(1024), description: "numbers, names of the group's counters, sum(labels), + - * / and brackets"},
+}
+
+type setCounterExpressionParams struct {
+	Group      UUID
+	Id         UUID
+	Expression string
+}
+
+func parse_setCounterExpression(req Request) (setCounterExpressionParams, error) {
+	var p setCounterExpressionParams
+	pr := new_param_reader(req)
+
+	p.Group, _ = pr.uuid(setCounterExpression_params[0])
+	p.Id, _ = pr.uuid(setCounterExpression_params[1])
+	p.Expression, _ = pr.str(setCounterExpression_params[2])
+
+	return p, pr.err()
+}
+
+var listGroupLabels_params = []apiParam{
+	{name: "group", in: "path", kind: "uuid", required: true},
+}
+
+type listGroupLabelsParams struct {
+	Group UUID
+}
+
+func parse_listGroupLabels(req Request) (listGroupLabelsParams, error) {
+	var p listGroupLabelsParams
+	pr := new_param_reader(req)
+
+	p.Group, _ = pr.uuid(listGroupLabels_params[0])
+
+	return p, pr.err()
+}
+
+var exportGroup_params = []apiParam{
+	{name: "group", in: "path", kind: "uuid", required: true},
+	{name: "format", in: "query", kind: "enum", values: []string{"json", "csv"}, description: "json if not given"},
+}
+
+type exportGroupParams struct {
+	Group  UUID
+	Format string
+}
+
+func parse_exportGroup(req Request) (exportGroupParams, error) {
+	var p exportGroupParams
+	pr := new_param_reader(req)
+
+	p.Group, _ = pr.uuid(exportGroup_params[0])
+	p.Format, _ = pr.str(exportGroup_params[1])
+
+	return p, pr.err()
+}
+
+var importGroup_params = []apiParam{
+	{name: "group", in: "path", kind: "uuid", required: true},
+	{name: "format", in: "query", kind: "enum", values: []string{"json", "csv"}, description: "what the body is, json if not given"},
+	{name: "ids", in: "query", kind: "enum", values: []string{"new", "preserve"}, description: "new ids for the counters, or the ones in the export.  new if not given"},
+	{name: "mode", in: "query", kind: "enum", values: []string{"dry-run", "commit"}, description: "dry-run if not given"},
+}
+
+type importGroupParams struct {
+	Group  UUID
+	Format string
+	Ids    string
+	Mode   string
+}
+
+func parse_importGroup(req Request) (importGroupParams, error) {
+	var p importGroupParams
+	pr := new_param_reader(req)
+
+	p.Group, _ = pr.uuid(importGroup_params[0])
+	p.Format, _ = pr.str(importGroup_params[1])
+	p.Ids, _ = pr.str(importGroup_params[2])
+	p.Mode, _ = pr.str(importGroup_params[3])
+
+	return p, pr.err()
+}
+
+var cloneGroup_params = []apiParam{
+	{name: "group", in: "path", kind: "uuid", required: true},
+	{name: "name", in: "query", kind: "string", required: true, min: limit(1), max: limit(128), description: "the new group's name"},
+	{name: "values", in: "query", kind: "enum", values: []string{"keep", "zero"}, description: "keep the counts, or start the counters at their baseline.  keep if not given"},
+}
+
+type cloneGroupParams struct {
+	Group  UUID
+	Name   string
+	Values string
+}
+
+func parse_cloneGroup(req Request) (cloneGroupParams, error) {
+	var p cloneGroupParams
+	pr := new_param_reader(req)
+
+	p.Group, _ = pr.uuid(cloneGroup_params[0])
+	p.Name, _ = pr.str(cloneGroup_params[1])
+	p.Values, _ = pr.str(cloneGroup_params[2])
+
+	return p, pr.err()
+}
+
+var createTemplate_params = []apiParam{
+	{name: "group", in: "path", kind: "uuid", required: true},
+	{name: "name", in: "query", kind: "string", required: true, min: limit(1), max: limit(128)},
+}
+
+type createTemplateParams struct {
+	Group UUID
+	Name  string
+}
+
+func parse_createTemplate(req Request) (createTemplateParams, error) {
+	var p createTemplateParams
+	pr := new_param_reader(req)
+
+	p.Group, _ = pr.uuid(createTemplate_params[0])
+	p.Name, _ = pr.str(createTemplate_params[1])
+
+	return p, pr.err()
+}
+
+var getTemplate_params = []apiParam{
+	{name: "id", in: "path", kind: "uuid", required: true},
+}
+
+type getTemplateParams struct {
+	Id UUID
+}
+
+func parse_getTemplate(req Request) (getTemplateParams, error) {
+	var p getTemplateParams
+	pr := new_param_reader(req)
+
+	p.Id, _ = pr.uuid(getTemplate_params[0])
+
+	return p, pr.err()
+}
+
+var deleteTemplate_params = []apiParam{
+	{name: "id", in: "path", kind: "uuid", required: true},
+}
+
+type deleteTemplateParams struct {
+	Id UUID
+}
+
+func parse_deleteTemplate(req Request) (deleteTemplateParams, error) {
+	var p deleteTemplateParams
+	pr := new_param_reader(req)
+
+	p.Id, _ = pr.uuid(deleteTemplate_params[0])
+
+	return p, pr.err()
+}
+
+var createGroupFromTemplate_params = []apiParam{
+	{name: "id", in: "path", kind: "uuid", required: true},
+	{name: "name", in: "query", kind: "string", required: true, min: limit(1), max: limit(128), description: "the new group's name"},
+}
+
+type createGroupFromTemplateParams struct {
+	Id   UUID
+	Name string
+}
+
+func parse_createGroupFromTemplate(req Request) (createGroupFromTemplateParams, error) {
+	var p createGroupFromTemplateParams
+	pr := new_param_reader(req)
+
+	p.Id, _ = pr.uuid(createGroupFromTemplate_params[0])
+	p.Name, _ = pr.str(createGroupFromTemplate_params[1])
+
+	return p, pr.err()
+}
+
+var listWebhooks_params = []apiParam{
+	{name: "group", in: "path", kind: "uuid", required: true},
+}
+
+type listWebhooksParams struct {
+	Group UUID
+}
+
+func parse_listWebhooks(req Request) (listWebhooksParams, error) {
+	var p listWebhooksParams
+	pr := new_param_reader(req)
+
+	p.Group, _ = pr.uuid(listWebhooks_params[0])
+
+	return p, pr.err()
+}
+
+var getWebhook_params = []apiParam{
+	{name: "group", in: "path", kind: "uuid", required: true},
+	{name: "id", in: "path", kind: "uuid", required: true},
+}
+
+type getWebhookParams struct {
+	Group UUID
+	Id    UUID
+}
+
+func parse_getWebhook(req Request) (getWebhookParams, error) {
+	var p getWebhookParams
+	pr := new_param_reader(req)
+
+	p.Group, _ = pr.uuid(getWebhook_params[0])
+	p.Id, _ = pr.uuid(getWebhook_params[1])
+
+	return p, pr.err()
+}
+
+var createWebhook_params = []apiParam{
+	{name: "group", in: "path", kind: "uuid", required: true},
+	{name: "url", in: "query", kind: "string", required: true, max: limit(2048), description: "https URL to post events to, which must not be a private address"},
+	{name: "events", in: "query", kind: "enum", required: true, list: true, values: []string{"create", "increment", "decrement", "reset", "step", "update", "delete", "threshold", "*"}, description: "comma separated events to send"},
+	{name: "secret", in: "query", kind: "string", max: limit(256), description: "signs each delivery"},
+	{name: "counter", in: "query", kind: "uuid", description: "only this counter's events"},
+	{name: "threshold", in: "query", kind: "integer", description: "the value threshold events fire at"},
+}
+
+type createWebhookParams struct {
+	Group     UUID
+	Url       string
+	Events    []string
+	Secret    string
+	Counter   *UUID
+	Threshold *int
+}
+
+func parse_createWebhook(req Request) (createWebhookParams, error) {
+	var p createWebhookParams
+	pr := new_param_reader(req)
+
+	p.Group, _ = pr.uuid(createWebhook_params[0])
+	p.Url, _ = pr.str(createWebhook_params[1])
+	p.Events = pr.strs(createWebhook_params[2])
+	p.Secret, _ = pr.str(createWebhook_params[3])
+	if v, found := pr.uuid(createWebhook_params[4]); found {
+		p.Counter = &v
+	}
+	if v, found := pr.integer(createWebhook_params[5]); found {
+		p.Threshold = &v
+	}
+
+	return p, pr.err()
+}
+
+var deleteWebhook_params = []apiParam{
+	{name: "group", in: "path", kind: "uuid", required: true},
+	{name: "id", in: "path", kind: "uuid", required: true},
+}
+
+type deleteWebhookParams struct {
+	Group UUID
+	Id    UUID
+}
+
+func parse_deleteWebhook(req Request) (deleteWebhookParams, error) {
+	var p deleteWebhookParams
+	pr := new_param_reader(req)
+
+	p.Group, _ = pr.uuid(deleteWebhook_params[0])
+	p.Id, _ = pr.uuid(deleteWebhook_params[1])
+
+	return p, pr.err()
+}
+
+var listWebhookDeliveries_params = []apiParam{
+	{name: "group", in: "path", kind: "uuid", required: true},
+	{name: "id", in: "path", kind: "uuid", required: true},
+}
+
+type listWebhookDeliveriesParams struct {
+	Group UUID
+	Id    UUID
+}
+
+func parse_listWebhookDeliveries(req Request) (listWebhookDeliveriesParams, error) {
+	var p listWebhookDeliveriesParams
+	pr := new_param_reader(req)
+
+	p.Group, _ = pr.uuid(listWebhookDeliveries_params[0])
+	p.Id, _ = pr.uuid(listWebhookDeliveries_params[1])
+
+	return p, pr.err()
+}
+
+var listGroupsV2_params = []apiParam{
+	{name: "limit", in: "query", kind: "integer", min: limit(1), max: limit(100), description: "items on a page, 50 if not given"},
+	{name: "cursor", in: "query", kind: "uuid", description: "next from the page before"},
+}
+
+type listGroupsV2Params struct {
+	Limit  *int
+	Cursor *UUID
+}
+
+func parse_listGroupsV2(req Request) (listGroupsV2Params, error) {
+	var p listGroupsV2Params
+	pr := new_param_reader(req)
+
+	if v, found := pr.integer(listGroupsV2_params[0]); found {
+		p.Limit = &v
+	}
+	if v, found := pr.uuid(listGroupsV2_params[1]); found {
+		p.Cursor = &v
+	}
+
+	return p, pr.err()
+}
+
+var createGroupV2_params = []apiParam{
+	{name: "name", in: "body", kind: "string", required: true, min: limit(1), max: limit(128)},
+}
+
+type createGroupV2Params struct {
+	Name string
+}
+
+func parse_createGroupV2(req Request) (createGroupV2Params, error) {
+	var p createGroupV2Params
+	pr := new_param_reader(req)
+
+	p.Name, _ = pr.str(createGroupV2_params[0])
+
+	return p, pr.err()
+}
+
+var getGroupV2_params = []apiParam{
+	{name: "group", in: "path", kind: "uuid", required: true},
+}
+
+type getGroupV2Params struct {
+	Group UUID
+}
+
+func parse_getGroupV2(req Request) (getGroupV2Params, error) {
+	var p getGroupV2Params
+	pr := new_param_reader(req)
+
+	p.Group, _ = pr.uuid(getGroupV2_params[0])
+
+	return p, pr.err()
+}
+
+var listCountersV2_params = []apiParam{
+	{name: "group", in: "path", kind: "uuid", required: true},
+	{name: "limit", in: "query", kind: "integer", min: limit(1), max: limit(100), description: "items on a page, 50 if not given"},
+	{name: "cursor", in: "query", kind: "uuid", description: "next from the page before"},
+	{name: "label", in: "query", kind: "string", list: true, description: "only the counters with these labels, e.g. env=prod,team!=infra,owner"},
+}
+
+type listCountersV2Params struct {
+	Group  UUID
+	Limit  *int
+	Cursor *UUID
+	Label  []string
+}
+
+func parse_listCountersV2(req Request) (listCountersV2Params, error) {
+	var p listCountersV2Params
+	pr := new_param_reader(req)
+
+	p.Group, _ = pr.uuid(listCountersV2_params[0])
+	if v, found := pr.integer(listCountersV2_params[1]); found {
+		p.Limit = &v
+	}
+	if v, found := pr.uuid(listCountersV2_params[2]); found {
+		p.Cursor = &v
+	}
+	p.Label = pr.strs(listCountersV2_params[3])
+
+	return p, pr.err()
+}
+
+var createCounterV2_params = []apiParam{
+	{name: "group", in: "path", kind: "uuid", required: true},
+	{name: "name", in: "body", kind: "string", required: true, min: limit(1), max: limit(128)},
+	{name: "shards", in: "body", kind: "integer", min: limit(0), max: limit(32), description: "number of items to spread the count over, up to 32"},
+	{name: "description", in: "body", kind: "string", max: limit(1024), description: "what the counter counts"},
+	{name: "unit", in: "body", kind: "string", max: limit(32), description: "e.g. cups, incidents or ms"},
+	{name: "color", in: "body", kind: "string", max: limit(7), description: "#rrggbb to show the counter in"},
+	{name: "icon", in: "body", kind: "string", max: limit(64), description: "name of an icon to show the counter with"},
+	{name: "numberFormat", in: "body", kind: "enum", values: []string{"plain", "grouped", "compact", "percent", "duration"}, description: "how to write the count"},
+	{name: "value", in: "body", kind: "integer", description: "the count to start at, the baseline if not given"},
+	{name: "step", in: "body", kind: "integer", description: "what an increment adds, 1 if not given"},
+	{name: "baseline", in: "body", kind: "integer", description: "what a reset goes back to, 0 if not given"},
+	{name: "min", in: "body", kind: "integer", description: "the least the count can be taken down to"},
+	{name: "max", in: "body", kind: "integer", description: "the most the count can be taken up to"},
+	{name: "expression", in: "body", kind: "string", max: limit(1024), description: "makes a derived counter, e.g. passed / (passed + failed) or sum(env=prod)"},
+}
+
+type createCounterV2Params struct {
+	Group        UUID
+	Name         string
+	Shards       *int
+	Description  string
+	Unit         string
+	Color        string
+	Icon         string
+	NumberFormat string
+	Value        *int
+	Step         *int
+	Baseline     *int
+	Min          *int
+	Max          *int
+	Expression   string
+}
+
+func parse_createCounterV2(req Request) (createCounterV2Params, error) {
+	var p createCounterV2Params
+	pr := new_param_reader(req)
+
+	p.Group, _ = pr.uuid(createCounterV2_params[0])
+	p.Name, _ = pr.str(createCounterV2_params[1])
+	if v, found := pr.integer(createCounterV2_params[2]); found {
+		p.Shards = &v
+	}
+	p.Description, _ = pr.str(createCounterV2_params[3])
+	p.Unit, _ = pr.str(createCounterV2_params[4])
+	p.Color, _ = pr.str(createCounterV2_params[5])
+	p.Icon, _ = pr.str(createCounterV2_params[6])
+	p.NumberFormat, _ = pr.str(createCounterV2_params[7])
+	if v, found := pr.integer(createCounterV2_params[8]); found {
+		p.Value = &v
+	}
+	if v, found := pr.integer(createCounterV2_params[9]); found {
+		p.Step = &v
+	}
+	if v, found := pr.integer(createCounterV2_params[10]); found {
+		p.Baseline = &v
+	}
+	if v, found := pr.integer(createCounterV2_params[11]); found {
+		p.Min = &v
+	}
+	if v, found := pr.integer(createCounterV2_params[12]); found {
+		p.Max = &v
+	}
+	p.Expression, _ = pr.str(createCounterV2_params[13])
+
+	return p, pr.err()
+}
+
+var getCounterV2_params = []apiParam{
+	{name: "group", in: "path", kind: "uuid", required: true},
+	{name: "id", in: "path", kind: "uuid", required: true},
+}
+
+type getCounterV2Params struct {
+	Group UUID
+	Id    UUID
+}
+
+func parse_getCounterV2(req Request) (getCounterV2Params, error) {
+	var p getCounterV2Params
+	pr := new_param_reader(req)
+
+	p.Group, _ = pr.uuid(getCounterV2_params[0])
+	p.Id, _ = pr.uuid(getCounterV2_params[1])
+
+	return p, pr.err()
+}
+
+var updateCounterV2_params = []apiParam{
+	{name: "group", in: "path", kind: "uuid", required: true},
+	{name: "id", in: "path", kind: "uuid", required: true},
+	{name: "step", in: "body", kind: "integer", required: true, description: "the new step"},
+}
+
+type updateCounterV2Params struct {
+	Group UUID
+	Id    UUID
+	Step  int
+}
+
+func parse_updateCounterV2(req Request) (updateCounterV2Params, error) {
+	var p updateCounterV2Params
+	pr := new_param_reader(req)
+
+	p.Group, _ = pr.uuid(updateCounterV2_params[0])
+	p.Id, _ = pr.uuid(updateCounterV2_params[1])
+	p.Step, _ = pr.integer(updateCounterV2_params[2])
+
+	return p, pr.err()
+}
+
+var deleteCounterV2_params = []apiParam{
+	{name: "group", in: "path", kind: "uuid", required: true},
+	{name: "id", in: "path", kind: "uuid", required: true},
+}
+
+type deleteCounterV2Params struct {
+	Group UUID
+	Id    UUID
+}
+
+func parse_deleteCounterV2(req Request) (deleteCounterV2Params, error) {
+	var p deleteCounterV2Params
+	pr := new_param_reader(req)
+
+	p.Group, _ = pr.uuid(deleteCounterV2_params[0])
+	p.Id, _ = pr.uuid(deleteCounterV2_params[1])
+
+	return p, pr.err()
+}
+
+var incCounterV2_params = []apiParam{
+	{name: "group", in: "path", kind: "uuid", required: true},
+	{name: "id", in: "path", kind: "uuid", required: true},
+}
+
+type incCounterV2Params struct {
+	Group UUID
+	Id    UUID
+}
+
+func parse_incCounterV2(req Request) (incCounterV2Params, error) {
+	var p incCounterV2Params
+	pr := new_param_reader(req)
+
+	p.Group, _ = pr.uuid(incCounterV2_params[0])
+	p.Id, _ = pr.uuid(incCounterV2_params[1])
+
+	return p, pr.err()
+}
+
+var decCounterV2_params = []apiParam{
+	{name: "group", in: "path", kind: "uuid", required: true},
+	{name: "id", in: "path", kind: "uuid", required: true},
+}
+
+type decCounterV2Params struct {
+	Group UUID
+	Id    UUID
+}
+
+func parse_decCounterV2(req Request) (decCounterV2Params, error) {
+	var p decCounterV2Params
+	pr := new_param_reader(req)
+
+	p.Group, _ = pr.uuid(decCounterV2_params[0])
+	p.Id, _ = pr.uuid(decCounterV2_params[1])
+
+	return p, pr.err()
+}
+
+var resetCounterV2_params = []apiParam{
+	{name: "group", in: "path", kind: "uuid", required: true},
+	{name: "id", in: "path", kind: "uuid", required: true},
+}
+
+type resetCounterV2Params struct {
+	Group UUID
+	Id    UUID
+}
+
+func parse_resetCounterV2(req Request) (resetCounterV2Params, error) {
+	var p resetCounterV2Params
+	pr := new_param_reader(req)
+
+	p.Group, _ = pr.uuid(resetCounterV2_params[0])
+	p.Id, _ = pr.uuid(resetCounterV2_params[1])
+
+	return p, pr.err()
+}
+
+var getAPIKey_params = []apiParam{
+	{name: "id", in: "path", kind: "uuid", required: true},
+}
+
+type getAPIKeyParams struct {
+	Id UUID
+}
+
+func parse_getAPIKey(req Request) (getAPIKeyParams, error) {
+	var p getAPIKeyParams
+	pr := new_param_reader(req)
+
+	p.Id, _ = pr.uuid(getAPIKey_params[0])
+
+	return p, pr.err()
+}
+
+var createAPIKey_params = []apiParam{
+	{name: "name", in: "path", kind: "string", required: true, min: limit(1), max: limit(128)},
+	{name: "group", in: "query", kind: "uuid", required: true},
+	{name: "rights", in: "query", kind: "enum", required: true, list: true, values: []string{"read", "inc", "dec", "config", "admin", "create", "delete"}, description: "comma separated rights the key has"},
+	{name: "counters", in: "query", kind: "uuid", list: true, description: "comma separated counters the key is limited to"},
+}
+
+type createAPIKeyParams struct {
+	Name     string
+	Group    UUID
+	Rights   []string
+	Counters []UUID
+}
+
+func parse_createAPIKey(req Request) (createAPIKeyParams, error) {
+	var p createAPIKeyParams
+	pr := new_param_reader(req)
+
+	p.Name, _ = pr.str(createAPIKey_params[0])
+	p.Group, _ = pr.uuid(createAPIKey_params[1])
+	p.Rights = pr.strs(createAPIKey_params[2])
+	p.Counters = pr.uuids(createAPIKey_params[3])
+
+	return p, pr.err()
+}
+
+var rotateAPIKey_params = []apiParam{
+	{name: "id", in: "path", kind: "uuid", required: true},
+}
+
+type rotateAPIKeyParams struct {
+	Id UUID
+}
+
+func parse_rotateAPIKey(req Request) (rotateAPIKeyParams, error) {
+	var p rotateAPIKeyParams
+	pr := new_param_reader(req)
+
+	p.Id, _ = pr.uuid(rotateAPIKey_params[0])
+
+	return p, pr.err()
+}
+
+var revokeAPIKey_params = []apiParam{
+	{name: "id", in: "path", kind: "uuid", required: true},
+}
+
+type revokeAPIKeyParams struct {
+	Id UUID
+}
+
+func parse_revokeAPIKey(req Request) (revokeAPIKeyParams, error) {
+	var p revokeAPIKeyParams
+	pr := new_param_reader(req)
+
+	p.Id, _ = pr.uuid(revokeAPIKey_params[0])
+
+	return p, pr.err()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"slices"
+	"testing"
+
+	"myhello/apigen"
+)
+
+// api_handlers.go is what go generate makes of api.yaml now
+func TestGeneratedRoutes(t *testing.T) {
+	spec, err := apigen.Load("../api/api.yaml")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkg, err := apigen.ScanPackage(".", "api_handlers.go")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := spec.Validate(pkg); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := spec.GoSource("api/api.yaml")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if current, _ := os.ReadFile("api_handlers.go"); !bytes.Equal(current, src) {
+		t.Error("api_handlers.go is out of date with api/api.yaml, run go generate")
+	}
+}
+
+// the enums in api.yaml are the rights and events the Go code knows
+func TestParamEnums(t *testing.T) {
+	spec, err := apigen.Load("../api/api.yaml")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkg, err := apigen.ScanPackage(".", "api_handlers.go")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range spec.Private.Endpoints {
+		for _, p := range e.Params {
+			switch p.Name {
+			case "rights":
+				rights := slices.Clone(p.Values)
+				slices.Sort(rights)
+
+				if !slices.Equal(rights, pkg.Rights) {
+					t.Errorf("%s rights are %v, not %v", e.Route(), p.Values, pkg.Rights)
+				}
+			case "events":
+				if !slices.Equal(p.Values, webhook_events) {
+					t.Errorf("%s events are %v, not %v", e.Route(), p.Values, webhook_events)
+				}
+			}
+		}
+	}
+}
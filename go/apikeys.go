@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// API keys let machine clients such as CI pipelines use the private API
+// without a cognito login.  A key is scoped to one group, optionally to some
+// of the counters in it, and to a subset of the perm_* rights.
+// Keys look like ocd_<key uuid>_<secret> and only a hash of them is stored.
+// Only a logged in user can make keys, so a key can't make another.
+
+const apiKeyPrefix = "ocd"
+
+// API gateway lower cases header names
+const apiKeyHeader = "x-api-key"
+
+// requests with an API key come in on this route, which has no JWT authorizer.
+// The real route is found from the rest of the path.
+const keyProxyRoute = "ANY /key/{proxy+}"
+
+func make_api_key(keyId UUID) (string, error) {
+	secret := make([]byte, 32)
+
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s_%s_%s", apiKeyPrefix, keyId.String(), base64.RawURLEncoding.EncodeToString(secret)), nil
+}
+
+func parse_api_key(key string) (UUID, error) {
+	parts := strings.SplitN(key, "_", 3)
+
+	if len(parts) != 3 || parts[0] != apiKeyPrefix || parts[2] == "" {
+		return NullUUID(), fmt.Errorf("malformed API key")
+	}
+
+	return ToUUID(parts[1])
+}
+
+func hash_api_key(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func api_key_header(req Request) (string, bool) {
+	key, found := req.Headers[apiKeyHeader]
+	return key, found && key != ""
+}
+
+// turns a request on the key proxy route into a request on the route it is for.
+func resolve_key_route(req Request) (Request, bool) {
+	path := "/" + req.PathParameters["proxy"]
+
+	route, params, found := match_route(private_handlers, req.RequestContext.HTTP.Method, path)
+
+	if !found || route == keyProxyRoute {
+		return req, false
+	}
+
+	req.RouteKey = route
+	req.RawPath = path
+	req.PathParameters = params
+
+	return req, true
+}
+
+// keys can only use routes which need one of their rights, and if the key
+// names counters then only the routes about one counter, on those counters.
+// Cognito sessions are not limited.
+func (s APISession) Allows(right string, req Request) bool {
+	if s.apiKey == nil {
+		return true
+	}
+
+	if right == "" || !slices.Contains(s.apiKey.Rights, right) {
+		return false
+	}
+
+	if len(s.apiKey.Counters) == 0 {
+		return true
+	}
+
+	param, scoped := private_counters[req.RouteKey]
+
+	return scoped && slices.Contains(s.apiKey.Counters, req.PathParameters[param])
+}
+
+// a key limited to some counters can only give a derived counter an
+// expression of those counters.  sum() could add up any counter in the
+// group, so it can't use that either.
+func key_expression_scope(key *APIKeyData, node exprNode, refs map[string]string) error {
+	if key == nil || len(key.Counters) == 0 {
+		return nil
+	}
+
+	if has_sum(node) {
+		return bad_request("the key can't sum counters outside its own")
+	}
+
+	for name, id := range refs {
+		if !slices.Contains(key.Counters, id) {
+			return bad_request("the key can't use counter %s", name)
+		}
+	}
+
+	return nil
+}
+
+// key handed back to the caller on creation and rotation.  It can't be read again after this.
+type apiKeyResult struct {
+	opResult
+	Key string
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestAPIKeyFormat(t *testing.T) {
+	keyId := MakeUUID()
+
+	key, err := make_api_key(keyId)
+
+	checkError(t, err, nil)
+
+	if !strings.HasPrefix(key, "ocd_"+keyId.String()+"_") {
+		t.Errorf("Key %s does not start with its id", key)
+	}
+
+	if pid, perr := parse_api_key(key); perr != nil || pid != keyId {
+		t.Errorf("Parsed key id %s not %s: %s", pid, keyId, perr)
+	}
+
+	other, _ := make_api_key(keyId)
+
+	if other == key || hash_api_key(other) == hash_api_key(key) {
+		t.Error("Two keys for the same id are the same")
+	}
+
+	for _, bad := range []string{"", "ocd", "ocd_" + keyId.String(), "xyz_" + keyId.String() + "_abc", "ocd_notauuid_abc"} {
+		if _, perr := parse_api_key(bad); perr == nil {
+			t.Errorf("Malformed key %s parsed", bad)
+		}
+	}
+}
+
+func TestMatchRoute(t *testing.T) {
+	routes := map[string]int{
+		"GET /api/v1/group/{group}/counter":             1,
+		"GET /api/v1/group/{group}/counter/{id}":        2,
+		"POST /api/v1/group/{group}/counter/{name}":     3,
+		"POST /api/v1/group/{group}/counter/{id}/reset": 4,
+		"POST /api/v1/group/{group}/counter/new":        5,
+		"ANY /key/{proxy+}":                             6,
+	}
+
+	check := func(method string, path string, expRoute string, expParams map[string]string) {
+		route, params, found := match_route(routes, method, path)
+
+		if expRoute == "" {
+			if found {
+				t.Errorf("%s %s matched %s", method, path, route)
+			}
+			return
+		}
+
+		if route != expRoute {
+			t.Errorf("%s %s matched %s not %s", method, path, route, expRoute)
+		}
+
+		for k, v := range expParams {
+			if params[k] != v {
+				t.Errorf("%s %s parameter %s is %s not %s", method, path, k, params[k], v)
+			}
+		}
+	}
+
+	check("GET", "/api/v1/group/g1/counter", "GET /api/v1/group/{group}/counter", map[string]string{"group": "g1"})
+	check("GET", "/api/v1/group/g1/counter/c1", "GET /api/v1/group/{group}/counter/{id}", map[string]string{"group": "g1", "id": "c1"})
+	check("POST", "/api/v1/group/g1/counter/c1/reset", "POST /api/v1/group/{group}/counter/{id}/reset", map[string]string{"id": "c1"})
+	check("POST", "/api/v1/group/g1/counter/new", "POST /api/v1/group/{group}/counter/new", nil)
+	check("POST", "/api/v1/group/g1/counter/coffee", "POST /api/v1/group/{group}/counter/{name}", map[string]string{"name": "coffee"})
+	check("DELETE", "/key/a/b/c", "ANY /key/{proxy+}", map[string]string{"proxy": "a/b/c"})
+	check("DELETE", "/api/v1/group/g1/counter", "", nil)
+	check("GET", "/api/v1/group//counter", "", nil)
+	check("GET", "/api/v1/group/g1/counter/c1/extra", "", nil)
+}
+
+func keyRequest(method string, path string, key string) Request {
+	return Request{
+		RouteKey: keyProxyRoute,
+		Headers: map[string]string{
+			apiKeyHeader: key,
+		},
+		PathParameters: map[string]string{
+			"proxy": strings.TrimPrefix(path, "/"),
+		},
+		RequestContext: events.APIGatewayV2HTTPRequestContext{
+			HTTP: events.APIGatewayV2HTTPRequestContextHTTPDescription{
+				Method: method,
+			},
+		},
+	}
+}
+
+func TestKeyDispatch(t *testing.T) {
+	group := MakeUUID()
+	counter := MakeUUID()
+	other := MakeUUID()
+
+	dbo := MockDataOperator{
+		apiKey: APIKeyData{
+			UserId:   MakeUUID().String(),
+			GroupId:  group.String(),
+			Counters: []string{counter.String()},
+			Rights:   []string{perm_inc, perm_read},
+		},
+	}
+
+	api := APIHandler{dbo: &dbo}
+
+	check := func(method string, path string, expCode int, expCall string) {
+		dbo.funcName = nil
+
+		res, err := api.private_handler_gatewayv2(context.TODO(), keyRequest(method, path, "ocd_key"))
+
+		checkError(t, err, nil)
+
+		if res.StatusCode != expCode {
+			t.Errorf("%s %s returned %d (%s) not %d", method, path, res.StatusCode, res.Body, expCode)
+		}
+
+		if expCall != "" && dbo.funcName[len(dbo.funcName)-1] != expCall {
+			t.Errorf("%s %s called %v not %s", method, path, dbo.funcName, expCall)
+		}
+	}
+
+	prefix := "/api/v1/group/" + group.String() + "/counter"
+
+	check("POST", prefix+"/"+counter.String()+"/increment", 200, "CounterUpdate")
+	check("GET", prefix+"/"+counter.String(), 200, "CounterRead")
+
+	// a key scoped to counters can't use the routes about the whole group
+	check("GET", prefix, 404, "")
+	check("GET", "/api/v1/group/"+group.String()+"/labels", 404, "")
+	check("GET", "/api/v2/groups/"+group.String()+"/counters", 404, "")
+
+	// no right
+	check("POST", prefix+"/"+counter.String()+"/decrement", 404, "")
+	check("GET", "/api/v1/apikey", 404, "")
+
+	// not one of the key's counters
+	check("POST", prefix+"/"+other.String()+"/increment", 404, "")
+
+	// not the key's group
+	check("GET", "/api/v1/group/"+other.String()+"/counter", 404, "")
+
+	// no such route
+	check("GET", "/api/v1/nothing", 404, "")
+
+	// an unscoped key can
+	dbo.apiKey.Counters = nil
+
+	check("GET", prefix, 200, "CounterList")
+
+	// no key, no JWT
+	res, _ := api.private_handler_gatewayv2(context.TODO(), keyRequest("GET", prefix, ""))
+
+	if res.StatusCode != 404 || res.Body != "UNAUTHORIZED HANDLER" {
+		t.Errorf("Request with no credentials returned %d %s", res.StatusCode, res.Body)
+	}
+}
@@ -4,81 +4,152 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
-	"strconv"
+	"net"
+	"net/url"
+	"slices"
 )
 
-func incCounter(ctx context.Context, req Request, dbo DataOperator, s Session) (Response, error) {
-	if counterId, cerr := ToUUID(req.PathParameters["id"]); cerr != nil {
-		return makeerror(cerr)
-	} else {
-		return dbo.CounterUpdate(s, counterId, dnquery(dq_current, dq_inc), 1)
-	}
+func incCounter(ctx context.Context, req Request, dbo DataOperator, s Session, p incCounterParams) (Response, error) {
+	return dbo.CounterUpdate(ctx, s, p.Id, dnquery(dq_current, dq_inc), 1)
 }
 
-func decCounter(ctx context.Context, req Request, dbo DataOperator, s Session) (Response, error) {
-	if counterId, cerr := ToUUID(req.PathParameters["id"]); cerr != nil {
-		return makeerror(cerr)
-	} else {
-		return dbo.CounterUpdate(s, counterId, dnquery(dq_current, dq_dec), 1)
-	}
+func decCounter(ctx context.Context, req Request, dbo DataOperator, s Session, p decCounterParams) (Response, error) {
+	return dbo.CounterUpdate(ctx, s, p.Id, dnquery(dq_current, dq_dec), 1)
 }
 
-func getCounter(ctx context.Context, req Request, dbo DataOperator, s Session) (Response, error) {
-	if counterId, cerr := ToUUID(req.PathParameters["id"]); cerr != nil {
+func getCounter(ctx context.Context, req Request, dbo DataOperator, s Session, p getCounterParams) (Response, error) {
+	return dbo.CounterRead(ctx, s, p.Id)
+}
+
+func setCounterStep(ctx context.Context, req Request, dbo DataOperator, s Session, p setCounterStepParams) (Response, error) {
+	logger(ctx).Debug("step change", "stepVal", p.StepVal)
+	return dbo.CounterUpdate(ctx, s, p.Id, dnquery(dq_init, dq_current), p.StepVal)
+}
+
+func resetCounter(ctx context.Context, req Request, dbo DataOperator, s Session, p resetCounterParams) (Response, error) {
+	return dbo.CounterUpdate(ctx, s, p.Id, dnquery(dq_current, dq_base), 1)
+}
+
+func deleteCounter(ctx context.Context, req Request, dbo DataOperator, s Session, p deleteCounterParams) (Response, error) {
+	return dbo.CounterDelete(ctx, s, p.Id)
+}
+
+func createCounter(ctx context.Context, req Request, dbo DataOperator, s Session, p createCounterParams) (Response, error) {
+	meta, err := counter_meta(p.Description, p.Unit, p.Color, p.Icon, p.NumberFormat)
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	ci := counterConfig{Name: p.Name, Shards: p.Shards, Value: p.Value, Step: p.Step, Baseline: p.Baseline, Min: p.Min, Max: p.Max, Meta: meta, Expression: p.Expression}
+	created, cerr := ci.counter()
+
+	if cerr != nil {
 		return makeerror(cerr)
-	} else {
-		return dbo.CounterRead(s, counterId)
 	}
+
+	return dbo.CounterCreate(ctx, s, created)
 }
 
-func setCounterStep(ctx context.Context, req Request, dbo DataOperator, s Session) (Response, error) {
-	if sv, sverr := strconv.Atoi(req.QueryStringParameters["stepVal"]); sverr != nil {
-		return makeerror(sverr)
-	} else {
-		log.Print("stepVal is ", sv)
-		if counterId, cerr := ToUUID(req.PathParameters["id"]); cerr != nil {
-			return makeerror(cerr)
-		} else {
-			return dbo.CounterUpdate(s, counterId, dnquery(dq_init, dq_current), sv)
-		}
+func listCounters(ctx context.Context, req Request, dbo DataOperator, s Session, p listCountersParams) (Response, error) {
+	selector, err := parse_selector(p.Label)
+
+	if err != nil {
+		return makeerror(err)
 	}
+
+	return dbo.CounterList(ctx, s, selector)
 }
 
-func resetCounter(ctx context.Context, req Request, dbo DataOperator, s Session) (Response, error) {
-	if counterId, cerr := ToUUID(req.PathParameters["id"]); cerr != nil {
-		return makeerror(cerr)
-	} else {
-		return dbo.CounterUpdate(s, counterId, dnquery(dq_current, dq_init), 1)
+func listGroups(ctx context.Context, req Request, dbo DataOperator, s Session) (Response, error) {
+	return dbo.GroupList(ctx, s)
+}
+
+func getGroup(ctx context.Context, req Request, dbo DataOperator, s Session, p getGroupParams) (Response, error) {
+	return dbo.GroupRead(ctx, s)
+}
+
+func createGroup(ctx context.Context, req Request, dbo DataOperator, s Session, p createGroupParams) (Response, error) {
+	return dbo.GroupCreate(ctx, s, p.Name)
+}
+
+func deleteGroup(ctx context.Context, req Request, dbo DataOperator, s Session, p deleteGroupParams) (Response, error) {
+	return makeerror(errors.New("NYI"))
+}
+
+func listAPIKeys(ctx context.Context, req Request, dbo DataOperator, s Session) (Response, error) {
+	return dbo.APIKeyList(ctx, s)
+}
+
+func getAPIKey(ctx context.Context, req Request, dbo DataOperator, s Session, p getAPIKeyParams) (Response, error) {
+	return dbo.APIKeyRead(ctx, s, p.Id)
+}
+
+func createAPIKey(ctx context.Context, req Request, dbo DataOperator, s Session, p createAPIKeyParams) (Response, error) {
+	var counters []string
+
+	for _, c := range p.Counters {
+		counters = append(counters, c.String())
 	}
+
+	return dbo.APIKeyCreate(ctx, s, p.Name, p.Group, counters, p.Rights)
 }
 
-func deleteCounter(ctx context.Context, req Request, dbo DataOperator, s Session) (Response, error) {
-	if counterId, cerr := ToUUID(req.PathParameters["id"]); cerr != nil {
-		return makeerror(cerr)
-	} else {
-		return dbo.CounterDelete(s, counterId)
+func rotateAPIKey(ctx context.Context, req Request, dbo DataOperator, s Session, p rotateAPIKeyParams) (Response, error) {
+	return dbo.APIKeyRotate(ctx, s, p.Id)
+}
+
+func revokeAPIKey(ctx context.Context, req Request, dbo DataOperator, s Session, p revokeAPIKeyParams) (Response, error) {
+	return dbo.APIKeyDelete(ctx, s, p.Id)
+}
+
+func createWebhook(ctx context.Context, req Request, dbo DataOperator, s Session, p createWebhookParams) (Response, error) {
+	if u, uerr := url.Parse(p.Url); uerr != nil {
+		return makeerror(bad_request("webhook url %s: %w", p.Url, uerr))
+	} else if u.Scheme != "https" || u.Host == "" {
+		return makeerror(bad_request("webhook url %s is not an https url", p.Url))
+	} else if ip := net.ParseIP(u.Hostname()); ip != nil && !public_address(ip) {
+		return makeerror(bad_request("webhook url %s is not a public address", p.Url))
 	}
+
+	wd := WebhookData{
+		URL:       p.Url,
+		Events:    p.Events,
+		Secret:    p.Secret,
+		Threshold: p.Threshold,
+	}
+
+	if p.Counter != nil {
+		wd.CounterId = p.Counter.String()
+	}
+
+	if slices.Contains(wd.Events, ev_threshold) && wd.Threshold == nil {
+		return makeerror(bad_request("threshold events need a threshold"))
+	}
+
+	return dbo.WebhookCreate(ctx, s, wd)
 }
 
-func createCounter(ctx context.Context, req Request, dbo DataOperator, s Session) (Response, error) {
-	return dbo.CounterCreate(s, req.PathParameters["name"])
+func getWebhook(ctx context.Context, req Request, dbo DataOperator, s Session, p getWebhookParams) (Response, error) {
+	return dbo.WebhookRead(ctx, s, p.Id)
 }
 
-func listCounters(ctx context.Context, req Request, dbo DataOperator, s Session) (Response, error) {
-	return dbo.CounterList(s)
+func listWebhooks(ctx context.Context, req Request, dbo DataOperator, s Session, p listWebhooksParams) (Response, error) {
+	return dbo.WebhookList(ctx, s)
 }
 
-func listGroups(ctx context.Context, req Request, dbo DataOperator, s Session) (Response, error) {
-	return dbo.GroupList(s)
+func deleteWebhook(ctx context.Context, req Request, dbo DataOperator, s Session, p deleteWebhookParams) (Response, error) {
+	return dbo.WebhookDelete(ctx, s, p.Id)
 }
 
-func createGroup(ctx context.Context, req Request, dbo DataOperator, s Session) (Response, error) {
-	return dbo.GroupCreate(s, req.PathParameters["name"])
+func listWebhookDeliveries(ctx context.Context, req Request, dbo DataOperator, s Session, p listWebhookDeliveriesParams) (Response, error) {
+	return dbo.WebhookDeliveries(ctx, s, p.Id)
 }
 
-func deleteGroup(ctx context.Context, req Request, dbo DataOperator, s Session) (Response, error) {
-	return makeerror(errors.New("NYI"))
+// the key proxy route is swapped for the real route before dispatch,
+// so this is never reached with a session.
+func keyProxy(ctx context.Context, req Request, dbo DataOperator, s Session, p keyProxyParams) (Response, error) {
+	return makeerror(fmt.Errorf("route %s not found", req.RawPath))
 }
 
 func unauthorizedHandler() error {
@@ -91,9 +162,20 @@ func loop(ctx context.Context, req Request, dbo DataOperator, s Session) (Respon
 
 type APIHandler struct {
 	dbo DataOperator
+
+	// nil for no metrics
+	metrics MetricsSink
 }
 
 func (api APIHandler) public_handler_gatewayv2(ctx context.Context, req Request) (Response, error) {
+	return serve_logged(ctx, req, api.metrics, api.public_handler)
+}
+
+func (api APIHandler) private_handler_gatewayv2(ctx context.Context, req Request) (Response, error) {
+	return serve_logged(ctx, req, api.metrics, api.private_handler)
+}
+
+func (api APIHandler) public_handler(ctx context.Context, req Request) (Response, error) {
 	f, found := public_handlers[req.RouteKey]
 
 	if !found {
@@ -103,21 +185,39 @@ func (api APIHandler) public_handler_gatewayv2(ctx context.Context, req Request)
 	return f(ctx, req, api.dbo)
 }
 
-func (api APIHandler) private_handler_gatewayv2(ctx context.Context, req Request) (Response, error) {
-	if req.RequestContext.Authorizer == nil {
+func (api APIHandler) private_handler(ctx context.Context, req Request) (Response, error) {
+	_, haskey := api_key_header(req)
+
+	if req.RequestContext.Authorizer == nil && !haskey {
 		return makeerror(unauthorizedHandler())
 	}
 
+	if req.RouteKey == keyProxyRoute {
+		var resolved bool
+
+		if req, resolved = resolve_key_route(req); !resolved {
+			return makeerror(fmt.Errorf("route %s not found", req.RawPath))
+		}
+
+		set_route(ctx, req.RouteKey)
+	}
+
 	f, found := private_handlers[req.RouteKey]
 
 	if !found {
 		return makeerror(fmt.Errorf("route %s not found", req.RouteKey))
 	}
-	session, serr := Create_APISession(api.dbo, req)
+	session, serr := Create_APISession(ctx, api.dbo, req)
 
 	if serr != nil {
 		return makeerror(serr)
 	}
 
+	add_log_attrs(ctx, "userId", *session.GetUserIdString(), "groupId", *session.GetGroupIdString())
+
+	if !session.Allows(private_rights[req.RouteKey], req) {
+		return makeerror(unauthorizedHandler())
+	}
+
 	return f(ctx, req, api.dbo, &session)
 }
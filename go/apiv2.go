@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+// v2 of the API takes JSON bodies in place of names in the path and
+// configuration in the query, and answers with resources and collections
+// of them rather than opResult.  It is built on the same DataOperator calls
+// as v1, whose responses are read back into the resources.
+
+const (
+	v2Prefix = "/api/v2"
+
+	// page sizes of collections, if the request doesn't give one
+	defaultPageSize = 50
+)
+
+type counterResource struct {
+	Id      string `json:"id"`
+	Name    string `json:"name"`
+	Value   int    `json:"value"`
+	Step    int    `json:"step"`
+	Group   string `json:"group"`
+	Shards  int    `json:"shards,omitempty"`
+	Version int    `json:"version"`
+
+	Baseline int  `json:"baseline,omitempty"`
+	Min      *int `json:"min,omitempty"`
+	Max      *int `json:"max,omitempty"`
+
+	Labels map[string]string `json:"labels,omitempty"`
+	Links  map[string]string `json:"links"`
+
+	// a derived counter's expression, and its value or why it has none
+	Expression   string   `json:"expression,omitempty"`
+	Derived      *float64 `json:"derived,omitempty"`
+	DerivedError string   `json:"derivedError,omitempty"`
+
+	CounterMeta
+}
+
+type groupResource struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+
+	// how many counters the group has.  They are at links.counters.
+	Counters int `json:"counters"`
+	Version  int `json:"version"`
+
+	Links map[string]string `json:"links"`
+}
+
+// a page of a collection.  next is the cursor of the page after, empty on the last.
+type collection[T any] struct {
+	Items []T               `json:"items"`
+	Next  string            `json:"next,omitempty"`
+	Links map[string]string `json:"links"`
+}
+
+func group_path(groupId string) string {
+	return v2Prefix + "/groups/" + groupId
+}
+
+func counter_path(groupId string, counterId string) string {
+	return group_path(groupId) + "/counters/" + counterId
+}
+
+func counter_resource(cd CountData) counterResource {
+	self := counter_path(cd.CounterGroup, cd.CounterId)
+
+	cr := counterResource{
+		Id:      cd.CounterId,
+		Name:    cd.CounterName,
+		Value:   cd.CounterVal,
+		Step:    cd.StepVal,
+		Group:   cd.CounterGroup,
+		Shards:  cd.Shards,
+		Version: cd.Version,
+		Labels:  cd.Labels,
+
+		Baseline:    cd.Baseline,
+		Min:         cd.MinVal,
+		Max:         cd.MaxVal,
+		CounterMeta: cd.CounterMeta,
+
+		Expression:   cd.Expression,
+		Derived:      cd.Derived,
+		DerivedError: cd.DerivedError,
+
+		Links: map[string]string{
+			"self":  self,
+			"group": group_path(cd.CounterGroup),
+		},
+	}
+
+	// a derived counter can't be changed
+	if cd.Expression == "" {
+		cr.Links["increment"] = self + "/increment"
+		cr.Links["decrement"] = self + "/decrement"
+		cr.Links["reset"] = self + "/reset"
+	}
+
+	return cr
+}
+
+func group_resource(gd GroupData) groupResource {
+	return groupResource{
+		Id:       gd.GroupId,
+		Name:     gd.GroupName,
+		Counters: len(gd.Counters),
+		Version:  gd.Version,
+		Links: map[string]string{
+			"self":     group_path(gd.GroupId),
+			"counters": group_path(gd.GroupId) + "/counters",
+		},
+	}
+}
+
+// the data in a v1 response.  If it is an error, it is given back to be
+// returned as it is.
+func response_data[T any](res Response, err error) (T, Response, bool) {
+	var data T
+
+	if err != nil || res.StatusCode != 200 {
+		return data, res, false
+	}
+
+	if uerr := json.Unmarshal([]byte(res.Body), &data); uerr != nil {
+		res, err = makeerror(uerr)
+		return data, res, false
+	}
+
+	return data, res, true
+}
+
+// the ids after cursor, up to limit of them, and the cursor of the next
+// page if there are more.  A cursor is the id of the last item on the page
+// before, and ids are in order so that cursors stay put as items come and go.
+func page(ids []string, limit *int, after *UUID) ([]string, string) {
+	size := defaultPageSize
+	cursor := cursor_string(after)
+
+	if limit != nil {
+		size = *limit
+	}
+
+	sorted := append([]string{}, ids...)
+	sort.Strings(sorted)
+
+	start := sort.SearchStrings(sorted, cursor)
+
+	if start < len(sorted) && sorted[start] == cursor {
+		start++
+	}
+
+	end := min(start+size, len(sorted))
+
+	if end < len(sorted) {
+		return sorted[start:end], sorted[end-1]
+	}
+
+	return sorted[start:end], ""
+}
+
+func to_uuids(ids []string) ([]UUID, error) {
+	var uuids []UUID
+
+	for _, id := range ids {
+		u, err := ToUUID(id)
+
+		if err != nil {
+			return nil, err
+		}
+
+		uuids = append(uuids, u)
+	}
+
+	return uuids, nil
+}
+
+func cursor_string(after *UUID) string {
+	if after == nil {
+		return ""
+	}
+
+	return after.String()
+}
+
+func collection_links(path string, filter url.Values, limit *int, after *UUID, next string) map[string]string {
+	query := func(cursor string) string {
+		q := url.Values{}
+
+		for name, values := range filter {
+			q[name] = values
+		}
+
+		if limit != nil {
+			q.Set("limit", strconv.Itoa(*limit))
+		}
+
+		if cursor != "" {
+			q.Set("cursor", cursor)
+		}
+
+		if len(q) == 0 {
+			return path
+		}
+
+		return path + "?" + q.Encode()
+	}
+
+	links := map[string]string{"self": query(cursor_string(after))}
+
+	if next != "" {
+		links["next"] = query(next)
+	}
+
+	return links
+}
+
+// the session in another of the user's groups, for reading each group of a list
+type groupSession struct {
+	Session
+
+	groupId       UUID
+	groupIdString string
+}
+
+func in_group(s Session, groupId UUID) Session {
+	return groupSession{Session: s, groupId: groupId, groupIdString: groupId.String()}
+}
+
+func (gs groupSession) GetGroupId() *UUID {
+	return &gs.groupId
+}
+
+func (gs groupSession) GetGroupIdString() *string {
+	return &gs.groupIdString
+}
+
+func read_counter_resource(ctx context.Context, dbo DataOperator, s Session, id UUID) (Response, error) {
+	cd, res, ok := response_data[CountData](dbo.CounterRead(ctx, s, id))
+
+	if !ok {
+		return res, nil
+	}
+
+	res, err := makeresponse(counter_resource(cd))
+
+	return with_etag(res, err, cd.Version)
+}
+
+// runs a v1 counter change and answers with the counter as it is after it.
+// A replayed change answers as it did the first time, not with the counter
+// as it is now.
+func update_counter_resource(ctx context.Context, dbo DataOperator, s Session, id UUID, query string, stepVal int) (Response, error) {
+	if _, res, ok := response_data[opResult](dbo.CounterUpdate(ctx, s, id, query, stepVal)); !ok || !applied(res) {
+		return res, nil
+	}
+
+	return read_counter_resource(ctx, dbo, s, id)
+}
+
+func listGroupsV2(ctx context.Context, req Request, dbo DataOperator, s Session, p listGroupsV2Params) (Response, error) {
+	list, res, ok := response_data[opResult](dbo.GroupList(ctx, s))
+
+	if !ok {
+		return res, nil
+	}
+
+	ids, next := page(list.Items, p.Limit, p.Cursor)
+
+	groups := collection[groupResource]{
+		Items: []groupResource{},
+		Next:  next,
+		Links: collection_links(v2Prefix+"/groups", nil, p.Limit, p.Cursor, next),
+	}
+
+	groupIds, ierr := to_uuids(ids)
+
+	if ierr != nil {
+		return makeerror(ierr)
+	}
+
+	read, res, ok := response_data[[]GroupData](dbo.GroupReadMany(ctx, s, groupIds))
+
+	if !ok {
+		return res, nil
+	}
+
+	for _, gd := range read {
+		groups.Items = append(groups.Items, group_resource(gd))
+	}
+
+	return makeresponse(groups)
+}
+
+func getGroupV2(ctx context.Context, req Request, dbo DataOperator, s Session, p getGroupV2Params) (Response, error) {
+	gd, res, ok := response_data[GroupData](dbo.GroupRead(ctx, s))
+
+	if !ok {
+		return res, nil
+	}
+
+	res, err := makeresponse(group_resource(gd))
+
+	return with_etag(res, err, gd.Version)
+}
+
+func createGroupV2(ctx context.Context, req Request, dbo DataOperator, s Session, p createGroupV2Params) (Response, error) {
+	created, res, ok := response_data[opResult](dbo.GroupCreate(ctx, s, p.Name))
+
+	if !ok {
+		return res, nil
+	}
+
+	res, err := makeresponse(group_resource(GroupData{GroupId: created.Id, GroupName: p.Name, Version: 1}))
+
+	if err == nil && res.StatusCode == 200 {
+		res.Headers["Location"] = group_path(created.Id)
+	}
+
+	return with_etag(res, err, 1)
+}
+
+func listCountersV2(ctx context.Context, req Request, dbo DataOperator, s Session, p listCountersV2Params) (Response, error) {
+	selector, serr := parse_selector(p.Label)
+
+	if serr != nil {
+		return makeerror(serr)
+	}
+
+	list, res, ok := response_data[opResult](dbo.CounterList(ctx, s, selector))
+
+	if !ok {
+		return res, nil
+	}
+
+	ids, next := page(list.Items, p.Limit, p.Cursor)
+	filter := url.Values{}
+
+	if len(selector) > 0 {
+		filter.Set("label", selector.String())
+	}
+
+	counters := collection[counterResource]{
+		Items: []counterResource{},
+		Next:  next,
+		Links: collection_links(group_path(p.Group.String())+"/counters", filter, p.Limit, p.Cursor, next),
+	}
+
+	counterIds, ierr := to_uuids(ids)
+
+	if ierr != nil {
+		return makeerror(ierr)
+	}
+
+	read, res, ok := response_data[[]CountData](dbo.CounterReadMany(ctx, s, counterIds))
+
+	if !ok {
+		return res, nil
+	}
+
+	for _, cd := range read {
+		counters.Items = append(counters.Items, counter_resource(cd))
+	}
+
+	return makeresponse(counters)
+}
+
+func getCounterV2(ctx context.Context, req Request, dbo DataOperator, s Session, p getCounterV2Params) (Response, error) {
+	return read_counter_resource(ctx, dbo, s, p.Id)
+}
+
+func createCounterV2(ctx context.Context, req Request, dbo DataOperator, s Session, p createCounterV2Params) (Response, error) {
+	meta, merr := counter_meta(p.Description, p.Unit, p.Color, p.Icon, p.NumberFormat)
+
+	if merr != nil {
+		return makeerror(merr)
+	}
+
+	ci := counterConfig{Name: p.Name, Shards: p.Shards, Value: p.Value, Step: p.Step, Baseline: p.Baseline, Min: p.Min, Max: p.Max, Meta: meta, Expression: p.Expression}
+	cd, cerr := ci.counter()
+
+	if cerr != nil {
+		return makeerror(cerr)
+	}
+
+	created, res, ok := response_data[opResult](dbo.CounterCreate(ctx, s, cd))
+
+	if !ok {
+		return res, nil
+	}
+
+	cd.CounterId, cd.CounterGroup, cd.Version = created.Id, p.Group.String(), 1
+
+	res, err := makeresponse(counter_resource(cd))
+
+	if err == nil && res.StatusCode == 200 {
+		res.Headers["Location"] = counter_path(p.Group.String(), created.Id)
+	}
+
+	return with_etag(res, err, 1)
+}
+
+func updateCounterV2(ctx context.Context, req Request, dbo DataOperator, s Session, p updateCounterV2Params) (Response, error) {
+	return update_counter_resource(ctx, dbo, s, p.Id, dnquery(dq_init, dq_current), p.Step)
+}
+
+func incCounterV2(ctx context.Context, req Request, dbo DataOperator, s Session, p incCounterV2Params) (Response, error) {
+	return update_counter_resource(ctx, dbo, s, p.Id, dnquery(dq_current, dq_inc), 1)
+}
+
+func decCounterV2(ctx context.Context, req Request, dbo DataOperator, s Session, p decCounterV2Params) (Response, error) {
+	return update_counter_resource(ctx, dbo, s, p.Id, dnquery(dq_current, dq_dec), 1)
+}
+
+func resetCounterV2(ctx context.Context, req Request, dbo DataOperator, s Session, p resetCounterV2Params) (Response, error) {
+	return update_counter_resource(ctx, dbo, s, p.Id, dnquery(dq_current, dq_base), 1)
+}
+
+// answers with the counter as it was before it went
+func deleteCounterV2(ctx context.Context, req Request, dbo DataOperator, s Session, p deleteCounterV2Params) (Response, error) {
+	cd, res, ok := response_data[CountData](dbo.CounterRead(ctx, s, p.Id))
+
+	if !ok {
+		return res, nil
+	}
+
+	if _, res, ok := response_data[opResult](dbo.CounterDelete(ctx, s, p.Id)); !ok {
+		return res, nil
+	}
+
+	return makeresponse(counter_resource(cd))
+}
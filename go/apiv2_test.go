@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPage(t *testing.T) {
+	id := func(n string) string {
+		return "00000000-0000-0000-0000-00000000000" + n
+	}
+	cursor := func(n string) *UUID {
+		u, err := ToUUID(id(n))
+		checkError(t, err, nil)
+		return &u
+	}
+
+	ids := []string{id("5"), id("1"), id("7"), id("3")}
+
+	if got, next := page(ids, limit(2), nil); !slices.Equal(got, []string{id("1"), id("3")}) || next != id("3") {
+		t.Errorf("First page is %v, next %s", got, next)
+	}
+
+	if got, next := page(ids, nil, nil); len(got) != 4 || next != "" {
+		t.Errorf("Whole page is %v, next %s", got, next)
+	}
+
+	// a cursor which isn't there any more starts at the id after it
+	if got, next := page(ids, limit(1), cursor("4")); !slices.Equal(got, []string{id("5")}) || next != id("5") {
+		t.Errorf("Page after 4 is %v, next %s", got, next)
+	}
+
+	if got, next := page(ids, limit(1), cursor("7")); len(got) != 0 || next != "" {
+		t.Errorf("Page after the last is %v, next %s", got, next)
+	}
+}
+
+type v2Client struct {
+	t     *testing.T
+	url   string
+	token string
+}
+
+func newV2Client(t *testing.T, dbo DataOperator) *v2Client {
+	ti := newTestIssuer(t)
+	srv := httptest.NewServer(Create_Server(APIHandler{dbo: dbo}, ti.verifier(), Create_SocketHub()))
+
+	t.Cleanup(srv.Close)
+
+	return &v2Client{t: t, url: srv.URL, token: ti.token(t, "foo@bar.com")}
+}
+
+// sends a request and decodes a 200's body into out
+func (c *v2Client) do(method string, path string, body string, out any) *http.Response {
+	c.t.Helper()
+
+	req, err := http.NewRequest(method, c.url+path, strings.NewReader(body))
+
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	res, err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode == 200 && out != nil {
+		if derr := json.NewDecoder(res.Body).Decode(out); derr != nil {
+			c.t.Fatalf("%s %s: %s", method, path, derr)
+		}
+	}
+
+	return res
+}
+
+func TestAPIv2(t *testing.T) {
+	mo := newMemoryOperator()
+	c := newV2Client(t, mo)
+
+	var group groupResource
+
+	if res := c.do("POST", "/api/v2/groups", `{"name": "team"}`, &group); res.StatusCode != 200 || res.Header.Get("Location") != group.Links["self"] {
+		t.Fatalf("Group create gave %d at %s", res.StatusCode, res.Header.Get("Location"))
+	}
+
+	if group.Name != "team" || group.Links["counters"] != "/api/v2/groups/"+group.Id+"/counters" {
+		t.Errorf("Group is %+v", group)
+	}
+
+	var counter counterResource
+
+	c.do("POST", group.Links["counters"], `{"name": "coffee", "shards": 2}`, &counter)
+
+	if counter.Name != "coffee" || counter.Group != group.Id || counter.Step != 1 || counter.Shards != 2 {
+		t.Errorf("Created counter is %+v", counter)
+	}
+
+	c.do("PATCH", counter.Links["self"], `{"step": 5}`, nil)
+	c.do("POST", counter.Links["increment"], "", &counter)
+
+	if counter.Value != 5 || counter.Step != 5 || counter.Version != 3 {
+		t.Errorf("Incremented counter is %+v", counter)
+	}
+
+	if res := c.do("PATCH", counter.Links["self"], `{"step": "five"}`, nil); res.StatusCode != 400 {
+		t.Errorf("Bad step gave %d", res.StatusCode)
+	}
+
+	c.do("POST", group.Links["counters"], `{"name": "tea"}`, nil)
+
+	var counters collection[counterResource]
+
+	c.do("GET", group.Links["counters"]+"?limit=1", "", &counters)
+
+	if len(counters.Items) != 1 || counters.Next != counters.Items[0].Id || counters.Links["next"] == "" {
+		t.Fatalf("First page is %+v", counters)
+	}
+
+	first := counters.Items[0].Name
+	next := counters.Links["next"]
+	counters = collection[counterResource]{}
+
+	c.do("GET", next, "", &counters)
+
+	if len(counters.Items) != 1 || counters.Items[0].Name == first || counters.Next != "" {
+		t.Errorf("Second page is %+v", counters)
+	}
+
+	c.do("PUT", "/api/v1/group/"+group.Id+"/counter/"+counter.Id+"/labels?labels=env=prod,team=infra", "", nil)
+
+	var labelled collection[counterResource]
+
+	c.do("GET", group.Links["counters"]+"?label=env=prod", "", &labelled)
+
+	if len(labelled.Items) != 1 || labelled.Items[0].Labels["team"] != "infra" || labelled.Links["self"] != group.Links["counters"]+"?label=env%3Dprod" {
+		t.Errorf("Counters labelled env=prod are %+v", labelled)
+	}
+
+	if res := c.do("GET", group.Links["counters"]+"?label=env=pr+od", "", nil); res.StatusCode != 400 {
+		t.Errorf("Bad selector gave %d", res.StatusCode)
+	}
+
+	var groups collection[groupResource]
+
+	c.do("GET", "/api/v2/groups", "", &groups)
+
+	if len(groups.Items) != 1 || groups.Items[0].Counters != 2 || groups.Items[0].Name != "team" {
+		t.Errorf("Groups are %+v", groups)
+	}
+
+	// v1 is still there alongside
+	var v1 CountData
+
+	if c.do("GET", "/api/v1/group/"+group.Id+"/counter/"+counter.Id, "", &v1); v1.CounterVal != counter.Value {
+		t.Errorf("v1 counter is %+v", v1)
+	}
+
+	var deleted counterResource
+
+	if c.do("DELETE", counter.Links["self"], "", &deleted); deleted.Value != 5 || len(mo.counters) != 1 {
+		t.Errorf("Deleted %+v, leaving %v", deleted, mo.counters)
+	}
+
+	if res := c.do("GET", counter.Links["self"], "", nil); res.StatusCode != 404 {
+		t.Errorf("Deleted counter gave %d", res.StatusCode)
+	}
+}
+
+// a replayed change answers as the first time, not with the counter as it is now
+func TestUpdateCounterReplay(t *testing.T) {
+	mo := MockDataOperator{replayed: true}
+	id := MakeUUID()
+
+	res, err := update_counter_resource(context.TODO(), &mo, &APISession{}, id, dnquery(dq_current, dq_inc), 1)
+
+	checkError(t, err, nil)
+
+	var result opResult
+
+	if json.Unmarshal([]byte(res.Body), &result); res.StatusCode != 200 || result.Id != id.String() || res.Headers[replayHeader] != "true" {
+		t.Errorf("Replay gave %d %v %s", res.StatusCode, res.Headers, res.Body)
+	}
+
+	checkCalls(t, mo.funcName, []string{"CounterUpdate"})
+}
@@ -2,89 +2,209 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
 )
 
-func login(ctx context.Context, req Request, dbo DataOperator) (Response, error) {
-	mySession := session.Must(session.NewSession())
-	svc := cognitoidentityprovider.New(mySession)
-	email := aws.String(req.QueryStringParameters["email"])
-	pwd := aws.String(req.QueryStringParameters["password"])
-	pool := aws.String(os.Getenv("USER_POOL"))
-	client := aws.String(os.Getenv("USER_POOL_CLIENT"))
+// constructor for the user pool interface.  Replaced with a mock in tests.
+var create_identity_interface = func() IdentityInterface {
+	return Create_CognitoInterface()
+}
 
-	input := cognitoidentityprovider.AdminInitiateAuthInput{
+func auth_input(email *string, pwd *string) *cognitoidentityprovider.AdminInitiateAuthInput {
+	return &cognitoidentityprovider.AdminInitiateAuthInput{
 		AuthFlow:   aws.String("ADMIN_NO_SRP_AUTH"),
-		UserPoolId: pool,
-		ClientId:   client,
+		UserPoolId: aws.String(os.Getenv("USER_POOL")),
+		ClientId:   aws.String(os.Getenv("USER_POOL_CLIENT")),
 		AuthParameters: map[string]*string{
 			"USERNAME": email,
 			"PASSWORD": pwd,
 		},
 	}
+}
+
+func login(ctx context.Context, req Request, dbo DataOperator, p loginParams) (Response, error) {
+	svc := create_identity_interface()
+	email := aws.String(p.Email)
+	pwd := aws.String(p.Password)
 
-	resp, err := svc.AdminInitiateAuth(&input)
+	resp, err := svc.AdminInitiateAuthWithContext(ctx, auth_input(email, pwd))
 
 	if err != nil {
 		return makeerror(err)
 	}
 
-	return makeresponse(map[string]string{"Result": "OK", "Token": *resp.AuthenticationResult.IdToken})
+	return makeresponse(loginResult{Result: "OK", Token: *resp.AuthenticationResult.IdToken})
 }
 
-func signup(ctx context.Context, req Request, dbo DataOperator) (Response, error) {
-	mySession := session.Must(session.NewSession())
-	svc := cognitoidentityprovider.New(mySession)
+// signup is safe to retry.  The e-mail address is reserved in the user table
+// along with the user record, then the cognito user is created and given its
+// password.  A retry finds the reservation and finishes whatever the earlier
+// attempt did not, so the caller always gets the same user id back.
+func signup(ctx context.Context, req Request, dbo DataOperator, p signupParams) (Response, error) {
+	svc := create_identity_interface()
 
-	email := aws.String(req.QueryStringParameters["email"])
-	pwd := aws.String(req.QueryStringParameters["password"])
+	email := aws.String(p.Email)
+	pwd := aws.String(p.Password)
 	pool := aws.String(os.Getenv("USER_POOL"))
 
-	userUUID := MakeUUID()
-
-	input := cognitoidentityprovider.AdminCreateUserInput{
-		MessageAction: aws.String("SUPPRESS"),
-		UserPoolId:    pool,
-		Username:      email,
-		UserAttributes: []*cognitoidentityprovider.AttributeType{
-			{
-				Name:  aws.String("email"),
-				Value: email,
-			}, {
-				Name:  aws.String("email_verified"),
-				Value: aws.String("true"),
-			},
-		},
+	userUUID, reserved, rerr := dbo.LookupUserReservation(ctx, email)
+
+	if rerr != nil {
+		return makeerror(rerr)
 	}
 
-	_, err := svc.AdminCreateUser(&input)
+	created := false
 
-	if err != nil {
-		return makeerror(err)
+	if !reserved {
+		var crerr error
+
+		// a user from before addresses were reserved has theirs reserved
+		// now, rather than being made again
+		userUUID, reserved, crerr = dbo.UserReserveIndexed(ctx, email)
+
+		if crerr == nil && !reserved {
+			userUUID = MakeUUID()
+			crerr = dbo.UserCreate(ctx, userUUID, email)
+			created = crerr == nil
+		}
+
+		if is_condition_failure(crerr) {
+			// a concurrent signup reserved the address first.  Carry on with theirs.
+			userUUID, reserved, rerr = dbo.LookupUserReservation(ctx, email)
+
+			if rerr != nil {
+				return makeerror(rerr)
+			}
+
+			if !reserved {
+				return makeerror(fmt.Errorf("reservation for %s not found", *email))
+			}
+		} else if crerr != nil {
+			return makeerror(crerr)
+		}
+	}
+
+	idcreated, ierr := signup_identity(ctx, svc, pool, email, pwd, created)
+
+	if ierr != nil {
+		// don't leave records behind which nobody can log in to.
+		// even if the request was cancelled
+		cleanup := context.WithoutCancel(ctx)
+
+		if idcreated {
+			_, derr := svc.AdminDeleteUserWithContext(cleanup, &cognitoidentityprovider.AdminDeleteUserInput{
+				UserPoolId: pool,
+				Username:   email,
+			})
+			ierr = errors.Join(ierr, derr)
+		}
+
+		if created {
+			ierr = errors.Join(ierr, dbo.UserDelete(cleanup, userUUID, email))
+		}
+
+		return makeerror(ierr)
 	}
 
-	pwinput := cognitoidentityprovider.AdminSetUserPasswordInput{
-		Password:   pwd,
+	return makeresponse(signupResult{Result: "OK", Id: userUUID.String()})
+}
+
+// returns the cognito status of the user, or "" if they are not in the pool.
+func identity_user_status(ctx context.Context, svc IdentityInterface, pool *string, email *string) (string, error) {
+	out, err := svc.AdminGetUserWithContext(ctx, &cognitoidentityprovider.AdminGetUserInput{
 		UserPoolId: pool,
 		Username:   email,
-		Permanent:  aws.Bool(true),
-	}
+	})
+
+	if err != nil {
+		var aerr awserr.Error
 
-	_, pwerr := svc.AdminSetUserPassword(&pwinput)
+		if errors.As(err, &aerr) && aerr.Code() == cognitoidentityprovider.ErrCodeUserNotFoundException {
+			return "", nil
+		}
 
-	if pwerr != nil {
-		return makeerror(pwerr)
+		return "", err
 	}
 
-	crerr := dbo.UserCreate(userUUID, email)
+	return aws.StringValue(out.UserStatus), nil
+}
+
+// brings the cognito user up to date with the signup request.  The user is
+// made with the signup's password as its temporary one, so only a retry with
+// the same password can finish a signup which stopped before it was set,
+// unless this call made the reservation itself.  Returns true if the user was
+// created by this call.
+func signup_identity(ctx context.Context, svc IdentityInterface, pool *string, email *string, pwd *string, reserved bool) (bool, error) {
+	status, serr := identity_user_status(ctx, svc, pool, email)
 
-	if crerr != nil {
-		return makeerror(crerr)
+	if serr != nil {
+		return false, serr
 	}
 
-	return makeresponse(map[string]string{"Result": "OK"})
+	created := false
+
+	switch status {
+	case "":
+		input := cognitoidentityprovider.AdminCreateUserInput{
+			MessageAction:     aws.String("SUPPRESS"),
+			UserPoolId:        pool,
+			Username:          email,
+			TemporaryPassword: pwd,
+			UserAttributes: []*cognitoidentityprovider.AttributeType{
+				{
+					Name:  aws.String("email"),
+					Value: email,
+				}, {
+					Name:  aws.String("email_verified"),
+					Value: aws.String("true"),
+				},
+			},
+		}
+
+		_, err := svc.AdminCreateUserWithContext(ctx, &input)
+
+		var aerr awserr.Error
+
+		if err != nil && !(errors.As(err, &aerr) && aerr.Code() == cognitoidentityprovider.ErrCodeUsernameExistsException) {
+			return false, err
+		}
+
+		created = err == nil
+
+		fallthrough
+
+	case cognitoidentityprovider.UserStatusTypeForceChangePassword:
+		// created but the password was never set, by an earlier attempt which
+		// stopped half way or a concurrent one.  Only its own caller can finish it.
+		if !created && !reserved {
+			if _, aerr := svc.AdminInitiateAuthWithContext(ctx, auth_input(email, pwd)); aerr != nil {
+				return false, fmt.Errorf("user %s already exists", *email)
+			}
+		}
+
+		pwinput := cognitoidentityprovider.AdminSetUserPasswordInput{
+			Password:   pwd,
+			UserPoolId: pool,
+			Username:   email,
+			Permanent:  aws.Bool(true),
+		}
+
+		_, pwerr := svc.AdminSetUserPasswordWithContext(ctx, &pwinput)
+
+		return created, pwerr
+
+	default:
+		// signup already finished.  Only a retry by the same caller gets a success.
+		if _, aerr := svc.AdminInitiateAuthWithContext(ctx, auth_input(email, pwd)); aerr != nil {
+			return false, fmt.Errorf("user %s already exists", *email)
+		}
+
+		return false, nil
+	}
 }
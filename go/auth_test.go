@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func mockIdentity(t *testing.T, mi *MockIdentityInterface) {
+	orig := create_identity_interface
+	create_identity_interface = func() IdentityInterface { return mi }
+	t.Cleanup(func() { create_identity_interface = orig })
+}
+
+func signupRequest(email string, pwd string) Request {
+	return Request{
+		QueryStringParameters: map[string]string{
+			"email":    email,
+			"password": pwd,
+		},
+	}
+}
+
+func checkSignupOK(t *testing.T, res Response, expUid UUID) {
+	if res.StatusCode != 200 {
+		t.Fatalf("Signup failed: %d %s", res.StatusCode, res.Body)
+	}
+
+	if uid := decodeResultId(t, res); uid != expUid {
+		t.Errorf("Signup returned user %s not %s", uid, expUid)
+	}
+}
+
+func checkCalls(t *testing.T, calls []string, expect []string) {
+	if !slices.Equal(calls, expect) {
+		t.Errorf("Calls were %v not %v", calls, expect)
+	}
+}
+
+// a request cancelled part way stops the signup, which is still rolled back
+func TestSignupCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	mi := MockIdentityInterface{created: cancel}
+	mockIdentity(t, &mi)
+
+	dbo := MockDataOperator{}
+
+	res, _ := public_handlers["GET /signup"](ctx, signupRequest("foo@bar.com", "pwd"), &dbo)
+
+	if res.StatusCode == 200 {
+		t.Errorf("Cancelled signup gave %d %s", res.StatusCode, res.Body)
+	}
+
+	checkCalls(t, dbo.funcName, []string{"LookupUserReservation", "UserReserveIndexed", "UserCreate", "UserDelete"})
+	checkCalls(t, mi.funcName, []string{"AdminGetUser", "AdminCreateUser", "AdminSetUserPassword", "AdminDeleteUser"})
+
+	if mi.status != "" {
+		t.Errorf("Cognito user left %s", mi.status)
+	}
+}
+
+func TestSignupNew(t *testing.T) {
+	mi := MockIdentityInterface{}
+	mockIdentity(t, &mi)
+
+	dbo := MockDataOperator{}
+
+	res, err := public_handlers["GET /signup"](context.TODO(), signupRequest("foo@bar.com", "pwd"), &dbo)
+
+	checkError(t, err, nil)
+	checkSignupOK(t, res, dbo.newId)
+
+	checkCalls(t, dbo.funcName, []string{"LookupUserReservation", "UserReserveIndexed", "UserCreate"})
+	checkCalls(t, mi.funcName, []string{"AdminGetUser", "AdminCreateUser", "AdminSetUserPassword"})
+}
+
+func TestSignupResumeNoIdentity(t *testing.T) {
+	mi := MockIdentityInterface{}
+	mockIdentity(t, &mi)
+
+	expUid := MakeUUID()
+
+	dbo := MockDataOperator{reservation: &expUid}
+
+	res, err := public_handlers["GET /signup"](context.TODO(), signupRequest("foo@bar.com", "pwd"), &dbo)
+
+	checkError(t, err, nil)
+	checkSignupOK(t, res, expUid)
+
+	checkCalls(t, dbo.funcName, []string{"LookupUserReservation"})
+	checkCalls(t, mi.funcName, []string{"AdminGetUser", "AdminCreateUser", "AdminSetUserPassword"})
+}
+
+// a user made before addresses were reserved keeps their id, rather than
+// getting a second user record
+func TestSignupIndexedUser(t *testing.T) {
+	mi := MockIdentityInterface{}
+	mockIdentity(t, &mi)
+
+	expUid := MakeUUID()
+
+	dbo := MockDataOperator{indexed: &expUid}
+
+	res, err := public_handlers["GET /signup"](context.TODO(), signupRequest("foo@bar.com", "pwd"), &dbo)
+
+	checkError(t, err, nil)
+	checkSignupOK(t, res, expUid)
+
+	checkCalls(t, dbo.funcName, []string{"LookupUserReservation", "UserReserveIndexed"})
+}
+
+func TestSignupResumeNoPassword(t *testing.T) {
+	mi := MockIdentityInterface{status: cognitoidentityprovider.UserStatusTypeForceChangePassword}
+	mockIdentity(t, &mi)
+
+	expUid := MakeUUID()
+
+	dbo := MockDataOperator{reservation: &expUid}
+
+	res, err := public_handlers["GET /signup"](context.TODO(), signupRequest("foo@bar.com", "pwd"), &dbo)
+
+	checkError(t, err, nil)
+	checkSignupOK(t, res, expUid)
+
+	checkCalls(t, mi.funcName, []string{"AdminGetUser", "AdminInitiateAuth", "AdminSetUserPassword"})
+}
+
+// someone else's half finished signup can't be given another password
+func TestSignupResumeOtherPassword(t *testing.T) {
+	mi := MockIdentityInterface{
+		status:  cognitoidentityprovider.UserStatusTypeForceChangePassword,
+		authErr: fmt.Errorf("NotAuthorizedException"),
+	}
+	mockIdentity(t, &mi)
+
+	expUid := MakeUUID()
+
+	dbo := MockDataOperator{reservation: &expUid}
+
+	res, err := public_handlers["GET /signup"](context.TODO(), signupRequest("foo@bar.com", "mine"), &dbo)
+
+	checkError(t, err, nil)
+
+	if res.StatusCode == 200 || res.Body != "user foo@bar.com already exists" {
+		t.Errorf("Signup over a half finished one gave %d %s", res.StatusCode, res.Body)
+	}
+
+	checkCalls(t, mi.funcName, []string{"AdminGetUser", "AdminInitiateAuth"})
+}
+
+// a concurrent signup made the cognito user between the check and the create
+func TestSignupConcurrentIdentity(t *testing.T) {
+	mi := MockIdentityInterface{
+		createErr: awserr.New(cognitoidentityprovider.ErrCodeUsernameExistsException, "User account already exists", nil),
+		authErr:   fmt.Errorf("NotAuthorizedException"),
+	}
+	mockIdentity(t, &mi)
+
+	expUid := MakeUUID()
+
+	dbo := MockDataOperator{reservation: &expUid}
+
+	res, err := public_handlers["GET /signup"](context.TODO(), signupRequest("foo@bar.com", "mine"), &dbo)
+
+	checkError(t, err, nil)
+
+	if res.StatusCode == 200 {
+		t.Error("Signup racing another succeeded")
+	}
+
+	checkCalls(t, mi.funcName, []string{"AdminGetUser", "AdminCreateUser", "AdminInitiateAuth"})
+}
+
+func TestSignupRetryComplete(t *testing.T) {
+	mi := MockIdentityInterface{status: cognitoidentityprovider.UserStatusTypeConfirmed}
+	mockIdentity(t, &mi)
+
+	expUid := MakeUUID()
+
+	dbo := MockDataOperator{reservation: &expUid}
+
+	res, err := public_handlers["GET /signup"](context.TODO(), signupRequest("foo@bar.com", "pwd"), &dbo)
+
+	checkError(t, err, nil)
+	checkSignupOK(t, res, expUid)
+
+	checkCalls(t, mi.funcName, []string{"AdminGetUser", "AdminInitiateAuth"})
+}
+
+func TestSignupExistingUser(t *testing.T) {
+	mi := MockIdentityInterface{
+		status:  cognitoidentityprovider.UserStatusTypeConfirmed,
+		authErr: fmt.Errorf("NotAuthorizedException"),
+	}
+	mockIdentity(t, &mi)
+
+	expUid := MakeUUID()
+
+	dbo := MockDataOperator{reservation: &expUid}
+
+	res, err := public_handlers["GET /signup"](context.TODO(), signupRequest("foo@bar.com", "wrong"), &dbo)
+
+	checkError(t, err, nil)
+
+	if res.StatusCode == 200 {
+		t.Fatal("Signup over an existing user succeeded")
+	}
+
+	if res.Body != "user foo@bar.com already exists" {
+		t.Errorf("Wrong error text %s", res.Body)
+	}
+
+	checkCalls(t, mi.funcName, []string{"AdminGetUser", "AdminInitiateAuth"})
+}
+
+func TestSignupConcurrentReservation(t *testing.T) {
+	mi := MockIdentityInterface{}
+	mockIdentity(t, &mi)
+
+	expUid := MakeUUID()
+
+	dbo := MockDataOperator{
+		userId: expUid,
+		createErr: &dynamodb.TransactionCanceledException{
+			CancellationReasons: []*dynamodb.CancellationReason{
+				{Code: aws.String("ConditionalCheckFailed")},
+				{Code: aws.String("None")},
+			},
+		},
+	}
+
+	res, err := public_handlers["GET /signup"](context.TODO(), signupRequest("foo@bar.com", "pwd"), &dbo)
+
+	checkError(t, err, nil)
+	checkSignupOK(t, res, expUid)
+
+	checkCalls(t, dbo.funcName, []string{"LookupUserReservation", "UserReserveIndexed", "UserCreate", "LookupUserReservation"})
+}
+
+func TestSignupRollback(t *testing.T) {
+	mi := MockIdentityInterface{pwErr: fmt.Errorf("InvalidPasswordException")}
+	mockIdentity(t, &mi)
+
+	dbo := MockDataOperator{}
+
+	res, err := public_handlers["GET /signup"](context.TODO(), signupRequest("foo@bar.com", "x"), &dbo)
+
+	checkError(t, err, nil)
+
+	if res.StatusCode == 200 {
+		t.Fatal("Signup with a bad password succeeded")
+	}
+
+	checkCalls(t, dbo.funcName, []string{"LookupUserReservation", "UserReserveIndexed", "UserCreate", "UserDelete"})
+	checkCalls(t, mi.funcName, []string{"AdminGetUser", "AdminCreateUser", "AdminSetUserPassword", "AdminDeleteUser"})
+}
+
+func TestSignupDBFailure(t *testing.T) {
+	mi := MockIdentityInterface{}
+	mockIdentity(t, &mi)
+
+	dbo := MockDataOperator{createErr: fmt.Errorf("NOPE")}
+
+	res, err := public_handlers["GET /signup"](context.TODO(), signupRequest("foo@bar.com", "pwd"), &dbo)
+
+	checkError(t, err, nil)
+
+	if res.StatusCode == 200 || res.Body != "NOPE" {
+		t.Errorf("Unexpected response %d %s", res.StatusCode, res.Body)
+	}
+
+	checkCalls(t, mi.funcName, nil)
+}
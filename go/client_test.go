@@ -0,0 +1,22 @@
+package main
+
+import (
+	"testing"
+
+	"myhello/client"
+)
+
+// the client's routes are the server's
+func TestClientRoutes(t *testing.T) {
+	routes := map[string]bool{}
+
+	for _, r := range api_routes {
+		routes[r.method+" "+r.path] = true
+	}
+
+	for _, r := range client.Routes {
+		if !routes[r] {
+			t.Errorf("Client uses %s, which isn't in api.yaml", r)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider/cognitoidentityprovideriface"
+)
+
+// real cognito interface.  Like the dynamo interface it is kept separate
+// so that login and signup can be tested against a mock user pool.
+
+func Create_CognitoInterface() cognitoidentityprovideriface.CognitoIdentityProviderAPI {
+	sess := session.Must(session.NewSession())
+
+	svc := cognitoidentityprovider.New(sess)
+
+	return cognitoidentityprovideriface.CognitoIdentityProviderAPI(svc)
+}
@@ -21,4 +21,35 @@ const (
 	objectTypeCol   = "objectType"
 	principalIdCol  = "userUUID"
 	objectTypeIdCol = "objectTypeUUID"
+	reservedUserCol = "userUUID"
+	userIdVal       = "userId"
+	apiKeyIdCol     = "objectUUID"
+	apiKeyUserCol   = "userUUID"
+	keyHashCol      = "keyHash"
+	keyHashVal      = "keyHash"
+	keyListCol      = "apikeys"
+	hookGroupCol    = "objectUUID"
+	expiresAtCol    = "expiresAt"
+	connectionIdCol = "objectUUID"
+	versionCol      = "version"
+	versionZero     = "vzero"
+	versionOne      = "vone"
+	ifMatchVal      = "ifmatch"
+	shardsCol       = "shards"
+	labelsCol       = "labels"
+	labelsVal       = "labels"
+	descriptionCol  = "description"
+	unitCol         = "unit"
+	colorCol        = "color"
+	iconCol         = "icon"
+	numberFormatCol = "numberFormat"
+	baselineCol     = "baseline"
+	minCol          = "minVal"
+	maxCol          = "maxVal"
+	lowVal          = "low"
+	highVal         = "high"
+	expressionCol   = "expression"
+	expressionVal   = "expression"
+	referencesCol   = "references"
+	referencesVal   = "references"
 )
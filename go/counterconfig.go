@@ -0,0 +1,90 @@
+package main
+
+// A counter can be made with all of its configuration at once: where it
+// starts, its step, the baseline a reset takes it back to and the bounds
+// which increments and decrements keep it in.  Without them it starts at 0
+// with a step of 1, resets to 0 and has no bounds.  A sharded counter can't
+// have bounds, as no one item holds its count.  A derived counter has an
+// expression instead of any of them.
+
+type counterConfig struct {
+	Name   string
+	Shards *int
+
+	Value    *int
+	Step     *int
+	Baseline *int
+	Min      *int
+	Max      *int
+
+	Meta CounterMeta
+
+	Expression string
+}
+
+// the new counter, or why it can't be made
+func (ci counterConfig) counter() (CountData, error) {
+	if ci.Expression != "" {
+		for _, given := range []*int{ci.Shards, ci.Value, ci.Step, ci.Baseline, ci.Min, ci.Max} {
+			if given != nil {
+				return CountData{}, bad_request("a derived counter has no shards, value, step, baseline or bounds of its own")
+			}
+		}
+
+		cd := CountData{CounterName: ci.Name, Expression: ci.Expression, CounterMeta: ci.Meta}
+
+		return cd, check_derived(cd)
+	}
+
+	cd := CountData{CounterName: ci.Name, StepVal: 1, MinVal: ci.Min, MaxVal: ci.Max, CounterMeta: ci.Meta}
+
+	if ci.Shards != nil {
+		cd.Shards = *ci.Shards
+	}
+
+	if ci.Step != nil {
+		cd.StepVal = *ci.Step
+	}
+
+	if ci.Baseline != nil {
+		cd.Baseline = *ci.Baseline
+	}
+
+	// it starts at its baseline unless it is told otherwise
+	cd.CounterVal = cd.Baseline
+
+	if ci.Value != nil {
+		cd.CounterVal = *ci.Value
+	}
+
+	return cd, check_bounds(cd)
+}
+
+func check_bounds(cd CountData) error {
+	if cd.MinVal == nil && cd.MaxVal == nil {
+		return nil
+	}
+
+	if cd.Shards > 0 {
+		return bad_request("a sharded counter can't have bounds")
+	}
+
+	if cd.MinVal != nil && cd.MaxVal != nil && *cd.MinVal > *cd.MaxVal {
+		return bad_request("min %d is more than max %d", *cd.MinVal, *cd.MaxVal)
+	}
+
+	for _, v := range []struct {
+		name  string
+		value int
+	}{{"value", cd.CounterVal}, {"baseline", cd.Baseline}} {
+		if cd.MinVal != nil && v.value < *cd.MinVal {
+			return bad_request("%s %d is less than min %d", v.name, v.value, *cd.MinVal)
+		}
+
+		if cd.MaxVal != nil && v.value > *cd.MaxVal {
+			return bad_request("%s %d is more than max %d", v.name, v.value, *cd.MaxVal)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestCounterConfig(t *testing.T) {
+	cd, err := counterConfig{Name: "cups"}.counter()
+
+	checkError(t, err, nil)
+
+	if cd.CounterVal != 0 || cd.StepVal != 1 || cd.Baseline != 0 || cd.MinVal != nil || cd.MaxVal != nil {
+		t.Errorf("Default counter is %+v", cd)
+	}
+
+	// it starts at its baseline unless it is given a value
+	if cd, _ := (counterConfig{Name: "cups", Baseline: aws.Int(10), Step: aws.Int(-1)}).counter(); cd.CounterVal != 10 || cd.StepVal != -1 {
+		t.Errorf("Counter with a baseline is %+v", cd)
+	}
+
+	if cd, _ := (counterConfig{Name: "cups", Baseline: aws.Int(10), Value: aws.Int(3)}).counter(); cd.CounterVal != 3 || cd.Baseline != 10 {
+		t.Errorf("Counter with a value is %+v", cd)
+	}
+
+	for _, c := range []struct {
+		config counterConfig
+		exp    string
+	}{
+		{counterConfig{Min: aws.Int(5), Max: aws.Int(4), Value: aws.Int(4)}, "min 5 is more than max 4"},
+		{counterConfig{Min: aws.Int(1)}, "value 0 is less than min 1"},
+		{counterConfig{Max: aws.Int(9), Value: aws.Int(5), Baseline: aws.Int(10)}, "baseline 10 is more than max 9"},
+		{counterConfig{Max: aws.Int(9), Shards: aws.Int(2)}, "a sharded counter can't have bounds"},
+	} {
+		if _, err := c.config.counter(); err == nil || err.Error() != c.exp {
+			t.Errorf("%+v gave %v", c.config, err)
+		}
+	}
+}
+
+func TestCounterConfigRoutes(t *testing.T) {
+	mo := newMemoryOperator()
+	c := newV2Client(t, mo)
+
+	var group groupResource
+
+	c.do("POST", "/api/v2/groups", `{"name": "ops"}`, &group)
+
+	var counter counterResource
+
+	c.do("POST", group.Links["counters"], `{"name": "seats", "baseline": 2, "step": 2, "min": 0, "max": 6}`, &counter)
+
+	if counter.Value != 2 || counter.Step != 2 || counter.Baseline != 2 || *counter.Max != 6 {
+		t.Fatalf("Created counter is %+v", counter)
+	}
+
+	c.do("POST", counter.Links["increment"], "", nil)
+	c.do("POST", counter.Links["increment"], "", &counter)
+
+	if res := c.do("POST", counter.Links["increment"], "", nil); res.StatusCode != 409 || counter.Value != 6 {
+		t.Errorf("Increment past max gave %d at %d", res.StatusCode, counter.Value)
+	}
+
+	if c.do("POST", counter.Links["reset"], "", &counter); counter.Value != 2 {
+		t.Errorf("Reset went to %d", counter.Value)
+	}
+
+	// v1 takes the configuration in the body too
+	var created opResult
+
+	c.do("POST", "/api/v1/group/"+group.Id+"/counter/tickets", `{"value": 7}`, &created)
+
+	if cd := mo.counters[must_uuid(t, created.Id)]; cd == nil || cd.CounterVal != 7 || cd.StepVal != 1 {
+		t.Errorf("v1 counter is %+v", cd)
+	}
+
+	if res := c.do("POST", "/api/v1/group/"+group.Id+"/counter/tickets", `{"min": 1}`, nil); res.StatusCode != 400 {
+		t.Errorf("Value below min gave %d", res.StatusCode)
+	}
+}
+
+func must_uuid(t *testing.T, id string) UUID {
+	u, err := ToUUID(id)
+	checkError(t, err, nil)
+	return u
+}
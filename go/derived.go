@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// A derived counter's value is an expression over other counters of its
+// group, worked out whenever it is read, so it can't be incremented,
+// decremented, reset or given a step.  The names in its expression are
+// turned into ids when it is defined, and have to be counters of the group
+// then; a counter renamed or made later with the same name isn't picked up.
+// A derived counter can name other derived counters, so long as none of
+// them leads back to it.  sum() adds up the ordinary counters with some
+// labels, whenever they were made, and leaves out derived ones so that
+// labels can't make a cycle.
+
+// how deep derived counters can be read through each other
+const maxDerivedDepth = 8
+
+// a derived counter has to be configured as one, without any of the things
+// which only make sense for a count of its own
+func check_derived(cd CountData) error {
+	if cd.Expression == "" {
+		return nil
+	}
+
+	if cd.Shards > 0 || cd.MinVal != nil || cd.MaxVal != nil {
+		return bad_request("a derived counter can't have shards or bounds")
+	}
+
+	_, _, err := parse_expression(cd.Expression)
+
+	return err
+}
+
+// the ids of the counters named by an expression, which have to be just one
+// of the group's counters each
+func resolve_references(names []string, counters []CountData) (map[string]string, error) {
+	refs := map[string]string{}
+
+	for _, name := range names {
+		if _, done := refs[name]; done {
+			continue
+		}
+
+		for _, cd := range counters {
+			if cd.CounterName != name {
+				continue
+			}
+
+			if refs[name] != "" {
+				return nil, bad_request("the group has more than one counter called %s", name)
+			}
+
+			refs[name] = cd.CounterId
+		}
+
+		if refs[name] == "" {
+			return nil, bad_request("the group has no counter called %s", name)
+		}
+	}
+
+	return refs, nil
+}
+
+// true if following the references from refs leads back to the counter id
+func refers_back(id string, refs map[string]string, counter func(id string) (CountData, error)) (bool, error) {
+	seen := map[string]bool{}
+	var next []string
+
+	for _, ref := range refs {
+		next = append(next, ref)
+	}
+
+	for len(next) > 0 {
+		ref := next[0]
+		next = next[1:]
+
+		if ref == id {
+			return true, nil
+		}
+
+		if seen[ref] {
+			continue
+		}
+
+		seen[ref] = true
+
+		cd, err := counter(ref)
+
+		if err != nil {
+			return false, err
+		}
+
+		for _, further := range cd.References {
+			next = append(next, further)
+		}
+	}
+
+	return false, nil
+}
+
+// the new references of counters made together from others, by name among
+// them, as when a group is cloned.  The names of those whose expressions
+// name counters which aren't there are returned.
+func relink_references(counters []CountData) []string {
+	var broken []string
+
+	for i, cd := range counters {
+		if cd.Expression == "" {
+			continue
+		}
+
+		_, names, perr := parse_expression(cd.Expression)
+		refs, rerr := resolve_references(names, counters)
+
+		if perr != nil || rerr != nil {
+			broken = append(broken, cd.CounterName)
+			refs = nil
+		}
+
+		counters[i].References = refs
+	}
+
+	return broken
+}
+
+// works out derived values.  counter reads a counter by id and group reads
+// the counters of a group for sums, and values keeps the values of the
+// counters named so far, so that nothing is read or worked out twice however
+// often it is named.  One can be used for all the counters a request reads.
+type derivation struct {
+	counter func(id string) (CountData, error)
+	group   func(groupId string) ([]CountData, error)
+	values  map[string]float64
+}
+
+func new_derivation(counter func(id string) (CountData, error), group func(groupId string) ([]CountData, error)) derivation {
+	return derivation{counter: counter, group: group, values: map[string]float64{}}
+}
+
+// puts the counter's derived value in it
+func (dv derivation) set(cd *CountData) {
+	value, err := dv.derive(*cd, 0)
+
+	set_derived(cd, value, err)
+}
+
+// the value of a derived counter.  depth is how many derived counters have
+// been read through to get to it.
+func (dv derivation) derive(cd CountData, depth int) (float64, error) {
+	if value, done := dv.values[cd.CounterId]; done && cd.CounterId != "" {
+		return value, nil
+	}
+
+	if depth >= maxDerivedDepth {
+		return 0, fmt.Errorf("derived counters go more than %d deep", maxDerivedDepth)
+	}
+
+	node, _, err := parse_expression(cd.Expression)
+
+	if err != nil {
+		return 0, err
+	}
+
+	value, err := node.eval(exprEnv{
+		ref: func(name string) (float64, error) {
+			id, found := cd.References[name]
+
+			if !found {
+				return 0, fmt.Errorf("%s isn't a counter of the group", name)
+			}
+
+			if value, done := dv.values[id]; done {
+				return value, nil
+			}
+
+			ref, rerr := dv.counter(id)
+
+			if rerr != nil {
+				return 0, rerr
+			}
+
+			if ref.CounterId == "" || ref.CounterGroup != cd.CounterGroup {
+				return 0, fmt.Errorf("counter %s has gone", name)
+			}
+
+			if ref.Expression != "" {
+				return dv.derive(ref, depth+1)
+			}
+
+			dv.values[id] = float64(ref.CounterVal)
+
+			return float64(ref.CounterVal), nil
+		},
+		sum: func(selector LabelSelector) (float64, error) {
+			counters, gerr := dv.group(cd.CounterGroup)
+
+			if gerr != nil {
+				return 0, gerr
+			}
+
+			total := 0.0
+
+			for _, member := range counters {
+				if member.Expression == "" && selector.matches(member.Labels) {
+					total += float64(member.CounterVal)
+				}
+			}
+
+			return total, nil
+		},
+	})
+
+	if err == nil && cd.CounterId != "" {
+		dv.values[cd.CounterId] = value
+	}
+
+	return value, err
+}
+
+// puts the derived value in the counter as it is read, with its count the
+// nearest whole number for those which only look at that.  JSON has no
+// infinities, so a value which overflows is an error.
+func set_derived(cd *CountData, value float64, err error) {
+	if err == nil && (math.IsInf(value, 0) || math.IsNaN(value)) {
+		err = fmt.Errorf("the value is too big to be a number")
+	}
+
+	if err != nil {
+		cd.DerivedError = err.Error()
+		return
+	}
+
+	cd.Derived = &value
+	cd.CounterVal = int(math.Round(value))
+}
+
+func setCounterExpression(ctx context.Context, req Request, dbo DataOperator, s Session, p setCounterExpressionParams) (Response, error) {
+	if _, _, err := parse_expression(p.Expression); err != nil {
+		return makeerror(err)
+	}
+
+	return dbo.CounterExpression(ctx, s, p.Id, p.Expression)
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// passed and failed, labelled env=prod, and ratio derived from them
+func derivedCounters() []CountData {
+	group := expGroup.String()
+
+	return []CountData{
+		{CounterId: "p", CounterName: "passed", CounterGroup: group, CounterVal: 3, Labels: map[string]string{"env": "prod"}},
+		{CounterId: "f", CounterName: "failed", CounterGroup: group, CounterVal: 1, Labels: map[string]string{"env": "prod"}},
+		{CounterId: "r", CounterName: "ratio", CounterGroup: group, Expression: "passed / (passed + failed)", References: map[string]string{"passed": "p", "failed": "f"}, Labels: map[string]string{"env": "prod"}},
+	}
+}
+
+func counter_lookup(counters []CountData) func(id string) (CountData, error) {
+	return func(id string) (CountData, error) {
+		for _, cd := range counters {
+			if cd.CounterId == id {
+				return cd, nil
+			}
+		}
+		return CountData{}, nil
+	}
+}
+
+func TestDerivedConfig(t *testing.T) {
+	cd, err := counterConfig{Name: "ratio", Expression: "passed / failed", Meta: CounterMeta{Unit: "%"}}.counter()
+
+	if err != nil || cd.Expression != "passed / failed" || cd.StepVal != 0 || cd.Unit != "%" {
+		t.Errorf("Derived counter is %+v %s", cd, err)
+	}
+
+	if _, err = (counterConfig{Name: "ratio", Expression: "passed", Step: aws.Int(2)}).counter(); err == nil || !strings.Contains(err.Error(), "no shards, value, step") {
+		t.Errorf("Derived counter with a step gave %v", err)
+	}
+
+	if _, err = (counterConfig{Name: "ratio", Expression: "passed +"}).counter(); err == nil {
+		t.Error("Bad expression was taken")
+	}
+
+	if err = check_derived(CountData{Expression: "passed", MaxVal: aws.Int(1)}); err == nil {
+		t.Error("Derived counter with bounds was taken")
+	}
+}
+
+func TestResolveReferences(t *testing.T) {
+	counters := derivedCounters()
+
+	refs, err := resolve_references([]string{"passed", "ratio", "passed"}, counters)
+
+	if err != nil || len(refs) != 2 || refs["passed"] != "p" || refs["ratio"] != "r" {
+		t.Errorf("References are %v %s", refs, err)
+	}
+
+	if _, err = resolve_references([]string{"skipped"}, counters); err == nil || err.Error() != "the group has no counter called skipped" {
+		t.Errorf("Unknown name gave %v", err)
+	}
+
+	counters = append(counters, CountData{CounterId: "p2", CounterName: "passed"})
+
+	if _, err = resolve_references([]string{"passed"}, counters); err == nil || !strings.Contains(err.Error(), "more than one") {
+		t.Errorf("Ambiguous name gave %v", err)
+	}
+}
+
+func TestRefersBack(t *testing.T) {
+	counters := append(derivedCounters(), CountData{CounterId: "d", CounterName: "doubled", Expression: "ratio * 2", References: map[string]string{"ratio": "r"}})
+	lookup := counter_lookup(counters)
+
+	// ratio naming doubled would go round
+	if cycle, _ := refers_back("r", map[string]string{"doubled": "d"}, lookup); !cycle {
+		t.Error("Cycle through doubled wasn't found")
+	}
+
+	if cycle, _ := refers_back("r", map[string]string{"ratio": "r"}, lookup); !cycle {
+		t.Error("Counter naming itself wasn't found")
+	}
+
+	if cycle, _ := refers_back("d", map[string]string{"passed": "p", "failed": "f"}, lookup); cycle {
+		t.Error("Counters with no way back were a cycle")
+	}
+
+	failing := func(id string) (CountData, error) { return CountData{}, fmt.Errorf("read failed") }
+
+	if _, err := refers_back("r", map[string]string{"doubled": "d"}, failing); err == nil {
+		t.Error("Read error was lost")
+	}
+}
+
+func TestDerive(t *testing.T) {
+	counters := derivedCounters()
+	group := func(groupId string) ([]CountData, error) { return counters, nil }
+
+	if v, err := new_derivation(counter_lookup(counters), group).derive(counters[2], 0); err != nil || v != 0.75 {
+		t.Errorf("Ratio is %v %s", v, err)
+	}
+
+	// ratio is left out of its own sum
+	summed := CountData{CounterGroup: expGroup.String(), Expression: "sum(env=prod) + ratio", References: map[string]string{"ratio": "r"}}
+
+	if v, err := new_derivation(counter_lookup(counters), group).derive(summed, 0); err != nil || v != 4.75 {
+		t.Errorf("Sum is %v %s", v, err)
+	}
+
+	if _, err := new_derivation(counter_lookup(counters[1:]), group).derive(counters[2], 0); err == nil || err.Error() != "counter passed has gone" {
+		t.Errorf("Deleted counter gave %v", err)
+	}
+
+	// a loop which got in anyway stops
+	loop := CountData{CounterId: "l", CounterGroup: expGroup.String(), Expression: "loop", References: map[string]string{"loop": "l"}}
+
+	if _, err := new_derivation(counter_lookup([]CountData{loop}), group).derive(loop, 0); err == nil || !strings.Contains(err.Error(), "more than 8 deep") {
+		t.Errorf("Loop gave %v", err)
+	}
+
+	var cd CountData
+
+	if set_derived(&cd, 2.6, nil); *cd.Derived != 2.6 || cd.CounterVal != 3 {
+		t.Errorf("Derived value is %+v", cd)
+	}
+
+	if set_derived(&cd, 0, fmt.Errorf("division by zero")); cd.DerivedError != "division by zero" {
+		t.Errorf("Derived error is %+v", cd)
+	}
+
+	for _, value := range []float64{math.Inf(1), math.Inf(-1), math.NaN()} {
+		cd = CountData{}
+
+		if set_derived(&cd, value, nil); cd.Derived != nil || cd.DerivedError == "" {
+			t.Errorf("%v gave %+v", value, cd)
+		}
+
+		if _, err := json.Marshal(cd); err != nil {
+			t.Errorf("%v can't be marshalled: %s", value, err)
+		}
+	}
+
+	// about 1e400 overflows
+	huge := CountData{CounterGroup: expGroup.String(), Expression: strings.Repeat("9", 200) + " * " + strings.Repeat("9", 200)}
+
+	if new_derivation(counter_lookup(counters), group).set(&huge); huge.Derived != nil || !strings.Contains(huge.DerivedError, "too big") {
+		t.Errorf("Overflow gave %+v", huge)
+	}
+}
+
+func TestDeriveOnce(t *testing.T) {
+	// each of d1 to d7 names the one before twice
+	counters := []CountData{{CounterId: "d0", CounterName: "d0", CounterVal: 1}}
+
+	for i := 1; i < maxDerivedDepth; i++ {
+		prev := fmt.Sprintf("d%d", i-1)
+		counters = append(counters, CountData{CounterId: fmt.Sprintf("d%d", i), Expression: prev + " + " + prev + " + sum(env=prod) - sum(env=prod)", References: map[string]string{prev: prev}})
+	}
+
+	reads := map[string]int{}
+	lookup := counter_lookup(counters)
+
+	dv := new_derivation(func(id string) (CountData, error) {
+		reads[id]++
+		return lookup(id)
+	}, func(groupId string) ([]CountData, error) {
+		return counters, nil
+	})
+
+	last := counters[len(counters)-1]
+
+	if v, err := dv.derive(last, 0); err != nil || v != 128 {
+		t.Errorf("Value is %v %s", v, err)
+	}
+
+	for id, n := range reads {
+		if n != 1 {
+			t.Errorf("%s read %d times", id, n)
+		}
+	}
+}
+
+func TestRelinkReferences(t *testing.T) {
+	counters := derivedCounters()
+
+	counters[0].CounterId, counters[1].CounterId = "p2", "f2"
+
+	if broken := relink_references(counters); len(broken) != 0 || counters[2].References["passed"] != "p2" || counters[2].References["failed"] != "f2" {
+		t.Errorf("Relinked %v, broken %v", counters[2].References, broken)
+	}
+
+	if broken := relink_references(counters[1:]); len(broken) != 1 || broken[0] != "ratio" || counters[2].References != nil {
+		t.Errorf("Relinked without passed %v, broken %v", counters[2].References, broken)
+	}
+}
+
+func TestImportDerived(t *testing.T) {
+	exported := append(derivedCounters(),
+		CountData{CounterId: "o", CounterName: "orphan", Expression: "skipped * 2"},
+		CountData{CounterId: "a", CounterName: "a", Expression: "b + 1"},
+		CountData{CounterId: "b", CounterName: "b", Expression: "a + 1"},
+		CountData{CounterId: "n", CounterName: "needsorphan", Expression: "orphan"},
+	)
+
+	planned, result := plan_import(exported, MakeUUID(), map[string]bool{}, nil, false, random_ids)
+
+	if len(planned) != 3 || len(result.Counters) != 3 || len(result.Conflicts) != 4 {
+		t.Fatalf("Import planned %v with conflicts %v", planned, result.Conflicts)
+	}
+
+	if ratio := planned[2]; ratio.References["passed"] != planned[0].CounterId || ratio.References["failed"] != planned[1].CounterId {
+		t.Errorf("Imported ratio names %v", ratio.References)
+	}
+
+	reasons := map[string]string{}
+
+	for _, ic := range result.Conflicts {
+		reasons[ic.Counter] = ic.Reason
+	}
+
+	if !strings.Contains(reasons["o"], "aren't imported") || !strings.Contains(reasons["a"], "leads back") || !strings.Contains(reasons["b"], "leads back") || !strings.Contains(reasons["n"], "aren't imported") {
+		t.Errorf("Conflicts are %v", reasons)
+	}
+}
+
+func TestDerivedRoutes(t *testing.T) {
+	mo := newMemoryOperator()
+	c := newV2Client(t, mo)
+
+	var group groupResource
+
+	c.do("POST", "/api/v2/groups", `{"name": "ci"}`, &group)
+
+	var passed, failed, ratio counterResource
+
+	c.do("POST", group.Links["counters"], `{"name": "passed", "value": 3}`, &passed)
+	c.do("POST", group.Links["counters"], `{"name": "failed", "value": 1}`, &failed)
+
+	if res := c.do("POST", group.Links["counters"], `{"name": "ratio", "expression": "passed / (passed + failed)"}`, &ratio); res.StatusCode != 200 || ratio.Expression == "" {
+		t.Fatalf("Derived counter gave %d %+v", res.StatusCode, ratio)
+	}
+
+	if c.do("GET", ratio.Links["self"], "", &ratio); ratio.Derived == nil || *ratio.Derived != 0.75 || ratio.Value != 1 {
+		t.Errorf("Ratio is %+v", ratio)
+	}
+
+	c.do("POST", failed.Links["increment"], "", nil)
+
+	if c.do("GET", ratio.Links["self"], "", &ratio); *ratio.Derived != 0.6 {
+		t.Errorf("Ratio after a failure is %v", *ratio.Derived)
+	}
+
+	if _, linked := ratio.Links["increment"]; linked {
+		t.Error("Derived counter has an increment link")
+	}
+
+	if res := c.do("POST", ratio.Links["self"]+"/increment", "", nil); res.StatusCode != 409 {
+		t.Errorf("Incrementing a derived counter gave %d", res.StatusCode)
+	}
+
+	var page collection[counterResource]
+
+	c.do("GET", group.Links["counters"], "", &page)
+
+	for _, item := range page.Items {
+		if (item.Name == "ratio") != (item.Derived != nil) {
+			t.Errorf("Listed %s derived as %v", item.Name, item.Derived)
+		}
+	}
+
+	if res := c.do("POST", group.Links["counters"], `{"name": "bad", "expression": "skipped + 1"}`, nil); res.StatusCode != 400 {
+		t.Errorf("Unknown name gave %d", res.StatusCode)
+	}
+
+	if res := c.do("POST", group.Links["counters"], `{"name": "bad", "expression": "passed", "step": 2}`, nil); res.StatusCode != 400 {
+		t.Errorf("Derived counter with a step gave %d", res.StatusCode)
+	}
+
+	path := "/api/v1/group/" + group.Id + "/counter/" + ratio.Id + "/expression"
+
+	if res := c.do("PUT", path, `{"expression": "passed - failed"}`, nil); res.StatusCode != 200 || mo.funcName[len(mo.funcName)-1] != "CounterExpression passed - failed" {
+		t.Errorf("Expression gave %d %v", res.StatusCode, mo.funcName)
+	}
+
+	if res := c.do("PUT", path, `{"expression": "passed -"}`, nil); res.StatusCode != 400 {
+		t.Errorf("Bad expression gave %d", res.StatusCode)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+type APIKeyData struct {
+	KeyId      string   `dynamodbav:"objectUUID" json:"objectUUID"`
+	ObjectType string   `dynamodbav:"objectType" json:"objectType"`
+	KeyName    string   `dynamodbav:"keyName" json:"keyName"`
+	KeyHash    string   `dynamodbav:"keyHash" json:"-"`
+	UserId     string   `dynamodbav:"userUUID" json:"userUUID"`
+	GroupId    string   `dynamodbav:"keyGroupUUID" json:"keyGroupUUID"`
+	Counters   []string `dynamodbav:"counters,stringset,omitempty" json:"counters,omitempty"`
+	Rights     []string `dynamodbav:"rights,stringset,omitempty" json:"rights"`
+}
+
+func append_apikey_create(ops []*dynamodb.TransactWriteItem, table *string, kd APIKeyData) ([]*dynamodb.TransactWriteItem, error) {
+	kd.ObjectType = "APIKey"
+
+	record, rerr := dynamodbattribute.MarshalMap(kd)
+
+	if rerr != nil {
+		return ops, rerr
+	}
+
+	input := dynamodb.Put{
+		TableName:           table,
+		Item:                record,
+		ConditionExpression: aws.String(fmt.Sprintf("attribute_not_exists(%s)", apiKeyIdCol)),
+	}
+
+	ops = append(ops, &dynamodb.TransactWriteItem{
+		Put: &input,
+	})
+
+	return ops, nil
+}
+
+func append_apikey_rotate(ops []*dynamodb.TransactWriteItem, table *string, keyId UUID, userId *UUID, keyHash string) ([]*dynamodb.TransactWriteItem, error) {
+	udr := dynamodb.Update{
+		Key: map[string]*dynamodb.AttributeValue{
+			apiKeyIdCol:   {S: aws.String(keyId.String())},
+			objectTypeCol: {S: aws.String("APIKey")},
+		},
+		TableName: table,
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":" + keyHashVal: {S: aws.String(keyHash)},
+			":" + userIdVal:  {S: aws.String(userId.String())},
+		},
+		UpdateExpression:    aws.String(fmt.Sprintf("SET %s = :%s", keyHashCol, keyHashVal)),
+		ConditionExpression: aws.String(fmt.Sprintf("%s = :%s", apiKeyUserCol, userIdVal)),
+	}
+
+	ops = append(ops, &dynamodb.TransactWriteItem{
+		Update: &udr,
+	})
+
+	return ops, nil
+}
+
+func append_apikey_delete(ops []*dynamodb.TransactWriteItem, table *string, keyId UUID, userId *UUID) ([]*dynamodb.TransactWriteItem, error) {
+	dr := dynamodb.Delete{
+		Key: map[string]*dynamodb.AttributeValue{
+			apiKeyIdCol:   {S: aws.String(keyId.String())},
+			objectTypeCol: {S: aws.String("APIKey")},
+		},
+		TableName: table,
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":" + userIdVal: {S: aws.String(userId.String())},
+		},
+		ConditionExpression: aws.String(fmt.Sprintf("%s = :%s", apiKeyUserCol, userIdVal)),
+	}
+
+	ops = append(ops, &dynamodb.TransactWriteItem{
+		Delete: &dr,
+	})
+
+	return ops, nil
+}
+
+// adds or removes a key in the owner's key list.  When groupId is given the
+// owner must also be a member of that group, which is how key creation checks
+// the caller is allowed to hand out rights on it.
+func append_user_key_update(ops []*dynamodb.TransactWriteItem, table *string, user *UUID, query string, keyId UUID, groupId *UUID) ([]*dynamodb.TransactWriteItem, error) {
+	values := map[string]*dynamodb.AttributeValue{
+		":val1": {SS: []*string{aws.String(keyId.String())}},
+	}
+
+	condition := fmt.Sprintf("attribute_exists(%s) and attribute_not_exists(%s)", userIdCol, deleteMarkerCol)
+
+	if groupId != nil {
+		values[":"+groupIdVal] = &dynamodb.AttributeValue{S: aws.String(groupId.String())}
+		condition += fmt.Sprintf(" and contains(%s, :%s)", groupListCol, groupIdVal)
+	}
+
+	udr := dynamodb.Update{
+		Key: map[string]*dynamodb.AttributeValue{
+			userIdCol:     {S: aws.String(user.String())},
+			objectTypeCol: {S: aws.String("User")},
+		},
+		TableName:                 table,
+		ExpressionAttributeValues: values,
+		UpdateExpression:          aws.String(query),
+		ConditionExpression:       aws.String(condition),
+	}
+
+	ops = append(ops, &dynamodb.TransactWriteItem{
+		Update: &udr,
+	})
+
+	return ops, nil
+}
+
+// checks that every counter is in the group, so a key can't be scoped to someone else's counter.
+func append_group_counter_check(ops []*dynamodb.TransactWriteItem, table *string, groupId *UUID, counters []string) ([]*dynamodb.TransactWriteItem, error) {
+	values := map[string]*dynamodb.AttributeValue{}
+	conditions := []string{fmt.Sprintf("attribute_exists(%s)", groupIdCol)}
+
+	for i, c := range counters {
+		name := fmt.Sprintf(":ctr%d", i)
+		values[name] = &dynamodb.AttributeValue{S: aws.String(c)}
+		conditions = append(conditions, fmt.Sprintf("contains(%s, %s)", counterListCol, name))
+	}
+
+	cc := dynamodb.ConditionCheck{
+		Key: map[string]*dynamodb.AttributeValue{
+			groupIdCol:    {S: aws.String(groupId.String())},
+			objectTypeCol: {S: aws.String("Group")},
+		},
+		TableName:                 table,
+		ExpressionAttributeValues: values,
+		ConditionExpression:       aws.String(strings.Join(conditions, " and ")),
+	}
+
+	if len(values) == 0 {
+		cc.ExpressionAttributeValues = nil
+	}
+
+	ops = append(ops, &dynamodb.TransactWriteItem{
+		ConditionCheck: &cc,
+	})
+
+	return ops, nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+var expAPIKeyTable = "apiKeyTable"
+
+func TestAPIKeyCreate(t *testing.T) {
+	var ops []*dynamodb.TransactWriteItem
+	var err error
+
+	keyId := MakeUUID()
+
+	ops, err = append_apikey_create(ops, &expAPIKeyTable, APIKeyData{
+		KeyId:   keyId.String(),
+		KeyName: "ci",
+		KeyHash: "abc",
+		UserId:  expUser.String(),
+		GroupId: expGroup.String(),
+		Rights:  []string{perm_inc},
+	})
+
+	checkError(t, err, nil)
+
+	checkOpsLen(t, ops, 1)
+
+	put := ops[0].Put
+
+	if put == nil {
+		t.Fatal("Expected put request was not present")
+	}
+
+	if *put.TableName != expAPIKeyTable {
+		t.Errorf("Table name is %s not %s", *put.TableName, expAPIKeyTable)
+	}
+
+	if *put.Item[apiKeyIdCol].S != keyId.String() || *put.Item[objectTypeCol].S != "APIKey" {
+		t.Error("Key not correct in new API key")
+	}
+
+	if *put.Item[keyHashCol].S != "abc" || *put.Item[apiKeyUserCol].S != expUser.String() {
+		t.Error("Hash or owner not correct in new API key")
+	}
+
+	if _, present := put.Item[counterListCol]; present {
+		t.Error("Empty counter list in record")
+	}
+
+	if rights := put.Item["rights"].SS; len(rights) != 1 || *rights[0] != perm_inc {
+		t.Errorf("Rights are %v", rights)
+	}
+}
+
+func TestAPIKeyRotate(t *testing.T) {
+	var ops []*dynamodb.TransactWriteItem
+	var err error
+
+	keyId := MakeUUID()
+
+	ops, err = append_apikey_rotate(ops, &expAPIKeyTable, keyId, &expUser, "newhash")
+
+	checkError(t, err, nil)
+
+	checkOpsLen(t, ops, 1)
+
+	ud := ops[0].Update
+
+	if ud == nil {
+		t.Fatal("Expected Update request was not present")
+	}
+
+	if *ud.Key[apiKeyIdCol].S != keyId.String() {
+		t.Errorf("Key is %s not %s", *ud.Key[apiKeyIdCol].S, keyId)
+	}
+
+	if *ud.ExpressionAttributeValues[":"+keyHashVal].S != "newhash" {
+		t.Error("New hash not in update")
+	}
+
+	if *ud.ExpressionAttributeValues[":"+userIdVal].S != expUser.String() {
+		t.Error("Rotation is not conditional on the owner")
+	}
+}
+
+func TestAPIKeyDelete(t *testing.T) {
+	var ops []*dynamodb.TransactWriteItem
+	var err error
+
+	keyId := MakeUUID()
+
+	ops, err = append_apikey_delete(ops, &expAPIKeyTable, keyId, &expUser)
+
+	checkError(t, err, nil)
+
+	checkOpsLen(t, ops, 1)
+
+	dd := ops[0].Delete
+
+	if dd == nil {
+		t.Fatal("Expected Delete request was not present")
+	}
+
+	if *dd.Key[apiKeyIdCol].S != keyId.String() || *dd.Key[objectTypeCol].S != "APIKey" {
+		t.Error("Wrong key in API key delete")
+	}
+
+	if *dd.ExpressionAttributeValues[":"+userIdVal].S != expUser.String() {
+		t.Error("Delete is not conditional on the owner")
+	}
+}
+
+func TestUserKeyUpdate(t *testing.T) {
+	var ops []*dynamodb.TransactWriteItem
+	var err error
+
+	keyId := MakeUUID()
+
+	ops, err = append_user_key_update(ops, &expUserTable, &expUser, uquery(usr_add_key), keyId, &expGroup)
+
+	checkError(t, err, nil)
+
+	checkOpsLen(t, ops, 1)
+
+	checkUserUpdate(t, ops[0], keyId, "ADD apikeys :val1", expUserTable, expUser)
+
+	expCond := "attribute_exists(objectUUID) and attribute_not_exists(deleteMarker) and contains(groups, :groupId)"
+
+	if cond := *ops[0].Update.ConditionExpression; cond != expCond {
+		t.Errorf("Condition is %s not %s", cond, expCond)
+	}
+
+	ops, err = append_user_key_update(nil, &expUserTable, &expUser, uquery(usr_remove_key), keyId, nil)
+
+	checkError(t, err, nil)
+
+	checkUserUpdate(t, ops[0], keyId, "DELETE apikeys :val1", expUserTable, expUser)
+
+	if _, present := ops[0].Update.ExpressionAttributeValues[":"+groupIdVal]; present {
+		t.Error("Group check on key removal")
+	}
+}
+
+func TestGroupCounterCheck(t *testing.T) {
+	var ops []*dynamodb.TransactWriteItem
+	var err error
+
+	c1 := MakeUUID().String()
+	c2 := MakeUUID().String()
+
+	ops, err = append_group_counter_check(ops, &expGroupTable, &expGroup, []string{c1, c2})
+
+	checkError(t, err, nil)
+
+	checkOpsLen(t, ops, 1)
+
+	cc := ops[0].ConditionCheck
+
+	if cc == nil {
+		t.Fatal("Expected ConditionCheck was not present")
+	}
+
+	expCond := "attribute_exists(objectUUID) and contains(counters, :ctr0) and contains(counters, :ctr1)"
+
+	if *cc.ConditionExpression != expCond {
+		t.Errorf("Condition is %s not %s", *cc.ConditionExpression, expCond)
+	}
+
+	if *cc.ExpressionAttributeValues[":ctr0"].S != c1 || *cc.ExpressionAttributeValues[":ctr1"].S != c2 {
+		t.Error("Counter values are wrong in the condition check")
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// A bounded counter's increments and decrements are conditional on the
+// count being far enough inside its bounds for the step to stay inside
+// them.  DynamoDB can't add in a condition, so the step is the one read
+// before the update, and the update is conditional on it being the same.
+
+// the value of the step read with the bounds
+const boundStepVal = "boundstep"
+
+// what an update needs to know of a counter: its shards, step and bounds,
+// and whether it is derived.  The step can be out of date, which the
+// bounds' condition catches.
+func (dbo DynamoOperator) counter_config(ctx context.Context, counterId UUID) (CountData, error) {
+	out, err := dbo.dbi.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			counterIdCol:  {S: aws.String(counterId.String())},
+			objectTypeCol: {S: &dbo.counterType},
+		},
+		TableName:            &dbo.counterTable,
+		ProjectionExpression: aws.String(strings.Join([]string{shardsCol, stepCol, minCol, maxCol, expressionCol}, ", ")),
+	})
+
+	var cd CountData
+
+	if err != nil {
+		return cd, err
+	}
+
+	cderr := dynamodbattribute.UnmarshalMap(out.Item, &cd)
+
+	return cd, cderr
+}
+
+// holds the counter update to the counter's bounds, if it has any and the
+// update is an increment or decrement
+func bound_update(op *dynamodb.TransactWriteItem, cd CountData, query string) {
+	sign := map[string]int{dnquery(dq_current, dq_inc): 1, dnquery(dq_current, dq_dec): -1}[query]
+
+	if op.Update == nil || sign == 0 || (cd.MinVal == nil && cd.MaxVal == nil) {
+		return
+	}
+
+	upd := op.Update
+	delta := sign * cd.StepVal
+
+	number := func(n int) *dynamodb.AttributeValue {
+		return &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(n))}
+	}
+
+	upd.ExpressionAttributeValues[":"+boundStepVal] = number(cd.StepVal)
+	conditions := []string{*upd.ConditionExpression, fmt.Sprintf("%s = :%s", stepCol, boundStepVal)}
+
+	if cd.MinVal != nil {
+		upd.ExpressionAttributeValues[":"+lowVal] = number(*cd.MinVal - delta)
+		conditions = append(conditions, fmt.Sprintf("%s >= :%s", counterCol, lowVal))
+	}
+
+	if cd.MaxVal != nil {
+		upd.ExpressionAttributeValues[":"+highVal] = number(*cd.MaxVal - delta)
+		conditions = append(conditions, fmt.Sprintf("%s <= :%s", counterCol, highVal))
+	}
+
+	upd.ConditionExpression = aws.String(strings.Join(conditions, " and "))
+	upd.ReturnValuesOnConditionCheckFailure = aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld)
+}
+
+// a 409 if a bounded update failed on its bounds or its step, nil if it
+// failed for some other reason or wasn't bounded
+func bounds_error(op *dynamodb.TransactWriteItem, item map[string]*dynamodb.AttributeValue) error {
+	if op.Update == nil {
+		return nil
+	}
+
+	values := op.Update.ExpressionAttributeValues
+	step, bounded := values[":"+boundStepVal]
+
+	if !bounded {
+		return nil
+	}
+
+	if group := item[counterGroupCol]; group == nil || group.S == nil || *group.S != *values[":"+groupIdVal].S {
+		return nil
+	}
+
+	if current := item[stepCol]; current == nil || current.N == nil || *current.N != *step.N {
+		return conflict("the counter's step changed, try again")
+	}
+
+	return conflict("the counter can't go past its bounds")
+}
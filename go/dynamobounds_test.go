@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+func boundedEnv(step int, min *int, max *int) (Session, DynamoOperator, *MockDBInterface) {
+	s, dbo, dbi := mockEnv(expUser, expGroup, "foo@bar.com")
+
+	dbi.gio.Item, _ = dynamodbattribute.MarshalMap(CountData{
+		CounterId:    expCounterUUID.String(),
+		CounterGroup: expGroup.String(),
+		ObjectType:   "Counter",
+		StepVal:      step,
+		MinVal:       min,
+		MaxVal:       max,
+	})
+
+	return s, dbo, dbi
+}
+
+func TestBoundedUpdate(t *testing.T) {
+	s, dbo, dbi := boundedEnv(3, aws.Int(0), aws.Int(10))
+
+	dbo.CounterUpdate(context.Background(), s, expCounterUUID, dnquery(dq_current, dq_inc), 1)
+
+	upd := dbi.twi.TransactItems[0].Update
+
+	if !strings.HasSuffix(*upd.ConditionExpression, " and stepVal = :boundstep and countVal >= :low and countVal <= :high") {
+		t.Errorf("Increment condition is %s", *upd.ConditionExpression)
+	}
+
+	// an increment of 3 can't start below -3 or above 7
+	if *upd.ExpressionAttributeValues[":low"].N != "-3" || *upd.ExpressionAttributeValues[":high"].N != "7" || *upd.ExpressionAttributeValues[":boundstep"].N != "3" {
+		t.Errorf("Increment values are %v", upd.ExpressionAttributeValues)
+	}
+
+	dbo.CounterUpdate(context.Background(), s, expCounterUUID, dnquery(dq_current, dq_dec), 1)
+
+	upd = dbi.twi.TransactItems[0].Update
+
+	if *upd.ExpressionAttributeValues[":low"].N != "3" || *upd.ExpressionAttributeValues[":high"].N != "13" {
+		t.Errorf("Decrement values are %v", upd.ExpressionAttributeValues)
+	}
+
+	// resets and step changes aren't held to the bounds
+	dbo.CounterUpdate(context.Background(), s, expCounterUUID, dnquery(dq_current, dq_base), 1)
+
+	if upd = dbi.twi.TransactItems[0].Update; strings.Contains(*upd.ConditionExpression, "boundstep") {
+		t.Errorf("Reset condition is %s", *upd.ConditionExpression)
+	}
+
+	if *upd.UpdateExpression != "SET stepVal=if_not_exists(stepVal,:stepinit),countVal=if_not_exists(baseline,:countinit), version = if_not_exists(version, :vzero) + :vone" {
+		t.Errorf("Reset is %s", *upd.UpdateExpression)
+	}
+
+	// only the upper bound
+	s, dbo, dbi = boundedEnv(1, nil, aws.Int(5))
+
+	dbo.CounterUpdate(context.Background(), s, expCounterUUID, dnquery(dq_current, dq_inc), 1)
+
+	if upd = dbi.twi.TransactItems[0].Update; strings.Contains(*upd.ConditionExpression, ":low") || *upd.ExpressionAttributeValues[":high"].N != "4" {
+		t.Errorf("Upper bound only is %s %v", *upd.ConditionExpression, upd.ExpressionAttributeValues)
+	}
+}
+
+func TestBoundsError(t *testing.T) {
+	s, dbo, dbi := boundedEnv(2, nil, aws.Int(10))
+
+	at, _ := dynamodbattribute.MarshalMap(CountData{CounterGroup: expGroup.String(), StepVal: 2, CounterVal: 9})
+	dbi.twErrs = []error{cancelled(at)}
+
+	if res, _ := dbo.CounterUpdate(context.Background(), s, expCounterUUID, dnquery(dq_current, dq_inc), 1); res.StatusCode != 409 || res.Body != "the counter can't go past its bounds" {
+		t.Errorf("Increment past max gave %d %s", res.StatusCode, res.Body)
+	}
+
+	stepped, _ := dynamodbattribute.MarshalMap(CountData{CounterGroup: expGroup.String(), StepVal: 5, CounterVal: 1})
+	dbi.twErrs = []error{cancelled(stepped)}
+
+	if res, _ := dbo.CounterUpdate(context.Background(), s, expCounterUUID, dnquery(dq_current, dq_inc), 1); res.StatusCode != 409 || !strings.Contains(res.Body, "step changed") {
+		t.Errorf("Changed step gave %d %s", res.StatusCode, res.Body)
+	}
+
+	elsewhere, _ := dynamodbattribute.MarshalMap(CountData{CounterGroup: MakeUUID().String(), StepVal: 2})
+	dbi.twErrs = []error{cancelled(elsewhere)}
+
+	if res, _ := dbo.CounterUpdate(context.Background(), s, expCounterUUID, dnquery(dq_current, dq_inc), 1); res.StatusCode != 404 {
+		t.Errorf("Counter in another group gave %d %s", res.StatusCode, res.Body)
+	}
+}
+
+func TestCounterCreateConfig(t *testing.T) {
+	s, dbo, dbi := mockEnv(expUser, expGroup, "foo@bar.com")
+
+	dbo.CounterCreate(context.Background(), s, CountData{CounterName: "cups", CounterVal: 4, StepVal: 2, Baseline: 4, MaxVal: aws.Int(12)})
+
+	item := dbi.twi.TransactItems[0].Put.Item
+
+	if *item[counterCol].N != "4" || *item[stepCol].N != "2" || *item[baselineCol].N != "4" || *item[maxCol].N != "12" {
+		t.Errorf("New counter is %v", item)
+	}
+
+	if _, found := item[minCol]; found {
+		t.Error("Counter has a min it wasn't given")
+	}
+
+	if res, _ := dbo.CounterCreate(context.Background(), s, CountData{CounterName: "cups", MinVal: aws.Int(1)}); res.StatusCode != 400 {
+		t.Errorf("Value below min gave %d %s", res.StatusCode, res.Body)
+	}
+}
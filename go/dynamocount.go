@@ -19,26 +19,44 @@ type CountData struct {
 	CounterGroup string `json:"counterGroupUUID"`
 	CounterVal   int    `json:"countVal"`
 	StepVal      int    `json:"stepVal"`
+	Version      int    `json:"version"`
+	Shards       int    `json:"shards,omitempty"`
 	ObjectType   string `json:"objectType"`
+
+	// what a reset goes back to, and the bounds the count is kept in
+	Baseline int  `json:"baseline,omitempty"`
+	MinVal   *int `json:"minVal,omitempty"`
+	MaxVal   *int `json:"maxVal,omitempty"`
+
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// a derived counter's expression, with the ids of the counters it names.
+	// Its value is worked out when it is read, and isn't stored.
+	Expression   string            `json:"expression,omitempty"`
+	References   map[string]string `json:"references,omitempty"`
+	Derived      *float64          `json:"derived,omitempty" dynamodbav:"-"`
+	DerivedError string            `json:"derivedError,omitempty" dynamodbav:"-"`
+
+	CounterMeta
 }
 
-func append_counter_create(ops []*dynamodb.TransactWriteItem, table *string, counterUUID UUID, counterName string, groupId *UUID) ([]*dynamodb.TransactWriteItem, error) {
-	record, rerr := dynamodbattribute.MarshalMap(CountData{
-		CounterId:    counterUUID.String(),
-		ObjectType:   "Counter",
-		CounterName:  counterName,
-		CounterGroup: groupId.String(),
-		CounterVal:   0,
-		StepVal:      1,
-	})
+// cd is the counter as it starts, by default at 0 with a step of 1.  Shards is
+// 0 for an ordinary counter, and the shards themselves are added separately.
+// The id mustn't be in use, which only an imported one can be.
+func append_counter_create(ops []*dynamodb.TransactWriteItem, table *string, cd CountData) ([]*dynamodb.TransactWriteItem, error) {
+	cd.ObjectType = "Counter"
+	cd.Version = 1
+
+	record, rerr := dynamodbattribute.MarshalMap(cd)
 
 	if rerr != nil {
 		return ops, rerr
 	}
 
 	input := dynamodb.Put{
-		TableName: table,
-		Item:      record,
+		TableName:           table,
+		Item:                record,
+		ConditionExpression: aws.String(fmt.Sprintf("attribute_not_exists(%s)", counterIdCol)),
 	}
 
 	ops = append(ops, &dynamodb.TransactWriteItem{
@@ -48,23 +66,29 @@ func append_counter_create(ops []*dynamodb.TransactWriteItem, table *string, cou
 	return ops, nil
 }
 
-func append_counter_update(ops []*dynamodb.TransactWriteItem, table *string, groupId *UUID, counterId UUID, query string, stepval int) ([]*dynamodb.TransactWriteItem, error) {
+// ifMatch, if not nil, is the version the counter has to be at
+func append_counter_update(ops []*dynamodb.TransactWriteItem, table *string, groupId *UUID, counterId UUID, query string, stepval int, ifMatch *int) ([]*dynamodb.TransactWriteItem, error) {
+	values := map[string]*dynamodb.AttributeValue{
+		":" + stepInit:    {N: aws.String(fmt.Sprintf("%d", stepval))},
+		":" + counterInit: {N: aws.String("0")},
+		":" + groupIdVal:  {S: aws.String(groupId.String())},
+	}
+
+	update := version_bump(query, values)
+	condition, onFailure := version_condition(fmt.Sprintf("attribute_exists(%s) and %s = :%s", counterIdCol, counterGroupCol, groupIdVal), values, ifMatch)
+
 	udr := dynamodb.Update{
 		Key: map[string]*dynamodb.AttributeValue{
 			counterIdCol:  {S: aws.String(counterId.String())},
 			objectTypeCol: {S: aws.String("Counter")},
 		},
-		TableName: table,
-		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":" + stepInit:    {N: aws.String(fmt.Sprintf("%d", stepval))},
-			":" + counterInit: {N: aws.String("0")},
-			":" + groupIdVal:  {S: aws.String(groupId.String())},
-		},
-		UpdateExpression:    aws.String(query),
-		ConditionExpression: aws.String(fmt.Sprintf("attribute_exists(%s) and %s = :%s", counterIdCol, counterGroupCol, groupIdVal)),
+		TableName:                           table,
+		ExpressionAttributeValues:           values,
+		UpdateExpression:                    aws.String(update),
+		ConditionExpression:                 aws.String(condition),
+		ReturnValuesOnConditionCheckFailure: onFailure,
 	}
 
-	//log.Print("Update Query: ", query)
 	ops = append(ops, &dynamodb.TransactWriteItem{
 		Update: &udr,
 	})
@@ -72,17 +96,22 @@ func append_counter_update(ops []*dynamodb.TransactWriteItem, table *string, gro
 	return ops, nil
 }
 
-func append_counter_delete(ops []*dynamodb.TransactWriteItem, table *string, groupId *UUID, counterId UUID) ([]*dynamodb.TransactWriteItem, error) {
+func append_counter_delete(ops []*dynamodb.TransactWriteItem, table *string, groupId *UUID, counterId UUID, ifMatch *int) ([]*dynamodb.TransactWriteItem, error) {
+	values := map[string]*dynamodb.AttributeValue{
+		":" + groupIdVal: {S: aws.String(groupId.String())},
+	}
+
+	condition, onFailure := version_condition(fmt.Sprintf("%s = :%s", counterGroupCol, groupIdVal), values, ifMatch)
+
 	dr := dynamodb.Delete{
 		Key: map[string]*dynamodb.AttributeValue{
 			counterIdCol:  {S: aws.String(counterId.String())},
 			objectTypeCol: {S: aws.String("Counter")},
 		},
-		TableName: table,
-		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":" + groupIdVal: {S: aws.String(groupId.String())},
-		},
-		ConditionExpression: aws.String(fmt.Sprintf("%s = :%s", counterGroupCol, groupIdVal)),
+		TableName:                           table,
+		ExpressionAttributeValues:           values,
+		ConditionExpression:                 aws.String(condition),
+		ReturnValuesOnConditionCheckFailure: onFailure,
 	}
 
 	ops = append(ops, &dynamodb.TransactWriteItem{
@@ -97,6 +126,9 @@ const (
 	dq_current = iota
 	dq_inc     = iota
 	dq_dec     = iota
+
+	// the counter's baseline, 0 if it hasn't one
+	dq_base = iota
 )
 
 func dnquery(stepmode int, countermode int) string {
@@ -108,6 +140,8 @@ func dnquery(stepmode int, countermode int) string {
 			return fmt.Sprintf(":%s", defaultName)
 		case dq_current:
 			return fmt.Sprintf("if_not_exists(%s,:%s)", colName, defaultName)
+		case dq_base:
+			return fmt.Sprintf("if_not_exists(%s,:%s)", baselineCol, defaultName)
 		}
 		return ""
 	}
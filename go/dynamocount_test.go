@@ -58,7 +58,7 @@ func TestCounterCreate(t *testing.T) {
 
 	newid := MakeUUID()
 
-	ops, err = append_counter_create(ops, &expCounterTable, newid, expCounterName, &expGroup)
+	ops, err = append_counter_create(ops, &expCounterTable, CountData{CounterId: newid.String(), CounterName: expCounterName, CounterGroup: expGroup.String(), StepVal: 1})
 
 	checkError(t, err, nil)
 
@@ -71,13 +71,17 @@ func TestCounterUpdate(t *testing.T) {
 	var ops []*dynamodb.TransactWriteItem
 	var err error
 
-	ops, err = append_counter_update(ops, &expCounterTable, &expGroup, expCounterUUID, "hello world", 12345)
+	ops, err = append_counter_update(ops, &expCounterTable, &expGroup, expCounterUUID, "SET hello world", 12345, nil)
 
 	checkError(t, err, nil)
 
 	checkOpsLen(t, ops, 1)
 
-	checkCounterUpdate(t, ops[0], 12345, "hello world", expCounterTable, expGroup, expCounterUUID)
+	checkCounterUpdate(t, ops[0], 12345, "SET hello world, version = if_not_exists(version, :vzero) + :vone", expCounterTable, expGroup, expCounterUUID)
+
+	if ops[0].Update.ReturnValuesOnConditionCheckFailure != nil {
+		t.Error("Unconditional update asks for the old item")
+	}
 }
 
 func TestCounterDelete(t *testing.T) {
@@ -86,7 +90,7 @@ func TestCounterDelete(t *testing.T) {
 
 	dc := MakeUUID()
 
-	ops, err = append_counter_delete(ops, &expCounterTable, &expGroup, dc)
+	ops, err = append_counter_delete(ops, &expCounterTable, &expGroup, dc, nil)
 
 	checkError(t, err, nil)
 
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// replaces a derived counter's expression and references.  An ordinary
+// counter can't be made derived, as the condition is that it already is.
+func append_counter_expression(ops []*dynamodb.TransactWriteItem, table *string, groupId *UUID, counterId UUID, expression string, refs map[string]string, ifMatch *int) ([]*dynamodb.TransactWriteItem, error) {
+	mv, merr := dynamodbattribute.Marshal(refs)
+
+	if merr != nil {
+		return ops, merr
+	}
+
+	values := map[string]*dynamodb.AttributeValue{
+		":" + groupIdVal:    {S: aws.String(groupId.String())},
+		":" + expressionVal: {S: aws.String(expression)},
+		":" + referencesVal: mv,
+	}
+
+	update := version_bump(fmt.Sprintf("SET %s = :%s, %s = :%s", expressionCol, expressionVal, referencesCol, referencesVal), values)
+	condition, onFailure := version_condition(fmt.Sprintf("attribute_exists(%s) and %s = :%s", expressionCol, counterGroupCol, groupIdVal), values, ifMatch)
+
+	udr := dynamodb.Update{
+		Key: map[string]*dynamodb.AttributeValue{
+			counterIdCol:  {S: aws.String(counterId.String())},
+			objectTypeCol: {S: aws.String("Counter")},
+		},
+		TableName:                           table,
+		ExpressionAttributeValues:           values,
+		UpdateExpression:                    aws.String(update),
+		ConditionExpression:                 aws.String(condition),
+		ReturnValuesOnConditionCheckFailure: onFailure,
+	}
+
+	ops = append(ops, &dynamodb.TransactWriteItem{
+		Update: &udr,
+	})
+
+	return ops, nil
+}
+
+// the counters of a group, read whether or not the session's user is in it
+func (dbo DynamoOperator) group_members(ctx context.Context, groupId string) ([]CountData, error) {
+	id, ierr := ToUUID(groupId)
+
+	if ierr != nil {
+		return nil, ierr
+	}
+
+	gd, gerr := dbo.read_group(ctx, id)
+
+	if gerr != nil {
+		return nil, gerr
+	}
+
+	return dbo.read_counters(ctx, gd.Counters)
+}
+
+// the ids of the counters an expression names in the session's group, which
+// have to be ones the session's key can use
+func (dbo DynamoOperator) expression_references(ctx context.Context, s Session, expression string) (map[string]string, []CountData, error) {
+	node, names, perr := parse_expression(expression)
+
+	if perr != nil {
+		return nil, nil, perr
+	}
+
+	counters, cerr := dbo.group_members(ctx, *s.GetGroupIdString())
+
+	if cerr != nil {
+		return nil, nil, cerr
+	}
+
+	refs, rerr := resolve_references(names, counters)
+
+	if rerr == nil {
+		rerr = key_expression_scope(s.GetAPIKey(), node, refs)
+	}
+
+	return refs, counters, rerr
+}
+
+// works out derived values as counters are read, reading each counter and
+// group they need once.  Groups are only read if there is a sum.  Counters
+// already read are known, and aren't read again.
+func (dbo DynamoOperator) derivation(ctx context.Context, known ...CountData) derivation {
+	counters := map[string]CountData{}
+
+	for _, cd := range known {
+		counters[cd.CounterId] = cd
+	}
+	members := map[string][]CountData{}
+
+	counter := func(id string) (CountData, error) {
+		if cd, found := counters[id]; found {
+			return cd, nil
+		}
+
+		counterId, ierr := ToUUID(id)
+
+		if ierr != nil {
+			return CountData{}, ierr
+		}
+
+		cd, err := dbo.read_counter(ctx, counterId)
+
+		if err == nil {
+			counters[id] = cd
+		}
+
+		return cd, err
+	}
+
+	group := func(groupId string) ([]CountData, error) {
+		if gc, found := members[groupId]; found {
+			return gc, nil
+		}
+
+		gc, err := dbo.group_members(ctx, groupId)
+
+		if err == nil {
+			members[groupId] = gc
+
+			for _, cd := range gc {
+				counters[cd.CounterId] = cd
+			}
+		}
+
+		return gc, err
+	}
+
+	return new_derivation(counter, group)
+}
+
+func (dbo DynamoOperator) CounterExpression(ctx context.Context, s Session, id UUID, expression string) (Response, error) {
+	refs, counters, err := dbo.expression_references(ctx, s, expression)
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	byId := map[string]CountData{}
+
+	for _, cd := range counters {
+		byId[cd.CounterId] = cd
+	}
+
+	if target, found := byId[id.String()]; !found {
+		return makeerror(fmt.Errorf("counter %s not found", id.String()))
+	} else if target.Expression == "" {
+		return makeerror(conflict("counter %s isn't derived, so it has no expression", id.String()))
+	}
+
+	// the derived counters the cycle check went through
+	var through []CountData
+
+	cycle, cerr := refers_back(id.String(), refs, func(ref string) (CountData, error) {
+		if cd := byId[ref]; cd.Expression != "" {
+			through = append(through, cd)
+		}
+
+		return byId[ref], nil
+	})
+
+	if cerr != nil {
+		return makeerror(cerr)
+	}
+
+	if cycle {
+		return makeerror(bad_request("the expression leads back to counter %s", id.String()))
+	}
+
+	ops, oerr := append_counter_expression(nil, &dbo.counterTable, s.GetGroupId(), id, expression, refs, s.GetIfMatch())
+
+	if oerr != nil {
+		return makeerror(oerr)
+	}
+
+	// none of them can have changed since, or a concurrent change could
+	// have made a cycle the check didn't see
+	for _, cd := range through {
+		counterId, ierr := ToUUID(cd.CounterId)
+
+		if ierr != nil {
+			return makeerror(ierr)
+		}
+
+		if ops, oerr = append_counter_check(ops, &dbo.counterTable, s.GetGroupId(), counterId, &cd.Version); oerr != nil {
+			return makeerror(oerr)
+		}
+	}
+
+	return dbo.commit(ctx, s, ops, id)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// the group with passed, failed and ratio derived from them, and the
+// counters by name
+func derivedEnv(t *testing.T) (Session, DynamoOperator, *MockDBInterface, map[string]CountData) {
+	s, dbo, dbi := mockEnv(expUser, expGroup, "foo@bar.com")
+
+	counters := map[string]CountData{}
+	gd := GroupData{GroupId: expGroup.String(), GroupName: "ci"}
+	dbi.items = map[string]map[string]*dynamodb.AttributeValue{}
+
+	for _, cd := range derivedCounters() {
+		id := MakeUUID().String()
+
+		for name, ref := range cd.References {
+			cd.References[name] = counters[map[string]string{"p": "passed", "f": "failed"}[ref]].CounterId
+		}
+
+		cd.CounterId, cd.ObjectType = id, "Counter"
+		counters[cd.CounterName] = cd
+		gd.Counters = append(gd.Counters, id)
+
+		item, err := dynamodbattribute.MarshalMap(cd)
+		checkError(t, err, nil)
+		dbi.items[id] = item
+	}
+
+	item, err := dynamodbattribute.MarshalMap(gd)
+	checkError(t, err, nil)
+	dbi.items[gd.GroupId] = item
+
+	return s, dbo, dbi, counters
+}
+
+func TestDBODerivedRead(t *testing.T) {
+	s, dbo, _, counters := derivedEnv(t)
+
+	res, _ := dbo.CounterRead(context.Background(), s, must_uuid(t, counters["ratio"].CounterId))
+
+	var cd CountData
+
+	checkError(t, json.Unmarshal([]byte(res.Body), &cd), nil)
+
+	if cd.Derived == nil || *cd.Derived != 0.75 || cd.CounterVal != 1 || cd.References["passed"] != counters["passed"].CounterId {
+		t.Errorf("Ratio is %s", res.Body)
+	}
+
+	// what is worked out isn't stored
+	item, _ := dynamodbattribute.MarshalMap(cd)
+
+	if _, stored := item["derived"]; stored {
+		t.Errorf("Derived value would be stored: %v", item)
+	}
+}
+
+func TestDBODerivedReadMany(t *testing.T) {
+	s, dbo, dbi, counters := derivedEnv(t)
+
+	ids := []UUID{must_uuid(t, counters["ratio"].CounterId), must_uuid(t, counters["passed"].CounterId), must_uuid(t, counters["failed"].CounterId)}
+	res, _ := dbo.CounterReadMany(context.Background(), s, ids)
+
+	var read []CountData
+
+	checkError(t, json.Unmarshal([]byte(res.Body), &read), nil)
+
+	if len(read) != 3 || read[0].Derived == nil || *read[0].Derived != 0.75 || read[1].CounterName != "passed" {
+		t.Errorf("Counters are %s", res.Body)
+	}
+
+	// what ratio names was on the page, so nothing was read on its own
+	if len(dbi.bgis) != 1 || dbi.gii.Key != nil {
+		t.Errorf("Read %d batches and %v", len(dbi.bgis), dbi.gii.Key)
+	}
+
+	if res, _ = dbo.CounterReadMany(context.Background(), s, []UUID{ids[0], MakeUUID()}); res.StatusCode != 404 {
+		t.Errorf("Missing counter gave %d %s", res.StatusCode, res.Body)
+	}
+}
+
+func TestDBODerivedCreate(t *testing.T) {
+	s, dbo, dbi, counters := derivedEnv(t)
+
+	res, _ := dbo.CounterCreate(context.Background(), s, CountData{CounterName: "sum", Expression: "passed + ratio"})
+
+	if res.StatusCode != 200 {
+		t.Fatalf("Create gave %d %s", res.StatusCode, res.Body)
+	}
+
+	var created CountData
+
+	checkError(t, dynamodbattribute.UnmarshalMap(dbi.twi.TransactItems[0].Put.Item, &created), nil)
+
+	if created.Expression != "passed + ratio" || len(created.References) != 2 || created.References["ratio"] != counters["ratio"].CounterId {
+		t.Errorf("Derived counter is %+v", created)
+	}
+
+	writes := len(dbi.twis)
+
+	if res, _ = dbo.CounterCreate(context.Background(), s, CountData{CounterName: "bad", Expression: "skipped"}); res.StatusCode != 400 || len(dbi.twis) != writes {
+		t.Errorf("Unknown name gave %d %s", res.StatusCode, res.Body)
+	}
+
+	if res, _ = dbo.CounterCreate(context.Background(), s, CountData{CounterName: "bad", Expression: "passed", Shards: 2}); res.StatusCode != 400 {
+		t.Errorf("Sharded derived counter gave %d %s", res.StatusCode, res.Body)
+	}
+}
+
+func TestDBOCounterExpression(t *testing.T) {
+	s, dbo, dbi, counters := derivedEnv(t)
+	ratio := must_uuid(t, counters["ratio"].CounterId)
+
+	if res, _ := dbo.CounterExpression(context.Background(), s, ratio, "passed - failed"); res.StatusCode != 200 {
+		t.Fatalf("Expression gave %d %s", res.StatusCode, res.Body)
+	}
+
+	upd := dbi.twi.TransactItems[0].Update
+
+	if *upd.UpdateExpression != "SET expression = :expression, references = :references, version = if_not_exists(version, :vzero) + :vone" {
+		t.Errorf("Update is %s", *upd.UpdateExpression)
+	}
+
+	if !strings.HasPrefix(*upd.ConditionExpression, "attribute_exists(expression) and counterGroupUUID = :groupId") {
+		t.Errorf("Condition is %s", *upd.ConditionExpression)
+	}
+
+	if refs := upd.ExpressionAttributeValues[":references"].M; *refs["failed"].S != counters["failed"].CounterId {
+		t.Errorf("References are %v", refs)
+	}
+
+	// doubled names ratio, so ratio can't name doubled
+	doubled := CountData{CounterId: MakeUUID().String(), CounterName: "doubled", CounterGroup: expGroup.String(), Expression: "ratio * 2", References: map[string]string{"ratio": ratio.String()}}
+	dbi.items[doubled.CounterId], _ = dynamodbattribute.MarshalMap(doubled)
+
+	var gd GroupData
+
+	dynamodbattribute.UnmarshalMap(dbi.items[expGroup.String()], &gd)
+	gd.Counters = append(gd.Counters, doubled.CounterId)
+	dbi.items[expGroup.String()], _ = dynamodbattribute.MarshalMap(gd)
+
+	writes := len(dbi.twis)
+
+	if res, _ := dbo.CounterExpression(context.Background(), s, ratio, "doubled / 2"); res.StatusCode != 400 || !strings.Contains(res.Body, "leads back") || len(dbi.twis) != writes {
+		t.Errorf("Cycle gave %d %s", res.StatusCode, res.Body)
+	}
+
+	// the derived counters named can't change before the new expression is written
+	if res, _ := dbo.CounterExpression(context.Background(), s, must_uuid(t, doubled.CounterId), "ratio * 3"); res.StatusCode != 200 {
+		t.Fatalf("Expression naming ratio gave %d %s", res.StatusCode, res.Body)
+	}
+
+	if ops := dbi.twi.TransactItems; len(ops) != 2 || ops[1].ConditionCheck == nil || *ops[1].ConditionCheck.Key[counterIdCol].S != ratio.String() || !strings.Contains(*ops[1].ConditionCheck.ConditionExpression, versionCol) {
+		t.Errorf("Expression update is %v", ops)
+	}
+
+	checkGranted(t, dbi.twi.TransactItems)
+
+	if res, _ := dbo.CounterExpression(context.Background(), s, must_uuid(t, counters["passed"].CounterId), "failed"); res.StatusCode != 409 {
+		t.Errorf("Ordinary counter gave %d %s", res.StatusCode, res.Body)
+	}
+
+	if res, _ := dbo.CounterExpression(context.Background(), s, MakeUUID(), "failed"); res.StatusCode != 404 {
+		t.Errorf("Missing counter gave %d %s", res.StatusCode, res.Body)
+	}
+}
+
+// a key limited to some counters can't give an expression any others
+func TestDBOScopedKeyExpression(t *testing.T) {
+	s, dbo, dbi, counters := derivedEnv(t)
+	ratio := counters["ratio"].CounterId
+
+	s.(*APISession).apiKey = &APIKeyData{GroupId: expGroup.String(), Rights: []string{perm_config}, Counters: []string{ratio, counters["passed"].CounterId}}
+
+	if res, _ := dbo.CounterExpression(context.Background(), s, must_uuid(t, ratio), "passed * 2"); res.StatusCode != 200 {
+		t.Errorf("Expression in scope gave %d %s", res.StatusCode, res.Body)
+	}
+
+	writes := len(dbi.twis)
+
+	for _, expression := range []string{"passed - failed", "sum(env=prod)"} {
+		if res, _ := dbo.CounterExpression(context.Background(), s, must_uuid(t, ratio), expression); res.StatusCode != 400 || len(dbi.twis) != writes {
+			t.Errorf("%s gave %d %s", expression, res.StatusCode, res.Body)
+		}
+	}
+}
+
+func TestDBODerivedUpdate(t *testing.T) {
+	s, dbo, dbi, counters := derivedEnv(t)
+
+	for _, query := range []string{dnquery(dq_current, dq_inc), dnquery(dq_current, dq_base), dnquery(dq_init, dq_current)} {
+		if res, _ := dbo.CounterUpdate(context.Background(), s, must_uuid(t, counters["ratio"].CounterId), query, 2); res.StatusCode != 409 {
+			t.Errorf("%s gave %d %s", query, res.StatusCode, res.Body)
+		}
+	}
+
+	if len(dbi.twis) != 0 {
+		t.Errorf("Derived counter was written %d times", len(dbi.twis))
+	}
+}
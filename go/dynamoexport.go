@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// DynamoDB takes up to 100 items in a transaction
+const maxTransactItems = 100
+
+// adds counters to a group in one update, as a transaction can only touch
+// the group once
+func append_group_add_counters(ops []*dynamodb.TransactWriteItem, table *string, groupId *UUID, counterIds []string) ([]*dynamodb.TransactWriteItem, error) {
+	values := map[string]*dynamodb.AttributeValue{
+		":val1": {SS: aws.StringSlice(counterIds)},
+	}
+
+	udr := dynamodb.Update{
+		Key: map[string]*dynamodb.AttributeValue{
+			groupIdCol:    {S: aws.String(groupId.String())},
+			objectTypeCol: {S: aws.String("Group")},
+		},
+		TableName:                 table,
+		ExpressionAttributeValues: values,
+		UpdateExpression:          aws.String(version_bump(gquery(gr_add_ctr), values)),
+		ConditionExpression:       aws.String(fmt.Sprintf("attribute_exists(%s) and attribute_not_exists(%s)", groupIdCol, deleteMarkerCol)),
+	}
+
+	ops = append(ops, &dynamodb.TransactWriteItem{
+		Update: &udr,
+	})
+
+	return ops, nil
+}
+
+// the transactions which make counters, each under the item limit with
+// room for reserve more items, which close adds to each, e.g. the group's
+// update with the ids of the counters in it.  A counter and its shards are
+// always in the same transaction.
+func counter_transactions(counters []CountData, table *string, groupId *UUID, reserve int, close func(tx int, ops []*dynamodb.TransactWriteItem, ids []string) ([]*dynamodb.TransactWriteItem, error)) ([][]*dynamodb.TransactWriteItem, error) {
+	var txs [][]*dynamodb.TransactWriteItem
+	var ops []*dynamodb.TransactWriteItem
+	var ids []string
+	var err error
+
+	flush := func() {
+		if len(ids) > 0 && err == nil {
+			ops, err = close(len(txs), ops, ids)
+			txs = append(txs, ops)
+		}
+
+		ops, ids = nil, nil
+	}
+
+	for _, cd := range counters {
+		if len(ops)+1+cd.Shards+reserve > maxTransactItems {
+			flush()
+		}
+
+		id, _ := ToUUID(cd.CounterId)
+
+		ops, err = append_counter_create(ops, table, cd)
+
+		for shard := 0; err == nil && shard < cd.Shards; shard++ {
+			ops, err = append_shard_create(ops, table, id, groupId, shard, cd.StepVal)
+		}
+
+		ids = append(ids, cd.CounterId)
+	}
+
+	flush()
+
+	return txs, err
+}
+
+// the session's group and everything in it, if the user is in it.  Only a
+// user or a key with the admin right gets the permissions.
+func (dbo DynamoOperator) GroupExport(ctx context.Context, s Session) (ExportData, error) {
+	var ed ExportData
+
+	ud, uerr := dbo.read_user(ctx, *s.GetUserId())
+
+	if uerr != nil {
+		return ed, uerr
+	}
+
+	if !slices.Contains(ud.Groups, *s.GetGroupIdString()) {
+		return ed, fmt.Errorf("group %s not found", *s.GetGroupIdString())
+	}
+
+	gd, gerr := dbo.read_group(ctx, *s.GetGroupId())
+
+	if gerr != nil {
+		return ed, gerr
+	}
+
+	ed.Group = gd
+	ed.Counters = []CountData{}
+
+	for _, id := range gd.Counters {
+		counterId, ierr := ToUUID(id)
+
+		if ierr != nil {
+			return ed, ierr
+		}
+
+		cd, cerr := dbo.read_counter(ctx, counterId)
+
+		if cerr != nil {
+			return ed, cerr
+		}
+
+		ed.Counters = append(ed.Counters, cd)
+	}
+
+	if key := s.GetAPIKey(); key != nil && !slices.Contains(key.Rights, perm_admin) {
+		return ed, nil
+	}
+
+	for _, id := range ud.APIKeys {
+		keyId, ierr := ToUUID(id)
+
+		if ierr != nil {
+			continue
+		}
+
+		if kd, kerr := dbo.read_apikey(ctx, keyId); kerr == nil && kd.GroupId == gd.GroupId {
+			ed.Permissions = append(ed.Permissions, kd)
+		}
+	}
+
+	return ed, nil
+}
+
+// makes the exported counters in the session's group.  Nothing is written on
+// a dry run or if any counter conflicts, which is a 409 when it wasn't a dry
+// run.  An import too big for one transaction can be finished by retrying it
+// with the same idempotency key, which makes the same counters again, so
+// those it already made don't conflict.
+func (dbo DynamoOperator) GroupImport(ctx context.Context, s Session, ed ExportData, preserveIds bool, dryRun bool) (Response, error) {
+	_, existing, gerr := dbo.read_group_counters(ctx, s)
+
+	if gerr != nil {
+		return makeerror(gerr)
+	}
+
+	newId := func(n int) UUID { return key_uuid(s, "counter", n) }
+	resumed := map[string]bool{}
+
+	if s.GetIdempotencyKey() != nil {
+		for n, cd := range ed.Counters {
+			id := newId(n).String()
+
+			if preserveIds {
+				id = cd.CounterId
+			}
+
+			resumed[id] = true
+		}
+	}
+
+	taken := map[string]bool{}
+	ours := map[string]bool{}
+
+	for _, cd := range existing {
+		if resumed[cd.CounterId] {
+			ours[cd.CounterId] = true
+		} else {
+			taken[cd.CounterName] = true
+		}
+	}
+
+	exists := func(id UUID) bool {
+		if ours[id.String()] {
+			return false
+		}
+
+		cd, _ := dbo.read_counter(ctx, id)
+		return cd.CounterId != ""
+	}
+
+	counters, result := plan_import(ed.Counters, *s.GetGroupId(), taken, exists, preserveIds, newId)
+	result.DryRun = dryRun
+
+	if dryRun || len(result.Conflicts) > 0 {
+		res, err := makeresponse(result)
+
+		if !dryRun && err == nil && res.StatusCode == 200 {
+			res.StatusCode = http.StatusConflict
+		}
+
+		return res, err
+	}
+
+	txs, terr := counter_transactions(counters, &dbo.counterTable, s.GetGroupId(), 1, func(tx int, ops []*dynamodb.TransactWriteItem, ids []string) ([]*dynamodb.TransactWriteItem, error) {
+		return append_group_add_counters(ops, &dbo.groupTable, s.GetGroupId(), ids)
+	})
+
+	if terr != nil {
+		return makeerror(terr)
+	}
+
+	if len(txs) == 0 {
+		return makeresponse(result)
+	}
+
+	result.Transactions = len(txs)
+
+	return dbo.commit_all(ctx, s, txs, result)
+}
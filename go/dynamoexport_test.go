@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+func TestImportTransactions(t *testing.T) {
+	var counters []CountData
+
+	// 30 items each with the shards, so three fit in a transaction with the group
+	for i := 0; i < 7; i++ {
+		counters = append(counters, CountData{CounterId: MakeUUID().String(), CounterName: "c", CounterGroup: expGroup.String(), StepVal: 3, Shards: 29})
+	}
+
+	counters = append(counters, CountData{CounterId: MakeUUID().String(), CounterName: "plain", CounterGroup: expGroup.String()})
+
+	txs, err := counter_transactions(counters, &expCounterTable, &expGroup, 1, func(tx int, ops []*dynamodb.TransactWriteItem, ids []string) ([]*dynamodb.TransactWriteItem, error) {
+		return append_group_add_counters(ops, &expGroupTable, &expGroup, ids)
+	})
+
+	checkError(t, err, nil)
+
+	if len(txs) != 3 {
+		t.Fatalf("%d transactions", len(txs))
+	}
+
+	for i, exp := range []int{91, 91, 32} {
+		checkOpsLen(t, txs[i], exp)
+
+		last := txs[i][len(txs[i])-1]
+
+		if last.Update == nil || *last.Update.TableName != expGroupTable || *last.Update.Key[groupIdCol].S != expGroup.String() {
+			t.Errorf("Transaction %d ends with %v", i, last)
+		}
+	}
+
+	if put := txs[0][0].Put; put == nil || *put.ConditionExpression != "attribute_not_exists(objectUUID)" || *put.Item[counterNameCol].S != "c" {
+		t.Errorf("First op is %v", txs[0][0])
+	}
+
+	// shards step like the counter
+	if shard := txs[0][1].Put; shard == nil || *shard.Item[stepCol].N != "3" || *shard.Item[objectTypeCol].S != "Counter#0" {
+		t.Errorf("First shard is %v", txs[0][1])
+	}
+
+	if ids := txs[2][len(txs[2])-1].Update.ExpressionAttributeValues[":val1"].SS; len(ids) != 2 || *ids[1] != counters[7].CounterId {
+		t.Errorf("Last group update adds %v", ids)
+	}
+}
+
+// the user's group with two counters and an API key in it
+func exportEnv(t *testing.T) (DynamoOperator, []string) {
+	_, dbo, dbi := mockEnv(expUser, expGroup, "foo@bar.com")
+
+	ids := []string{MakeUUID().String(), MakeUUID().String()}
+	keyId := MakeUUID().String()
+
+	dbi.items = map[string]map[string]*dynamodb.AttributeValue{}
+
+	for id, record := range map[string]any{
+		expUser.String():  UserData{UserId: expUser.String(), Groups: []string{expGroup.String()}, APIKeys: []string{keyId}},
+		expGroup.String(): GroupData{GroupId: expGroup.String(), Counters: ids},
+		ids[0]:            CountData{CounterId: ids[0], CounterName: "tea", CounterGroup: expGroup.String()},
+		ids[1]:            CountData{CounterId: ids[1], CounterName: "coffee", CounterGroup: expGroup.String()},
+		keyId:             APIKeyData{KeyId: keyId, GroupId: expGroup.String(), Rights: []string{perm_read}},
+	} {
+		item, err := dynamodbattribute.MarshalMap(record)
+		checkError(t, err, nil)
+		dbi.items[id] = item
+	}
+
+	return dbo, ids
+}
+
+func TestDBOGroupExport(t *testing.T) {
+	dbo, ids := exportEnv(t)
+
+	ed, err := dbo.GroupExport(context.Background(), &APISession{userId: expUser, groupId: expGroup})
+
+	if err != nil || len(ed.Counters) != 2 || len(ed.Permissions) != 1 {
+		t.Errorf("User export is %+v %s", ed, err)
+	}
+
+	key := &APISession{userId: expUser, groupId: expGroup, apiKey: &APIKeyData{GroupId: expGroup.String(), Rights: []string{perm_read}, Counters: ids[1:]}}
+	route := "GET /api/v1/group/{group}/export"
+
+	if key.Allows(private_rights[route], Request{RouteKey: route}) {
+		t.Error("Scoped key can export")
+	}
+
+	key.apiKey.Counters = nil
+
+	if ed, err = dbo.GroupExport(context.Background(), key); err != nil || len(ed.Counters) != 2 || ed.Permissions != nil {
+		t.Errorf("Read key export is %+v %s", ed, err)
+	}
+
+	key.apiKey.Rights = []string{perm_read, perm_admin}
+
+	if ed, err = dbo.GroupExport(context.Background(), key); err != nil || len(ed.Counters) != 2 || len(ed.Permissions) != 1 {
+		t.Errorf("Admin key export is %+v %s", ed, err)
+	}
+}
+
+func TestDBOGroupImportResume(t *testing.T) {
+	dbo, ids := exportEnv(t)
+	dbi := dbo.dbi.(*MockDBInterface)
+
+	s := &APISession{userId: expUser, groupId: expGroup, idempotencyKey: &IdempotencyKey{Key: "k1", Request: "POST /import"}}
+
+	// three transactions' worth
+	var exported []CountData
+
+	for i := 0; i < 7; i++ {
+		exported = append(exported, CountData{CounterId: MakeUUID().String(), CounterName: fmt.Sprintf("c%d", i), StepVal: 1, Shards: 29})
+	}
+
+	if res, _ := dbo.GroupImport(context.Background(), s, ExportData{Counters: exported}, false, false); res.StatusCode != 200 || len(dbi.twis) != 3 {
+		t.Fatalf("Import gave %d %s in %d transactions", res.StatusCode, res.Body, len(dbi.twis))
+	}
+
+	first := dbi.twis
+
+	// as if it stopped after the first transaction
+	gd := GroupData{GroupId: expGroup.String(), Counters: ids}
+
+	for _, op := range first[0].TransactItems {
+		if op.Put != nil && *op.Put.Item[objectTypeCol].S == "Counter" {
+			dbi.items[*op.Put.Item[counterIdCol].S] = op.Put.Item
+			gd.Counters = append(gd.Counters, *op.Put.Item[counterIdCol].S)
+		}
+	}
+
+	dbi.items[expGroup.String()], _ = dynamodbattribute.MarshalMap(gd)
+	dbi.twis = nil
+
+	if res, _ := dbo.GroupImport(context.Background(), s, ExportData{Counters: exported}, false, false); res.StatusCode != 200 || len(dbi.twis) != 2 {
+		t.Fatalf("Retry gave %d %s in %d transactions", res.StatusCode, res.Body, len(dbi.twis))
+	}
+
+	if id := dbi.twis[0].TransactItems[0].Put.Item[counterIdCol].S; *id != *first[1].TransactItems[0].Put.Item[counterIdCol].S {
+		t.Errorf("Retry made counter %s", *id)
+	}
+
+	last := dbi.twis[1].TransactItems
+
+	if put := last[len(last)-1].Put; put == nil || *put.Item[objectTypeCol].S != idempotency_type("k1") {
+		t.Errorf("Last transaction ends with %v", last[len(last)-1])
+	}
+}
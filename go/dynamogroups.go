@@ -9,17 +9,21 @@ import (
 )
 
 type GroupData struct {
-	GroupId    string   `dynamodbav:"objectUUID"`
-	Counters   []string `dynamodbav:"counters,stringset,omitempty"`
-	GroupName  string   `dynamodbav:"groupName"`
-	ObjectType string   `dynamodbav:"objectType"`
+	GroupId    string   `dynamodbav:"objectUUID" json:"objectUUID"`
+	Counters   []string `dynamodbav:"counters,stringset,omitempty" json:"counters"`
+	GroupName  string   `dynamodbav:"groupName" json:"groupName"`
+	Version    int      `dynamodbav:"version" json:"version"`
+	ObjectType string   `dynamodbav:"objectType" json:"-"`
 }
 
-func append_group_create(ops []*dynamodb.TransactWriteItem, table *string, groupUUID UUID, groupName string) ([]*dynamodb.TransactWriteItem, error) {
+// counters are the ids of any counters made in the same transaction
+func append_group_create(ops []*dynamodb.TransactWriteItem, table *string, groupUUID UUID, groupName string, counters []string) ([]*dynamodb.TransactWriteItem, error) {
 	record, rerr := dynamodbattribute.MarshalMap(GroupData{
 		GroupId:    groupUUID.String(),
 		ObjectType: "Group",
 		GroupName:  groupName,
+		Counters:   counters,
+		Version:    1,
 	})
 
 	if rerr != nil {
@@ -38,21 +42,27 @@ func append_group_create(ops []*dynamodb.TransactWriteItem, table *string, group
 	return ops, nil
 }
 
-func append_group_update(ops []*dynamodb.TransactWriteItem, table *string, groupId *UUID, query string, val1 UUID) ([]*dynamodb.TransactWriteItem, error) {
+// ifMatch, if not nil, is the version the group has to be at
+func append_group_update(ops []*dynamodb.TransactWriteItem, table *string, groupId *UUID, query string, val1 UUID, ifMatch *int) ([]*dynamodb.TransactWriteItem, error) {
+	values := map[string]*dynamodb.AttributeValue{
+		":val1": {SS: []*string{aws.String(val1.String())}},
+	}
+
+	update := version_bump(query, values)
+	condition, onFailure := version_condition(fmt.Sprintf("attribute_exists(%s) and attribute_not_exists(%s)", groupIdCol, deleteMarkerCol), values, ifMatch)
+
 	udr := dynamodb.Update{
 		Key: map[string]*dynamodb.AttributeValue{
 			groupIdCol:    {S: aws.String(groupId.String())},
 			objectTypeCol: {S: aws.String("Group")},
 		},
-		TableName: table,
-		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":val1": {SS: []*string{aws.String(val1.String())}},
-		},
-		UpdateExpression:    aws.String(query),
-		ConditionExpression: aws.String(fmt.Sprintf("attribute_exists(%s) and attribute_not_exists(%s)", groupIdCol, deleteMarkerCol)),
+		TableName:                           table,
+		ExpressionAttributeValues:           values,
+		UpdateExpression:                    aws.String(update),
+		ConditionExpression:                 aws.String(condition),
+		ReturnValuesOnConditionCheckFailure: onFailure,
 	}
 
-	//log.Print("Update Query: ", query)
 	ops = append(ops, &dynamodb.TransactWriteItem{
 		Update: &udr,
 	})
@@ -75,7 +85,6 @@ func group_mark_delete(ops []*dynamodb.TransactWriteItem, table *string, groupId
 		ConditionExpression: aws.String(fmt.Sprintf("attribute_exists(%s)", groupIdCol)),
 	}
 
-	//log.Print("Update Query: ", query)
 	ops = append(ops, &dynamodb.TransactWriteItem{
 		Update: &udr,
 	})
@@ -90,7 +99,6 @@ func group_fulldelete(ops []*dynamodb.TransactWriteItem, table *string, groupId
 		TableName: table,
 	}
 
-	//log.Print("Update Query: ", query)
 	ops = append(ops, &dynamodb.TransactWriteItem{
 		Delete: &dr,
 	})
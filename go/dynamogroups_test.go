@@ -13,7 +13,7 @@ func TestGroupCreate(t *testing.T) {
 	var ops []*dynamodb.TransactWriteItem
 	var err error
 
-	ops, err = append_group_create(ops, &expGroupTable, expGroup, expGroupName)
+	ops, err = append_group_create(ops, &expGroupTable, expGroup, expGroupName, nil)
 
 	checkError(t, err, nil)
 
@@ -28,11 +28,11 @@ func TestGroupUpdate(t *testing.T) {
 
 	nuuid := MakeUUID()
 
-	ops, err = append_group_update(ops, &expGroupTable, &expGroup, "hello world", nuuid)
+	ops, err = append_group_update(ops, &expGroupTable, &expGroup, "ADD hello world", nuuid, nil)
 
 	checkError(t, err, nil)
 
 	checkOpsLen(t, ops, 1)
 
-	checkGroupUpdate(t, ops[0], nuuid, "hello world", expGroupTable, expGroup, expUser)
+	checkGroupUpdate(t, ops[0], nuuid, "ADD hello world SET version = if_not_exists(version, :vzero) + :vone", expGroupTable, expGroup, expUser)
 }
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// A change made with an Idempotency-Key header records the key, and the
+// response it got, in the same transaction as the change.  A retry with the
+// same key then fails that transaction and gets the recorded response back
+// instead of being applied twice.  Keys belong to the user, so the record
+// lives under the user with the sort key "Idempotency#<key>".
+type IdempotencyData struct {
+	UserId     string            `dynamodbav:"objectUUID"`
+	ObjectType string            `dynamodbav:"objectType"`
+	Request    string            `dynamodbav:"request"`
+	StatusCode int               `dynamodbav:"statusCode"`
+	Headers    map[string]string `dynamodbav:"headers,omitempty"`
+	Body       string            `dynamodbav:"body"`
+	ExpiresAt  int64             `dynamodbav:"expiresAt"`
+}
+
+// the key from the header and the request it was first used for
+type IdempotencyKey struct {
+	Key     string
+	Request string
+}
+
+const idempotencyTypePrefix = "Idempotency#"
+
+// long enough to cover any client's retries
+const idempotencyTTL = 24 * time.Hour
+
+const maxIdempotencyKey = 255
+
+// set on responses which are replays of an earlier one
+const replayHeader = "Idempotent-Replayed"
+
+const nowVal = "now"
+
+func idempotency_type(key string) string {
+	return idempotencyTypePrefix + key
+}
+
+// the key from an Idempotency-Key header, or nil if there isn't one.  A key
+// can only be reused for the same method, path and query.
+func parse_idempotency_key(header string, req Request) (*IdempotencyKey, error) {
+	if header == "" {
+		return nil, nil
+	}
+
+	if len(header) > maxIdempotencyKey {
+		return nil, fmt.Errorf("Idempotency-Key is longer than %d characters", maxIdempotencyKey)
+	}
+
+	for _, c := range header {
+		if c < ' ' || c > '~' {
+			return nil, fmt.Errorf("Idempotency-Key has a character which is not printable ASCII")
+		}
+	}
+
+	request := req.RequestContext.HTTP.Method + " " + req.RawPath
+
+	if req.RawQueryString != "" {
+		request += "?" + req.RawQueryString
+	}
+
+	return &IdempotencyKey{Key: header, Request: request}, nil
+}
+
+// records the response for a key.  An expired record which TTL hasn't got round
+// to deleting yet doesn't count.
+func append_idempotency_put(ops []*dynamodb.TransactWriteItem, table *string, userId *UUID, ik *IdempotencyKey, res Response, now time.Time) ([]*dynamodb.TransactWriteItem, error) {
+	record, rerr := dynamodbattribute.MarshalMap(IdempotencyData{
+		UserId:     userId.String(),
+		ObjectType: idempotency_type(ik.Key),
+		Request:    ik.Request,
+		StatusCode: res.StatusCode,
+		Headers:    res.Headers,
+		Body:       res.Body,
+		ExpiresAt:  now.Add(idempotencyTTL).Unix(),
+	})
+
+	if rerr != nil {
+		return ops, rerr
+	}
+
+	input := dynamodb.Put{
+		TableName: table,
+		Item:      record,
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":" + nowVal: {N: aws.String(strconv.FormatInt(now.Unix(), 10))},
+		},
+		ConditionExpression:                 aws.String(fmt.Sprintf("attribute_not_exists(%s) or %s < :%s", userIdCol, expiresAtCol, nowVal)),
+		ReturnValuesOnConditionCheckFailure: aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld),
+	}
+
+	ops = append(ops, &dynamodb.TransactWriteItem{
+		Put: &input,
+	})
+
+	return ops, nil
+}
+
+// the recorded response if a transaction was cancelled because its key, the
+// last op, had been used already.  The record comes back with the cancellation.
+func idempotent_replay(err error, ops []*dynamodb.TransactWriteItem, ik *IdempotencyKey) (Response, bool, error) {
+	tce, ok := err.(*dynamodb.TransactionCanceledException)
+
+	if !ok || len(tce.CancellationReasons) != len(ops) {
+		return Response{}, false, nil
+	}
+
+	reason := tce.CancellationReasons[len(ops)-1]
+
+	if reason.Code == nil || *reason.Code != "ConditionalCheckFailed" || reason.Item == nil {
+		return Response{}, false, nil
+	}
+
+	var id IdempotencyData
+
+	if uerr := dynamodbattribute.UnmarshalMap(reason.Item, &id); uerr != nil {
+		return Response{}, true, uerr
+	}
+
+	if id.Request != ik.Request {
+		res, rerr := makeerror(statusError{
+			status: http.StatusUnprocessableEntity,
+			err:    fmt.Errorf("Idempotency-Key %s was used for %s", ik.Key, id.Request),
+		})
+		return res, true, rerr
+	}
+
+	headers := map[string]string{}
+
+	for k, v := range id.Headers {
+		headers[k] = v
+	}
+
+	headers[replayHeader] = "true"
+
+	return Response{StatusCode: id.StatusCode, Headers: headers, Body: id.Body}, true, nil
+}
+
+// true for a successful response which isn't a replay, i.e. one which changed something
+func applied(res Response) bool {
+	return res.StatusCode == 200 && res.Headers[replayHeader] == ""
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+func idempotentEnv(key string, request string) (Session, DynamoOperator, *MockDBInterface) {
+	s, dbo, dbi := mockEnv(expUser, expGroup, "foo@bar.com")
+
+	s.(*APISession).idempotencyKey = &IdempotencyKey{Key: key, Request: request}
+
+	return s, dbo, dbi
+}
+
+// what DynamoDB says when the key's put was the op which failed
+func keyUsed(ops int, id IdempotencyData) error {
+	item, _ := dynamodbattribute.MarshalMap(id)
+
+	reasons := make([]*dynamodb.CancellationReason, ops)
+
+	for i := range reasons {
+		reasons[i] = &dynamodb.CancellationReason{Code: aws.String("None")}
+	}
+
+	reasons[ops-1] = &dynamodb.CancellationReason{Code: aws.String("ConditionalCheckFailed"), Item: item}
+
+	return &dynamodb.TransactionCanceledException{CancellationReasons: reasons}
+}
+
+func TestParseIdempotencyKey(t *testing.T) {
+	req := Request{RawPath: "/api/v1/counter/abc/step", RawQueryString: "stepVal=2"}
+	req.RequestContext.HTTP.Method = "PATCH"
+
+	if ik, err := parse_idempotency_key("", req); err != nil || ik != nil {
+		t.Errorf("No header gave %v %s", ik, err)
+	}
+
+	ik, err := parse_idempotency_key("retry-1", req)
+
+	checkError(t, err, nil)
+
+	if ik.Key != "retry-1" || ik.Request != "PATCH /api/v1/counter/abc/step?stepVal=2" {
+		t.Errorf("Key is %v", ik)
+	}
+
+	for _, bad := range []string{strings.Repeat("k", maxIdempotencyKey+1), "bad\nkey", "ключ"} {
+		if _, err := parse_idempotency_key(bad, req); err == nil {
+			t.Errorf("Key %q accepted", bad)
+		}
+	}
+}
+
+func TestIdempotentCounterUpdate(t *testing.T) {
+	s, dbo, dbi := idempotentEnv("retry-1", "POST /inc")
+
+	res, err := dbo.CounterUpdate(context.Background(), s, expCounterUUID, dnquery(dq_current, dq_inc), 1)
+
+	checkError(t, err, nil)
+
+	if res.StatusCode != 200 || res.Headers[replayHeader] != "" {
+		t.Errorf("Update gave %d %v", res.StatusCode, res.Headers)
+	}
+
+	checkOpsLen(t, dbi.twi.TransactItems, 2)
+
+	put := dbi.twi.TransactItems[1].Put
+
+	if put == nil || *put.TableName != "IdempotencyTable" {
+		t.Fatalf("Key not recorded: %v", dbi.twi.TransactItems[1])
+	}
+
+	var id IdempotencyData
+
+	dynamodbattribute.UnmarshalMap(put.Item, &id)
+
+	if id.UserId != expUser.String() || id.ObjectType != "Idempotency#retry-1" || id.Request != "POST /inc" {
+		t.Errorf("Recorded %v", id)
+	}
+
+	if id.StatusCode != 200 || id.Body != res.Body {
+		t.Errorf("Recorded response %d %s not %s", id.StatusCode, id.Body, res.Body)
+	}
+
+	if ttl := time.Until(time.Unix(id.ExpiresAt, 0)); ttl < idempotencyTTL-time.Minute || ttl > idempotencyTTL {
+		t.Errorf("Key expires in %s", ttl)
+	}
+
+	if *put.ConditionExpression != "attribute_not_exists(objectUUID) or expiresAt < :now" {
+		t.Errorf("Condition is %s", *put.ConditionExpression)
+	}
+
+	// the retry
+	dbi.twErrs = []error{keyUsed(2, id)}
+
+	replay, rerr := dbo.CounterUpdate(context.Background(), s, expCounterUUID, dnquery(dq_current, dq_inc), 1)
+
+	checkError(t, rerr, nil)
+
+	if replay.StatusCode != 200 || replay.Body != res.Body || replay.Headers[replayHeader] != "true" {
+		t.Errorf("Retry gave %d %s %v", replay.StatusCode, replay.Body, replay.Headers)
+	}
+
+	if applied(replay) || !applied(res) {
+		t.Error("Replay treated as a change")
+	}
+}
+
+func TestIdempotencyKeyReused(t *testing.T) {
+	s, dbo, dbi := idempotentEnv("retry-1", "POST /dec")
+
+	dbi.twErrs = []error{keyUsed(2, IdempotencyData{Request: "POST /inc", StatusCode: 200, Body: "{}"})}
+
+	res, _ := dbo.CounterUpdate(context.Background(), s, expCounterUUID, dnquery(dq_current, dq_dec), 1)
+
+	if res.StatusCode != 422 {
+		t.Errorf("Key reused for another request gave %d %s", res.StatusCode, res.Body)
+	}
+}
+
+func TestIdempotencyOtherFailure(t *testing.T) {
+	s, dbo, dbi := idempotentEnv("retry-1", "POST /inc")
+
+	// the counter's condition failed, not the key's
+	dbi.twErrs = []error{cancelledWith("ConditionalCheckFailed", "None")}
+
+	res, _ := dbo.CounterUpdate(context.Background(), s, expCounterUUID, dnquery(dq_current, dq_inc), 1)
+
+	if res.StatusCode != 404 || res.Headers[replayHeader] != "" {
+		t.Errorf("Failed update gave %d %v", res.StatusCode, res.Headers)
+	}
+}
+
+func TestIdempotentAPIKeyCreate(t *testing.T) {
+	s, dbo, dbi := idempotentEnv("retry-1", "POST /apikey")
+
+	res, _ := dbo.APIKeyCreate(context.Background(), s, "ci", expGroup, nil, []string{"read"})
+
+	if res.StatusCode != 200 || !strings.Contains(res.Body, `"Key":"`) {
+		t.Fatalf("Create gave %d %s", res.StatusCode, res.Body)
+	}
+
+	ops := dbi.twi.TransactItems
+	put := ops[len(ops)-1].Put
+
+	var id IdempotencyData
+
+	dynamodbattribute.UnmarshalMap(put.Item, &id)
+
+	if strings.Contains(id.Body, "Key") {
+		t.Errorf("Secret recorded: %s", id.Body)
+	}
+}
@@ -1,15 +1,23 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // real dynamo DB interface.  All the code which actually talks to dynamo is in here.
 // can be mocked for testing purposes and that's why it is separated like this.
 
-func Create_DynamoDBInterface() dynamodbiface.DynamoDBAPI {
+func Create_DynamoDBInterface() DBInterface {
 	sess := session.Must(session.NewSessionWithOptions(session.Options{
 		SharedConfigState: session.SharedConfigEnable,
 	}))
@@ -17,5 +25,192 @@ func Create_DynamoDBInterface() dynamodbiface.DynamoDBAPI {
 	//Create DynamoDB client
 	svc := dynamodb.New(sess)
 
-	return dynamodbiface.DynamoDBAPI(svc)
+	return timedDB{dbi: svc}
+}
+
+// a call gives up this long before the lambda's deadline, which leaves time
+// to send back a timeout error instead of the function being killed.
+const deadlineReserve = 500 * time.Millisecond
+
+// no single call takes longer than this, however long the request has left
+const callTimeout = 3 * time.Second
+
+// timedDB gives every call its own timeout, cut short by the request's
+// deadline, logs the call at debug level and traces it.
+type timedDB struct {
+	dbi DBInterface
+}
+
+func call_context(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := callTimeout
+
+	if deadline, found := ctx.Deadline(); found {
+		timeout = min(timeout, time.Until(deadline)-deadlineReserve)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+func (td timedDB) TransactWriteItemsWithContext(ctx aws.Context, input *dynamodb.TransactWriteItemsInput, opts ...request.Option) (*dynamodb.TransactWriteItemsOutput, error) {
+	ctx, span := start_db_span(ctx, "TransactWriteItems")
+
+	cctx, cancel := call_context(ctx)
+	defer cancel()
+
+	start := time.Now()
+
+	out, err := td.dbi.TransactWriteItemsWithContext(cctx, input, opts...)
+
+	if span.IsRecording() {
+		span.SetAttributes(
+			attribute.StringSlice("aws.dynamodb.table_names", op_tables(input.TransactItems)),
+			attribute.Int("aws.dynamodb.item_count", len(input.TransactItems)),
+		)
+
+		if reasons := cancellation_reasons(err); reasons != nil {
+			span.SetAttributes(attribute.StringSlice("aws.dynamodb.cancellation_reasons", reasons))
+		}
+	}
+
+	end_span(span, err)
+
+	// describing the ops is too much work to do for nothing
+	if l := logger(ctx); l.Enabled(ctx, slog.LevelDebug) {
+		l.Debug("dynamodb transaction", "ops", describe_ops(input.TransactItems), "latencyMs", time.Since(start).Milliseconds(), "error", err)
+	}
+
+	return out, err
+}
+
+func (td timedDB) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	ctx, span := start_db_span(ctx, "GetItem")
+
+	cctx, cancel := call_context(ctx)
+	defer cancel()
+
+	start := time.Now()
+
+	out, err := td.dbi.GetItemWithContext(cctx, input, opts...)
+
+	span.SetAttributes(attribute.StringSlice("aws.dynamodb.table_names", []string{aws.StringValue(input.TableName)}))
+
+	if err == nil && out.Item != nil {
+		span.SetAttributes(attribute.Int("aws.dynamodb.item_count", 1))
+	}
+
+	end_span(span, err)
+
+	logger(ctx).Debug("dynamodb get", "table", aws.StringValue(input.TableName), "key", describe_key(input.Key), "latencyMs", time.Since(start).Milliseconds(), "error", err)
+
+	return out, err
+}
+
+func (td timedDB) BatchGetItemWithContext(ctx aws.Context, input *dynamodb.BatchGetItemInput, opts ...request.Option) (*dynamodb.BatchGetItemOutput, error) {
+	ctx, span := start_db_span(ctx, "BatchGetItem")
+
+	cctx, cancel := call_context(ctx)
+	defer cancel()
+
+	start := time.Now()
+
+	out, err := td.dbi.BatchGetItemWithContext(cctx, input, opts...)
+
+	var tables []string
+	keys := 0
+
+	for table, ka := range input.RequestItems {
+		tables = append(tables, table)
+		keys += len(ka.Keys)
+	}
+
+	span.SetAttributes(attribute.StringSlice("aws.dynamodb.table_names", tables))
+
+	if err == nil {
+		items := 0
+
+		for _, found := range out.Responses {
+			items += len(found)
+		}
+
+		span.SetAttributes(attribute.Int("aws.dynamodb.item_count", items))
+	}
+
+	end_span(span, err)
+
+	logger(ctx).Debug("dynamodb batch get", "tables", tables, "keys", keys, "latencyMs", time.Since(start).Milliseconds(), "error", err)
+
+	return out, err
+}
+
+func (td timedDB) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	ctx, span := start_db_span(ctx, "Query")
+
+	cctx, cancel := call_context(ctx)
+	defer cancel()
+
+	start := time.Now()
+
+	out, err := td.dbi.QueryWithContext(cctx, input, opts...)
+
+	span.SetAttributes(
+		attribute.StringSlice("aws.dynamodb.table_names", []string{aws.StringValue(input.TableName)}),
+		attribute.String("aws.dynamodb.index_name", aws.StringValue(input.IndexName)),
+	)
+
+	if err == nil {
+		span.SetAttributes(attribute.Int("aws.dynamodb.item_count", len(out.Items)))
+	}
+
+	end_span(span, err)
+
+	logger(ctx).Debug("dynamodb query", "table", aws.StringValue(input.TableName), "index", aws.StringValue(input.IndexName), "condition", aws.StringValue(input.KeyConditionExpression), "latencyMs", time.Since(start).Milliseconds(), "error", err)
+
+	return out, err
+}
+
+// the table, key and expressions of each op in a transaction
+func describe_ops(ops []*dynamodb.TransactWriteItem) []map[string]string {
+	var described []map[string]string
+
+	for _, op := range ops {
+		d := map[string]string{}
+
+		switch {
+		case op.Put != nil:
+			d["op"], d["table"], d["key"], d["condition"] = "put", aws.StringValue(op.Put.TableName), describe_key(op.Put.Item), aws.StringValue(op.Put.ConditionExpression)
+		case op.Update != nil:
+			d["op"], d["table"], d["key"], d["condition"] = "update", aws.StringValue(op.Update.TableName), describe_key(op.Update.Key), aws.StringValue(op.Update.ConditionExpression)
+			d["update"] = aws.StringValue(op.Update.UpdateExpression)
+		case op.Delete != nil:
+			d["op"], d["table"], d["key"], d["condition"] = "delete", aws.StringValue(op.Delete.TableName), describe_key(op.Delete.Key), aws.StringValue(op.Delete.ConditionExpression)
+		case op.ConditionCheck != nil:
+			d["op"], d["table"], d["key"], d["condition"] = "check", aws.StringValue(op.ConditionCheck.TableName), describe_key(op.ConditionCheck.Key), aws.StringValue(op.ConditionCheck.ConditionExpression)
+		}
+
+		described = append(described, d)
+	}
+
+	return described
+}
+
+// objectUUID/objectType.  Other attributes of a put are left out.  An e-mail
+// reservation's id is the address, so only a hash of it goes in the logs,
+// which is enough to tell one address from another.
+func describe_key(key map[string]*dynamodb.AttributeValue) string {
+	var id, ot string
+
+	if v, found := key[userIdCol]; found {
+		id = aws.StringValue(v.S)
+	}
+
+	if v, found := key[objectTypeCol]; found {
+		ot = aws.StringValue(v.S)
+	}
+
+	if ot == "UserEmail" {
+		sum := sha256.Sum256([]byte(id))
+		id = "sha256:" + hex.EncodeToString(sum[:8])
+	}
+
+	return id + "/" + ot
 }
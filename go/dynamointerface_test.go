@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// waits for the call's context like a slow DynamoDB would
+type slowDB struct {
+	MockDBInterface
+	deadline time.Time
+}
+
+func (sd *slowDB) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	sd.deadline, _ = ctx.Deadline()
+	<-ctx.Done()
+	return nil, awserr.New(request.CanceledErrorCode, "request context canceled", ctx.Err())
+}
+
+func TestCallContext(t *testing.T) {
+	cctx, cancel := call_context(context.Background())
+	defer cancel()
+
+	if deadline, found := cctx.Deadline(); !found || time.Until(deadline) > callTimeout {
+		t.Errorf("Call without a request deadline has %s", time.Until(deadline))
+	}
+
+	ctx, rcancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer rcancel()
+
+	cctx, cancel = call_context(ctx)
+	defer cancel()
+
+	if deadline, _ := cctx.Deadline(); time.Until(deadline) > 2*time.Second-deadlineReserve {
+		t.Errorf("Call has %s of a 2s request", time.Until(deadline))
+	}
+}
+
+func TestCallTimeout(t *testing.T) {
+	s, dbo, _ := mockEnv(expUser, expGroup, "foo@bar.com")
+
+	slow := slowDB{}
+	dbo.dbi = timedDB{dbi: &slow}
+
+	ctx, cancel := context.WithTimeout(context.Background(), deadlineReserve+50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	res, _ := dbo.CounterRead(ctx, s, expCounterUUID)
+
+	if res.StatusCode != 504 {
+		t.Errorf("Timed out read gave %d %s", res.StatusCode, res.Body)
+	}
+
+	if took := time.Since(start); took > 200*time.Millisecond {
+		t.Errorf("Timed out read took %s", took)
+	}
+
+	if reqDeadline, _ := ctx.Deadline(); !slow.deadline.Before(reqDeadline) {
+		t.Error("Call deadline is not before the request's")
+	}
+}
+
+func TestTimedOutError(t *testing.T) {
+	cancelled := awserr.New(request.CanceledErrorCode, "request context canceled", context.Canceled)
+
+	if res, _ := makeerror(cancelled); res.StatusCode != 404 {
+		t.Errorf("Cancelled call gave %d", res.StatusCode)
+	}
+
+	if res, _ := makeerror(context.DeadlineExceeded); res.StatusCode != 504 {
+		t.Errorf("Deadline gave %d", res.StatusCode)
+	}
+}
+
+func TestDescribeKey(t *testing.T) {
+	counter := map[string]*dynamodb.AttributeValue{
+		counterIdCol:  {S: aws.String(expCounterUUID.String())},
+		objectTypeCol: {S: aws.String("Counter")},
+	}
+
+	if d := describe_key(counter); d != expCounterUUID.String()+"/Counter" {
+		t.Errorf("Counter key is %s", d)
+	}
+
+	reservation := func(email string) string {
+		return describe_key(map[string]*dynamodb.AttributeValue{
+			userIdCol:     {S: aws.String(email)},
+			objectTypeCol: {S: aws.String("UserEmail")},
+		})
+	}
+
+	d := reservation("foo@bar.com")
+
+	if strings.Contains(d, "foo") || !strings.HasPrefix(d, "sha256:") || !strings.HasSuffix(d, "/UserEmail") {
+		t.Errorf("Reservation key is %s", d)
+	}
+
+	if d == reservation("baz@bar.com") || d != reservation("foo@bar.com") {
+		t.Error("Reservation keys don't tell addresses apart")
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// replaces the counter's labels, or takes them all off if there are none.
+// Only the counter itself has labels, not its shards.
+func append_counter_labels(ops []*dynamodb.TransactWriteItem, table *string, groupId *UUID, counterId UUID, labels map[string]string, ifMatch *int) ([]*dynamodb.TransactWriteItem, error) {
+	values := map[string]*dynamodb.AttributeValue{
+		":" + groupIdVal: {S: aws.String(groupId.String())},
+	}
+
+	query := "REMOVE " + labelsCol
+
+	if len(labels) > 0 {
+		mv, merr := dynamodbattribute.Marshal(labels)
+
+		if merr != nil {
+			return ops, merr
+		}
+
+		values[":"+labelsVal] = mv
+		query = fmt.Sprintf("SET %s = :%s", labelsCol, labelsVal)
+	}
+
+	update := version_bump(query, values)
+	condition, onFailure := version_condition(fmt.Sprintf("attribute_exists(%s) and %s = :%s", counterIdCol, counterGroupCol, groupIdVal), values, ifMatch)
+
+	udr := dynamodb.Update{
+		Key: map[string]*dynamodb.AttributeValue{
+			counterIdCol:  {S: aws.String(counterId.String())},
+			objectTypeCol: {S: aws.String("Counter")},
+		},
+		TableName:                           table,
+		ExpressionAttributeValues:           values,
+		UpdateExpression:                    aws.String(update),
+		ConditionExpression:                 aws.String(condition),
+		ReturnValuesOnConditionCheckFailure: onFailure,
+	}
+
+	ops = append(ops, &dynamodb.TransactWriteItem{
+		Update: &udr,
+	})
+
+	return ops, nil
+}
+
+// the counter items with these ids by id, with just the attributes named if
+// there are any
+func (dbo DynamoOperator) batch_counters(ctx context.Context, ids []string, attributes ...string) (map[string]CountData, error) {
+	ids = slices.Clone(ids)
+	slices.Sort(ids)
+
+	var keys []map[string]*dynamodb.AttributeValue
+
+	for _, id := range slices.Compact(ids) {
+		keys = append(keys, map[string]*dynamodb.AttributeValue{
+			counterIdCol:  {S: aws.String(id)},
+			objectTypeCol: {S: &dbo.counterType},
+		})
+	}
+
+	items, err := dbo.batch_get(ctx, dbo.counterTable, keys, attributes...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var counters []CountData
+
+	if uerr := dynamodbattribute.UnmarshalListOfMaps(items, &counters); uerr != nil {
+		return nil, uerr
+	}
+
+	found := map[string]CountData{}
+
+	for _, cd := range counters {
+		found[cd.CounterId] = cd
+	}
+
+	return found, nil
+}
+
+// the counters with these ids, in the same order, read together
+func (dbo DynamoOperator) read_counters(ctx context.Context, ids []string) ([]CountData, error) {
+	counterIds := make([]UUID, len(ids))
+
+	for i, id := range ids {
+		counterId, ierr := ToUUID(id)
+
+		if ierr != nil {
+			return nil, ierr
+		}
+
+		counterIds[i] = counterId
+	}
+
+	found, err := dbo.batch_counters(ctx, ids)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var counters []CountData
+
+	for i, id := range ids {
+		cd := found[id]
+
+		if cd.Shards > 0 {
+			total, serr := dbo.read_shards(ctx, counterIds[i])
+
+			if serr != nil {
+				return nil, serr
+			}
+
+			cd.CounterVal += total
+		}
+
+		counters = append(counters, cd)
+	}
+
+	return counters, nil
+}
+
+// the ids of the counters which the selector matches.  Only the labels are read.
+func (dbo DynamoOperator) select_counters(ctx context.Context, ids []string, selector LabelSelector) ([]string, error) {
+	found, err := dbo.batch_counters(ctx, ids, counterIdCol, labelsCol)
+
+	if err != nil {
+		return nil, err
+	}
+
+	selected := []string{}
+
+	for _, id := range ids {
+		if cd, ok := found[id]; ok && selector.matches(cd.Labels) {
+			selected = append(selected, id)
+		}
+	}
+
+	return selected, nil
+}
+
+func (dbo DynamoOperator) CounterLabels(ctx context.Context, s Session, id UUID, labels map[string]string) (Response, error) {
+	ops, err := append_counter_labels(nil, &dbo.counterTable, s.GetGroupId(), id, labels, s.GetIfMatch())
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	return dbo.commit(ctx, s, ops, id)
+}
+
+// only the counters' labels are read, as when selecting them
+func (dbo DynamoOperator) GroupLabels(ctx context.Context, s Session) (Response, error) {
+	gd, err := dbo.read_member_group(ctx, s)
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	found, ferr := dbo.batch_counters(ctx, gd.Counters, counterIdCol, labelsCol)
+
+	if ferr != nil {
+		return makeerror(ferr)
+	}
+
+	var counters []CountData
+
+	for _, id := range gd.Counters {
+		if cd, ok := found[id]; ok {
+			counters = append(counters, cd)
+		}
+	}
+
+	return makeresponse(label_index(gd.GroupId, counters))
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+func TestCounterLabelsUpdate(t *testing.T) {
+	counter := MakeUUID()
+
+	ops, err := append_counter_labels(nil, &expCounterTable, &expGroup, counter, map[string]string{"env": "prod"}, aws.Int(3))
+
+	checkError(t, err, nil)
+	checkOpsLen(t, ops, 1)
+
+	upd := ops[0].Update
+
+	if *upd.UpdateExpression != "SET labels = :labels, version = if_not_exists(version, :vzero) + :vone" {
+		t.Errorf("Update is %s", *upd.UpdateExpression)
+	}
+
+	if *upd.ExpressionAttributeValues[":labels"].M["env"].S != "prod" || *upd.ExpressionAttributeValues[":ifmatch"].N != "3" {
+		t.Errorf("Values are %v", upd.ExpressionAttributeValues)
+	}
+
+	if *upd.Key[counterIdCol].S != counter.String() || *upd.ExpressionAttributeValues[":groupId"].S != expGroup.String() {
+		t.Errorf("Update is of %v in %v", upd.Key, upd.ExpressionAttributeValues)
+	}
+
+	ops, _ = append_counter_labels(nil, &expCounterTable, &expGroup, counter, map[string]string{}, nil)
+
+	if upd := ops[0].Update; *upd.UpdateExpression != "REMOVE labels SET version = if_not_exists(version, :vzero) + :vone" || upd.ExpressionAttributeValues[":labels"] != nil {
+		t.Errorf("Taking labels off is %s", *upd.UpdateExpression)
+	}
+}
+
+func TestDBOCounterListSelector(t *testing.T) {
+	s, dbo, dbi := mockEnv(expUser, expGroup, "foo@bar.com")
+
+	counters := []string{MakeUUID().String(), MakeUUID().String()}
+
+	// the mock gives every read the same item, so it is the group and both its counters
+	item, _ := dynamodbattribute.MarshalMap(GroupData{GroupId: expGroup.String(), Counters: counters})
+	item[labelsCol] = &dynamodb.AttributeValue{M: map[string]*dynamodb.AttributeValue{"env": {S: aws.String("prod")}}}
+	item["groups"] = &dynamodb.AttributeValue{SS: []*string{aws.String(expGroup.String())}}
+
+	dbi.gio.Item = item
+
+	for selector, exp := range map[string]int{"env=prod": 2, "env=dev": 0, "env,team!=infra": 2, "team": 0} {
+		ls, _ := parse_selector(split_list(selector))
+
+		res, err := dbo.CounterList(context.Background(), s, ls)
+
+		checkError(t, err, nil)
+
+		var r opResult
+
+		json.Unmarshal([]byte(res.Body), &r)
+
+		if len(r.Items) != exp {
+			t.Errorf("%s selected %v", selector, r.Items)
+		}
+	}
+
+	dbi.bgis, dbi.qi = nil, dynamodb.QueryInput{}
+
+	res, err := dbo.GroupLabels(context.Background(), s)
+
+	checkError(t, err, nil)
+
+	if res.Body != `{"group":"`+expGroup.String()+`","labels":{"env":["prod"]}}` {
+		t.Errorf("Group labels are %s", res.Body)
+	}
+
+	// just the labels, in one batch, and no shards
+	if len(dbi.bgis) != 1 || *dbi.bgis[0].RequestItems[dbo.counterTable].ProjectionExpression != "#a0, #a1" || dbi.qi.TableName != nil {
+		t.Errorf("Labels read with %v", dbi.bgis)
+	}
+}
+
+func TestDBOReadCounters(t *testing.T) {
+	_, dbo, dbi := mockEnv(expUser, expGroup, "foo@bar.com")
+	delays := noSleep(t)
+
+	var ids []string
+	dbi.items = map[string]map[string]*dynamodb.AttributeValue{}
+
+	for i := 0; i < batchGetKeys+5; i++ {
+		cd := CountData{CounterId: MakeUUID().String(), ObjectType: "Counter", CounterVal: i}
+		dbi.items[cd.CounterId], _ = dynamodbattribute.MarshalMap(cd)
+		ids = append(ids, cd.CounterId)
+	}
+
+	dbi.unprocessed = 1
+
+	counters, err := dbo.read_counters(context.Background(), ids)
+
+	checkError(t, err, nil)
+
+	// two batches, and the key left out of the first asked for again
+	if len(dbi.bgis) != 3 || len(*delays) != 1 || len(dbi.bgis[1].RequestItems[dbo.counterTable].Keys) != 1 {
+		t.Fatalf("Reads were %d batches after %v", len(dbi.bgis), *delays)
+	}
+
+	for i, cd := range counters {
+		if cd.CounterId != ids[i] || cd.CounterVal != i {
+			t.Fatalf("Counter %d is %+v", i, cd)
+		}
+	}
+
+	dbi.bgis = nil
+
+	if _, err = dbo.select_counters(context.Background(), ids[:2], LabelSelector{}); err != nil || len(dbi.bgis) != 1 {
+		t.Fatalf("Selecting gave %v after %d batches", err, len(dbi.bgis))
+	}
+
+	if ka := dbi.bgis[0].RequestItems[dbo.counterTable]; *ka.ProjectionExpression != "#a0, #a1" || *ka.ExpressionAttributeNames["#a1"] != labelsCol {
+		t.Errorf("Selecting read %v", ka)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// the metadata's attributes, in the order they are updated
+func meta_attributes(meta CounterMeta) [][2]string {
+	return [][2]string{
+		{descriptionCol, meta.Description},
+		{unitCol, meta.Unit},
+		{colorCol, meta.Color},
+		{iconCol, meta.Icon},
+		{numberFormatCol, meta.NumberFormat},
+	}
+}
+
+// sets the counter's metadata, and removes what isn't given.  The attributes
+// go by #names, as some are DynamoDB reserved words.
+func append_counter_meta(ops []*dynamodb.TransactWriteItem, table *string, groupId *UUID, counterId UUID, meta CounterMeta, ifMatch *int) ([]*dynamodb.TransactWriteItem, error) {
+	values := map[string]*dynamodb.AttributeValue{
+		":" + groupIdVal: {S: aws.String(groupId.String())},
+	}
+	names := map[string]*string{}
+
+	var sets, removes []string
+
+	for _, attr := range meta_attributes(meta) {
+		col, value := attr[0], attr[1]
+		names["#"+col] = aws.String(col)
+
+		if value == "" {
+			removes = append(removes, "#"+col)
+			continue
+		}
+
+		values[":"+col] = &dynamodb.AttributeValue{S: aws.String(value)}
+		sets = append(sets, fmt.Sprintf("#%s = :%s", col, col))
+	}
+
+	query := ""
+
+	if len(sets) > 0 {
+		query = "SET " + strings.Join(sets, ", ")
+	}
+
+	// the version goes in the SET, which has to come after the REMOVE
+	update := strings.TrimSpace(version_bump(query, values))
+
+	if len(removes) > 0 {
+		update = "REMOVE " + strings.Join(removes, ", ") + " " + update
+	}
+
+	condition, onFailure := version_condition(fmt.Sprintf("attribute_exists(%s) and %s = :%s", counterIdCol, counterGroupCol, groupIdVal), values, ifMatch)
+
+	udr := dynamodb.Update{
+		Key: map[string]*dynamodb.AttributeValue{
+			counterIdCol:  {S: aws.String(counterId.String())},
+			objectTypeCol: {S: aws.String("Counter")},
+		},
+		TableName:                           table,
+		ExpressionAttributeNames:            names,
+		ExpressionAttributeValues:           values,
+		UpdateExpression:                    aws.String(update),
+		ConditionExpression:                 aws.String(condition),
+		ReturnValuesOnConditionCheckFailure: onFailure,
+	}
+
+	ops = append(ops, &dynamodb.TransactWriteItem{
+		Update: &udr,
+	})
+
+	return ops, nil
+}
+
+func (dbo DynamoOperator) CounterMeta(ctx context.Context, s Session, id UUID, meta CounterMeta) (Response, error) {
+	ops, err := append_counter_meta(nil, &dbo.counterTable, s.GetGroupId(), id, meta, s.GetIfMatch())
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	return dbo.commit(ctx, s, ops, id)
+}
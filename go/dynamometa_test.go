@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+func TestCounterMetaUpdate(t *testing.T) {
+	counter := MakeUUID()
+
+	ops, err := append_counter_meta(nil, &expCounterTable, &expGroup, counter, CounterMeta{Unit: "cups", NumberFormat: "grouped"}, nil)
+
+	checkError(t, err, nil)
+	checkOpsLen(t, ops, 1)
+
+	upd := ops[0].Update
+
+	if *upd.UpdateExpression != "REMOVE #description, #color, #icon SET #unit = :unit, #numberFormat = :numberFormat, version = if_not_exists(version, :vzero) + :vone" {
+		t.Errorf("Update is %s", *upd.UpdateExpression)
+	}
+
+	if *upd.ExpressionAttributeValues[":unit"].S != "cups" || *upd.ExpressionAttributeNames["#numberFormat"] != numberFormatCol || len(upd.ExpressionAttributeNames) != 5 {
+		t.Errorf("Update has %v and %v", upd.ExpressionAttributeValues, upd.ExpressionAttributeNames)
+	}
+
+	ops, _ = append_counter_meta(nil, &expCounterTable, &expGroup, counter, CounterMeta{}, nil)
+
+	if exp := "REMOVE #description, #unit, #color, #icon, #numberFormat SET version = if_not_exists(version, :vzero) + :vone"; *ops[0].Update.UpdateExpression != exp {
+		t.Errorf("Taking it all off is %s", *ops[0].Update.UpdateExpression)
+	}
+}
+
+func TestCounterMetaCreate(t *testing.T) {
+	ops, err := append_counter_create(nil, &expCounterTable, CountData{
+		CounterId:   MakeUUID().String(),
+		CounterName: "incidents",
+		CounterMeta: CounterMeta{Description: "pages out of hours", Color: "#cc0000"},
+	})
+
+	checkError(t, err, nil)
+
+	item := ops[0].Put.Item
+
+	if *item[descriptionCol].S != "pages out of hours" || *item[colorCol].S != "#cc0000" {
+		t.Errorf("New counter is %v", item)
+	}
+
+	if _, found := item[unitCol]; found {
+		t.Error("Empty unit was written")
+	}
+
+	var back CountData
+
+	dynamodbattribute.UnmarshalMap(item, &back)
+
+	if back.Description != "pages out of hours" {
+		t.Errorf("Read back %+v", back)
+	}
+
+}
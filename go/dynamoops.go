@@ -1,7 +1,14 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
@@ -16,46 +23,212 @@ type opResult struct {
 }
 
 type DynamoOperator struct {
-	groupTable      string
-	userTable       string
-	counterTable    string
-	permissionTable string
-	userEmailIndex  string
+	groupTable       string
+	userTable        string
+	counterTable     string
+	permissionTable  string
+	apiKeyTable      string
+	webhookTable     string
+	socketTable      string
+	idempotencyTable string
+	userEmailIndex   string
 
 	dbi DBInterface
 
+	// delivers webhooks for the webhook event sink.  nil turns webhooks off.
+	hooks *WebhookDispatcher
+
+	// told about counter changes in the request path, e.g. the standalone
+	// server's socket hub.  Lambdas leave it nil and hear of them from the stream.
+	notify EventSink
+
+	// takes transaction metrics.  nil for none.
+	metrics MetricsSink
+
 	// put these here for ease of address-taking.
 	counterType string
 	userType    string
 	groupType   string
+	emailType   string
+	apiKeyType  string
+	connType    string
+}
+
+func (dbo DynamoOperator) commit(ctx context.Context, s Session, ops []*dynamodb.TransactWriteItem, id UUID) (Response, error) {
+	result := opResult{Success: true, Result: "OK", Id: id.String()}
+
+	return dbo.commit_once(ctx, s, ops, result, result)
 }
 
-func commit(dbi DBInterface, ops []*dynamodb.TransactWriteItem, id UUID) (Response, error) {
-	input := dynamodb.TransactWriteItemsInput{
-		TransactItems: ops,
+// commits a session's change and responds with result.  With an idempotency key
+// recorded is kept as the response for retries, which leaves out anything that
+// may only be shown once, like a new secret.
+func (dbo DynamoOperator) commit_once(ctx context.Context, s Session, ops []*dynamodb.TransactWriteItem, result any, recorded any) (Response, error) {
+	ik := s.GetIdempotencyKey()
+
+	if ik != nil {
+		rec, rerr := makeresponse(recorded)
+
+		if rerr != nil || rec.StatusCode != 200 {
+			return rec, rerr
+		}
+
+		var err error
+
+		ops, err = append_idempotency_put(ops, &dbo.idempotencyTable, s.GetUserId(), ik, rec, time.Now())
+
+		if err != nil {
+			return makeerror(err)
+		}
 	}
 
-	_, err := dbi.TransactWriteItems(&input)
+	if err := dbo.inline_commit(ctx, ops); err != nil {
+		if ik != nil {
+			if res, replayed, rerr := idempotent_replay(err, ops, ik); replayed {
+				return res, rerr
+			}
+		}
 
-	if err != nil {
-		return makeerror(err)
+		return makeerror(version_error(err, ops))
+	}
+
+	return makeresponse(result)
+}
+
+// commits the transactions which make counters, when there are too many for
+// one, and responds with result.  The last transaction finishes the change.
+// With an idempotency key the change makes the same ids every time, so a
+// retry after a failure part way skips the transactions whose counters are
+// there, and one after it finished gets the recorded response.
+func (dbo DynamoOperator) commit_all(ctx context.Context, s Session, txs [][]*dynamodb.TransactWriteItem, result any) (Response, error) {
+	last := len(txs) - 1
+
+	for i, ops := range txs[:last] {
+		if s.GetIdempotencyKey() != nil && dbo.counters_written(ctx, ops) {
+			continue
+		}
+
+		if cerr := dbo.inline_commit(ctx, ops); cerr != nil {
+			return makeerror(fmt.Errorf("stopped after %d of %d transactions: %w", i, len(txs), cerr))
+		}
+	}
+
+	return dbo.commit_once(ctx, s, txs[last], result, result)
+}
+
+// the id of the n'th thing of a kind a change makes.  With an idempotency
+// key it is the same on every retry.
+func key_uuid(s Session, kind string, n int) UUID {
+	ik := s.GetIdempotencyKey()
+
+	if ik == nil {
+		return MakeUUID()
 	}
 
-	return makeresponse(opResult{Success: true, Result: "OK", Id: id.String()})
+	return NameUUID(fmt.Sprintf("%s %s %s %d", *s.GetUserIdString(), ik.Key, kind, n))
 }
 
-func inline_commit(dbi DBInterface, ops []*dynamodb.TransactWriteItem) error {
-	input := dynamodb.TransactWriteItemsInput{
-		TransactItems: ops,
+// true if the first counter a transaction makes is there, and so all of them
+func (dbo DynamoOperator) counters_written(ctx context.Context, ops []*dynamodb.TransactWriteItem) bool {
+	for _, op := range ops {
+		if op.Put == nil || aws.StringValue(op.Put.Item[objectTypeCol].S) != dbo.counterType {
+			continue
+		}
+
+		counterId, ierr := ToUUID(aws.StringValue(op.Put.Item[counterIdCol].S))
+
+		if ierr != nil {
+			return false
+		}
+
+		cd, err := dbo.read_counter(ctx, counterId)
+
+		return err == nil && cd.CounterId != ""
 	}
 
-	_, err := dbi.TransactWriteItems(&input)
+	return false
+}
+
+// every transaction goes through here, is retried if it clashed with another
+// and is measured
+func (dbo DynamoOperator) inline_commit(ctx context.Context, ops []*dynamodb.TransactWriteItem) error {
+	start := time.Now()
+	retries, capacity, err := try_commit(ctx, dbo.dbi, ops)
+
+	emit(ctx, dbo.metrics, transaction_metrics(retries, capacity, time.Since(start), err)...)
 
 	return err
 }
 
-func (dbo DynamoOperator) LookupUserUUID(email *string) (UUID, error) {
-	resp, err := dbo.dbi.Query(&dynamodb.QueryInput{
+// the retry loop.  Gives the number of retries and the capacity used by the
+// attempt which went through, if one did.
+func try_commit(ctx context.Context, dbi DBInterface, ops []*dynamodb.TransactWriteItem) (int, []*dynamodb.ConsumedCapacity, error) {
+	for attempt := 0; ; attempt++ {
+		input := dynamodb.TransactWriteItemsInput{
+			TransactItems:          ops,
+			ReturnConsumedCapacity: aws.String(dynamodb.ReturnConsumedCapacityTotal),
+		}
+
+		out, err := dbi.TransactWriteItemsWithContext(ctx, &input)
+
+		if err == nil {
+			if attempt > 0 {
+				logger(ctx).Info("transaction committed after retries", "ops", len(ops), "retries", attempt)
+			}
+			return attempt, out.ConsumedCapacity, nil
+		}
+
+		if !should_retry(err) {
+			return attempt, nil, err
+		}
+
+		if attempt+1 == commitAttempts {
+			logger(ctx).Warn("transaction gave up", "ops", len(ops), "retries", attempt, "error", err)
+			return attempt, nil, busy(err)
+		}
+
+		if serr := retry_sleep(ctx, retry_delay(attempt)); serr != nil {
+			logger(ctx).Warn("transaction gave up", "ops", len(ops), "retries", attempt, "error", serr)
+			return attempt, nil, fmt.Errorf("%w, then gave up: %w", err, serr)
+		}
+	}
+}
+
+// true if a transaction was cancelled because one of its condition checks failed,
+// e.g. an e-mail address which is already reserved.
+func is_condition_failure(err error) bool {
+	var tce *dynamodb.TransactionCanceledException
+
+	if !errors.As(err, &tce) {
+		return false
+	}
+
+	for _, r := range tce.CancellationReasons {
+		if r != nil && r.Code != nil && *r.Code == "ConditionalCheckFailed" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (dbo DynamoOperator) LookupUserUUID(ctx context.Context, email *string) (UUID, error) {
+	items, err := dbo.indexed_users(ctx, email)
+
+	if err != nil {
+		return NullUUID(), err
+	}
+
+	if resi := len(items); resi != 1 {
+		return NullUUID(), fmt.Errorf("incorrect Item count (%d) from user lookup", resi)
+	}
+
+	return ToUUID(*items[0][userIdCol].S)
+}
+
+// the users the e-mail index has for an address
+func (dbo DynamoOperator) indexed_users(ctx context.Context, email *string) ([]map[string]*dynamodb.AttributeValue, error) {
+	resp, err := dbo.dbi.QueryWithContext(ctx, &dynamodb.QueryInput{
 		TableName: &dbo.userTable,
 		IndexName: &dbo.userEmailIndex,
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
@@ -66,45 +239,126 @@ func (dbo DynamoOperator) LookupUserUUID(email *string) (UUID, error) {
 	})
 
 	if err != nil {
-		return NullUUID(), err
+		return nil, err
 	}
 
-	if resi := len(resp.Items); resi != 1 {
-		return NullUUID(), fmt.Errorf("incorrect Item count (%d) from user lookup", resi)
+	return resp.Items, nil
+}
+
+func (dbo DynamoOperator) LookupUserReservation(ctx context.Context, email *string) (UUID, bool, error) {
+	out, err := dbo.dbi.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			userIdCol:     {S: email},
+			objectTypeCol: {S: &dbo.emailType},
+		},
+		TableName:      &dbo.userTable,
+		ConsistentRead: aws.Bool(true),
+	})
+
+	if err != nil {
+		return NullUUID(), false, err
+	}
+
+	if out.Item == nil {
+		return NullUUID(), false, nil
+	}
+
+	var ed UserEmailData
+
+	ederr := dynamodbattribute.UnmarshalMap(out.Item, &ed)
+
+	if ederr != nil {
+		return NullUUID(), false, ederr
 	}
 
-	return ToUUID(*resp.Items[0][userIdCol].S)
+	uuid, uerr := ToUUID(ed.UserId)
+
+	return uuid, uerr == nil, uerr
 }
 
-func (dbo DynamoOperator) CounterRead(s Session, counterId UUID) (Response, error) {
-	out, err := dbo.dbi.GetItem(&dynamodb.GetItemInput{
+func (dbo DynamoOperator) read_counter(ctx context.Context, counterId UUID) (CountData, error) {
+	out, err := dbo.dbi.GetItemWithContext(ctx, &dynamodb.GetItemInput{
 		Key: map[string]*dynamodb.AttributeValue{
 			counterIdCol:  {S: aws.String(counterId.String())},
 			objectTypeCol: {S: &dbo.counterType},
 		},
-		TableName: &dbo.counterTable,
+		TableName:      &dbo.counterTable,
+		ConsistentRead: aws.Bool(true),
 	})
+
+	var cd CountData
+
 	if err != nil {
-		return makeerror(err)
+		return cd, err
 	}
 
-	var cd CountData
+	if cderr := dynamodbattribute.UnmarshalMap(out.Item, &cd); cderr != nil || cd.Shards == 0 {
+		return cd, cderr
+	}
 
-	cderr := dynamodbattribute.UnmarshalMap(out.Item, &cd)
+	total, serr := dbo.read_shards(ctx, counterId)
+
+	cd.CounterVal += total
 
-	if cderr != nil {
-		return makeerror(cderr)
+	return cd, serr
+}
+
+func (dbo DynamoOperator) CounterRead(ctx context.Context, s Session, counterId UUID) (Response, error) {
+	cd, err := dbo.read_counter(ctx, counterId)
+
+	if err != nil {
+		return makeerror(err)
 	}
 
 	if cd.CounterGroup != *s.GetGroupIdString() {
 		return makeerror(fmt.Errorf("counter group is %s not %s", cd.CounterGroup, *s.GetGroupIdString()))
 	}
 
-	return makeresponse(cd)
+	if cd.Expression != "" {
+		dbo.derivation(ctx).set(&cd)
+	}
+
+	res, rerr := makeresponse(cd)
+
+	return with_etag(res, rerr, cd.Version)
+}
+
+func (dbo DynamoOperator) CounterReadMany(ctx context.Context, s Session, counterIds []UUID) (Response, error) {
+	var ids []string
+
+	for _, counterId := range counterIds {
+		ids = append(ids, counterId.String())
+	}
+
+	counters, err := dbo.read_counters(ctx, ids)
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	// derived counters are often derived from others on the same page
+	dv := dbo.derivation(ctx, counters...)
+
+	for i := range counters {
+		if counters[i].CounterGroup != *s.GetGroupIdString() {
+			return makeerror(fmt.Errorf("counter group is %s not %s", counters[i].CounterGroup, *s.GetGroupIdString()))
+		}
+
+		if counters[i].Expression != "" {
+			dv.set(&counters[i])
+		}
+	}
+
+	if counters == nil {
+		counters = []CountData{}
+	}
+
+	return makeresponse(counters)
 }
 
-func (dbo DynamoOperator) CounterList(s Session) (Response, error) {
-	out, err := dbo.dbi.GetItem(&dynamodb.GetItemInput{
+// the ids of the group's counters, only those the selector matches if it has any terms
+func (dbo DynamoOperator) CounterList(ctx context.Context, s Session, selector LabelSelector) (Response, error) {
+	out, err := dbo.dbi.GetItemWithContext(ctx, &dynamodb.GetItemInput{
 		Key: map[string]*dynamodb.AttributeValue{
 			groupIdCol:    {S: s.GetGroupIdString()},
 			objectTypeCol: {S: &dbo.groupType},
@@ -124,74 +378,190 @@ func (dbo DynamoOperator) CounterList(s Session) (Response, error) {
 		return makeerror(gderr)
 	}
 
-	return makeresponse(opResult{
+	ids := gd.Counters
+
+	if len(selector) > 0 {
+		var serr error
+
+		if ids, serr = dbo.select_counters(ctx, ids, selector); serr != nil {
+			return makeerror(serr)
+		}
+	}
+
+	res, rerr := makeresponse(opResult{
 		Success: true,
 		Result:  "OK",
 		Id:      gd.GroupId,
-		Items:   gd.Counters,
+		Items:   ids,
 	})
+
+	return with_etag(res, rerr, gd.Version)
 }
 
-func (dbo DynamoOperator) CounterUpdate(s Session, id UUID, query string, stepVal int) (Response, error) {
+func (dbo DynamoOperator) CounterUpdate(ctx context.Context, s Session, id UUID, query string, stepVal int) (Response, error) {
 	var ops []*dynamodb.TransactWriteItem
 	var err error
 
-	ops, err = append_counter_update(ops, &dbo.counterTable, s.GetGroupId(), id, query, stepVal)
+	config, err := dbo.counter_config(ctx, id)
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	if config.Expression != "" {
+		return makeerror(conflict("counter %s is derived from others, so it can't be changed", id.String()))
+	}
+
+	shards := config.Shards
+
+	switch {
+	case shards > 0 && shard_query(query):
+		ops, err = append_shard_update(ops, &dbo.counterTable, s.GetGroupId(), id, random_shard(shards), query, stepVal)
+
+		if err == nil && s.GetIfMatch() != nil {
+			ops, err = append_counter_check(ops, &dbo.counterTable, s.GetGroupId(), id, s.GetIfMatch())
+		}
+
+	default:
+		ops, err = append_counter_update(ops, &dbo.counterTable, s.GetGroupId(), id, query, stepVal, s.GetIfMatch())
+
+		if err == nil {
+			bound_update(ops[0], config, query)
+		}
+
+		for shard := 0; err == nil && shard < shards; shard++ {
+			ops, err = append_shard_update(ops, &dbo.counterTable, s.GetGroupId(), id, shard, query, stepVal)
+		}
+	}
 
 	if err != nil {
 		return makeerror(err)
 	}
 
-	return commit(dbo.dbi, ops, id)
+	var before CountData
+
+	if dbo.notify != nil {
+		before, _ = dbo.read_counter(ctx, id)
+	}
+
+	res, cerr := dbo.commit(ctx, s, ops, id)
+
+	if dbo.notify != nil && applied(res) {
+		if after, aerr := dbo.read_counter(ctx, id); aerr == nil {
+			dbo.counter_changed(ctx, counter_change(counter_event(query), before, after))
+		}
+	}
+
+	return res, cerr
 }
 
-func (dbo DynamoOperator) CounterCreate(s Session, name string) (Response, error) {
+// created is the new counter's configuration, which is given an id in the session's group
+func (dbo DynamoOperator) CounterCreate(ctx context.Context, s Session, created CountData) (Response, error) {
 	var ops []*dynamodb.TransactWriteItem
 	var err error
 
+	shards := created.Shards
+
+	if shards < 0 || shards > maxShards {
+		return makeerror(bad_request("a counter can have up to %d shards, not %d", maxShards, shards))
+	}
+
+	if berr := check_bounds(created); berr != nil {
+		return makeerror(berr)
+	}
+
+	if derr := check_derived(created); derr != nil {
+		return makeerror(derr)
+	}
+
+	// a new counter can't be named by any other, so its expression can't make a cycle
+	if created.Expression != "" {
+		refs, _, rerr := dbo.expression_references(ctx, s, created.Expression)
+
+		if rerr != nil {
+			return makeerror(rerr)
+		}
+
+		created.References = refs
+	}
+
 	newid := MakeUUID()
+	created.CounterId = newid.String()
+	created.CounterGroup = *s.GetGroupIdString()
 
-	ops, err = append_counter_create(ops, &dbo.counterTable, newid, name, s.GetGroupId())
+	ops, err = append_counter_create(ops, &dbo.counterTable, created)
+
+	for shard := 0; err == nil && shard < shards; shard++ {
+		ops, err = append_shard_create(ops, &dbo.counterTable, newid, s.GetGroupId(), shard, created.StepVal)
+	}
 
 	if err != nil {
 		return makeerror(err)
 	}
 
-	ops, err = append_group_update(ops, &dbo.groupTable, s.GetGroupId(), gquery(gr_add_ctr), newid)
+	ops, err = append_group_update(ops, &dbo.groupTable, s.GetGroupId(), gquery(gr_add_ctr), newid, s.GetIfMatch())
 
 	if err != nil {
 		return makeerror(err)
 	}
 
-	return commit(dbo.dbi, ops, newid)
+	res, cerr := dbo.commit(ctx, s, ops, newid)
+
+	if dbo.notify != nil && applied(res) {
+		dbo.counter_changed(ctx, counter_change(ev_create, created, created))
+	}
+
+	return res, cerr
 }
 
-func (dbo DynamoOperator) CounterDelete(s Session, counterId UUID) (Response, error) {
+func (dbo DynamoOperator) CounterDelete(ctx context.Context, s Session, counterId UUID) (Response, error) {
 	var ops []*dynamodb.TransactWriteItem
 	var err error
 
-	ops, err = append_counter_delete(ops, &dbo.counterTable, s.GetGroupId(), counterId)
+	shards, err := dbo.counter_shards(ctx, counterId)
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	ops, err = append_counter_delete(ops, &dbo.counterTable, s.GetGroupId(), counterId, s.GetIfMatch())
+
+	for shard := 0; err == nil && shard < shards; shard++ {
+		ops, err = append_shard_delete(ops, &dbo.counterTable, counterId, shard)
+	}
 
 	if err != nil {
 		return makeerror(err)
 	}
 
-	ops, err = append_group_update(ops, &dbo.groupTable, s.GetGroupId(), gquery(gr_remove_ctr), counterId)
+	ops, err = append_group_update(ops, &dbo.groupTable, s.GetGroupId(), gquery(gr_remove_ctr), counterId, nil)
 
 	if err != nil {
 		return makeerror(err)
 	}
 
-	return commit(dbo.dbi, ops, counterId)
+	var before CountData
+
+	if dbo.notify != nil {
+		before, _ = dbo.read_counter(ctx, counterId)
+	}
+
+	res, cerr := dbo.commit(ctx, s, ops, counterId)
+
+	if dbo.notify != nil && applied(res) {
+		dbo.counter_changed(ctx, counter_change(ev_delete, before, before))
+	}
+
+	return res, cerr
 }
 
-func (dbo DynamoOperator) GroupCreate(s Session, name string) (Response, error) {
+func (dbo DynamoOperator) GroupCreate(ctx context.Context, s Session, name string) (Response, error) {
 	var ops []*dynamodb.TransactWriteItem
 	var err error
 
 	newid := MakeUUID()
 
-	ops, err = append_group_create(ops, &dbo.groupTable, newid, name)
+	ops, err = append_group_create(ops, &dbo.groupTable, newid, name, nil)
 
 	if err != nil {
 		return makeerror(err)
@@ -203,11 +573,70 @@ func (dbo DynamoOperator) GroupCreate(s Session, name string) (Response, error)
 		return makeerror(err)
 	}
 
-	return commit(dbo.dbi, ops, newid)
+	return dbo.commit(ctx, s, ops, newid)
+}
+
+// the session's group, if the user is in it
+func (dbo DynamoOperator) GroupRead(ctx context.Context, s Session) (Response, error) {
+	ud, uerr := dbo.read_user(ctx, *s.GetUserId())
+
+	if uerr != nil {
+		return makeerror(uerr)
+	}
+
+	if !slices.Contains(ud.Groups, *s.GetGroupIdString()) {
+		return makeerror(fmt.Errorf("group %s not found", *s.GetGroupIdString()))
+	}
+
+	gd, gerr := dbo.read_group(ctx, *s.GetGroupId())
+
+	if gerr != nil {
+		return makeerror(gerr)
+	}
+
+	if gd.Counters == nil {
+		gd.Counters = []string{}
+	}
+
+	res, rerr := makeresponse(gd)
+
+	return with_etag(res, rerr, gd.Version)
+}
+
+func (dbo DynamoOperator) GroupReadMany(ctx context.Context, s Session, groupIds []UUID) (Response, error) {
+	ud, uerr := dbo.read_user(ctx, *s.GetUserId())
+
+	if uerr != nil {
+		return makeerror(uerr)
+	}
+
+	for _, groupId := range groupIds {
+		if !slices.Contains(ud.Groups, groupId.String()) {
+			return makeerror(fmt.Errorf("group %s not found", groupId.String()))
+		}
+	}
+
+	groups, gerr := dbo.read_groups(ctx, groupIds)
+
+	if gerr != nil {
+		return makeerror(gerr)
+	}
+
+	for i := range groups {
+		if groups[i].Counters == nil {
+			groups[i].Counters = []string{}
+		}
+	}
+
+	if groups == nil {
+		groups = []GroupData{}
+	}
+
+	return makeresponse(groups)
 }
 
-func (dbo DynamoOperator) GroupList(s Session) (Response, error) {
-	out, err := dbo.dbi.GetItem(&dynamodb.GetItemInput{
+func (dbo DynamoOperator) GroupList(ctx context.Context, s Session) (Response, error) {
+	out, err := dbo.dbi.GetItemWithContext(ctx, &dynamodb.GetItemInput{
 		Key: map[string]*dynamodb.AttributeValue{
 			userIdCol:     {S: s.GetUserIdString()},
 			objectTypeCol: {S: &dbo.userType},
@@ -235,15 +664,766 @@ func (dbo DynamoOperator) GroupList(s Session) (Response, error) {
 	})
 }
 
-func (dbo DynamoOperator) UserCreate(newUserId UUID, name *string) error {
+func (dbo DynamoOperator) UserCreate(ctx context.Context, newUserId UUID, name *string) error {
 	var ops []*dynamodb.TransactWriteItem
 	var err error
 
+	ops, err = append_user_reserve(ops, &dbo.userTable, newUserId, name)
+
+	if err != nil {
+		return err
+	}
+
 	ops, err = append_user_create(ops, &dbo.userTable, newUserId, name)
 
 	if err != nil {
 		return err
 	}
 
-	return inline_commit(dbo.dbi, ops)
+	return dbo.inline_commit(ctx, ops)
+}
+
+// users made before e-mail addresses were reserved are only in the index, so
+// their address is reserved when they are found there
+func (dbo DynamoOperator) UserReserveIndexed(ctx context.Context, name *string) (UUID, bool, error) {
+	items, err := dbo.indexed_users(ctx, name)
+
+	if err != nil || len(items) == 0 {
+		return NullUUID(), false, err
+	}
+
+	if len(items) > 1 {
+		return NullUUID(), false, fmt.Errorf("incorrect Item count (%d) from user lookup", len(items))
+	}
+
+	userId, uerr := ToUUID(*items[0][userIdCol].S)
+
+	if uerr != nil {
+		return NullUUID(), false, uerr
+	}
+
+	ops, oerr := append_user_reserve(nil, &dbo.userTable, userId, name)
+
+	if oerr != nil {
+		return NullUUID(), false, oerr
+	}
+
+	return userId, true, dbo.inline_commit(ctx, ops)
+}
+
+func (dbo DynamoOperator) UserDelete(ctx context.Context, userId UUID, name *string) error {
+	var ops []*dynamodb.TransactWriteItem
+	var err error
+
+	ops, err = append_user_release(ops, &dbo.userTable, userId, name)
+
+	if err != nil {
+		return err
+	}
+
+	ops, err = append_user_delete(ops, &dbo.userTable, userId)
+
+	if err != nil {
+		return err
+	}
+
+	return dbo.inline_commit(ctx, ops)
+}
+
+func (dbo DynamoOperator) read_apikey(ctx context.Context, keyId UUID) (APIKeyData, error) {
+	out, err := dbo.dbi.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			apiKeyIdCol:   {S: aws.String(keyId.String())},
+			objectTypeCol: {S: &dbo.apiKeyType},
+		},
+		TableName: &dbo.apiKeyTable,
+	})
+
+	if err != nil {
+		return APIKeyData{}, err
+	}
+
+	var kd APIKeyData
+
+	if out.Item == nil {
+		return kd, fmt.Errorf("API key %s not found", keyId.String())
+	}
+
+	kderr := dynamodbattribute.UnmarshalMap(out.Item, &kd)
+
+	return kd, kderr
+}
+
+func (dbo DynamoOperator) APIKeyVerify(ctx context.Context, key string) (APIKeyData, error) {
+	keyId, perr := parse_api_key(key)
+
+	if perr != nil {
+		return APIKeyData{}, perr
+	}
+
+	kd, err := dbo.read_apikey(ctx, keyId)
+
+	if err != nil || subtle.ConstantTimeCompare([]byte(kd.KeyHash), []byte(hash_api_key(key))) != 1 {
+		return APIKeyData{}, fmt.Errorf("invalid API key")
+	}
+
+	return kd, nil
+}
+
+func (dbo DynamoOperator) APIKeyCreate(ctx context.Context, s Session, name string, groupId UUID, counters []string, rights []string) (Response, error) {
+	var ops []*dynamodb.TransactWriteItem
+	var err error
+
+	newid := MakeUUID()
+
+	key, kerr := make_api_key(newid)
+
+	if kerr != nil {
+		return makeerror(kerr)
+	}
+
+	ops, err = append_apikey_create(ops, &dbo.apiKeyTable, APIKeyData{
+		KeyId:    newid.String(),
+		KeyName:  name,
+		KeyHash:  hash_api_key(key),
+		UserId:   *s.GetUserIdString(),
+		GroupId:  groupId.String(),
+		Counters: counters,
+		Rights:   rights,
+	})
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	ops, err = append_user_key_update(ops, &dbo.userTable, s.GetUserId(), uquery(usr_add_key), newid, &groupId)
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	if len(counters) > 0 {
+		ops, err = append_group_counter_check(ops, &dbo.groupTable, &groupId, counters)
+
+		if err != nil {
+			return makeerror(err)
+		}
+	}
+
+	result := opResult{Success: true, Result: "OK", Id: newid.String()}
+
+	return dbo.commit_once(ctx, s, ops, apiKeyResult{opResult: result, Key: key}, result)
+}
+
+func (dbo DynamoOperator) APIKeyRead(ctx context.Context, s Session, keyId UUID) (Response, error) {
+	kd, err := dbo.read_apikey(ctx, keyId)
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	if kd.UserId != *s.GetUserIdString() {
+		return makeerror(fmt.Errorf("API key %s not found", keyId.String()))
+	}
+
+	return makeresponse(kd)
+}
+
+func (dbo DynamoOperator) APIKeyList(ctx context.Context, s Session) (Response, error) {
+	out, err := dbo.dbi.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			userIdCol:     {S: s.GetUserIdString()},
+			objectTypeCol: {S: &dbo.userType},
+		},
+		TableName: &dbo.userTable,
+	})
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	var ud UserData
+
+	uderr := dynamodbattribute.UnmarshalMap(out.Item, &ud)
+
+	if uderr != nil {
+		return makeerror(uderr)
+	}
+
+	return makeresponse(opResult{
+		Success: true,
+		Result:  "OK",
+		Id:      ud.UserId,
+		Items:   ud.APIKeys,
+	})
+}
+
+func (dbo DynamoOperator) APIKeyRotate(ctx context.Context, s Session, keyId UUID) (Response, error) {
+	var ops []*dynamodb.TransactWriteItem
+	var err error
+
+	key, kerr := make_api_key(keyId)
+
+	if kerr != nil {
+		return makeerror(kerr)
+	}
+
+	ops, err = append_apikey_rotate(ops, &dbo.apiKeyTable, keyId, s.GetUserId(), hash_api_key(key))
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	result := opResult{Success: true, Result: "OK", Id: keyId.String()}
+
+	return dbo.commit_once(ctx, s, ops, apiKeyResult{opResult: result, Key: key}, result)
+}
+
+func (dbo DynamoOperator) APIKeyDelete(ctx context.Context, s Session, keyId UUID) (Response, error) {
+	var ops []*dynamodb.TransactWriteItem
+	var err error
+
+	ops, err = append_apikey_delete(ops, &dbo.apiKeyTable, keyId, s.GetUserId())
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	ops, err = append_user_key_update(ops, &dbo.userTable, s.GetUserId(), uquery(usr_remove_key), keyId, nil)
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	return dbo.commit(ctx, s, ops, keyId)
+}
+
+func counter_change(event string, before CountData, after CountData) CounterEvent {
+	return CounterEvent{
+		Event:       event,
+		GroupId:     after.CounterGroup,
+		CounterId:   after.CounterId,
+		CounterName: after.CounterName,
+		OldVal:      before.CounterVal,
+		NewVal:      after.CounterVal,
+		Delta:       after.CounterVal - before.CounterVal,
+		StepVal:     after.StepVal,
+		Time:        time.Now().UTC().Format(time.RFC3339Nano),
+	}
+}
+
+// only for the standalone server, which has no stream.  The values are read
+// either side of the change, so a concurrent one can show up in them.
+func (dbo DynamoOperator) counter_changed(ctx context.Context, ev CounterEvent) {
+	if err := dbo.notify.Publish(ctx, DomainEvent{Kind: "Counter", Counter: &ev}); err != nil {
+		logger(ctx).Warn("counter event failed", "error", err)
+	}
+}
+
+func (dbo DynamoOperator) read_webhooks(ctx context.Context, groupId *UUID) ([]WebhookData, error) {
+	out, err := dbo.dbi.QueryWithContext(ctx, prefix_query(&dbo.webhookTable, groupId.String(), webhookTypePrefix))
+
+	if err != nil {
+		return nil, err
+	}
+
+	var hooks []WebhookData
+
+	hkerr := dynamodbattribute.UnmarshalListOfMaps(out.Items, &hooks)
+
+	return hooks, hkerr
+}
+
+// delivers an event to every webhook which wants it and records the outcome.
+func (dbo DynamoOperator) fire_webhooks(ctx context.Context, hooks []WebhookData, ev CounterEvent) {
+	var wg sync.WaitGroup
+
+	results := make([]*DeliveryData, len(hooks))
+
+	for i, wd := range hooks {
+		if hev, wanted := wd.match(ev); wanted {
+			wg.Add(1)
+			go func(i int, wd WebhookData) {
+				defer wg.Done()
+				dd := dbo.hooks.Deliver(ctx, wd, hev)
+				results[i] = &dd
+			}(i, wd)
+		}
+	}
+
+	wg.Wait()
+
+	var ops []*dynamodb.TransactWriteItem
+
+	for _, dd := range results {
+		if dd != nil {
+			ops, _ = append_delivery_log(ops, &dbo.webhookTable, *dd, time.Now())
+		}
+	}
+
+	// transactions are limited to 100 items, so write the log in chunks
+	for len(ops) > 0 {
+		n := min(len(ops), 100)
+
+		if lerr := dbo.inline_commit(ctx, ops[:n]); lerr != nil {
+			logger(ctx).Warn("webhook delivery log failed", "error", lerr)
+		}
+
+		ops = ops[n:]
+	}
+}
+
+func (dbo DynamoOperator) read_webhook(ctx context.Context, groupId *UUID, hookId UUID) (WebhookData, error) {
+	out, err := dbo.dbi.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			hookGroupCol:  {S: aws.String(groupId.String())},
+			objectTypeCol: {S: aws.String(webhook_type(hookId))},
+		},
+		TableName: &dbo.webhookTable,
+	})
+
+	var wd WebhookData
+
+	if err != nil {
+		return wd, err
+	}
+
+	if out.Item == nil {
+		return wd, fmt.Errorf("webhook %s not found", hookId.String())
+	}
+
+	wderr := dynamodbattribute.UnmarshalMap(out.Item, &wd)
+
+	return wd, wderr
+}
+
+func (dbo DynamoOperator) WebhookCreate(ctx context.Context, s Session, wd WebhookData) (Response, error) {
+	var ops []*dynamodb.TransactWriteItem
+	var err error
+
+	newid := MakeUUID()
+
+	wd.HookId = newid.String()
+	wd.GroupId = *s.GetGroupIdString()
+	wd.UserId = *s.GetUserIdString()
+
+	if wd.Secret == "" {
+		if wd.Secret, err = make_webhook_secret(); err != nil {
+			return makeerror(err)
+		}
+	}
+
+	ops, err = append_webhook_create(ops, &dbo.webhookTable, wd)
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	var counters []string
+
+	if wd.CounterId != "" {
+		counters = append(counters, wd.CounterId)
+	}
+
+	ops, err = append_group_counter_check(ops, &dbo.groupTable, s.GetGroupId(), counters)
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	result := opResult{Success: true, Result: "OK", Id: newid.String()}
+
+	return dbo.commit_once(ctx, s, ops, webhookResult{opResult: result, Secret: wd.Secret}, result)
+}
+
+func (dbo DynamoOperator) WebhookRead(ctx context.Context, s Session, hookId UUID) (Response, error) {
+	wd, err := dbo.read_webhook(ctx, s.GetGroupId(), hookId)
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	return makeresponse(wd)
+}
+
+func (dbo DynamoOperator) WebhookList(ctx context.Context, s Session) (Response, error) {
+	hooks, err := dbo.read_webhooks(ctx, s.GetGroupId())
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	ids := []string{}
+
+	for _, wd := range hooks {
+		ids = append(ids, wd.HookId)
+	}
+
+	return makeresponse(opResult{
+		Success: true,
+		Result:  "OK",
+		Id:      *s.GetGroupIdString(),
+		Items:   ids,
+	})
+}
+
+func (dbo DynamoOperator) WebhookDelete(ctx context.Context, s Session, hookId UUID) (Response, error) {
+	var ops []*dynamodb.TransactWriteItem
+	var err error
+
+	ops, err = append_webhook_delete(ops, &dbo.webhookTable, s.GetGroupId(), hookId)
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	return dbo.commit(ctx, s, ops, hookId)
+}
+
+// most recent deliveries first
+func (dbo DynamoOperator) WebhookDeliveries(ctx context.Context, s Session, hookId UUID) (Response, error) {
+	if _, herr := dbo.read_webhook(ctx, s.GetGroupId(), hookId); herr != nil {
+		return makeerror(herr)
+	}
+
+	input := prefix_query(&dbo.webhookTable, hookId.String(), deliveryTypePrefix)
+	input.ScanIndexForward = aws.Bool(false)
+	input.Limit = aws.Int64(50)
+
+	out, err := dbo.dbi.QueryWithContext(ctx, input)
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	deliveries := []DeliveryData{}
+
+	dderr := dynamodbattribute.UnmarshalListOfMaps(out.Items, &deliveries)
+
+	if dderr != nil {
+		return makeerror(dderr)
+	}
+
+	return makeresponse(deliveries)
+}
+
+func (dbo DynamoOperator) read_user(ctx context.Context, userId UUID) (UserData, error) {
+	out, err := dbo.dbi.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			userIdCol:     {S: aws.String(userId.String())},
+			objectTypeCol: {S: &dbo.userType},
+		},
+		TableName: &dbo.userTable,
+	})
+
+	var ud UserData
+
+	if err != nil {
+		return ud, err
+	}
+
+	if out.Item == nil {
+		return ud, fmt.Errorf("user %s not found", userId.String())
+	}
+
+	uderr := dynamodbattribute.UnmarshalMap(out.Item, &ud)
+
+	return ud, uderr
+}
+
+func (dbo DynamoOperator) read_group(ctx context.Context, groupId UUID) (GroupData, error) {
+	out, err := dbo.dbi.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			groupIdCol:    {S: aws.String(groupId.String())},
+			objectTypeCol: {S: &dbo.groupType},
+		},
+		TableName: &dbo.groupTable,
+	})
+
+	var gd GroupData
+
+	if err != nil {
+		return gd, err
+	}
+
+	if out.Item == nil {
+		return gd, fmt.Errorf("group %s not found", groupId.String())
+	}
+
+	gderr := dynamodbattribute.UnmarshalMap(out.Item, &gd)
+
+	return gd, gderr
+}
+
+// the groups with these ids, in the same order, read together
+func (dbo DynamoOperator) read_groups(ctx context.Context, groupIds []UUID) ([]GroupData, error) {
+	var keys []map[string]*dynamodb.AttributeValue
+
+	for _, groupId := range groupIds {
+		keys = append(keys, map[string]*dynamodb.AttributeValue{
+			groupIdCol:    {S: aws.String(groupId.String())},
+			objectTypeCol: {S: &dbo.groupType},
+		})
+	}
+
+	items, err := dbo.batch_get(ctx, dbo.groupTable, keys)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var read []GroupData
+
+	if uerr := dynamodbattribute.UnmarshalListOfMaps(items, &read); uerr != nil {
+		return nil, uerr
+	}
+
+	found := map[string]GroupData{}
+
+	for _, gd := range read {
+		found[gd.GroupId] = gd
+	}
+
+	var groups []GroupData
+
+	for _, groupId := range groupIds {
+		gd, ok := found[groupId.String()]
+
+		if !ok {
+			return nil, fmt.Errorf("group %s not found", groupId.String())
+		}
+
+		groups = append(groups, gd)
+	}
+
+	return groups, nil
+}
+
+// BatchGetItem takes up to this many keys
+const batchGetKeys = 100
+
+// the items of the table with these keys, in no particular order, with just
+// the attributes named if there are any.  Keys can't be repeated.  Those
+// which DynamoDB leaves unprocessed are asked for again, with the same
+// backoff as a clashing transaction.
+func (dbo DynamoOperator) batch_get(ctx context.Context, table string, keys []map[string]*dynamodb.AttributeValue, attributes ...string) ([]map[string]*dynamodb.AttributeValue, error) {
+	var items []map[string]*dynamodb.AttributeValue
+
+	for start := 0; start < len(keys); start += batchGetKeys {
+		ka := dynamodb.KeysAndAttributes{
+			Keys:           keys[start:min(start+batchGetKeys, len(keys))],
+			ConsistentRead: aws.Bool(true),
+		}
+
+		if len(attributes) > 0 {
+			var names []string
+			ka.ExpressionAttributeNames = map[string]*string{}
+
+			for i, attribute := range attributes {
+				name := fmt.Sprintf("#a%d", i)
+				names = append(names, name)
+				ka.ExpressionAttributeNames[name] = aws.String(attribute)
+			}
+
+			ka.ProjectionExpression = aws.String(strings.Join(names, ", "))
+		}
+
+		requested := map[string]*dynamodb.KeysAndAttributes{table: &ka}
+
+		for attempt := 0; len(requested) > 0; attempt++ {
+			if attempt == commitAttempts {
+				return nil, busy(fmt.Errorf("items still unread after %d attempts", commitAttempts))
+			}
+
+			if attempt > 0 {
+				if err := retry_sleep(ctx, retry_delay(attempt)); err != nil {
+					return nil, err
+				}
+			}
+
+			out, err := dbo.dbi.BatchGetItemWithContext(ctx, &dynamodb.BatchGetItemInput{RequestItems: requested})
+
+			if err != nil {
+				return nil, err
+			}
+
+			items = append(items, out.Responses[table]...)
+			requested = out.UnprocessedKeys
+		}
+	}
+
+	return items, nil
+}
+
+// the user has to be in the group, and any counters have to be in it too
+func (dbo DynamoOperator) SubscriptionCheck(ctx context.Context, userId UUID, sub Subscription) error {
+	ud, uerr := dbo.read_user(ctx, userId)
+
+	if uerr != nil {
+		return uerr
+	}
+
+	if !slices.Contains(ud.Groups, sub.GroupId) {
+		return fmt.Errorf("user is not in group %s", sub.GroupId)
+	}
+
+	if len(sub.Counters) == 0 {
+		return nil
+	}
+
+	groupId, gerr := ToUUID(sub.GroupId)
+
+	if gerr != nil {
+		return gerr
+	}
+
+	gd, rerr := dbo.read_group(ctx, groupId)
+
+	if rerr != nil {
+		return rerr
+	}
+
+	for _, c := range sub.Counters {
+		if !slices.Contains(gd.Counters, c) {
+			return fmt.Errorf("counter %s is not in group %s", c, sub.GroupId)
+		}
+	}
+
+	return nil
+}
+
+func (dbo DynamoOperator) ConnectionCreate(ctx context.Context, connectionId string, userId UUID) error {
+	var ops []*dynamodb.TransactWriteItem
+	var err error
+
+	ops, err = append_connection_create(ops, &dbo.socketTable, ConnectionData{
+		ConnectionId: connectionId,
+		ObjectType:   dbo.connType,
+		UserId:       userId.String(),
+		ExpiresAt:    time.Now().Add(connectionTTL).Unix(),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return dbo.inline_commit(ctx, ops)
+}
+
+func (dbo DynamoOperator) ConnectionRead(ctx context.Context, connectionId string) (ConnectionData, error) {
+	out, err := dbo.dbi.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			connectionIdCol: {S: aws.String(connectionId)},
+			objectTypeCol:   {S: &dbo.connType},
+		},
+		TableName: &dbo.socketTable,
+	})
+
+	var cd ConnectionData
+
+	if err != nil {
+		return cd, err
+	}
+
+	if out.Item == nil {
+		return cd, fmt.Errorf("connection %s not found", connectionId)
+	}
+
+	cderr := dynamodbattribute.UnmarshalMap(out.Item, &cd)
+
+	return cd, cderr
+}
+
+// removes the connection and all of its subscriptions
+func (dbo DynamoOperator) ConnectionDelete(ctx context.Context, connectionId string) error {
+	cd, rerr := dbo.ConnectionRead(ctx, connectionId)
+
+	if rerr != nil {
+		return rerr
+	}
+
+	var ops []*dynamodb.TransactWriteItem
+
+	for _, group := range cd.Groups {
+		ops, _ = append_subscription_delete(ops, &dbo.socketTable, group, connectionId)
+	}
+
+	ops, _ = append_connection_delete(ops, &dbo.socketTable, &dbo.connType, connectionId)
+
+	return dbo.inline_commit(ctx, ops)
+}
+
+// call SubscriptionCheck first, this only records the subscription
+func (dbo DynamoOperator) SubscriptionCreate(ctx context.Context, connectionId string, userId UUID, sub Subscription) error {
+	cd, rerr := dbo.ConnectionRead(ctx, connectionId)
+
+	if rerr != nil {
+		return rerr
+	}
+
+	if len(cd.Groups) >= maxSubscriptions && !slices.Contains(cd.Groups, sub.GroupId) {
+		return fmt.Errorf("connection already has %d subscriptions", len(cd.Groups))
+	}
+
+	groupId, gerr := ToUUID(sub.GroupId)
+
+	if gerr != nil {
+		return gerr
+	}
+
+	var ops []*dynamodb.TransactWriteItem
+	var err error
+
+	ops, err = append_subscription_put(ops, &dbo.socketTable, SubscriptionData{
+		GroupId:      sub.GroupId,
+		ConnectionId: connectionId,
+		UserId:       userId.String(),
+		Counters:     sub.Counters,
+		ExpiresAt:    cd.ExpiresAt,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	ops, err = append_connection_update(ops, &dbo.socketTable, &dbo.connType, connectionId, conn_add_group, groupId)
+
+	if err != nil {
+		return err
+	}
+
+	return dbo.inline_commit(ctx, ops)
+}
+
+func (dbo DynamoOperator) SubscriptionDelete(ctx context.Context, connectionId string, groupId UUID) error {
+	var ops []*dynamodb.TransactWriteItem
+	var err error
+
+	ops, err = append_subscription_delete(ops, &dbo.socketTable, groupId.String(), connectionId)
+
+	if err != nil {
+		return err
+	}
+
+	ops, err = append_connection_update(ops, &dbo.socketTable, &dbo.connType, connectionId, conn_remove_group, groupId)
+
+	if err != nil {
+		return err
+	}
+
+	return dbo.inline_commit(ctx, ops)
+}
+
+func (dbo DynamoOperator) read_subscriptions(ctx context.Context, groupId string) ([]SubscriptionData, error) {
+	out, err := dbo.dbi.QueryWithContext(ctx, prefix_query(&dbo.socketTable, groupId, subscriptionTypePrefix))
+
+	if err != nil {
+		return nil, err
+	}
+
+	var subs []SubscriptionData
+
+	sderr := dynamodbattribute.UnmarshalListOfMaps(out.Items, &subs)
+
+	return subs, sderr
 }
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 )
@@ -18,17 +23,23 @@ func mockEnv(expUid UUID, expGid UUID, expEmail string) (Session, DynamoOperator
 	dbi := MockDBInterface{}
 
 	dbo := DynamoOperator{
-		counterTable:    "CounterTable",
-		groupTable:      "GroupTable",
-		userTable:       "UserTable",
-		permissionTable: "PermissionTable",
-		userEmailIndex:  "UserEmailIndex",
+		counterTable:     "CounterTable",
+		groupTable:       "GroupTable",
+		userTable:        "UserTable",
+		permissionTable:  "PermissionTable",
+		apiKeyTable:      "APIKeyTable",
+		socketTable:      "SocketTable",
+		idempotencyTable: "IdempotencyTable",
+		userEmailIndex:   "UserEmailIndex",
 
 		dbi: &dbi,
 
 		counterType: "Counter",
 		userType:    "User",
 		groupType:   "Group",
+		emailType:   "UserEmail",
+		apiKeyType:  "APIKey",
+		connType:    "Connection",
 	}
 	return &s, dbo, &dbi
 }
@@ -40,13 +51,107 @@ func TestDBOUserCreate(t *testing.T) {
 
 	newid := MakeUUID()
 
-	err := dbo.UserCreate(newid, &expEmail)
+	err := dbo.UserCreate(context.Background(), newid, &expEmail)
 
 	checkError(t, err, nil)
 
-	checkOpsLen(t, dbi.twi.TransactItems, 1)
+	checkOpsLen(t, dbi.twi.TransactItems, 2)
+
+	checkUserReserve(t, dbi.twi.TransactItems[0], dbo.userTable, newid, expEmail)
+	checkNewUser(t, dbi.twi.TransactItems[1], dbo.userTable, newid, expEmail)
+}
+
+func TestDBOUserDelete(t *testing.T) {
+	var expEmail = "foo@bar.com"
+
+	_, dbo, dbi := mockEnv(MakeUUID(), MakeUUID(), expEmail)
+
+	oldid := MakeUUID()
+
+	err := dbo.UserDelete(context.Background(), oldid, &expEmail)
+
+	checkError(t, err, nil)
+
+	checkOpsLen(t, dbi.twi.TransactItems, 2)
+
+	checkUserRelease(t, dbi.twi.TransactItems[0], dbo.userTable, oldid, expEmail)
+
+	if dd := dbi.twi.TransactItems[1].Delete; dd == nil || *dd.Key[userIdCol].S != oldid.String() || *dd.Key[objectTypeCol].S != "User" {
+		t.Error("Expected delete of the user record")
+	}
+}
+
+func TestDBOLookupUserReservation(t *testing.T) {
+	var expEmail = "foo@bar.com"
+
+	_, dbo, dbi := mockEnv(MakeUUID(), MakeUUID(), expEmail)
+
+	_, found, err := dbo.LookupUserReservation(context.Background(), &expEmail)
+
+	checkError(t, err, nil)
+
+	if found {
+		t.Error("Found a reservation in an empty table")
+	}
+
+	if *dbi.gii.Key[userIdCol].S != expEmail || *dbi.gii.Key[objectTypeCol].S != "UserEmail" {
+		t.Error("Reservation lookup used the wrong key")
+	}
+
+	if dbi.gii.ConsistentRead == nil || !*dbi.gii.ConsistentRead {
+		t.Error("Reservation lookup is not a consistent read")
+	}
+
+	expid := MakeUUID()
+
+	edm, merr := dynamodbattribute.MarshalMap(UserEmailData{
+		Email:      expEmail,
+		UserId:     expid.String(),
+		ObjectType: "UserEmail",
+	})
+
+	checkError(t, merr, nil)
+
+	dbi.gio = dynamodb.GetItemOutput{
+		Item: edm,
+	}
+
+	uid, found, err := dbo.LookupUserReservation(context.Background(), &expEmail)
+
+	checkError(t, err, nil)
 
-	checkNewUser(t, dbi.twi.TransactItems[0], dbo.userTable, newid, expEmail)
+	if !found || uid != expid {
+		t.Errorf("Reservation is %s (%t) not %s", uid, found, expid)
+	}
+}
+
+func TestDBOUserReserveIndexed(t *testing.T) {
+	var expEmail = "foo@bar.com"
+
+	_, dbo, dbi := mockEnv(MakeUUID(), MakeUUID(), expEmail)
+
+	if _, found, err := dbo.UserReserveIndexed(context.Background(), &expEmail); err != nil || found || dbi.twi.TransactItems != nil {
+		t.Errorf("Empty index gave %t %s", found, err)
+	}
+
+	if *dbi.qi.IndexName != dbo.userEmailIndex || *dbi.qi.ExpressionAttributeValues[":email"].S != expEmail {
+		t.Errorf("Index query is %v", dbi.qi)
+	}
+
+	expid := MakeUUID()
+
+	dbi.qo.Items = []map[string]*dynamodb.AttributeValue{{userIdCol: {S: aws.String(expid.String())}}}
+
+	uid, found, err := dbo.UserReserveIndexed(context.Background(), &expEmail)
+
+	checkError(t, err, nil)
+
+	if !found || uid != expid {
+		t.Errorf("Indexed user is %s (%t) not %s", uid, found, expid)
+	}
+
+	checkOpsLen(t, dbi.twi.TransactItems, 1)
+	checkUserReserve(t, dbi.twi.TransactItems[0], dbo.userTable, expid, expEmail)
 }
 
 func TestDBOGroupCreate(t *testing.T) {
@@ -55,7 +160,7 @@ func TestDBOGroupCreate(t *testing.T) {
 
 	s, dbo, dbi := mockEnv(MakeUUID(), MakeUUID(), expEmail)
 
-	resp, err := dbo.GroupCreate(s, groupName)
+	resp, err := dbo.GroupCreate(context.Background(), s, groupName)
 
 	checkError(t, err, nil)
 
@@ -88,7 +193,7 @@ func TestDBOGroupList(t *testing.T) {
 		Item: udm,
 	}
 
-	resp, err := dbo.GroupList(s)
+	resp, err := dbo.GroupList(context.Background(), s)
 
 	checkError(t, err, nil)
 
@@ -111,6 +216,68 @@ func TestDBOGroupList(t *testing.T) {
 	}
 }
 
+func TestDBOGroupRead(t *testing.T) {
+	s, dbo, dbi := mockEnv(MakeUUID(), expGroup, "foo@bar.com")
+
+	counter := MakeUUID()
+
+	// the mock gives every read the same item, so it is both the user and the group
+	item, _ := dynamodbattribute.MarshalMap(GroupData{
+		GroupId:   expGroup.String(),
+		GroupName: "coffee",
+		Counters:  []string{counter.String()},
+		Version:   2,
+	})
+	item["groups"] = &dynamodb.AttributeValue{SS: []*string{aws.String(expGroup.String())}}
+
+	dbi.gio.Item = item
+
+	resp, err := dbo.GroupRead(context.Background(), s)
+
+	checkError(t, err, nil)
+
+	var gd GroupData
+
+	json.Unmarshal([]byte(resp.Body), &gd)
+
+	if resp.StatusCode != 200 || gd.GroupName != "coffee" || len(gd.Counters) != 1 || resp.Headers["ETag"] != `"2"` {
+		t.Errorf("Read gave %d %s %v", resp.StatusCode, resp.Body, resp.Headers)
+	}
+
+	// not one of the user's groups
+	item["groups"] = &dynamodb.AttributeValue{SS: []*string{aws.String(MakeUUID().String())}}
+
+	if resp, _ := dbo.GroupRead(context.Background(), s); resp.StatusCode != 404 {
+		t.Errorf("Someone else's group gave %d %s", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestDBOGroupReadMany(t *testing.T) {
+	s, dbo, dbi := mockEnv(MakeUUID(), expGroup, "foo@bar.com")
+
+	other := MakeUUID()
+	user, _ := dynamodbattribute.MarshalMap(UserData{UserId: s.GetUserId().String(), Groups: []string{expGroup.String(), other.String()}})
+	dbi.items = map[string]map[string]*dynamodb.AttributeValue{s.GetUserId().String(): user}
+
+	for i, id := range []UUID{expGroup, other} {
+		dbi.items[id.String()], _ = dynamodbattribute.MarshalMap(GroupData{GroupId: id.String(), GroupName: "group" + strconv.Itoa(i)})
+	}
+
+	resp, _ := dbo.GroupReadMany(context.Background(), s, []UUID{other, expGroup})
+
+	var groups []GroupData
+
+	json.Unmarshal([]byte(resp.Body), &groups)
+
+	if resp.StatusCode != 200 || len(groups) != 2 || groups[0].GroupName != "group1" || groups[1].Counters == nil || len(dbi.bgis) != 1 {
+		t.Errorf("Read gave %d %s in %d batches", resp.StatusCode, resp.Body, len(dbi.bgis))
+	}
+
+	if resp, _ := dbo.GroupReadMany(context.Background(), s, []UUID{expGroup, MakeUUID()}); resp.StatusCode != 404 {
+		t.Errorf("Someone else's group gave %d %s", resp.StatusCode, resp.Body)
+	}
+}
+
 func TestDBOCounterList(t *testing.T) {
 	var expEmail = "foo@bar.com"
 	s, dbo, dbi := mockEnv(MakeUUID(), MakeUUID(), expEmail)
@@ -135,7 +302,7 @@ func TestDBOCounterList(t *testing.T) {
 		Item: udm,
 	}
 
-	resp, err := dbo.CounterList(s)
+	resp, err := dbo.CounterList(context.Background(), s, nil)
 
 	checkError(t, err, nil)
 
@@ -157,3 +324,140 @@ func TestDBOCounterList(t *testing.T) {
 		t.Errorf("Counter list is incorrect:  %s", r.Items)
 	}
 }
+
+func TestDBOAPIKeyCreate(t *testing.T) {
+	var expEmail = "foo@bar.com"
+
+	s, dbo, dbi := mockEnv(MakeUUID(), MakeUUID(), expEmail)
+
+	group := MakeUUID()
+	counter := MakeUUID().String()
+
+	resp, err := dbo.APIKeyCreate(context.Background(), s, "ci", group, []string{counter}, []string{perm_inc})
+
+	checkError(t, err, nil)
+
+	checkOpsLen(t, dbi.twi.TransactItems, 3)
+
+	newid := decodeResultId(t, resp)
+
+	var r apiKeyResult
+
+	checkError(t, json.Unmarshal([]byte(resp.Body), &r), nil)
+
+	put := dbi.twi.TransactItems[0].Put
+
+	if *put.Item[apiKeyIdCol].S != newid.String() {
+		t.Errorf("Key id is %s not %s", *put.Item[apiKeyIdCol].S, newid)
+	}
+
+	if *put.Item[keyHashCol].S != hash_api_key(r.Key) {
+		t.Error("Stored hash does not match the returned key")
+	}
+
+	if strings.Contains(resp.Body, *put.Item[keyHashCol].S) {
+		t.Error("Hash returned to the caller")
+	}
+
+	checkUserUpdate(t, dbi.twi.TransactItems[1], newid, uquery(usr_add_key), dbo.userTable, *s.GetUserId())
+
+	if dbi.twi.TransactItems[2].ConditionCheck == nil {
+		t.Error("Counters are not checked against the group")
+	}
+
+	checkGranted(t, dbi.twi.TransactItems)
+}
+
+func TestDBOAPIKeyVerify(t *testing.T) {
+	var expEmail = "foo@bar.com"
+
+	_, dbo, dbi := mockEnv(MakeUUID(), MakeUUID(), expEmail)
+
+	keyId := MakeUUID()
+	key, _ := make_api_key(keyId)
+
+	kdm, err := dynamodbattribute.MarshalMap(APIKeyData{
+		KeyId:      keyId.String(),
+		ObjectType: "APIKey",
+		KeyHash:    hash_api_key(key),
+		Rights:     []string{perm_read},
+	})
+
+	checkError(t, err, nil)
+
+	dbi.gio = dynamodb.GetItemOutput{
+		Item: kdm,
+	}
+
+	kd, verr := dbo.APIKeyVerify(context.Background(), key)
+
+	checkError(t, verr, nil)
+
+	if kd.KeyId != keyId.String() || *dbi.gii.Key[apiKeyIdCol].S != keyId.String() {
+		t.Errorf("Looked up key %s not %s", *dbi.gii.Key[apiKeyIdCol].S, keyId)
+	}
+
+	if _, verr := dbo.APIKeyVerify(context.Background(), key+"x"); verr == nil {
+		t.Error("Wrong key verified")
+	}
+
+	dbi.gio = dynamodb.GetItemOutput{}
+
+	if _, verr := dbo.APIKeyVerify(context.Background(), key); verr == nil {
+		t.Error("Missing key verified")
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	var expEmail = "foo@bar.com"
+
+	s, dbo, dbi := mockEnv(MakeUUID(), MakeUUID(), expEmail)
+
+	tr := testReceiver{t: t, secret: "sssh", statuses: []int{200}}
+	srv := httptest.NewServer(&tr)
+	defer srv.Close()
+
+	dbo.hooks = testDispatcher()
+
+	counter := MakeUUID()
+
+	hooks, _ := dynamodbattribute.MarshalList([]WebhookData{
+		{GroupId: *s.GetGroupIdString(), HookId: MakeUUID().String(), URL: srv.URL, Events: []string{ev_increment}, Secret: tr.secret},
+		{GroupId: *s.GetGroupIdString(), HookId: MakeUUID().String(), URL: srv.URL, Events: []string{ev_reset}, Secret: tr.secret},
+	})
+
+	for _, h := range hooks {
+		dbi.qo.Items = append(dbi.qo.Items, h.M)
+	}
+
+	cdm, _ := dynamodbattribute.MarshalMap(CountData{CounterId: counter.String(), CounterGroup: *s.GetGroupIdString(), CounterName: "coffee", CounterVal: 3, StepVal: 1})
+
+	dbi.gio = dynamodb.GetItemOutput{Item: cdm}
+
+	resp, err := dbo.CounterUpdate(context.Background(), s, counter, dnquery(dq_current, dq_inc), 1)
+
+	checkError(t, err, nil)
+
+	if resp.StatusCode != 200 {
+		t.Fatalf("Update failed: %s", resp.Body)
+	}
+
+	// webhooks are only delivered from the stream
+	if len(tr.events) != 0 || len(dbi.twis) != 1 || dbi.qi.TableName != nil {
+		t.Fatalf("Update delivered %v in %d transactions", tr.events, len(dbi.twis))
+	}
+
+	checkCounterUpdate(t, dbi.twis[0].TransactItems[0], 1, version_bump(dnquery(dq_current, dq_inc), map[string]*dynamodb.AttributeValue{}), dbo.counterTable, *s.GetGroupId(), counter)
+
+	ev := CounterEvent{Event: ev_increment, GroupId: *s.GetGroupIdString(), CounterId: counter.String(), CounterName: "coffee", OldVal: 3, NewVal: 4}
+
+	checkError(t, WebhookSink{dbo: dbo}.Publish(context.Background(), DomainEvent{Kind: "Counter", Counter: &ev}), nil)
+
+	if len(tr.events) != 1 || tr.events[0].Event != ev_increment || tr.events[0].CounterName != "coffee" {
+		t.Errorf("Receiver got %v", tr.events)
+	}
+
+	if log := dbi.twis[1].TransactItems; len(log) != 1 || !strings.HasPrefix(*log[0].Put.Item[objectTypeCol].S, "Delivery#") {
+		t.Error("Delivery was not logged")
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Transactions on a busy counter get cancelled when another transaction has
+// the item at the same moment, and under load they get throttled.  Both go
+// away on their own so those are retried, with jittered exponential backoff
+// so that the clashing writers spread out.  A failed condition is an answer,
+// not a clash, and is never retried.
+
+const (
+	commitAttempts = 5
+	retryBase      = 20 * time.Millisecond
+	retryCap       = 500 * time.Millisecond
+)
+
+// waits before the next attempt, or gives up when the request runs out of time.
+// Swapped out by tests.
+var retry_sleep = func(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// cancellation reasons and error codes which are worth another go
+var retryable = map[string]bool{
+	"TransactionConflict": true,
+	"ThrottlingError":     true,
+
+	dynamodb.ErrCodeTransactionConflictException:           true,
+	dynamodb.ErrCodeTransactionInProgressException:         true,
+	dynamodb.ErrCodeProvisionedThroughputExceededException: true,
+	dynamodb.ErrCodeRequestLimitExceeded:                   true,
+	"ThrottlingException":                                  true,
+}
+
+// true if a failed transaction should be tried again.  A cancelled transaction
+// is only retried if nothing in it failed a condition.
+func should_retry(err error) bool {
+	var tce *dynamodb.TransactionCanceledException
+
+	if errors.As(err, &tce) {
+		retry := false
+
+		for _, r := range tce.CancellationReasons {
+			if r == nil || r.Code == nil {
+				continue
+			}
+
+			if *r.Code == "ConditionalCheckFailed" {
+				return false
+			}
+
+			if retryable[*r.Code] {
+				retry = true
+			}
+		}
+
+		return retry
+	}
+
+	var ae awserr.Error
+
+	return errors.As(err, &ae) && retryable[ae.Code()]
+}
+
+// "full jitter": anywhere up to the capped exponential delay
+func retry_delay(attempt int) time.Duration {
+	return time.Duration(rand.Int63n(int64(min(retryCap, retryBase<<attempt)) + 1))
+}
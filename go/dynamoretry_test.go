@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func cancelledWith(codes ...string) error {
+	var reasons []*dynamodb.CancellationReason
+
+	for _, c := range codes {
+		reasons = append(reasons, &dynamodb.CancellationReason{Code: aws.String(c)})
+	}
+
+	return &dynamodb.TransactionCanceledException{CancellationReasons: reasons}
+}
+
+// records the backoff instead of sleeping
+func noSleep(t *testing.T) *[]time.Duration {
+	var delays []time.Duration
+
+	sleep := retry_sleep
+
+	retry_sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+
+	t.Cleanup(func() { retry_sleep = sleep })
+
+	return &delays
+}
+
+func TestShouldRetry(t *testing.T) {
+	checks := map[error]bool{
+		cancelledWith("None", "TransactionConflict"):                                         true,
+		cancelledWith("ThrottlingError", "None"):                                             true,
+		cancelledWith("ConditionalCheckFailed", "None"):                                      false,
+		cancelledWith("TransactionConflict", "ConditionalCheckFailed"):                       false,
+		cancelledWith("ValidationError"):                                                     false,
+		awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "slow down", nil): true,
+		awserr.New(dynamodb.ErrCodeResourceNotFoundException, "no table", nil):               false,
+	}
+
+	for err, expect := range checks {
+		if should_retry(err) != expect {
+			t.Errorf("Retry of %s is not %t", err, expect)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for attempt := 0; attempt < 10; attempt++ {
+		if d := retry_delay(attempt); d < 0 || d > retryCap || d > retryBase<<attempt {
+			t.Errorf("Delay for attempt %d is %s", attempt, d)
+		}
+	}
+}
+
+func TestCommitRetriesConflict(t *testing.T) {
+	delays := noSleep(t)
+
+	s, dbo, dbi := mockEnv(expUser, expGroup, "foo@bar.com")
+
+	dbi.twErrs = []error{cancelledWith("TransactionConflict"), cancelledWith("ThrottlingError")}
+
+	res, _ := dbo.CounterUpdate(context.Background(), s, expCounterUUID, dnquery(dq_current, dq_inc), 1)
+
+	if res.StatusCode != 200 {
+		t.Errorf("Conflicted increment gave %d %s", res.StatusCode, res.Body)
+	}
+
+	if len(dbi.twis) != 3 || len(*delays) != 2 {
+		t.Errorf("%d attempts with %d sleeps", len(dbi.twis), len(*delays))
+	}
+}
+
+func TestCommitGivesUp(t *testing.T) {
+	delays := noSleep(t)
+
+	s, dbo, dbi := mockEnv(expUser, expGroup, "foo@bar.com")
+
+	for i := 0; i < commitAttempts; i++ {
+		dbi.twErrs = append(dbi.twErrs, cancelledWith("TransactionConflict"))
+	}
+
+	res, _ := dbo.CounterUpdate(context.Background(), s, expCounterUUID, dnquery(dq_current, dq_inc), 1)
+
+	// not the counter's fault, so the client is told to try again
+	if res.StatusCode != 503 || res.Headers["Retry-After"] != busyRetryAfter {
+		t.Errorf("Endless conflict gave %d %v", res.StatusCode, res.Headers)
+	}
+
+	if len(dbi.twis) != commitAttempts || len(*delays) != commitAttempts-1 {
+		t.Errorf("%d attempts with %d sleeps", len(dbi.twis), len(*delays))
+	}
+}
+
+func TestCommitRunsOutOfTime(t *testing.T) {
+	s, dbo, dbi := mockEnv(expUser, expGroup, "foo@bar.com")
+
+	sleep := retry_sleep
+	retry_sleep = func(ctx context.Context, d time.Duration) error { return context.DeadlineExceeded }
+	t.Cleanup(func() { retry_sleep = sleep })
+
+	dbi.twErrs = []error{cancelledWith("TransactionConflict")}
+
+	if res, _ := dbo.CounterUpdate(context.Background(), s, expCounterUUID, dnquery(dq_current, dq_inc), 1); res.StatusCode != 504 {
+		t.Errorf("Conflict until the deadline gave %d %s", res.StatusCode, res.Body)
+	}
+}
+
+func TestCommitConditionNotRetried(t *testing.T) {
+	delays := noSleep(t)
+
+	s, dbo, dbi := mockEnv(expUser, expGroup, "foo@bar.com")
+
+	dbi.twErrs = []error{cancelledWith("ConditionalCheckFailed")}
+
+	res, _ := dbo.CounterUpdate(context.Background(), s, expCounterUUID, dnquery(dq_current, dq_inc), 1)
+
+	if res.StatusCode != 404 || len(dbi.twis) != 1 || len(*delays) != 0 {
+		t.Errorf("Missing counter gave %d after %d attempts", res.StatusCode, len(dbi.twis))
+	}
+}
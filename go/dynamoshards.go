@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// A sharded counter spreads its count over shard items so that increments
+// aren't held to what one item can take.  The counter item keeps the name,
+// group, step and version; shard n has the sort key "Counter#<n>" and holds
+// part of the count and a copy of the step.  Increments go to a shard picked
+// at random and leave the counter item alone, so its version only moves on
+// resets and step changes, which update every shard in one transaction.
+// The stream handler turns a shard's write into an event for its counter.
+
+const shardTypePrefix = "Counter#"
+
+// create and delete touch every shard, the counter and the group in one transaction
+const maxShards = 32
+
+// the parts of a shard which aren't in CountData
+type ShardData struct {
+	CounterId    string `dynamodbav:"objectUUID"`
+	ObjectType   string `dynamodbav:"objectType"`
+	CounterGroup string `dynamodbav:"counterGroupUUID"`
+	CounterVal   int    `dynamodbav:"countVal"`
+	StepVal      int    `dynamodbav:"stepVal"`
+}
+
+func shard_type(shard int) string {
+	return shardTypePrefix + strconv.Itoa(shard)
+}
+
+func random_shard(shards int) int {
+	return rand.Intn(shards)
+}
+
+// true for updates which only add to the count, which a single shard can take
+func shard_query(query string) bool {
+	return query == dnquery(dq_current, dq_inc) || query == dnquery(dq_current, dq_dec)
+}
+
+// shards start at 0, with the counter's step
+func append_shard_create(ops []*dynamodb.TransactWriteItem, table *string, counterId UUID, groupId *UUID, shard int, stepVal int) ([]*dynamodb.TransactWriteItem, error) {
+	record, rerr := dynamodbattribute.MarshalMap(ShardData{
+		CounterId:    counterId.String(),
+		ObjectType:   shard_type(shard),
+		CounterGroup: groupId.String(),
+		CounterVal:   0,
+		StepVal:      stepVal,
+	})
+
+	if rerr != nil {
+		return ops, rerr
+	}
+
+	input := dynamodb.Put{
+		TableName: table,
+		Item:      record,
+	}
+
+	ops = append(ops, &dynamodb.TransactWriteItem{
+		Put: &input,
+	})
+
+	return ops, nil
+}
+
+// the same update as the counter gets, less the version
+func append_shard_update(ops []*dynamodb.TransactWriteItem, table *string, groupId *UUID, counterId UUID, shard int, query string, stepval int) ([]*dynamodb.TransactWriteItem, error) {
+	udr := dynamodb.Update{
+		Key: map[string]*dynamodb.AttributeValue{
+			counterIdCol:  {S: aws.String(counterId.String())},
+			objectTypeCol: {S: aws.String(shard_type(shard))},
+		},
+		TableName: table,
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":" + stepInit:    {N: aws.String(fmt.Sprintf("%d", stepval))},
+			":" + counterInit: {N: aws.String("0")},
+			":" + groupIdVal:  {S: aws.String(groupId.String())},
+		},
+		UpdateExpression:    aws.String(query),
+		ConditionExpression: aws.String(fmt.Sprintf("attribute_exists(%s) and %s = :%s", counterIdCol, counterGroupCol, groupIdVal)),
+	}
+
+	ops = append(ops, &dynamodb.TransactWriteItem{
+		Update: &udr,
+	})
+
+	return ops, nil
+}
+
+func append_shard_delete(ops []*dynamodb.TransactWriteItem, table *string, counterId UUID, shard int) ([]*dynamodb.TransactWriteItem, error) {
+	dr := dynamodb.Delete{
+		Key: map[string]*dynamodb.AttributeValue{
+			counterIdCol:  {S: aws.String(counterId.String())},
+			objectTypeCol: {S: aws.String(shard_type(shard))},
+		},
+		TableName: table,
+	}
+
+	ops = append(ops, &dynamodb.TransactWriteItem{
+		Delete: &dr,
+	})
+
+	return ops, nil
+}
+
+// holds a shard increment to If-Match without writing to the counter item
+func append_counter_check(ops []*dynamodb.TransactWriteItem, table *string, groupId *UUID, counterId UUID, ifMatch *int) ([]*dynamodb.TransactWriteItem, error) {
+	values := map[string]*dynamodb.AttributeValue{
+		":" + groupIdVal: {S: aws.String(groupId.String())},
+	}
+
+	condition, onFailure := version_condition(fmt.Sprintf("attribute_exists(%s) and %s = :%s", counterIdCol, counterGroupCol, groupIdVal), values, ifMatch)
+
+	cc := dynamodb.ConditionCheck{
+		Key: map[string]*dynamodb.AttributeValue{
+			counterIdCol:  {S: aws.String(counterId.String())},
+			objectTypeCol: {S: aws.String("Counter")},
+		},
+		TableName:                           table,
+		ExpressionAttributeValues:           values,
+		ConditionExpression:                 aws.String(condition),
+		ReturnValuesOnConditionCheckFailure: onFailure,
+	}
+
+	ops = append(ops, &dynamodb.TransactWriteItem{
+		ConditionCheck: &cc,
+	})
+
+	return ops, nil
+}
+
+// how many shards a counter has, 0 for an ordinary counter.  That never
+// changes after creation, so an eventually consistent read will do.
+func (dbo DynamoOperator) counter_shards(ctx context.Context, counterId UUID) (int, error) {
+	out, err := dbo.dbi.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			counterIdCol:  {S: aws.String(counterId.String())},
+			objectTypeCol: {S: &dbo.counterType},
+		},
+		TableName:            &dbo.counterTable,
+		ProjectionExpression: aws.String(shardsCol),
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	var cd CountData
+
+	cderr := dynamodbattribute.UnmarshalMap(out.Item, &cd)
+
+	return cd.Shards, cderr
+}
+
+// the sum of a counter's shards
+func (dbo DynamoOperator) read_shards(ctx context.Context, counterId UUID) (int, error) {
+	out, err := dbo.dbi.QueryWithContext(ctx, &dynamodb.QueryInput{
+		TableName: &dbo.counterTable,
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":id":     {S: aws.String(counterId.String())},
+			":prefix": {S: aws.String(shardTypePrefix)},
+		},
+		KeyConditionExpression: aws.String(fmt.Sprintf("%s = :id and begins_with(%s, :prefix)", counterIdCol, objectTypeCol)),
+		ProjectionExpression:   aws.String(counterCol),
+		ConsistentRead:         aws.Bool(true),
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	var shards []ShardData
+
+	if serr := dynamodbattribute.UnmarshalListOfMaps(out.Items, &shards); serr != nil {
+		return 0, serr
+	}
+
+	total := 0
+
+	for _, sd := range shards {
+		total += sd.CounterVal
+	}
+
+	return total, nil
+}
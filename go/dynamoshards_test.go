@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+func shardedEnv(shards int) (Session, DynamoOperator, *MockDBInterface) {
+	s, dbo, dbi := mockEnv(expUser, expGroup, "foo@bar.com")
+
+	dbi.gio.Item, _ = dynamodbattribute.MarshalMap(CountData{
+		CounterId:    expCounterUUID.String(),
+		CounterGroup: expGroup.String(),
+		ObjectType:   "Counter",
+		StepVal:      1,
+		Version:      3,
+		Shards:       shards,
+	})
+
+	return s, dbo, dbi
+}
+
+func shardOf(t *testing.T, key map[string]*dynamodb.AttributeValue) string {
+	if *key[counterIdCol].S != expCounterUUID.String() {
+		t.Errorf("Op is on %s", *key[counterIdCol].S)
+	}
+	return *key[objectTypeCol].S
+}
+
+func TestShardedCounterCreate(t *testing.T) {
+	s, dbo, dbi := mockEnv(expUser, expGroup, "foo@bar.com")
+
+	res, _ := dbo.CounterCreate(context.Background(), s, CountData{CounterName: "clicks", StepVal: 1, Shards: 3})
+
+	if res.StatusCode != 200 {
+		t.Fatalf("Create gave %d %s", res.StatusCode, res.Body)
+	}
+
+	ops := dbi.twi.TransactItems
+
+	checkOpsLen(t, ops, 5)
+
+	var cd CountData
+
+	dynamodbattribute.UnmarshalMap(ops[0].Put.Item, &cd)
+
+	if cd.Shards != 3 || cd.ObjectType != "Counter" {
+		t.Errorf("Counter is %v", cd)
+	}
+
+	for i, op := range ops[1:4] {
+		var sd ShardData
+
+		dynamodbattribute.UnmarshalMap(op.Put.Item, &sd)
+
+		if sd.ObjectType != shard_type(i) || sd.CounterId != cd.CounterId || sd.CounterGroup != expGroup.String() || sd.StepVal != 1 {
+			t.Errorf("Shard %d is %v", i, sd)
+		}
+	}
+
+	if ops[4].Update == nil || *ops[4].Update.TableName != dbo.groupTable {
+		t.Errorf("Group not updated: %v", ops[4])
+	}
+
+	if res, _ := dbo.CounterCreate(context.Background(), s, CountData{CounterName: "clicks", StepVal: 1, Shards: maxShards + 1}); res.StatusCode != 400 {
+		t.Errorf("Counter with %d shards gave %d", maxShards+1, res.StatusCode)
+	}
+}
+
+func TestShardedIncrement(t *testing.T) {
+	s, dbo, dbi := shardedEnv(4)
+
+	seen := map[string]bool{}
+
+	for i := 0; i < 40; i++ {
+		res, _ := dbo.CounterUpdate(context.Background(), s, expCounterUUID, dnquery(dq_current, dq_inc), 1)
+
+		if res.StatusCode != 200 {
+			t.Fatalf("Increment gave %d %s", res.StatusCode, res.Body)
+		}
+
+		ops := dbi.twi.TransactItems
+
+		checkOpsLen(t, ops, 1)
+
+		shard := shardOf(t, ops[0].Update.Key)
+
+		if !strings.HasPrefix(shard, shardTypePrefix) {
+			t.Fatalf("Increment went to %s", shard)
+		}
+
+		if strings.Contains(*ops[0].Update.UpdateExpression, versionCol) {
+			t.Errorf("Shard increment bumps the version: %s", *ops[0].Update.UpdateExpression)
+		}
+
+		seen[shard] = true
+	}
+
+	if len(seen) < 2 {
+		t.Errorf("Increments all went to %v", seen)
+	}
+
+	for shard := range seen {
+		if n, err := strconv.Atoi(strings.TrimPrefix(shard, shardTypePrefix)); err != nil || n < 0 || n > 3 {
+			t.Errorf("No shard %s", shard)
+		}
+	}
+}
+
+func TestShardedIncrementIfMatch(t *testing.T) {
+	s, dbo, dbi := shardedEnv(4)
+
+	s.(*APISession).ifMatch = aws.Int(3)
+
+	dbo.CounterUpdate(context.Background(), s, expCounterUUID, dnquery(dq_current, dq_dec), 1)
+
+	ops := dbi.twi.TransactItems
+
+	checkOpsLen(t, ops, 2)
+
+	if cc := ops[1].ConditionCheck; cc == nil || shardOf(t, cc.Key) != "Counter" {
+		t.Fatalf("Counter version not checked: %v", ops[1])
+	}
+
+	if version, found := op_if_match(ops[1]); !found || version != 3 {
+		t.Errorf("Check is on version %d %t", version, found)
+	}
+
+	checkGranted(t, ops)
+}
+
+func TestShardedReset(t *testing.T) {
+	s, dbo, dbi := shardedEnv(4)
+
+	query := dnquery(dq_current, dq_init)
+
+	dbo.CounterUpdate(context.Background(), s, expCounterUUID, query, 1)
+
+	ops := dbi.twi.TransactItems
+
+	checkOpsLen(t, ops, 5)
+
+	if shardOf(t, ops[0].Update.Key) != "Counter" || !strings.Contains(*ops[0].Update.UpdateExpression, versionCol) {
+		t.Errorf("Counter not reset: %v", ops[0])
+	}
+
+	for i, op := range ops[1:] {
+		if shardOf(t, op.Update.Key) != shard_type(i) || *op.Update.UpdateExpression != query {
+			t.Errorf("Shard %d not reset: %v", i, op)
+		}
+	}
+}
+
+func TestShardedStep(t *testing.T) {
+	s, dbo, dbi := shardedEnv(2)
+
+	dbo.CounterUpdate(context.Background(), s, expCounterUUID, dnquery(dq_init, dq_current), 5)
+
+	ops := dbi.twi.TransactItems
+
+	checkOpsLen(t, ops, 3)
+
+	for _, op := range ops {
+		if *op.Update.ExpressionAttributeValues[":"+stepInit].N != "5" {
+			t.Errorf("Step not set on %s", shardOf(t, op.Update.Key))
+		}
+	}
+}
+
+func TestShardedRead(t *testing.T) {
+	s, dbo, dbi := shardedEnv(2)
+
+	for _, v := range []int{3, 4} {
+		item, _ := dynamodbattribute.MarshalMap(ShardData{CounterVal: v})
+		dbi.qo.Items = append(dbi.qo.Items, item)
+	}
+
+	res, _ := dbo.CounterRead(context.Background(), s, expCounterUUID)
+
+	var cd CountData
+
+	json.Unmarshal([]byte(res.Body), &cd)
+
+	if res.StatusCode != 200 || cd.CounterVal != 7 || cd.Shards != 2 {
+		t.Errorf("Read gave %d %s", res.StatusCode, res.Body)
+	}
+
+	if *dbi.qi.KeyConditionExpression != "objectUUID = :id and begins_with(objectType, :prefix)" || !*dbi.qi.ConsistentRead {
+		t.Errorf("Shards read with %s", *dbi.qi.KeyConditionExpression)
+	}
+}
+
+func TestShardedDelete(t *testing.T) {
+	s, dbo, dbi := shardedEnv(2)
+
+	dbo.CounterDelete(context.Background(), s, expCounterUUID)
+
+	ops := dbi.twi.TransactItems
+
+	checkOpsLen(t, ops, 4)
+
+	for i, op := range ops[1:3] {
+		if op.Delete == nil || shardOf(t, op.Delete.Key) != shard_type(i) {
+			t.Errorf("Shard %d not deleted: %v", i, op)
+		}
+	}
+}
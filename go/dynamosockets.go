@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// one record per API gateway websocket connection, keyed on the connection id.
+// Groups lists the connection's subscriptions so they can be removed on disconnect.
+type ConnectionData struct {
+	ConnectionId string   `dynamodbav:"objectUUID"`
+	ObjectType   string   `dynamodbav:"objectType"`
+	UserId       string   `dynamodbav:"userUUID"`
+	Groups       []string `dynamodbav:"groups,stringset,omitempty"`
+	ExpiresAt    int64    `dynamodbav:"expiresAt"`
+}
+
+// subscriptions live under their group, like webhooks, so one query finds
+// every connection to push a counter change to.  The sort key is "Socket#<connection id>".
+// No counters means the whole group.
+type SubscriptionData struct {
+	GroupId      string   `dynamodbav:"objectUUID"`
+	ObjectType   string   `dynamodbav:"objectType"`
+	ConnectionId string   `dynamodbav:"connectionId"`
+	UserId       string   `dynamodbav:"userUUID"`
+	Counters     []string `dynamodbav:"counters,stringset,omitempty"`
+	ExpiresAt    int64    `dynamodbav:"expiresAt"`
+}
+
+const subscriptionTypePrefix = "Socket#"
+
+// API gateway closes websockets after two hours, so nothing outlives that
+const connectionTTL = 2 * time.Hour
+
+// disconnecting removes every subscription in one transaction, which caps how many there can be
+const maxSubscriptions = 25
+
+const (
+	conn_add_group = iota
+	conn_remove_group
+)
+
+func subscription_type(connectionId string) string {
+	return subscriptionTypePrefix + connectionId
+}
+
+func append_connection_create(ops []*dynamodb.TransactWriteItem, table *string, cd ConnectionData) ([]*dynamodb.TransactWriteItem, error) {
+	record, rerr := dynamodbattribute.MarshalMap(cd)
+
+	if rerr != nil {
+		return ops, rerr
+	}
+
+	input := dynamodb.Put{
+		TableName: table,
+		Item:      record,
+	}
+
+	ops = append(ops, &dynamodb.TransactWriteItem{
+		Put: &input,
+	})
+
+	return ops, nil
+}
+
+func append_connection_update(ops []*dynamodb.TransactWriteItem, table *string, connectionType *string, connectionId string, mode int, groupId UUID) ([]*dynamodb.TransactWriteItem, error) {
+	var query string
+
+	switch mode {
+	case conn_add_group:
+		query = fmt.Sprintf("ADD %s :group", groupListCol)
+	case conn_remove_group:
+		query = fmt.Sprintf("DELETE %s :group", groupListCol)
+	default:
+		return ops, fmt.Errorf("unknown connection update %d", mode)
+	}
+
+	ui := dynamodb.Update{
+		Key: map[string]*dynamodb.AttributeValue{
+			connectionIdCol: {S: aws.String(connectionId)},
+			objectTypeCol:   {S: connectionType},
+		},
+		TableName: table,
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":group": {SS: []*string{aws.String(groupId.String())}},
+		},
+		UpdateExpression:    aws.String(query),
+		ConditionExpression: aws.String(fmt.Sprintf("attribute_exists(%s)", connectionIdCol)),
+	}
+
+	ops = append(ops, &dynamodb.TransactWriteItem{
+		Update: &ui,
+	})
+
+	return ops, nil
+}
+
+func append_connection_delete(ops []*dynamodb.TransactWriteItem, table *string, connectionType *string, connectionId string) ([]*dynamodb.TransactWriteItem, error) {
+	dr := dynamodb.Delete{
+		Key: map[string]*dynamodb.AttributeValue{
+			connectionIdCol: {S: aws.String(connectionId)},
+			objectTypeCol:   {S: connectionType},
+		},
+		TableName: table,
+	}
+
+	ops = append(ops, &dynamodb.TransactWriteItem{
+		Delete: &dr,
+	})
+
+	return ops, nil
+}
+
+// subscribing again to the same group replaces the counters
+func append_subscription_put(ops []*dynamodb.TransactWriteItem, table *string, sd SubscriptionData) ([]*dynamodb.TransactWriteItem, error) {
+	sd.ObjectType = subscription_type(sd.ConnectionId)
+
+	record, rerr := dynamodbattribute.MarshalMap(sd)
+
+	if rerr != nil {
+		return ops, rerr
+	}
+
+	input := dynamodb.Put{
+		TableName: table,
+		Item:      record,
+	}
+
+	ops = append(ops, &dynamodb.TransactWriteItem{
+		Put: &input,
+	})
+
+	return ops, nil
+}
+
+func append_subscription_delete(ops []*dynamodb.TransactWriteItem, table *string, groupId string, connectionId string) ([]*dynamodb.TransactWriteItem, error) {
+	dr := dynamodb.Delete{
+		Key: map[string]*dynamodb.AttributeValue{
+			groupIdCol:    {S: aws.String(groupId)},
+			objectTypeCol: {S: aws.String(subscription_type(connectionId))},
+		},
+		TableName: table,
+	}
+
+	ops = append(ops, &dynamodb.TransactWriteItem{
+		Delete: &dr,
+	})
+
+	return ops, nil
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// templates are kept in the group table, and listed from the user's record
+// like API keys
+
+func append_template_create(ops []*dynamodb.TransactWriteItem, table *string, td TemplateData) ([]*dynamodb.TransactWriteItem, error) {
+	td.ObjectType = "Template"
+
+	record, rerr := dynamodbattribute.MarshalMap(td)
+
+	if rerr != nil {
+		return ops, rerr
+	}
+
+	input := dynamodb.Put{
+		TableName: table,
+		Item:      record,
+	}
+
+	ops = append(ops, &dynamodb.TransactWriteItem{
+		Put: &input,
+	})
+
+	return ops, nil
+}
+
+// only the template's owner can delete it
+func append_template_delete(ops []*dynamodb.TransactWriteItem, table *string, templateId UUID, userId *UUID) ([]*dynamodb.TransactWriteItem, error) {
+	dr := dynamodb.Delete{
+		Key: map[string]*dynamodb.AttributeValue{
+			groupIdCol:    {S: aws.String(templateId.String())},
+			objectTypeCol: {S: aws.String("Template")},
+		},
+		TableName: table,
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":" + userIdVal: {S: aws.String(userId.String())},
+		},
+		ConditionExpression: aws.String(fmt.Sprintf("%s = :%s", principalIdCol, userIdVal)),
+	}
+
+	ops = append(ops, &dynamodb.TransactWriteItem{
+		Delete: &dr,
+	})
+
+	return ops, nil
+}
+
+// the transactions which make a group of the user's with counters in it.
+// The first makes the group and adds it to the user, with as many of the
+// counters as fit, and the rest add the others to it.
+func group_transactions(counters []CountData, counterTable *string, groupTable *string, userTable *string, userId *UUID, groupId UUID, name string) ([][]*dynamodb.TransactWriteItem, error) {
+	for i := range counters {
+		counters[i].CounterGroup = groupId.String()
+	}
+
+	create := func(ops []*dynamodb.TransactWriteItem, ids []string) ([]*dynamodb.TransactWriteItem, error) {
+		ops, err := append_group_create(ops, groupTable, groupId, name, ids)
+
+		if err != nil {
+			return ops, err
+		}
+
+		return append_user_update(ops, userTable, userId, uquery(usr_add_grp), groupId)
+	}
+
+	if len(counters) == 0 {
+		ops, err := create(nil, nil)
+		return [][]*dynamodb.TransactWriteItem{ops}, err
+	}
+
+	return counter_transactions(counters, counterTable, &groupId, 2, func(tx int, ops []*dynamodb.TransactWriteItem, ids []string) ([]*dynamodb.TransactWriteItem, error) {
+		if tx == 0 {
+			return create(ops, ids)
+		}
+
+		return append_group_add_counters(ops, groupTable, &groupId, ids)
+	})
+}
+
+// makes a new group of the session user's with the counters in it, which
+// are given new ids and name each other by them.  A big group is made in
+// several transactions; with an idempotency key the group and its counters
+// get the same ids every time, so a retry finishes one which stopped part
+// way rather than making another.
+func (dbo DynamoOperator) create_group_with(ctx context.Context, s Session, name string, counters []CountData) (Response, error) {
+	groupId := key_uuid(s, "group", 0)
+
+	for i := range counters {
+		counters[i].CounterId = key_uuid(s, "counter", i).String()
+	}
+
+	relink_references(counters)
+
+	txs, err := group_transactions(counters, &dbo.counterTable, &dbo.groupTable, &dbo.userTable, s.GetUserId(), groupId, name)
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	return dbo.commit_all(ctx, s, txs, opResult{Success: true, Result: "OK", Id: groupId.String()})
+}
+
+// the session's group and its counters, if the user is in it
+// the session's group, if the user is in it
+func (dbo DynamoOperator) read_member_group(ctx context.Context, s Session) (GroupData, error) {
+	ud, uerr := dbo.read_user(ctx, *s.GetUserId())
+
+	if uerr != nil {
+		return GroupData{}, uerr
+	}
+
+	if !slices.Contains(ud.Groups, *s.GetGroupIdString()) {
+		return GroupData{}, fmt.Errorf("group %s not found", *s.GetGroupIdString())
+	}
+
+	return dbo.read_group(ctx, *s.GetGroupId())
+}
+
+func (dbo DynamoOperator) read_group_counters(ctx context.Context, s Session) (GroupData, []CountData, error) {
+	gd, gerr := dbo.read_member_group(ctx, s)
+
+	if gerr != nil {
+		return gd, nil, gerr
+	}
+
+	counters, cerr := dbo.read_counters(ctx, gd.Counters)
+
+	if cerr != nil {
+		return gd, nil, cerr
+	}
+
+	// the group's counters are a set, so put them in a stable order
+	slices.SortFunc(counters, func(a, b CountData) int { return strings.Compare(a.CounterName, b.CounterName) })
+
+	return gd, counters, nil
+}
+
+func (dbo DynamoOperator) read_template(ctx context.Context, s Session, templateId UUID) (TemplateData, error) {
+	out, err := dbo.dbi.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			groupIdCol:    {S: aws.String(templateId.String())},
+			objectTypeCol: {S: aws.String("Template")},
+		},
+		TableName: &dbo.groupTable,
+	})
+
+	var td TemplateData
+
+	if err != nil {
+		return td, err
+	}
+
+	if out.Item != nil {
+		err = dynamodbattribute.UnmarshalMap(out.Item, &td)
+	}
+
+	if err == nil && (out.Item == nil || td.UserId != *s.GetUserIdString()) {
+		err = fmt.Errorf("template %s not found", templateId.String())
+	}
+
+	return td, err
+}
+
+func (dbo DynamoOperator) GroupClone(ctx context.Context, s Session, name string, zero bool) (Response, error) {
+	_, counters, err := dbo.read_group_counters(ctx, s)
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	var clones []CountData
+
+	for _, cd := range counters {
+		value := cd.CounterVal
+
+		if zero {
+			value = cd.Baseline
+		}
+
+		clones = append(clones, spec_counter(counter_spec(cd), value))
+	}
+
+	return dbo.create_group_with(ctx, s, name, clones)
+}
+
+// saves the session group's counters as a template
+func (dbo DynamoOperator) TemplateCreate(ctx context.Context, s Session, name string) (Response, error) {
+	_, counters, err := dbo.read_group_counters(ctx, s)
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	templateId := MakeUUID()
+
+	td := TemplateData{
+		TemplateId:   templateId.String(),
+		TemplateName: name,
+		UserId:       *s.GetUserIdString(),
+		Counters:     []CounterSpec{},
+	}
+
+	for _, cd := range counters {
+		td.Counters = append(td.Counters, counter_spec(cd))
+	}
+
+	var ops []*dynamodb.TransactWriteItem
+
+	ops, err = append_template_create(ops, &dbo.groupTable, td)
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	ops, err = append_user_update(ops, &dbo.userTable, s.GetUserId(), uquery(usr_add_tpl), templateId)
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	return dbo.commit(ctx, s, ops, templateId)
+}
+
+func (dbo DynamoOperator) TemplateRead(ctx context.Context, s Session, templateId UUID) (Response, error) {
+	td, err := dbo.read_template(ctx, s, templateId)
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	return makeresponse(td)
+}
+
+func (dbo DynamoOperator) TemplateList(ctx context.Context, s Session) (Response, error) {
+	ud, err := dbo.read_user(ctx, *s.GetUserId())
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	return makeresponse(opResult{
+		Success: true,
+		Result:  "OK",
+		Id:      ud.UserId,
+		Items:   ud.Templates,
+	})
+}
+
+func (dbo DynamoOperator) TemplateDelete(ctx context.Context, s Session, templateId UUID) (Response, error) {
+	var ops []*dynamodb.TransactWriteItem
+	var err error
+
+	ops, err = append_template_delete(ops, &dbo.groupTable, templateId, s.GetUserId())
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	ops, err = append_user_update(ops, &dbo.userTable, s.GetUserId(), uquery(usr_remove_tpl), templateId)
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	return dbo.commit(ctx, s, ops, templateId)
+}
+
+func (dbo DynamoOperator) GroupFromTemplate(ctx context.Context, s Session, templateId UUID, name string) (Response, error) {
+	td, err := dbo.read_template(ctx, s, templateId)
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	var counters []CountData
+
+	for _, spec := range td.Counters {
+		counters = append(counters, spec_counter(spec, spec.Baseline))
+	}
+
+	return dbo.create_group_with(ctx, s, name, counters)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+func TestGroupTransactions(t *testing.T) {
+	group := MakeUUID()
+
+	var counters []CountData
+
+	for i := 0; i < 3; i++ {
+		counters = append(counters, spec_counter(CounterSpec{Name: "c", Step: 2, Shards: 40}, 5))
+	}
+
+	txs, err := group_transactions(counters, &expCounterTable, &expGroupTable, &expUserTable, &expUser, group, "copy")
+
+	checkError(t, err, nil)
+
+	if len(txs) != 2 {
+		t.Fatalf("%d transactions", len(txs))
+	}
+
+	// two counters and their shards, the group with them in and the user
+	checkOpsLen(t, txs[0], 84)
+	checkOpsLen(t, txs[1], 42)
+
+	if put := txs[0][82].Put; put == nil || *put.Item[groupIdCol].S != group.String() || *put.Item[groupNameCol].S != "copy" {
+		t.Fatalf("New group is %v", txs[0][82])
+	}
+
+	if ids := txs[0][82].Put.Item[counterListCol].SS; len(ids) != 2 || *ids[0] != counters[0].CounterId {
+		t.Errorf("New group has counters %v", ids)
+	}
+
+	if upd := txs[0][83].Update; upd == nil || *upd.UpdateExpression != "ADD groups :val1" || *upd.TableName != expUserTable {
+		t.Errorf("First transaction ends with %v", txs[0][83])
+	}
+
+	if put := txs[1][0].Put; put == nil || *put.Item[counterGroupCol].S != group.String() || *put.Item[counterCol].N != "5" {
+		t.Errorf("Third counter is %v", txs[1][0])
+	}
+
+	empty, _ := group_transactions(nil, &expCounterTable, &expGroupTable, &expUserTable, &expUser, group, "empty")
+
+	if len(empty) != 1 {
+		t.Fatalf("Empty group takes %d transactions", len(empty))
+	}
+
+	checkOpsLen(t, empty[0], 2)
+}
+
+func TestDBOGroupClone(t *testing.T) {
+	s, dbo, dbi := mockEnv(expUser, expGroup, "foo@bar.com")
+
+	// the mock gives every read the same item, so it is the user, the group and its counter
+	item, _ := dynamodbattribute.MarshalMap(GroupData{
+		GroupId:  expGroup.String(),
+		Counters: []string{MakeUUID().String()},
+	})
+	item["groups"] = &dynamodb.AttributeValue{SS: []*string{aws.String(expGroup.String())}}
+	item[counterNameCol] = &dynamodb.AttributeValue{S: aws.String("coffee")}
+	item[counterCol] = &dynamodb.AttributeValue{N: aws.String("7")}
+	item[stepCol] = &dynamodb.AttributeValue{N: aws.String("3")}
+
+	dbi.gio.Item = item
+
+	for zero, exp := range map[bool]string{false: "7", true: "0"} {
+		res, err := dbo.GroupClone(context.Background(), s, "copy", zero)
+
+		checkError(t, err, nil)
+
+		if res.StatusCode != 200 {
+			t.Fatalf("Clone gave %d %s", res.StatusCode, res.Body)
+		}
+
+		ops := dbi.twi.TransactItems
+
+		checkOpsLen(t, ops, 3)
+
+		if c := ops[0].Put.Item; *c[counterNameCol].S != "coffee" || *c[counterCol].N != exp || *c[stepCol].N != "3" {
+			t.Errorf("Cloned counter is %v", c)
+		}
+
+		var r opResult
+
+		json.Unmarshal([]byte(res.Body), &r)
+
+		if *ops[1].Put.Item[groupIdCol].S != r.Id || *ops[1].Put.Item[counterListCol].SS[0] != *ops[0].Put.Item[counterIdCol].S {
+			t.Errorf("New group is %v, not %s", ops[1].Put.Item, r.Id)
+		}
+	}
+
+	// not one of the user's groups
+	item["groups"] = &dynamodb.AttributeValue{SS: []*string{aws.String(MakeUUID().String())}}
+
+	if res, _ := dbo.GroupClone(context.Background(), s, "copy", false); res.StatusCode != 404 {
+		t.Errorf("Someone else's group gave %d", res.StatusCode)
+	}
+}
+
+func TestDBOTemplate(t *testing.T) {
+	s, dbo, dbi := mockEnv(expUser, expGroup, "foo@bar.com")
+
+	item, _ := dynamodbattribute.MarshalMap(TemplateData{
+		TemplateId: MakeUUID().String(),
+		UserId:     expUser.String(),
+		Counters:   []CounterSpec{{Name: "tea", Step: 2}, {Name: "busy", Step: 1, Shards: 4}},
+	})
+
+	dbi.gio.Item = item
+
+	res, err := dbo.GroupFromTemplate(context.Background(), s, MakeUUID(), "from template")
+
+	checkError(t, err, nil)
+
+	ops := dbi.twi.TransactItems
+
+	if res.StatusCode != 200 || len(ops) != 8 {
+		t.Fatalf("Group from template gave %d %s in %d ops", res.StatusCode, res.Body, len(ops))
+	}
+
+	if c := ops[1].Put.Item; *c[counterNameCol].S != "busy" || *c[counterCol].N != "0" || *c[shardsCol].N != "4" {
+		t.Errorf("Second counter is %v", c)
+	}
+
+	// someone else's template
+	item[principalIdCol] = &dynamodb.AttributeValue{S: aws.String(MakeUUID().String())}
+
+	if res, _ := dbo.TemplateRead(context.Background(), s, MakeUUID()); res.StatusCode != 404 {
+		t.Errorf("Someone else's template gave %d %s", res.StatusCode, res.Body)
+	}
+
+	ops, err = append_template_delete(nil, &expGroupTable, MakeUUID(), &expUser)
+
+	checkError(t, err, nil)
+
+	if del := ops[0].Delete; *del.ConditionExpression != "userUUID = :userId" || *del.ExpressionAttributeValues[":userId"].S != expUser.String() {
+		t.Errorf("Delete is %v", del)
+	}
+}
+
+func TestDBOGroupFromTemplateResume(t *testing.T) {
+	_, dbo, dbi := mockEnv(expUser, expGroup, "foo@bar.com")
+
+	s := &APISession{userId: expUser, groupId: expGroup, idempotencyKey: &IdempotencyKey{Key: "k1", Request: "POST /template"}}
+	templateId := MakeUUID()
+
+	// two counters fit in a transaction
+	item, _ := dynamodbattribute.MarshalMap(TemplateData{
+		TemplateId: templateId.String(),
+		UserId:     expUser.String(),
+		Counters:   []CounterSpec{{Name: "a", Step: 1, Shards: 40}, {Name: "b", Step: 1, Shards: 40}, {Name: "c", Step: 1, Shards: 40}, {Name: "all", Expression: "a + b + c"}},
+	})
+
+	dbi.items = map[string]map[string]*dynamodb.AttributeValue{templateId.String(): item}
+
+	res, _ := dbo.GroupFromTemplate(context.Background(), s, templateId, "from template")
+
+	if res.StatusCode != 200 || len(dbi.twis) != 2 {
+		t.Fatalf("Group from template gave %d %s in %d transactions", res.StatusCode, res.Body, len(dbi.twis))
+	}
+
+	first := dbi.twis
+
+	// as if it stopped after the first transaction
+	counter := first[0].TransactItems[0].Put.Item
+	dbi.items[*counter[counterIdCol].S] = counter
+	dbi.twis = nil
+
+	again, _ := dbo.GroupFromTemplate(context.Background(), s, templateId, "from template")
+
+	if again.Body != res.Body || len(dbi.twis) != 1 {
+		t.Fatalf("Retry gave %s in %d transactions", again.Body, len(dbi.twis))
+	}
+
+	if !reflect.DeepEqual(dbi.twis[0].TransactItems[:len(first[1].TransactItems)], first[1].TransactItems) {
+		t.Error("Retry wrote different counters")
+	}
+
+	ops := dbi.twis[0].TransactItems
+
+	if put := ops[len(ops)-1].Put; put == nil || *put.Item[objectTypeCol].S != idempotency_type("k1") {
+		t.Errorf("Last transaction ends with %v", ops[len(ops)-1])
+	}
+
+	// the derived counter names the others by their new ids
+	for _, op := range ops {
+		if op.Put != nil && op.Put.Item[expressionCol] != nil && *op.Put.Item[referencesCol].M["a"].S != *counter[counterIdCol].S {
+			t.Errorf("Derived counter names %v", op.Put.Item[referencesCol].M)
+		}
+	}
+}
@@ -11,10 +11,20 @@ import (
 type UserData struct {
 	UserId     string   `dynamodbav:"objectUUID"`
 	Groups     []string `dynamodbav:"groups,stringset,omitempty"`
+	APIKeys    []string `dynamodbav:"apikeys,stringset,omitempty"`
+	Templates  []string `dynamodbav:"templates,stringset,omitempty"`
 	UserName   string   `dynamodbav:"userEmail"`
 	ObjectType string   `dynamodbav:"objectType"`
 }
 
+// e-mail reservation record.  Keyed on the e-mail address so that only one
+// user can ever hold it, and written in the same transaction as the user record.
+type UserEmailData struct {
+	Email      string `dynamodbav:"objectUUID"`
+	UserId     string `dynamodbav:"userUUID"`
+	ObjectType string `dynamodbav:"objectType"`
+}
+
 func append_user_create(ops []*dynamodb.TransactWriteItem, table *string, userId UUID, userName *string) ([]*dynamodb.TransactWriteItem, error) {
 	record, rerr := dynamodbattribute.MarshalMap(UserData{
 		UserId:     userId.String(),
@@ -38,6 +48,67 @@ func append_user_create(ops []*dynamodb.TransactWriteItem, table *string, userId
 	return ops, nil
 }
 
+func append_user_reserve(ops []*dynamodb.TransactWriteItem, table *string, userId UUID, userName *string) ([]*dynamodb.TransactWriteItem, error) {
+	record, rerr := dynamodbattribute.MarshalMap(UserEmailData{
+		Email:      *userName,
+		UserId:     userId.String(),
+		ObjectType: "UserEmail",
+	})
+
+	if rerr != nil {
+		return ops, rerr
+	}
+
+	input := dynamodb.Put{
+		TableName:           table,
+		Item:                record,
+		ConditionExpression: aws.String(fmt.Sprintf("attribute_not_exists(%s)", userIdCol)),
+	}
+
+	ops = append(ops, &dynamodb.TransactWriteItem{
+		Put: &input,
+	})
+
+	return ops, nil
+}
+
+func append_user_release(ops []*dynamodb.TransactWriteItem, table *string, userId UUID, userName *string) ([]*dynamodb.TransactWriteItem, error) {
+	dr := dynamodb.Delete{
+		Key: map[string]*dynamodb.AttributeValue{
+			userIdCol:     {S: userName},
+			objectTypeCol: {S: aws.String("UserEmail")},
+		},
+		TableName: table,
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":" + userIdVal: {S: aws.String(userId.String())},
+		},
+		ConditionExpression: aws.String(fmt.Sprintf("%s = :%s", reservedUserCol, userIdVal)),
+	}
+
+	ops = append(ops, &dynamodb.TransactWriteItem{
+		Delete: &dr,
+	})
+
+	return ops, nil
+}
+
+func append_user_delete(ops []*dynamodb.TransactWriteItem, table *string, userId UUID) ([]*dynamodb.TransactWriteItem, error) {
+	dr := dynamodb.Delete{
+		Key: map[string]*dynamodb.AttributeValue{
+			userIdCol:     {S: aws.String(userId.String())},
+			objectTypeCol: {S: aws.String("User")},
+		},
+		TableName:           table,
+		ConditionExpression: aws.String(fmt.Sprintf("attribute_not_exists(%s)", groupListCol)),
+	}
+
+	ops = append(ops, &dynamodb.TransactWriteItem{
+		Delete: &dr,
+	})
+
+	return ops, nil
+}
+
 func append_user_update(ops []*dynamodb.TransactWriteItem, table *string, user *UUID, query string, val1 UUID) ([]*dynamodb.TransactWriteItem, error) {
 	udr := dynamodb.Update{
 		Key: map[string]*dynamodb.AttributeValue{
@@ -52,7 +123,6 @@ func append_user_update(ops []*dynamodb.TransactWriteItem, table *string, user *
 		ConditionExpression: aws.String(fmt.Sprintf("attribute_exists(%s) and attribute_not_exists(%s)", userIdCol, deleteMarkerCol)),
 	}
 
-	//log.Print("Update Query: ", query)
 	ops = append(ops, &dynamodb.TransactWriteItem{
 		Update: &udr,
 	})
@@ -63,6 +133,10 @@ func append_user_update(ops []*dynamodb.TransactWriteItem, table *string, user *
 const (
 	usr_add_grp    = iota
 	usr_remove_grp = iota
+	usr_add_key    = iota
+	usr_remove_key = iota
+	usr_add_tpl    = iota
+	usr_remove_tpl = iota
 )
 
 func uquery(mode int) string {
@@ -71,6 +145,14 @@ func uquery(mode int) string {
 		return "ADD groups :val1"
 	case usr_remove_grp:
 		return "DELETE groups :val1"
+	case usr_add_key:
+		return "ADD apikeys :val1"
+	case usr_remove_key:
+		return "DELETE apikeys :val1"
+	case usr_add_tpl:
+		return "ADD templates :val1"
+	case usr_remove_tpl:
+		return "DELETE templates :val1"
 	}
 	return ""
 }
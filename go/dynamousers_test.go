@@ -37,3 +37,29 @@ func TestUserUpdate(t *testing.T) {
 
 	checkUserUpdate(t, ops[0], nuuid, "hello world", expUserTable, expUser)
 }
+
+func TestUserReserve(t *testing.T) {
+	var ops []*dynamodb.TransactWriteItem
+	var err error
+
+	ops, err = append_user_reserve(ops, &expUserTable, expUser, &expUserName)
+
+	checkError(t, err, nil)
+
+	checkOpsLen(t, ops, 1)
+
+	checkUserReserve(t, ops[0], expUserTable, expUser, expUserName)
+}
+
+func TestUserRelease(t *testing.T) {
+	var ops []*dynamodb.TransactWriteItem
+	var err error
+
+	ops, err = append_user_release(ops, &expUserTable, expUser, &expUserName)
+
+	checkError(t, err, nil)
+
+	checkOpsLen(t, ops, 1)
+
+	checkUserRelease(t, ops[0], expUserTable, expUser, expUserName)
+}
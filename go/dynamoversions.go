@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Counters and groups carry a version which goes up by one on every change.
+// Reads hand it out as an ETag and changes can be made conditional on it
+// with If-Match.  Items written before versions existed have none, and get
+// one on their next change.
+
+// adds the version increment to an update expression.  SET can only appear
+// once, so it joins an existing SET clause.
+func version_bump(query string, values map[string]*dynamodb.AttributeValue) string {
+	values[":"+versionZero] = &dynamodb.AttributeValue{N: aws.String("0")}
+	values[":"+versionOne] = &dynamodb.AttributeValue{N: aws.String("1")}
+
+	bump := fmt.Sprintf("%s = if_not_exists(%s, :%s) + :%s", versionCol, versionCol, versionZero, versionOne)
+
+	if strings.HasPrefix(query, "SET ") {
+		return query + ", " + bump
+	}
+
+	return query + " SET " + bump
+}
+
+// adds the If-Match check to a condition.  The old item comes back when the
+// condition fails so that a version mismatch can be told apart from the rest.
+func version_condition(condition string, values map[string]*dynamodb.AttributeValue, ifMatch *int) (string, *string) {
+	if ifMatch == nil {
+		return condition, nil
+	}
+
+	values[":"+ifMatchVal] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(*ifMatch))}
+
+	return fmt.Sprintf("%s and %s = :%s", condition, versionCol, ifMatchVal), aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld)
+}
+
+func item_version(item map[string]*dynamodb.AttributeValue) int {
+	if v, found := item[versionCol]; found && v.N != nil {
+		version, _ := strconv.Atoi(*v.N)
+		return version
+	}
+	return 0
+}
+
+// the version an op was conditional on, if any
+func op_if_match(op *dynamodb.TransactWriteItem) (int, bool) {
+	var values map[string]*dynamodb.AttributeValue
+
+	switch {
+	case op.Update != nil:
+		values = op.Update.ExpressionAttributeValues
+	case op.Delete != nil:
+		values = op.Delete.ExpressionAttributeValues
+	case op.ConditionCheck != nil:
+		values = op.ConditionCheck.ExpressionAttributeValues
+	}
+
+	if v, found := values[":"+ifMatchVal]; found && v.N != nil {
+		version, err := strconv.Atoi(*v.N)
+		return version, err == nil
+	}
+
+	return 0, false
+}
+
+// turns a cancelled transaction into a 412 if it failed because an item
+// wasn't at the version the client asked for, or a 409 if a counter would
+// have gone past its bounds.  A missing item or one in the wrong group is
+// still the usual error.
+func version_error(err error, ops []*dynamodb.TransactWriteItem) error {
+	tce, ok := err.(*dynamodb.TransactionCanceledException)
+
+	if !ok {
+		return err
+	}
+
+	for i, reason := range tce.CancellationReasons {
+		if i >= len(ops) || reason.Code == nil || *reason.Code != "ConditionalCheckFailed" || reason.Item == nil {
+			continue
+		}
+
+		if expected, conditional := op_if_match(ops[i]); conditional {
+			if actual := item_version(reason.Item); actual != expected {
+				return precondition_failed("version is %d not %d", actual, expected)
+			}
+		}
+
+		if berr := bounds_error(ops[i], reason.Item); berr != nil {
+			return berr
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+func TestParseETag(t *testing.T) {
+	if v, err := parse_etag(`"3"`); err != nil || v == nil || *v != 3 {
+		t.Errorf("ETag \"3\" parsed as %v %s", v, err)
+	}
+
+	for _, h := range []string{"", "*"} {
+		if v, err := parse_etag(h); err != nil || v != nil {
+			t.Errorf("If-Match %q parsed as %v %s", h, v, err)
+		}
+	}
+
+	for _, h := range []string{"3", `"0"`, `"abc"`, `"1", "2"`, `W/"7"`} {
+		if _, err := parse_etag(h); err == nil {
+			t.Errorf("If-Match %q accepted", h)
+		} else if res, _ := makeerror(err); res.StatusCode != 412 {
+			t.Errorf("If-Match %q gave status %d", h, res.StatusCode)
+		}
+	}
+
+	if make_etag(12) != `"12"` {
+		t.Errorf("ETag is %s", make_etag(12))
+	}
+}
+
+func TestVersionCondition(t *testing.T) {
+	var ops []*dynamodb.TransactWriteItem
+
+	ops, _ = append_counter_update(ops, &expCounterTable, &expGroup, expCounterUUID, dnquery(dq_current, dq_init), 1, aws.Int(4))
+
+	ud := ops[0].Update
+
+	if *ud.ConditionExpression != "attribute_exists(objectUUID) and counterGroupUUID = :groupId and version = :ifmatch" {
+		t.Errorf("Condition is %s", *ud.ConditionExpression)
+	}
+
+	if *ud.ExpressionAttributeValues[":"+ifMatchVal].N != "4" || *ud.ReturnValuesOnConditionCheckFailure != "ALL_OLD" {
+		t.Errorf("If-Match not set up: %v", ud)
+	}
+
+	if version, found := op_if_match(ops[0]); !found || version != 4 {
+		t.Errorf("Op is conditional on %d %t", version, found)
+	}
+
+	ops, _ = append_counter_delete(ops, &expCounterTable, &expGroup, expCounterUUID, aws.Int(2))
+
+	if version, found := op_if_match(ops[1]); !found || version != 2 {
+		t.Errorf("Delete is conditional on %d %t", version, found)
+	}
+}
+
+func cancelled(item map[string]*dynamodb.AttributeValue) error {
+	return &dynamodb.TransactionCanceledException{
+		CancellationReasons: []*dynamodb.CancellationReason{
+			{Code: aws.String("ConditionalCheckFailed"), Item: item},
+		},
+	}
+}
+
+func TestVersionError(t *testing.T) {
+	var ops []*dynamodb.TransactWriteItem
+
+	ops, _ = append_counter_update(ops, &expCounterTable, &expGroup, expCounterUUID, dnquery(dq_current, dq_init), 1, aws.Int(4))
+
+	stale, _ := dynamodbattribute.MarshalMap(CountData{CounterGroup: expGroup.String(), Version: 5})
+	current, _ := dynamodbattribute.MarshalMap(CountData{CounterGroup: MakeUUID().String(), Version: 4})
+
+	if res, _ := makeerror(version_error(cancelled(stale), ops)); res.StatusCode != 412 {
+		t.Errorf("Stale version gave %d %s", res.StatusCode, res.Body)
+	}
+
+	// right version, wrong group
+	if res, _ := makeerror(version_error(cancelled(current), ops)); res.StatusCode != 404 {
+		t.Errorf("Wrong group gave %d %s", res.StatusCode, res.Body)
+	}
+
+	// no such counter
+	if res, _ := makeerror(version_error(cancelled(nil), ops)); res.StatusCode != 404 {
+		t.Errorf("Missing counter gave %d %s", res.StatusCode, res.Body)
+	}
+}
+
+func TestCounterIfMatch(t *testing.T) {
+	s, dbo, dbi := mockEnv(expUser, expGroup, "foo@bar.com")
+
+	as := s.(*APISession)
+	as.ifMatch = aws.Int(1)
+
+	stale, _ := dynamodbattribute.MarshalMap(CountData{CounterGroup: expGroup.String(), Version: 2})
+	dbi.twErrs = []error{cancelled(stale)}
+
+	res, _ := dbo.CounterUpdate(context.Background(), s, expCounterUUID, dnquery(dq_current, dq_init), 1)
+
+	if res.StatusCode != 412 {
+		t.Errorf("Reset of a changed counter gave %d %s", res.StatusCode, res.Body)
+	}
+
+	if *dbi.twi.TransactItems[0].Update.ExpressionAttributeValues[":"+ifMatchVal].N != "1" {
+		t.Error("If-Match not passed to the update")
+	}
+}
+
+func TestCounterReadETag(t *testing.T) {
+	s, dbo, dbi := mockEnv(expUser, expGroup, "foo@bar.com")
+
+	dbi.gio.Item, _ = dynamodbattribute.MarshalMap(CountData{CounterId: expCounterUUID.String(), CounterGroup: expGroup.String(), Version: 9})
+
+	res, _ := dbo.CounterRead(context.Background(), s, expCounterUUID)
+
+	if res.StatusCode != 200 || res.Headers["ETag"] != `"9"` {
+		t.Errorf("Read gave %d with ETag %s", res.StatusCode, res.Headers["ETag"])
+	}
+
+	// counters from before versions have no ETag
+	dbi.gio.Item, _ = dynamodbattribute.MarshalMap(CountData{CounterId: expCounterUUID.String(), CounterGroup: expGroup.String()})
+
+	if res, _ := dbo.CounterRead(context.Background(), s, expCounterUUID); res.Headers["ETag"] != "" {
+		t.Errorf("Unversioned counter has ETag %s", res.Headers["ETag"])
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// webhooks live under their group so that one query finds every hook which
+// might fire for a counter.  The sort key is "Webhook#<hook uuid>".
+type WebhookData struct {
+	GroupId    string   `dynamodbav:"objectUUID" json:"groupUUID"`
+	ObjectType string   `dynamodbav:"objectType" json:"-"`
+	HookId     string   `dynamodbav:"hookUUID" json:"objectUUID"`
+	CounterId  string   `dynamodbav:"hookCounterUUID,omitempty" json:"counterUUID,omitempty"`
+	URL        string   `dynamodbav:"url" json:"url"`
+	Events     []string `dynamodbav:"events,stringset" json:"events"`
+	Threshold  *int     `dynamodbav:"threshold,omitempty" json:"threshold,omitempty"`
+	Secret     string   `dynamodbav:"secret" json:"-"`
+	UserId     string   `dynamodbav:"userUUID" json:"userUUID"`
+}
+
+// one record per delivery, kept under the hook with a TTL.
+// The sort key is "Delivery#<time>#<uuid>" so they list in time order.
+type DeliveryData struct {
+	HookId     string `dynamodbav:"objectUUID" json:"hookUUID"`
+	ObjectType string `dynamodbav:"objectType" json:"-"`
+	Event      string `dynamodbav:"event" json:"event"`
+	CounterId  string `dynamodbav:"counterUUID" json:"counterUUID"`
+	Attempts   int    `dynamodbav:"attempts" json:"attempts"`
+	Status     int    `dynamodbav:"status" json:"status"`
+	Error      string `dynamodbav:"error,omitempty" json:"error,omitempty"`
+	Time       string `dynamodbav:"time" json:"time"`
+	ExpiresAt  int64  `dynamodbav:"expiresAt" json:"-"`
+}
+
+const webhookTypePrefix = "Webhook#"
+const deliveryTypePrefix = "Delivery#"
+
+// how long delivery records are kept
+const deliveryLogTTL = 7 * 24 * time.Hour
+
+func webhook_type(hookId UUID) string {
+	return webhookTypePrefix + hookId.String()
+}
+
+func delivery_type(t time.Time, deliveryId UUID) string {
+	return fmt.Sprintf("%s%s#%s", deliveryTypePrefix, t.UTC().Format(time.RFC3339Nano), deliveryId.String())
+}
+
+func append_webhook_create(ops []*dynamodb.TransactWriteItem, table *string, wd WebhookData) ([]*dynamodb.TransactWriteItem, error) {
+	hookId, herr := ToUUID(wd.HookId)
+
+	if herr != nil {
+		return ops, herr
+	}
+
+	wd.ObjectType = webhook_type(hookId)
+
+	record, rerr := dynamodbattribute.MarshalMap(wd)
+
+	if rerr != nil {
+		return ops, rerr
+	}
+
+	input := dynamodb.Put{
+		TableName:           table,
+		Item:                record,
+		ConditionExpression: aws.String(fmt.Sprintf("attribute_not_exists(%s)", objectTypeCol)),
+	}
+
+	ops = append(ops, &dynamodb.TransactWriteItem{
+		Put: &input,
+	})
+
+	return ops, nil
+}
+
+func append_webhook_delete(ops []*dynamodb.TransactWriteItem, table *string, groupId *UUID, hookId UUID) ([]*dynamodb.TransactWriteItem, error) {
+	dr := dynamodb.Delete{
+		Key: map[string]*dynamodb.AttributeValue{
+			hookGroupCol:  {S: aws.String(groupId.String())},
+			objectTypeCol: {S: aws.String(webhook_type(hookId))},
+		},
+		TableName:           table,
+		ConditionExpression: aws.String(fmt.Sprintf("attribute_exists(%s)", objectTypeCol)),
+	}
+
+	ops = append(ops, &dynamodb.TransactWriteItem{
+		Delete: &dr,
+	})
+
+	return ops, nil
+}
+
+func append_delivery_log(ops []*dynamodb.TransactWriteItem, table *string, dd DeliveryData, t time.Time) ([]*dynamodb.TransactWriteItem, error) {
+	dd.ObjectType = delivery_type(t, MakeUUID())
+	dd.Time = t.UTC().Format(time.RFC3339Nano)
+	dd.ExpiresAt = t.Add(deliveryLogTTL).Unix()
+
+	record, rerr := dynamodbattribute.MarshalMap(dd)
+
+	if rerr != nil {
+		return ops, rerr
+	}
+
+	input := dynamodb.Put{
+		TableName: table,
+		Item:      record,
+	}
+
+	ops = append(ops, &dynamodb.TransactWriteItem{
+		Put: &input,
+	})
+
+	return ops, nil
+}
+
+// query for every item of one kind under a key, e.g. all of a group's webhooks
+func prefix_query(table *string, key string, prefix string) *dynamodb.QueryInput {
+	return &dynamodb.QueryInput{
+		TableName: table,
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":key":    {S: aws.String(key)},
+			":prefix": {S: aws.String(prefix)},
+		},
+		KeyConditionExpression: aws.String(fmt.Sprintf("%s = :key and begins_with(%s, :prefix)", hookGroupCol, objectTypeCol)),
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+var expWebhookTable = "webhookTable"
+
+func TestWebhookCreate(t *testing.T) {
+	var ops []*dynamodb.TransactWriteItem
+	var err error
+
+	hookId := MakeUUID()
+	threshold := 100
+
+	ops, err = append_webhook_create(ops, &expWebhookTable, WebhookData{
+		GroupId:   expGroup.String(),
+		HookId:    hookId.String(),
+		URL:       "https://example.com/hook",
+		Events:    []string{ev_threshold},
+		Threshold: &threshold,
+		Secret:    "sssh",
+	})
+
+	checkError(t, err, nil)
+
+	checkOpsLen(t, ops, 1)
+
+	put := ops[0].Put
+
+	if put == nil {
+		t.Fatal("Expected put request was not present")
+	}
+
+	if *put.TableName != expWebhookTable {
+		t.Errorf("Table name is %s not %s", *put.TableName, expWebhookTable)
+	}
+
+	if *put.Item[hookGroupCol].S != expGroup.String() || *put.Item[objectTypeCol].S != "Webhook#"+hookId.String() {
+		t.Errorf("Key is %s/%s", *put.Item[hookGroupCol].S, *put.Item[objectTypeCol].S)
+	}
+
+	if *put.Item["threshold"].N != "100" {
+		t.Error("Threshold not in webhook record")
+	}
+
+	if _, present := put.Item["hookCounterUUID"]; present {
+		t.Error("Empty counter in webhook record")
+	}
+
+	if _, err = append_webhook_create(nil, &expWebhookTable, WebhookData{HookId: "nope"}); err == nil {
+		t.Error("Webhook with a bad id created")
+	}
+}
+
+func TestWebhookDelete(t *testing.T) {
+	var ops []*dynamodb.TransactWriteItem
+	var err error
+
+	hookId := MakeUUID()
+
+	ops, err = append_webhook_delete(ops, &expWebhookTable, &expGroup, hookId)
+
+	checkError(t, err, nil)
+
+	checkOpsLen(t, ops, 1)
+
+	dd := ops[0].Delete
+
+	if dd == nil {
+		t.Fatal("Expected Delete request was not present")
+	}
+
+	if *dd.Key[hookGroupCol].S != expGroup.String() || *dd.Key[objectTypeCol].S != "Webhook#"+hookId.String() {
+		t.Errorf("Key is %s/%s", *dd.Key[hookGroupCol].S, *dd.Key[objectTypeCol].S)
+	}
+}
+
+func TestDeliveryLog(t *testing.T) {
+	var ops []*dynamodb.TransactWriteItem
+	var err error
+
+	hookId := MakeUUID()
+	now := time.Now()
+
+	ops, err = append_delivery_log(ops, &expWebhookTable, DeliveryData{HookId: hookId.String(), Event: ev_reset, Attempts: 2, Status: 200}, now)
+
+	checkError(t, err, nil)
+
+	checkOpsLen(t, ops, 1)
+
+	put := ops[0].Put
+
+	if *put.Item[hookGroupCol].S != hookId.String() || !strings.HasPrefix(*put.Item[objectTypeCol].S, "Delivery#") {
+		t.Errorf("Key is %s/%s", *put.Item[hookGroupCol].S, *put.Item[objectTypeCol].S)
+	}
+
+	if _, present := put.Item[expiresAtCol]; !present {
+		t.Error("Delivery record has no TTL")
+	}
+}
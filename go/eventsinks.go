@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// LogSink writes every event to the log.
+type LogSink struct{}
+
+func (ls LogSink) Publish(ctx context.Context, ev DomainEvent) error {
+	logger(ctx).Info("domain event", "kind", ev.Kind, "event", ev)
+
+	return nil
+}
+
+// WebhookSink fires the group's webhooks for counter events.
+// Other kinds of event have no webhooks.
+type WebhookSink struct {
+	dbo DynamoOperator
+}
+
+func (ws WebhookSink) Publish(ctx context.Context, ev DomainEvent) error {
+	if ev.Counter == nil {
+		return nil
+	}
+
+	groupId, gerr := ToUUID(ev.Counter.GroupId)
+
+	if gerr != nil {
+		return gerr
+	}
+
+	hooks, err := ws.dbo.read_webhooks(ctx, &groupId)
+
+	if err != nil {
+		return err
+	}
+
+	ws.dbo.fire_webhooks(ctx, hooks, *ev.Counter)
+
+	return nil
+}
+
+// QueueSink sends every event to an SQS queue for other services to consume.
+type QueueSink struct {
+	sqs      QueueInterface
+	queueURL string
+}
+
+func (qs QueueSink) Publish(ctx context.Context, ev DomainEvent) error {
+	body, err := json.Marshal(ev)
+
+	if err != nil {
+		return err
+	}
+
+	_, serr := qs.sqs.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:    aws.String(qs.queueURL),
+		MessageBody: aws.String(string(body)),
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"kind": {DataType: aws.String("String"), StringValue: aws.String(ev.Kind)},
+		},
+	})
+
+	return serr
+}
+
+// SocketSink pushes counter events to the API gateway websocket connections
+// subscribed to them.  Pushes are best effort: a retry would push to every
+// subscriber again, so failures are logged rather than returned.
+type SocketSink struct {
+	dbo     DynamoOperator
+	sockets SocketInterface
+}
+
+func (ss SocketSink) Publish(ctx context.Context, ev DomainEvent) error {
+	if ev.Counter == nil || !slices.Contains(socket_events, ev.Counter.Event) {
+		return nil
+	}
+
+	subs, err := ss.dbo.read_subscriptions(ctx, ev.Counter.GroupId)
+
+	if err != nil {
+		return err
+	}
+
+	body, merr := socket_push(*ev.Counter)
+
+	if merr != nil {
+		return merr
+	}
+
+	for _, sd := range subs {
+		if !(Subscription{GroupId: sd.GroupId, Counters: sd.Counters}).wants(*ev.Counter) {
+			continue
+		}
+
+		_, perr := ss.sockets.PostToConnection(&apigatewaymanagementapi.PostToConnectionInput{
+			ConnectionId: aws.String(sd.ConnectionId),
+			Data:         body,
+		})
+
+		// the client went away without a $disconnect, so tidy up after it
+		if aerr, ok := perr.(awserr.Error); ok && aerr.Code() == apigatewaymanagementapi.ErrCodeGoneException {
+			perr = ss.dbo.ConnectionDelete(ctx, sd.ConnectionId)
+		}
+
+		if perr != nil {
+			logger(ctx).Warn("socket push failed", "connectionId", sd.ConnectionId, "error", perr)
+		}
+	}
+
+	return nil
+}
+
+// SinkList publishes to each of its sinks in turn.
+type SinkList []EventSink
+
+func (sl SinkList) Publish(ctx context.Context, ev DomainEvent) error {
+	var errs []error
+
+	for _, sink := range sl {
+		errs = append(errs, sink.Publish(ctx, ev))
+	}
+
+	return errors.Join(errs...)
+}
+
+// AsyncSink publishes in the background, for slow sinks outside a stream
+// handler.  Failures are logged.
+type AsyncSink struct {
+	sink EventSink
+}
+
+func (as AsyncSink) Publish(ctx context.Context, ev DomainEvent) error {
+	ctx = context.WithoutCancel(ctx)
+
+	go func() {
+		if err := as.sink.Publish(ctx, ev); err != nil {
+			logger(ctx).Warn("event sink failed", "kind", ev.Kind, "error", err)
+		}
+	}()
+
+	return nil
+}
+
+// builds the sinks named in a comma separated list, e.g. "log,webhook"
+func Create_EventSinks(names string, dbo DynamoOperator, queueURL string, socketEndpoint string) ([]EventSink, error) {
+	var sinks []EventSink
+
+	for _, name := range split_list(names) {
+		switch name {
+		case "log":
+			sinks = append(sinks, LogSink{})
+		case "webhook":
+			if dbo.hooks == nil {
+				dbo.hooks = Create_WebhookDispatcher()
+			}
+			sinks = append(sinks, WebhookSink{dbo: dbo})
+		case "queue":
+			if queueURL == "" {
+				return nil, fmt.Errorf("queue event sink needs a queue url")
+			}
+			sinks = append(sinks, QueueSink{sqs: Create_SQSInterface(), queueURL: queueURL})
+		case "websocket":
+			if socketEndpoint == "" {
+				return nil, fmt.Errorf("websocket event sink needs an endpoint")
+			}
+			sinks = append(sinks, SocketSink{dbo: dbo, sockets: create_socket_interface(socketEndpoint)})
+		default:
+			return nil, fmt.Errorf("unknown event sink %s", name)
+		}
+	}
+
+	return sinks, nil
+}
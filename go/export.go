@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// A group's counters go out as one document for moving them between
+// environments, or as CSV for looking at, which has the same counters but
+// not the group or permissions.  Counters keep no history, so there is none
+// to go with them; the permissions are the user's API keys in the group,
+// without their secrets, and only go to the user or an admin key.  Export
+// is a group route, so a key limited to some counters can't use it.  An
+// import makes the counters again in another group, and is a dry run unless
+// it is told to commit.
+
+type ExportData struct {
+	Group       GroupData    `json:"group"`
+	Counters    []CountData  `json:"counters"`
+	Permissions []APIKeyData `json:"permissions,omitempty"`
+}
+
+// a counter made by an import, from the one in the export
+type ImportedCounter struct {
+	From string `json:"from"`
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// a counter which can't be imported, and why
+type ImportConflict struct {
+	Counter string `json:"counter"`
+	Name    string `json:"name"`
+	Reason  string `json:"reason"`
+}
+
+type ImportResult struct {
+	DryRun    bool              `json:"dryRun"`
+	Counters  []ImportedCounter `json:"counters"`
+	Conflicts []ImportConflict  `json:"conflicts"`
+
+	// how many transactions the counters were written in
+	Transactions int `json:"transactions"`
+}
+
+// everything about a counter goes in the CSV, so that importing it loses
+// nothing.  Labels are key=value,key=value and bounds which aren't set are empty.
+var exportColumns = []string{counterIdCol, counterNameCol, counterCol, stepCol, shardsCol, versionCol,
+	baselineCol, minCol, maxCol, labelsCol, expressionCol, descriptionCol, unitCol, colorCol, iconCol, numberFormatCol}
+
+func exportGroup(ctx context.Context, req Request, dbo DataOperator, s Session, p exportGroupParams) (Response, error) {
+	ed, err := dbo.GroupExport(ctx, s)
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	if p.Format != "csv" {
+		return makeresponse(ed)
+	}
+
+	var buf bytes.Buffer
+
+	if werr := write_export_csv(&buf, ed); werr != nil {
+		return makeerror(werr)
+	}
+
+	return Response{
+		StatusCode: 200,
+		Body:       buf.String(),
+		Headers: map[string]string{
+			"Content-Type":        "text/csv",
+			"Content-Disposition": fmt.Sprintf("attachment; filename=%q", ed.Group.GroupId+".csv"),
+		},
+	}, nil
+}
+
+func importGroup(ctx context.Context, req Request, dbo DataOperator, s Session, p importGroupParams) (Response, error) {
+	body, berr := request_body(req)
+
+	if berr != nil {
+		return makeerror(berr)
+	}
+
+	var ed ExportData
+
+	if p.Format == "csv" {
+		ed, berr = read_export_csv(bytes.NewReader(body))
+	} else if jerr := json.Unmarshal(body, &ed); jerr != nil {
+		berr = bad_request("request body isn't an export: %s", jerr)
+	}
+
+	if berr != nil {
+		return makeerror(berr)
+	}
+
+	return dbo.GroupImport(ctx, s, ed, p.Ids == "preserve", p.Mode != "commit")
+}
+
+// one row a counter, under a header of the columns
+func write_export_csv(w io.Writer, ed ExportData) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write(exportColumns); err != nil {
+		return err
+	}
+
+	bound := func(v *int) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.Itoa(*v)
+	}
+
+	for _, cd := range ed.Counters {
+		var labels []string
+
+		for key, value := range cd.Labels {
+			labels = append(labels, key+"="+value)
+		}
+
+		slices.Sort(labels)
+
+		cw.Write([]string{
+			cd.CounterId,
+			cd.CounterName,
+			strconv.Itoa(cd.CounterVal),
+			strconv.Itoa(cd.StepVal),
+			strconv.Itoa(cd.Shards),
+			strconv.Itoa(cd.Version),
+			strconv.Itoa(cd.Baseline),
+			bound(cd.MinVal),
+			bound(cd.MaxVal),
+			strings.Join(labels, ","),
+			cd.Expression,
+			cd.Description,
+			cd.Unit,
+			cd.Color,
+			cd.Icon,
+			cd.NumberFormat,
+		})
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// the counters of a CSV export.  The columns can be in any order, and only
+// the name has to be there.
+func read_export_csv(r io.Reader) (ExportData, error) {
+	var ed ExportData
+
+	rows, err := csv.NewReader(r).ReadAll()
+
+	if err != nil {
+		return ed, bad_request("request body isn't CSV: %s", err)
+	}
+
+	if len(rows) == 0 {
+		return ed, bad_request("CSV has no header")
+	}
+
+	cols := map[string]int{}
+
+	for i, name := range rows[0] {
+		cols[strings.TrimSpace(name)] = i
+	}
+
+	if _, found := cols[counterNameCol]; !found {
+		return ed, bad_request("CSV has no %s column", counterNameCol)
+	}
+
+	for n, row := range rows[1:] {
+		field := func(col string) string {
+			if i, found := cols[col]; found && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+
+		number := func(col string, missing int) int {
+			if field(col) == "" {
+				return missing
+			}
+
+			v, verr := strconv.Atoi(field(col))
+
+			if verr != nil && err == nil {
+				err = bad_request("CSV row %d: %s must be an integer, not %s", n+2, col, field(col))
+			}
+
+			return v
+		}
+
+		bound := func(col string) *int {
+			if field(col) == "" {
+				return nil
+			}
+
+			v := number(col, 0)
+			return &v
+		}
+
+		var labels map[string]string
+
+		if field(labelsCol) != "" {
+			var lerr error
+
+			if labels, lerr = parse_labels(split_list(field(labelsCol))); lerr != nil && err == nil {
+				err = bad_request("CSV row %d: %s", n+2, lerr)
+			}
+		}
+
+		ed.Counters = append(ed.Counters, CountData{
+			CounterId:   field(counterIdCol),
+			CounterName: field(counterNameCol),
+			CounterVal:  number(counterCol, 0),
+			StepVal:     number(stepCol, 1),
+			Shards:      number(shardsCol, 0),
+			Version:     number(versionCol, 1),
+			Baseline:    number(baselineCol, 0),
+			MinVal:      bound(minCol),
+			MaxVal:      bound(maxCol),
+			Labels:      labels,
+			Expression:  field(expressionCol),
+			CounterMeta: CounterMeta{
+				Description:  field(descriptionCol),
+				Unit:         field(unitCol),
+				Color:        field(colorCol),
+				Icon:         field(iconCol),
+				NumberFormat: field(numberFormatCol),
+			},
+		})
+	}
+
+	return ed, err
+}
+
+// the counters an import makes in groupId, and what it can't.  taken is the
+// names already in the group; exists says whether a counter id is in use,
+// which only matters when the ids are kept.  Otherwise the n'th counter gets
+// the id newId(n).
+func plan_import(counters []CountData, groupId UUID, taken map[string]bool, exists func(UUID) bool, preserve bool, newId func(n int) UUID) ([]CountData, ImportResult) {
+	var planned []CountData
+
+	result := ImportResult{Counters: []ImportedCounter{}, Conflicts: []ImportConflict{}}
+	names := map[string]bool{}
+	ids := map[string]bool{}
+
+	for n, cd := range counters {
+		conflict := func(format string, args ...any) {
+			result.Conflicts = append(result.Conflicts, ImportConflict{Counter: cd.CounterId, Name: cd.CounterName, Reason: fmt.Sprintf(format, args...)})
+		}
+
+		id := newId(n)
+
+		if preserve {
+			var ierr error
+
+			if id, ierr = ToUUID(cd.CounterId); ierr != nil {
+				conflict("id %q isn't a UUID", cd.CounterId)
+				continue
+			}
+		}
+
+		berr := check_bounds(cd)
+		derr := check_derived(cd)
+
+		switch {
+		case cd.CounterName == "":
+			conflict("counter has no name")
+		case taken[cd.CounterName]:
+			conflict("the group already has a counter called %s", cd.CounterName)
+		case names[cd.CounterName]:
+			conflict("the import has more than one counter called %s", cd.CounterName)
+		case cd.Shards < 0 || cd.Shards > maxShards:
+			conflict("a counter can have up to %d shards, not %d", maxShards, cd.Shards)
+		case berr != nil:
+			conflict("%s", berr)
+		case derr != nil:
+			conflict("%s", derr)
+		case preserve && ids[id.String()]:
+			conflict("the import has counter %s more than once", id)
+		case preserve && exists(id):
+			conflict("counter %s already exists", id)
+		default:
+			planned = append(planned, CountData{
+				CounterId:    id.String(),
+				CounterName:  cd.CounterName,
+				CounterGroup: groupId.String(),
+				CounterVal:   cd.CounterVal,
+				StepVal:      cd.StepVal,
+				Shards:       cd.Shards,
+				Baseline:     cd.Baseline,
+				MinVal:       cd.MinVal,
+				MaxVal:       cd.MaxVal,
+				Labels:       cd.Labels,
+				Expression:   cd.Expression,
+				CounterMeta:  cd.CounterMeta,
+			})
+
+			result.Counters = append(result.Counters, ImportedCounter{From: cd.CounterId, Id: id.String(), Name: cd.CounterName})
+		}
+
+		names[cd.CounterName] = true
+		ids[id.String()] = true
+	}
+
+	return settle_derived(planned, &result), result
+}
+
+// the planned counters without the derived ones whose expressions name
+// counters which aren't imported or lead back to themselves.  Dropping one
+// can break another which names it, so it goes on until none are dropped.
+func settle_derived(planned []CountData, result *ImportResult) []CountData {
+	for dropped := true; dropped; {
+		dropped = false
+		broken := map[string]bool{}
+
+		for _, name := range relink_references(planned) {
+			broken[name] = true
+		}
+
+		byId := map[string]CountData{}
+
+		for _, cd := range planned {
+			byId[cd.CounterId] = cd
+		}
+
+		var kept []CountData
+
+		for _, cd := range planned {
+			reason := ""
+
+			if broken[cd.CounterName] {
+				reason = "its expression names counters which aren't imported"
+			} else if cycle, _ := refers_back(cd.CounterId, cd.References, func(ref string) (CountData, error) { return byId[ref], nil }); cycle {
+				reason = "its expression leads back to it"
+			}
+
+			if reason == "" {
+				kept = append(kept, cd)
+				continue
+			}
+
+			dropped = true
+			from := cd.CounterId
+
+			result.Counters = slices.DeleteFunc(result.Counters, func(ic ImportedCounter) bool {
+				if ic.Id == cd.CounterId {
+					from = ic.From
+				}
+
+				return ic.Id == cd.CounterId
+			})
+			result.Conflicts = append(result.Conflicts, ImportConflict{Counter: from, Name: cd.CounterName, Reason: reason})
+		}
+
+		planned = kept
+	}
+
+	return planned
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// new ids for imported counters
+func random_ids(n int) UUID {
+	return MakeUUID()
+}
+
+func TestExportCSV(t *testing.T) {
+	ed := ExportData{Counters: []CountData{
+		{CounterId: MakeUUID().String(), CounterName: "coffee, black", CounterVal: -3, StepVal: 2, Shards: 4, Version: 7},
+		{CounterId: MakeUUID().String(), CounterName: "tea", CounterVal: 9, StepVal: 1, Version: 1},
+		{CounterId: MakeUUID().String(), CounterName: "biscuits", CounterVal: 4, StepVal: 1, Version: 2, Baseline: 2, MinVal: aws.Int(0), MaxVal: aws.Int(10),
+			Labels: map[string]string{"env": "prod", "team": "kitchen"}, CounterMeta: CounterMeta{Description: "in the tin", Unit: "biscuits", Color: "#aa5500", Icon: "cookie", NumberFormat: "plain"}},
+		{CounterId: MakeUUID().String(), CounterName: "all", Expression: "tea + biscuits", Version: 1},
+	}}
+
+	var buf bytes.Buffer
+
+	checkError(t, write_export_csv(&buf, ed), nil)
+
+	if !strings.HasPrefix(buf.String(), "objectUUID,counterName,countVal,stepVal,shards,version,baseline,minVal,maxVal,labels,expression,description,unit,color,icon,numberFormat\n") {
+		t.Errorf("CSV is\n%s", buf.String())
+	}
+
+	back, err := read_export_csv(&buf)
+
+	checkError(t, err, nil)
+
+	if !reflect.DeepEqual(back.Counters, ed.Counters) {
+		t.Errorf("Read back %v", back.Counters)
+	}
+
+	// only the name has to be there
+	if short, _ := read_export_csv(strings.NewReader("counterName\ncups\n")); len(short.Counters) != 1 || short.Counters[0].StepVal != 1 {
+		t.Errorf("Name only is %v", short.Counters)
+	}
+
+	for csv, exp := range map[string]string{
+		"objectUUID\nx\n":            "CSV has no counterName column",
+		"counterName,stepVal\na,b\n": "CSV row 2: stepVal must be an integer, not b",
+		"counterName,maxVal\na,b\n":  "CSV row 2: maxVal must be an integer, not b",
+		"counterName,labels\na,b\n":  `CSV row 2: label "b" isn't key=value`,
+		"":                           "CSV has no header",
+	} {
+		if _, err := read_export_csv(strings.NewReader(csv)); err == nil || err.Error() != exp {
+			t.Errorf("%q gave %v", csv, err)
+		}
+	}
+}
+
+func TestPlanImport(t *testing.T) {
+	group := MakeUUID()
+	used := MakeUUID()
+
+	counters := []CountData{
+		{CounterId: MakeUUID().String(), CounterName: "coffee", CounterVal: 5, StepVal: 2, Version: 9},
+		{CounterId: MakeUUID().String(), CounterName: "tea"},
+		{CounterId: MakeUUID().String(), CounterName: "coffee"},
+		{CounterId: used.String(), CounterName: "cups"},
+		{CounterId: "c1", CounterName: "mugs"},
+		{CounterId: MakeUUID().String(), CounterName: "busy", Shards: 33},
+	}
+
+	taken := map[string]bool{"tea": true}
+	exists := func(id UUID) bool { return id == used }
+
+	planned, result := plan_import(counters, group, taken, exists, false, random_ids)
+
+	if len(planned) != 3 || len(result.Conflicts) != 3 {
+		t.Fatalf("New ids planned %v with conflicts %v", planned, result.Conflicts)
+	}
+
+	if p := planned[0]; p.CounterId == counters[0].CounterId || p.CounterGroup != group.String() || p.CounterVal != 5 || p.StepVal != 2 {
+		t.Errorf("coffee is planned as %+v", p)
+	}
+
+	if result.Counters[0].From != counters[0].CounterId || result.Counters[0].Id != planned[0].CounterId {
+		t.Errorf("coffee is imported as %+v", result.Counters[0])
+	}
+
+	_, kept := plan_import(counters, group, taken, exists, true, random_ids)
+
+	var reasons []string
+
+	for _, c := range kept.Conflicts {
+		reasons = append(reasons, c.Reason)
+	}
+
+	exp := []string{
+		"the group already has a counter called tea",
+		"the import has more than one counter called coffee",
+		"counter " + used.String() + " already exists",
+		`id "c1" isn't a UUID`,
+		"a counter can have up to 32 shards, not 33",
+	}
+
+	if !slices.Equal(reasons, exp) {
+		t.Errorf("Kept id conflicts are\n%s", strings.Join(reasons, "\n"))
+	}
+
+	if kept.Counters[0].Id != counters[0].CounterId {
+		t.Errorf("coffee's id wasn't kept: %+v", kept.Counters[0])
+	}
+}
+
+func TestExportRoutes(t *testing.T) {
+	group := MakeUUID()
+
+	dbo := MockDataOperator{
+		newId: MakeUUID(),
+		apiKey: APIKeyData{
+			UserId:  MakeUUID().String(),
+			GroupId: group.String(),
+			Rights:  []string{perm_read, perm_create},
+		},
+	}
+
+	api := APIHandler{dbo: &dbo}
+	prefix := "/api/v1/group/" + group.String()
+
+	export := keyRequest("GET", prefix+"/export", "ocd_key")
+	export.QueryStringParameters = map[string]string{"format": "csv"}
+
+	res, err := api.private_handler_gatewayv2(context.TODO(), export)
+
+	checkError(t, err, nil)
+
+	if res.StatusCode != 200 || res.Headers["Content-Type"] != "text/csv" || !strings.Contains(res.Body, dbo.newId.String()+",coffee,3,1,0,4") {
+		t.Errorf("CSV export is %d %v\n%s", res.StatusCode, res.Headers, res.Body)
+	}
+
+	for _, tc := range []struct {
+		query map[string]string
+		body  string
+		code  int
+		call  string
+	}{
+		{nil, res.Body, 400, ""},
+		{map[string]string{"format": "csv"}, res.Body, 200, "GroupImport 1 false true"},
+		{map[string]string{"format": "csv", "ids": "preserve", "mode": "commit"}, res.Body, 200, "GroupImport 1 true false"},
+		{nil, `{"counters": [{"counterName": "a"}, {"counterName": "b"}]}`, 200, "GroupImport 2 false true"},
+		{map[string]string{"mode": "now"}, "{}", 400, ""},
+	} {
+		dbo.funcName = nil
+
+		req := keyRequest("POST", prefix+"/import", "ocd_key")
+		req.QueryStringParameters = tc.query
+		req.Body = tc.body
+
+		res, err := api.private_handler_gatewayv2(context.TODO(), req)
+
+		checkError(t, err, nil)
+
+		if last := dbo.funcName[len(dbo.funcName)-1]; res.StatusCode != tc.code || (tc.call != "" && last != tc.call) {
+			t.Errorf("Import %v gave %d %s after %v", tc.query, res.StatusCode, res.Body, dbo.funcName)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The expressions of derived counters.  They are numbers, the names of
+// counters in the same group, sum(selector) for the counters with some
+// labels, + - * / and brackets, e.g. passed / (passed + failed) or
+// sum(env=prod) * 2.  A name which isn't a plain identifier can be given
+// in double quotes.  The parser is a recursive descent over the text, with
+// limits on its length and nesting so that no expression can take it far.
+
+const (
+	maxExpressionLength = 1024
+	maxExpressionDepth  = 32
+	maxReferences       = 32
+)
+
+type exprNode interface {
+	eval(env exprEnv) (float64, error)
+}
+
+// what an expression's names and sums are worth
+type exprEnv struct {
+	ref func(name string) (float64, error)
+	sum func(selector LabelSelector) (float64, error)
+}
+
+type numberNode float64
+
+type refNode string
+
+type sumNode struct {
+	selector LabelSelector
+}
+
+type negNode struct {
+	operand exprNode
+}
+
+type binaryNode struct {
+	op          byte
+	left, right exprNode
+}
+
+func (n numberNode) eval(env exprEnv) (float64, error) {
+	return float64(n), nil
+}
+
+func (n refNode) eval(env exprEnv) (float64, error) {
+	return env.ref(string(n))
+}
+
+func (n sumNode) eval(env exprEnv) (float64, error) {
+	return env.sum(n.selector)
+}
+
+func (n negNode) eval(env exprEnv) (float64, error) {
+	v, err := n.operand.eval(env)
+	return -v, err
+}
+
+func (n binaryNode) eval(env exprEnv) (float64, error) {
+	l, lerr := n.left.eval(env)
+
+	if lerr != nil {
+		return 0, lerr
+	}
+
+	r, rerr := n.right.eval(env)
+
+	if rerr != nil {
+		return 0, rerr
+	}
+
+	switch n.op {
+	case '+':
+		return l + r, nil
+	case '-':
+		return l - r, nil
+	case '*':
+		return l * r, nil
+	}
+
+	if r == 0 {
+		return 0, fmt.Errorf("division by zero")
+	}
+
+	return l / r, nil
+}
+
+type exprParser struct {
+	text  string
+	pos   int
+	depth int
+	refs  []string
+}
+
+func parse_expression(text string) (exprNode, []string, error) {
+	if len(text) > maxExpressionLength {
+		return nil, nil, bad_request("an expression can be up to %d long", maxExpressionLength)
+	}
+
+	// refs are the names, each once
+	ep := exprParser{text: text}
+
+	node, err := ep.expression()
+
+	if err == nil && ep.skip_space() < len(text) {
+		err = ep.fail("unexpected %q", text[ep.pos:ep.pos+1])
+	}
+
+	if err == nil && len(ep.refs) > maxReferences {
+		err = bad_request("an expression can name up to %d counters", maxReferences)
+	}
+
+	return node, ep.refs, err
+}
+
+// true if the expression adds up counters by their labels
+func has_sum(node exprNode) bool {
+	switch n := node.(type) {
+	case sumNode:
+		return true
+	case negNode:
+		return has_sum(n.operand)
+	case binaryNode:
+		return has_sum(n.left) || has_sum(n.right)
+	}
+
+	return false
+}
+
+func (ep *exprParser) fail(format string, args ...any) error {
+	return bad_request("expression at %d: %s", ep.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (ep *exprParser) skip_space() int {
+	for ep.pos < len(ep.text) && strings.ContainsRune(" \t\r\n", rune(ep.text[ep.pos])) {
+		ep.pos++
+	}
+
+	return ep.pos
+}
+
+// the next character after any space, 0 at the end
+func (ep *exprParser) peek() byte {
+	if ep.skip_space() < len(ep.text) {
+		return ep.text[ep.pos]
+	}
+
+	return 0
+}
+
+// expression := term (('+' | '-') term)*
+func (ep *exprParser) expression() (exprNode, error) {
+	ep.depth++
+	defer func() { ep.depth-- }()
+
+	if ep.depth > maxExpressionDepth {
+		return nil, ep.fail("brackets nested more than %d deep", maxExpressionDepth)
+	}
+
+	left, err := ep.term()
+
+	for err == nil && (ep.peek() == '+' || ep.peek() == '-') {
+		op := ep.text[ep.pos]
+		ep.pos++
+
+		var right exprNode
+
+		if right, err = ep.term(); err == nil {
+			left = binaryNode{op: op, left: left, right: right}
+		}
+	}
+
+	return left, err
+}
+
+// term := unary (('*' | '/') unary)*
+func (ep *exprParser) term() (exprNode, error) {
+	left, err := ep.unary()
+
+	for err == nil && (ep.peek() == '*' || ep.peek() == '/') {
+		op := ep.text[ep.pos]
+		ep.pos++
+
+		var right exprNode
+
+		if right, err = ep.unary(); err == nil {
+			left = binaryNode{op: op, left: left, right: right}
+		}
+	}
+
+	return left, err
+}
+
+// unary := '-' unary | primary
+func (ep *exprParser) unary() (exprNode, error) {
+	if ep.peek() != '-' {
+		return ep.primary()
+	}
+
+	ep.pos++
+
+	// a run of minuses goes as deep as brackets would
+	ep.depth++
+	defer func() { ep.depth-- }()
+
+	if ep.depth > maxExpressionDepth {
+		return nil, ep.fail("too many minuses")
+	}
+
+	operand, err := ep.unary()
+
+	return negNode{operand: operand}, err
+}
+
+// primary := number | name | "quoted name" | sum(selector) | '(' expression ')'
+func (ep *exprParser) primary() (exprNode, error) {
+	c := ep.peek()
+
+	switch {
+	case c == 0:
+		return nil, ep.fail("expression ends too soon")
+	case c == '(':
+		ep.pos++
+
+		node, err := ep.expression()
+
+		if err == nil && ep.peek() != ')' {
+			return nil, ep.fail("missing )")
+		}
+
+		ep.pos++
+
+		return node, err
+	case c == '"':
+		end := strings.IndexByte(ep.text[ep.pos+1:], '"')
+
+		if end < 0 {
+			return nil, ep.fail("missing \"")
+		}
+
+		name := ep.text[ep.pos+1 : ep.pos+1+end]
+		ep.pos += end + 2
+
+		return ep.ref(name)
+	case c >= '0' && c <= '9' || c == '.':
+		start := ep.pos
+
+		for ep.pos < len(ep.text) && (ep.text[ep.pos] >= '0' && ep.text[ep.pos] <= '9' || ep.text[ep.pos] == '.') {
+			ep.pos++
+		}
+
+		number := ep.text[start:ep.pos]
+		v, err := strconv.ParseFloat(number, 64)
+
+		if err != nil {
+			ep.pos = start
+			return nil, ep.fail("%s isn't a number", number)
+		}
+
+		return numberNode(v), nil
+	case identifier_start(c):
+		start := ep.pos
+
+		for ep.pos < len(ep.text) && (identifier_start(ep.text[ep.pos]) || ep.text[ep.pos] >= '0' && ep.text[ep.pos] <= '9') {
+			ep.pos++
+		}
+
+		name := ep.text[start:ep.pos]
+
+		if name == "sum" && ep.peek() == '(' {
+			return ep.sum()
+		}
+
+		return ep.ref(name)
+	}
+
+	return nil, ep.fail("unexpected %q", string(c))
+}
+
+func identifier_start(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+func (ep *exprParser) ref(name string) (exprNode, error) {
+	if name == "" {
+		return nil, ep.fail("a counter's name can't be empty")
+	}
+
+	if !slices.Contains(ep.refs, name) {
+		ep.refs = append(ep.refs, name)
+	}
+
+	return refNode(name), nil
+}
+
+// sum(selector), at the (
+func (ep *exprParser) sum() (exprNode, error) {
+	end := strings.IndexByte(ep.text[ep.pos:], ')')
+
+	if end < 0 {
+		return nil, ep.fail("missing )")
+	}
+
+	selector, err := parse_selector(split_list(ep.text[ep.pos+1 : ep.pos+end]))
+
+	if err != nil {
+		return nil, ep.fail("%s", err)
+	}
+
+	if len(selector) == 0 {
+		return nil, ep.fail("sum() needs labels to select counters by")
+	}
+
+	ep.pos += end + 1
+
+	return sumNode{selector: selector}, nil
+}
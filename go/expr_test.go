@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var exprCounters = map[string]float64{"passed": 3, "failed": 1, "cups of tea": 4}
+
+var exprEnvTest = exprEnv{
+	ref: func(name string) (float64, error) {
+		if v, found := exprCounters[name]; found {
+			return v, nil
+		}
+		return 0, fmt.Errorf("no %s", name)
+	},
+	sum: func(selector LabelSelector) (float64, error) {
+		return float64(len(selector)) * 10, nil
+	},
+}
+
+func TestExpressions(t *testing.T) {
+	for text, expected := range map[string]float64{
+		"1 + 2 * 3":                  7,
+		"(1 + 2) * 3":                9,
+		"10 - 4 - 3":                 3,
+		"12 / 4 / 3":                 1,
+		"-passed + --failed":         -2,
+		"passed / (passed + failed)": 0.75,
+		`"cups of tea" * 0.5`:        2,
+		"sum(env=prod,team!=infra)":  20,
+		"sum(env) - passed":          7,
+		"  2.5\n*2 ":                 5,
+	} {
+		node, _, err := parse_expression(text)
+
+		if err != nil {
+			t.Errorf("%s didn't parse: %s", text, err)
+			continue
+		}
+
+		if v, eerr := node.eval(exprEnvTest); eerr != nil || v != expected {
+			t.Errorf("%s is %v %v, not %v", text, v, eerr, expected)
+		}
+	}
+}
+
+func TestExpressionRefs(t *testing.T) {
+	node, refs, err := parse_expression(`passed / (passed + "cups of tea") + sum(env=prod)`)
+
+	checkError(t, err, nil)
+
+	if !slices.Equal(refs, []string{"passed", "cups of tea"}) || !has_sum(node) {
+		t.Errorf("Refs are %v, sum %t", refs, has_sum(node))
+	}
+
+	if _, _, err = parse_expression(strings.Repeat("a+", 32) + "a"); err != nil {
+		t.Errorf("One name many times gave %s", err)
+	}
+
+	// sum is only a function before a bracket
+	if node, refs, _ = parse_expression("sum + 1"); !slices.Equal(refs, []string{"sum"}) || has_sum(node) {
+		t.Errorf("Refs of sum are %v", refs)
+	}
+}
+
+// c0 + c1 + ... with n names
+func distinct_names(n int) string {
+	var names []string
+
+	for i := 0; i < n; i++ {
+		names = append(names, fmt.Sprintf("c%d", i))
+	}
+
+	return strings.Join(names, " + ")
+}
+
+func TestExpressionErrors(t *testing.T) {
+	for text, expected := range map[string]string{
+		"":             "ends too soon",
+		"1 +":          "ends too soon",
+		"(1 + 2":       "missing )",
+		"1 2":          `unexpected "2"`,
+		"passed % 2":   `unexpected "%"`,
+		`"passed`:      `missing "`,
+		`""`:           "can't be empty",
+		"1.2.3":        "at 1: 1.2.3 isn't a number",
+		"sum()":        "needs labels",
+		"sum(env=prod": "missing )",
+		"sum(=prod)":   "label key",
+		strings.Repeat("(", 40) + "1" + strings.Repeat(")", 40): "nested more than 32",
+		strings.Repeat("-", 40) + "1":                           "too many minuses",
+		distinct_names(33):                                      "up to 32 counters",
+		strings.Repeat("1", 1025):                               "up to 1024 long",
+	} {
+		_, _, err := parse_expression(text)
+
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%.20s gave %v, not %s", text, err, expected)
+		}
+
+		if res, _ := makeerror(err); err != nil && res.StatusCode != 400 {
+			t.Errorf("%.20s gave status %d", text, res.StatusCode)
+		}
+	}
+
+	node, _, _ := parse_expression("passed / (failed - 1)")
+
+	if _, err := node.eval(exprEnvTest); err == nil || err.Error() != "division by zero" {
+		t.Errorf("Division by zero gave %v", err)
+	}
+
+	node, _, _ = parse_expression("passed + missing")
+
+	if _, err := node.eval(exprEnvTest); err == nil || err.Error() != "no missing" {
+		t.Errorf("Missing counter gave %v", err)
+	}
+}
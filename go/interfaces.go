@@ -1,7 +1,13 @@
 package main
 
 import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/sqs"
 )
 
 // generic session specific routines for session parameters to operations
@@ -13,35 +19,135 @@ type Session interface {
 
 	GetGroupId() *UUID
 	GetGroupIdString() *string
+
+	// version from If-Match which the object being changed has to be at, or nil
+	GetIfMatch() *int
+
+	// key from Idempotency-Key which makes a change happen only once, or nil
+	GetIdempotencyKey() *IdempotencyKey
+
+	// the API key the request came in with, or nil for a user
+	GetAPIKey() *APIKeyData
 }
 
 // Data operator is the high level interface which lambda calls
 // this provides a task-based API and is mainly used for collecting together
 // global parameters like dynamo table names.
 // It could be used for mocking but mostly I used it as a handy collection of function definitions
+// Every call takes the request's context, whose deadline bounds the DynamoDB calls it makes.
 type DataOperator interface {
 	// User lookup by e-mail
-	LookupUserUUID(email *string) (UUID, error)
+	LookupUserUUID(ctx context.Context, email *string) (UUID, error)
+
+	// User lookup through the e-mail reservation.  Consistent, unlike the index.
+	// Returns false if the address has not been reserved.
+	LookupUserReservation(ctx context.Context, email *string) (UUID, bool, error)
+
+	// reserve the e-mail address of a user made before addresses were
+	// reserved, who is only in the index.  Returns false if there is no such user.
+	UserReserveIndexed(ctx context.Context, name *string) (UUID, bool, error)
+
+	// add a new record for a user, reserving the e-mail address at the same time
+	UserCreate(ctx context.Context, userId UUID, name *string) error
 
-	// add a new record for a user
-	UserCreate(userId UUID, name *string) error
+	// remove a user record and release the e-mail address.  Used to roll back a failed signup.
+	UserDelete(ctx context.Context, userId UUID, name *string) error
 
-	// CRUD functions for counters
-	CounterCreate(s Session, counterName string) (Response, error)
-	CounterRead(s Session, counterId UUID) (Response, error)
-	CounterUpdate(s Session, id UUID, query string, stepVal int) (Response, error)
-	CounterList(s Session) (Response, error)
-	CounterDelete(s Session, counterId UUID) (Response, error)
+	// CRUD functions for counters.  shards > 0 makes a sharded counter for high write rates.
+	// The counter is made with its name, value, step, baseline, bounds and metadata from created.
+	CounterCreate(ctx context.Context, s Session, created CountData) (Response, error)
+	CounterRead(ctx context.Context, s Session, counterId UUID) (Response, error)
+	CounterUpdate(ctx context.Context, s Session, id UUID, query string, stepVal int) (Response, error)
+	CounterList(ctx context.Context, s Session, selector LabelSelector) (Response, error)
+	CounterDelete(ctx context.Context, s Session, counterId UUID) (Response, error)
+
+	// a page of the session group's counters, read together, in the same order
+	CounterReadMany(ctx context.Context, s Session, counterIds []UUID) (Response, error)
+
+	// replace a counter's labels, and every label of the session group's counters
+	CounterLabels(ctx context.Context, s Session, id UUID, labels map[string]string) (Response, error)
+	GroupLabels(ctx context.Context, s Session) (Response, error)
+
+	// replace how a counter is described and shown
+	CounterMeta(ctx context.Context, s Session, id UUID, meta CounterMeta) (Response, error)
+
+	// replace a derived counter's expression, which can't lead back to it
+	CounterExpression(ctx context.Context, s Session, id UUID, expression string) (Response, error)
 
 	// CRUD functions for groups
-	GroupCreate(s Session, name string) (Response, error)
-	GroupList(s Session) (Response, error)
+	GroupCreate(ctx context.Context, s Session, name string) (Response, error)
+	GroupList(ctx context.Context, s Session) (Response, error)
+	GroupRead(ctx context.Context, s Session) (Response, error)
+
+	// a page of the user's groups, read together, in the same order
+	GroupReadMany(ctx context.Context, s Session, groupIds []UUID) (Response, error)
+
+	// the session's group with its counters, and exported counters made again in it.
+	// preserveIds keeps their ids rather than making new ones, and a dry run only says what would be made.
+	GroupExport(ctx context.Context, s Session) (ExportData, error)
+	GroupImport(ctx context.Context, s Session, ed ExportData, preserveIds bool, dryRun bool) (Response, error)
+
+	// a new group of the user's with the counters of the session's group, which start at their baselines if zero is set
+	GroupClone(ctx context.Context, s Session, name string, zero bool) (Response, error)
+
+	// the user's templates, saved from the session's group, and new groups made from them
+	TemplateCreate(ctx context.Context, s Session, name string) (Response, error)
+	TemplateRead(ctx context.Context, s Session, templateId UUID) (Response, error)
+	TemplateList(ctx context.Context, s Session) (Response, error)
+	TemplateDelete(ctx context.Context, s Session, templateId UUID) (Response, error)
+	GroupFromTemplate(ctx context.Context, s Session, templateId UUID, name string) (Response, error)
+
+	// check an API key and return what it is allowed to do
+	APIKeyVerify(ctx context.Context, key string) (APIKeyData, error)
+
+	// management functions for the session user's API keys
+	APIKeyCreate(ctx context.Context, s Session, name string, groupId UUID, counters []string, rights []string) (Response, error)
+	APIKeyRead(ctx context.Context, s Session, keyId UUID) (Response, error)
+	APIKeyList(ctx context.Context, s Session) (Response, error)
+	APIKeyRotate(ctx context.Context, s Session, keyId UUID) (Response, error)
+	APIKeyDelete(ctx context.Context, s Session, keyId UUID) (Response, error)
+
+	// webhook subscriptions in the session group, and their delivery log
+	WebhookCreate(ctx context.Context, s Session, wd WebhookData) (Response, error)
+	WebhookRead(ctx context.Context, s Session, hookId UUID) (Response, error)
+	WebhookList(ctx context.Context, s Session) (Response, error)
+	WebhookDelete(ctx context.Context, s Session, hookId UUID) (Response, error)
+	WebhookDeliveries(ctx context.Context, s Session, hookId UUID) (Response, error)
+
+	// websocket connections and their subscriptions.  SubscriptionCheck says whether a user may subscribe.
+	SubscriptionCheck(ctx context.Context, userId UUID, sub Subscription) error
+	ConnectionCreate(ctx context.Context, connectionId string, userId UUID) error
+	ConnectionRead(ctx context.Context, connectionId string) (ConnectionData, error)
+	ConnectionDelete(ctx context.Context, connectionId string) error
+	SubscriptionCreate(ctx context.Context, connectionId string, userId UUID, sub Subscription) error
+	SubscriptionDelete(ctx context.Context, connectionId string, groupId UUID) error
 }
 
 // DBInterface is the low level interface which actually talks to DynamoDB.
 // Separated so I can mock out things for testing.
 type DBInterface interface {
-	TransactWriteItems(*dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error)
-	GetItem(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error)
-	Query(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error)
+	TransactWriteItemsWithContext(aws.Context, *dynamodb.TransactWriteItemsInput, ...request.Option) (*dynamodb.TransactWriteItemsOutput, error)
+	GetItemWithContext(aws.Context, *dynamodb.GetItemInput, ...request.Option) (*dynamodb.GetItemOutput, error)
+	BatchGetItemWithContext(aws.Context, *dynamodb.BatchGetItemInput, ...request.Option) (*dynamodb.BatchGetItemOutput, error)
+	QueryWithContext(aws.Context, *dynamodb.QueryInput, ...request.Option) (*dynamodb.QueryOutput, error)
+}
+
+// IdentityInterface is the low level interface to the Cognito user pool.
+// Separated out for the same reason as DBInterface.
+type IdentityInterface interface {
+	AdminCreateUserWithContext(aws.Context, *cognitoidentityprovider.AdminCreateUserInput, ...request.Option) (*cognitoidentityprovider.AdminCreateUserOutput, error)
+	AdminDeleteUserWithContext(aws.Context, *cognitoidentityprovider.AdminDeleteUserInput, ...request.Option) (*cognitoidentityprovider.AdminDeleteUserOutput, error)
+	AdminGetUserWithContext(aws.Context, *cognitoidentityprovider.AdminGetUserInput, ...request.Option) (*cognitoidentityprovider.AdminGetUserOutput, error)
+	AdminInitiateAuthWithContext(aws.Context, *cognitoidentityprovider.AdminInitiateAuthInput, ...request.Option) (*cognitoidentityprovider.AdminInitiateAuthOutput, error)
+	AdminSetUserPasswordWithContext(aws.Context, *cognitoidentityprovider.AdminSetUserPasswordInput, ...request.Option) (*cognitoidentityprovider.AdminSetUserPasswordOutput, error)
+}
+
+// QueueInterface is the low level interface to SQS used by the queue event sink.
+type QueueInterface interface {
+	SendMessage(*sqs.SendMessageInput) (*sqs.SendMessageOutput, error)
+}
+
+// SocketInterface is the low level interface for pushing to API gateway websocket connections.
+type SocketInterface interface {
+	PostToConnection(*apigatewaymanagementapi.PostToConnectionInput) (*apigatewaymanagementapi.PostToConnectionOutput, error)
 }
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"maps"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 )
 
@@ -15,10 +21,28 @@ type MockDataOperator struct {
 
 	expEmail string
 
+	// e-mail reservation returned by LookupUserReservation, if any.
+	// UserCreate fails with createErr and then sets the reservation to userId.
+	reservation *UUID
+	createErr   error
+
+	// user only in the e-mail index, whose address UserReserveIndexed reserves
+	indexed *UUID
+
+	// CounterUpdate answers as a replay of an earlier request
+	replayed bool
+
+	// key returned by APIKeyVerify
+	apiKey APIKeyData
+
+	// connection returned by ConnectionRead, and what SubscriptionCheck says
+	connection ConnectionData
+	subErr     error
+
 	funcName []string
 }
 
-func (mo *MockDataOperator) LookupUserUUID(email *string) (UUID, error) {
+func (mo *MockDataOperator) LookupUserUUID(ctx context.Context, email *string) (UUID, error) {
 	if mo.expEmail == "" || mo.expEmail == *email {
 		mo.funcName = append(mo.funcName, "LookupUserUUID")
 		return mo.userId, mo.retErr
@@ -27,36 +51,82 @@ func (mo *MockDataOperator) LookupUserUUID(email *string) (UUID, error) {
 	}
 }
 
+func (mo *MockDataOperator) LookupUserReservation(ctx context.Context, email *string) (UUID, bool, error) {
+	mo.funcName = append(mo.funcName, "LookupUserReservation")
+	if mo.reservation == nil {
+		return NullUUID(), false, mo.retErr
+	}
+	return *mo.reservation, true, mo.retErr
+}
+
+func (mo *MockDataOperator) UserReserveIndexed(ctx context.Context, name *string) (UUID, bool, error) {
+	mo.funcName = append(mo.funcName, "UserReserveIndexed")
+	if mo.indexed == nil {
+		return NullUUID(), false, mo.retErr
+	}
+	mo.reservation = mo.indexed
+	return *mo.indexed, true, mo.retErr
+}
+
 // add a new record for a user
-func (mo *MockDataOperator) UserCreate(userId UUID, name *string) error {
+func (mo *MockDataOperator) UserCreate(ctx context.Context, userId UUID, name *string) error {
 	mo.funcName = append(mo.funcName, "UserCreate")
+	if mo.createErr != nil {
+		mo.reservation = &mo.userId
+		return mo.createErr
+	}
+	mo.newId = userId
+	return mo.retErr
+}
+
+func (mo *MockDataOperator) UserDelete(ctx context.Context, userId UUID, name *string) error {
+	mo.funcName = append(mo.funcName, "UserDelete")
 	return mo.retErr
 }
 
 // CRUD functions for counters
-func (mo *MockDataOperator) CounterCreate(s Session, counterName string) (Response, error) {
+func (mo *MockDataOperator) CounterCreate(ctx context.Context, s Session, created CountData) (Response, error) {
 	mo.funcName = append(mo.funcName, "CounterCreate")
 	if mo.retErr != nil {
 		return makeerror(mo.retErr)
 	}
 	return makeresponse(opResult{Success: true, Result: "OK", Id: mo.newId.String()})
 }
-func (mo *MockDataOperator) CounterRead(s Session, counterId UUID) (Response, error) {
+func (mo *MockDataOperator) CounterRead(ctx context.Context, s Session, counterId UUID) (Response, error) {
 	mo.funcName = append(mo.funcName, "CounterRead")
 	if mo.retErr != nil {
 		return makeerror(mo.retErr)
 	}
 	return makeresponse(opResult{Success: true, Result: "OK", Id: counterId.String()})
 }
-func (mo *MockDataOperator) CounterUpdate(s Session, id UUID, query string, stepVal int) (Response, error) {
+func (mo *MockDataOperator) CounterUpdate(ctx context.Context, s Session, id UUID, query string, stepVal int) (Response, error) {
 	mo.funcName = append(mo.funcName, "CounterUpdate")
 	if mo.retErr != nil {
 		return makeerror(mo.retErr)
 	}
-	return makeresponse(opResult{Success: true, Result: "OK", Id: id.String()})
+	res, err := makeresponse(opResult{Success: true, Result: "OK", Id: id.String()})
+	if mo.replayed {
+		res.Headers[replayHeader] = "true"
+	}
+	return res, err
+}
+func (mo *MockDataOperator) CounterReadMany(ctx context.Context, s Session, counterIds []UUID) (Response, error) {
+	mo.funcName = append(mo.funcName, "CounterReadMany")
+	if mo.retErr != nil {
+		return makeerror(mo.retErr)
+	}
+	counters := []CountData{}
+	for _, id := range counterIds {
+		counters = append(counters, CountData{CounterId: id.String(), CounterGroup: *s.GetGroupIdString()})
+	}
+	return makeresponse(counters)
 }
-func (mo *MockDataOperator) CounterList(s Session) (Response, error) {
-	mo.funcName = append(mo.funcName, "CounterList")
+func (mo *MockDataOperator) CounterList(ctx context.Context, s Session, selector LabelSelector) (Response, error) {
+	if len(selector) > 0 {
+		mo.funcName = append(mo.funcName, "CounterList "+selector.String())
+	} else {
+		mo.funcName = append(mo.funcName, "CounterList")
+	}
 	if mo.retErr != nil {
 		return makeerror(mo.retErr)
 	}
@@ -67,23 +137,51 @@ func (mo *MockDataOperator) CounterList(s Session) (Response, error) {
 		Items:   []string{mo.newId.String()},
 	})
 }
-func (mo *MockDataOperator) CounterDelete(s Session, counterId UUID) (Response, error) {
+func (mo *MockDataOperator) CounterDelete(ctx context.Context, s Session, counterId UUID) (Response, error) {
 	mo.funcName = append(mo.funcName, "CounterDelete")
 	if mo.retErr != nil {
 		return makeerror(mo.retErr)
 	}
 	return makeresponse(opResult{Success: true, Result: "OK", Id: counterId.String()})
 }
+func (mo *MockDataOperator) CounterLabels(ctx context.Context, s Session, id UUID, labels map[string]string) (Response, error) {
+	mo.funcName = append(mo.funcName, fmt.Sprintf("CounterLabels %d", len(labels)))
+	if mo.retErr != nil {
+		return makeerror(mo.retErr)
+	}
+	return makeresponse(opResult{Success: true, Result: "OK", Id: id.String()})
+}
+func (mo *MockDataOperator) CounterMeta(ctx context.Context, s Session, id UUID, meta CounterMeta) (Response, error) {
+	mo.funcName = append(mo.funcName, "CounterMeta "+meta.Unit)
+	if mo.retErr != nil {
+		return makeerror(mo.retErr)
+	}
+	return makeresponse(opResult{Success: true, Result: "OK", Id: id.String()})
+}
+func (mo *MockDataOperator) CounterExpression(ctx context.Context, s Session, id UUID, expression string) (Response, error) {
+	mo.funcName = append(mo.funcName, "CounterExpression "+expression)
+	if mo.retErr != nil {
+		return makeerror(mo.retErr)
+	}
+	return makeresponse(opResult{Success: true, Result: "OK", Id: id.String()})
+}
+func (mo *MockDataOperator) GroupLabels(ctx context.Context, s Session) (Response, error) {
+	mo.funcName = append(mo.funcName, "GroupLabels")
+	if mo.retErr != nil {
+		return makeerror(mo.retErr)
+	}
+	return makeresponse(LabelIndex{Group: *s.GetGroupIdString(), Labels: map[string][]string{}})
+}
 
 // CRUD functions for groups
-func (mo *MockDataOperator) GroupCreate(s Session, name string) (Response, error) {
+func (mo *MockDataOperator) GroupCreate(ctx context.Context, s Session, name string) (Response, error) {
 	mo.funcName = append(mo.funcName, "GroupCreate")
 	if mo.retErr != nil {
 		return makeerror(mo.retErr)
 	}
 	return makeresponse(opResult{Success: true, Result: "OK", Id: mo.newId.String()})
 }
-func (mo *MockDataOperator) GroupList(s Session) (Response, error) {
+func (mo *MockDataOperator) GroupList(ctx context.Context, s Session) (Response, error) {
 	mo.funcName = append(mo.funcName, "GroupList")
 	if mo.retErr != nil {
 		return makeerror(mo.retErr)
@@ -96,27 +194,393 @@ func (mo *MockDataOperator) GroupList(s Session) (Response, error) {
 	})
 }
 
+func (mo *MockDataOperator) GroupRead(ctx context.Context, s Session) (Response, error) {
+	mo.funcName = append(mo.funcName, "GroupRead")
+	if mo.retErr != nil {
+		return makeerror(mo.retErr)
+	}
+	return makeresponse(GroupData{GroupId: *s.GetGroupIdString(), Counters: []string{mo.newId.String()}})
+}
+
+func (mo *MockDataOperator) GroupReadMany(ctx context.Context, s Session, groupIds []UUID) (Response, error) {
+	mo.funcName = append(mo.funcName, "GroupReadMany")
+	if mo.retErr != nil {
+		return makeerror(mo.retErr)
+	}
+	groups := []GroupData{}
+	for _, id := range groupIds {
+		groups = append(groups, GroupData{GroupId: id.String(), Counters: []string{mo.newId.String()}})
+	}
+	return makeresponse(groups)
+}
+
+func (mo *MockDataOperator) GroupExport(ctx context.Context, s Session) (ExportData, error) {
+	mo.funcName = append(mo.funcName, "GroupExport")
+	return ExportData{
+		Group:    GroupData{GroupId: *s.GetGroupIdString(), Counters: []string{mo.newId.String()}, Version: 1},
+		Counters: []CountData{{CounterId: mo.newId.String(), CounterName: "coffee", CounterGroup: *s.GetGroupIdString(), CounterVal: 3, StepVal: 1, Version: 4}},
+	}, mo.retErr
+}
+
+func (mo *MockDataOperator) GroupImport(ctx context.Context, s Session, ed ExportData, preserveIds bool, dryRun bool) (Response, error) {
+	mo.funcName = append(mo.funcName, fmt.Sprintf("GroupImport %d %t %t", len(ed.Counters), preserveIds, dryRun))
+	if mo.retErr != nil {
+		return makeerror(mo.retErr)
+	}
+	return makeresponse(ImportResult{DryRun: dryRun})
+}
+
+func (mo *MockDataOperator) GroupClone(ctx context.Context, s Session, name string, zero bool) (Response, error) {
+	mo.funcName = append(mo.funcName, fmt.Sprintf("GroupClone %s %t", name, zero))
+	if mo.retErr != nil {
+		return makeerror(mo.retErr)
+	}
+	return makeresponse(opResult{Success: true, Result: "OK", Id: mo.newId.String()})
+}
+
+// template functions
+func (mo *MockDataOperator) TemplateCreate(ctx context.Context, s Session, name string) (Response, error) {
+	mo.funcName = append(mo.funcName, "TemplateCreate")
+	if mo.retErr != nil {
+		return makeerror(mo.retErr)
+	}
+	return makeresponse(opResult{Success: true, Result: "OK", Id: mo.newId.String()})
+}
+func (mo *MockDataOperator) TemplateRead(ctx context.Context, s Session, templateId UUID) (Response, error) {
+	mo.funcName = append(mo.funcName, "TemplateRead")
+	if mo.retErr != nil {
+		return makeerror(mo.retErr)
+	}
+	return makeresponse(TemplateData{TemplateId: templateId.String(), Counters: []CounterSpec{}})
+}
+func (mo *MockDataOperator) TemplateList(ctx context.Context, s Session) (Response, error) {
+	mo.funcName = append(mo.funcName, "TemplateList")
+	if mo.retErr != nil {
+		return makeerror(mo.retErr)
+	}
+	return makeresponse(opResult{
+		Result:  "OK",
+		Success: true,
+		Id:      "?",
+		Items:   []string{mo.newId.String()},
+	})
+}
+func (mo *MockDataOperator) TemplateDelete(ctx context.Context, s Session, templateId UUID) (Response, error) {
+	mo.funcName = append(mo.funcName, "TemplateDelete")
+	if mo.retErr != nil {
+		return makeerror(mo.retErr)
+	}
+	return makeresponse(opResult{Success: true, Result: "OK", Id: templateId.String()})
+}
+func (mo *MockDataOperator) GroupFromTemplate(ctx context.Context, s Session, templateId UUID, name string) (Response, error) {
+	mo.funcName = append(mo.funcName, "GroupFromTemplate "+name)
+	if mo.retErr != nil {
+		return makeerror(mo.retErr)
+	}
+	return makeresponse(opResult{Success: true, Result: "OK", Id: mo.newId.String()})
+}
+
+func (mo *MockDataOperator) APIKeyVerify(ctx context.Context, key string) (APIKeyData, error) {
+	mo.funcName = append(mo.funcName, "APIKeyVerify")
+	return mo.apiKey, mo.retErr
+}
+
+// management functions for API keys
+func (mo *MockDataOperator) APIKeyCreate(ctx context.Context, s Session, name string, groupId UUID, counters []string, rights []string) (Response, error) {
+	mo.funcName = append(mo.funcName, "APIKeyCreate")
+	if mo.retErr != nil {
+		return makeerror(mo.retErr)
+	}
+	return makeresponse(apiKeyResult{opResult: opResult{Success: true, Result: "OK", Id: mo.newId.String()}, Key: "ocd_key"})
+}
+func (mo *MockDataOperator) APIKeyRead(ctx context.Context, s Session, keyId UUID) (Response, error) {
+	mo.funcName = append(mo.funcName, "APIKeyRead")
+	if mo.retErr != nil {
+		return makeerror(mo.retErr)
+	}
+	return makeresponse(mo.apiKey)
+}
+func (mo *MockDataOperator) APIKeyList(ctx context.Context, s Session) (Response, error) {
+	mo.funcName = append(mo.funcName, "APIKeyList")
+	if mo.retErr != nil {
+		return makeerror(mo.retErr)
+	}
+	return makeresponse(opResult{
+		Result:  "OK",
+		Success: true,
+		Id:      "?",
+		Items:   []string{mo.newId.String()},
+	})
+}
+func (mo *MockDataOperator) APIKeyRotate(ctx context.Context, s Session, keyId UUID) (Response, error) {
+	mo.funcName = append(mo.funcName, "APIKeyRotate")
+	if mo.retErr != nil {
+		return makeerror(mo.retErr)
+	}
+	return makeresponse(apiKeyResult{opResult: opResult{Success: true, Result: "OK", Id: keyId.String()}, Key: "ocd_key"})
+}
+func (mo *MockDataOperator) APIKeyDelete(ctx context.Context, s Session, keyId UUID) (Response, error) {
+	mo.funcName = append(mo.funcName, "APIKeyDelete")
+	if mo.retErr != nil {
+		return makeerror(mo.retErr)
+	}
+	return makeresponse(opResult{Success: true, Result: "OK", Id: keyId.String()})
+}
+
+// webhook functions
+func (mo *MockDataOperator) WebhookCreate(ctx context.Context, s Session, wd WebhookData) (Response, error) {
+	mo.funcName = append(mo.funcName, "WebhookCreate")
+	if mo.retErr != nil {
+		return makeerror(mo.retErr)
+	}
+	return makeresponse(webhookResult{opResult: opResult{Success: true, Result: "OK", Id: mo.newId.String()}, Secret: wd.Secret})
+}
+func (mo *MockDataOperator) WebhookRead(ctx context.Context, s Session, hookId UUID) (Response, error) {
+	mo.funcName = append(mo.funcName, "WebhookRead")
+	if mo.retErr != nil {
+		return makeerror(mo.retErr)
+	}
+	return makeresponse(WebhookData{HookId: hookId.String()})
+}
+func (mo *MockDataOperator) WebhookList(ctx context.Context, s Session) (Response, error) {
+	mo.funcName = append(mo.funcName, "WebhookList")
+	if mo.retErr != nil {
+		return makeerror(mo.retErr)
+	}
+	return makeresponse(opResult{
+		Result:  "OK",
+		Success: true,
+		Id:      "?",
+		Items:   []string{mo.newId.String()},
+	})
+}
+func (mo *MockDataOperator) WebhookDelete(ctx context.Context, s Session, hookId UUID) (Response, error) {
+	mo.funcName = append(mo.funcName, "WebhookDelete")
+	if mo.retErr != nil {
+		return makeerror(mo.retErr)
+	}
+	return makeresponse(opResult{Success: true, Result: "OK", Id: hookId.String()})
+}
+func (mo *MockDataOperator) WebhookDeliveries(ctx context.Context, s Session, hookId UUID) (Response, error) {
+	mo.funcName = append(mo.funcName, "WebhookDeliveries")
+	if mo.retErr != nil {
+		return makeerror(mo.retErr)
+	}
+	return makeresponse([]DeliveryData{})
+}
+
+// websocket functions
+func (mo *MockDataOperator) SubscriptionCheck(ctx context.Context, userId UUID, sub Subscription) error {
+	mo.funcName = append(mo.funcName, "SubscriptionCheck")
+	return mo.subErr
+}
+func (mo *MockDataOperator) ConnectionCreate(ctx context.Context, connectionId string, userId UUID) error {
+	mo.funcName = append(mo.funcName, "ConnectionCreate")
+	mo.connection = ConnectionData{ConnectionId: connectionId, UserId: userId.String()}
+	return mo.retErr
+}
+func (mo *MockDataOperator) ConnectionRead(ctx context.Context, connectionId string) (ConnectionData, error) {
+	mo.funcName = append(mo.funcName, "ConnectionRead")
+	if mo.connection.ConnectionId != connectionId {
+		return ConnectionData{}, fmt.Errorf("connection %s not found", connectionId)
+	}
+	return mo.connection, mo.retErr
+}
+func (mo *MockDataOperator) ConnectionDelete(ctx context.Context, connectionId string) error {
+	mo.funcName = append(mo.funcName, "ConnectionDelete")
+	return mo.retErr
+}
+func (mo *MockDataOperator) SubscriptionCreate(ctx context.Context, connectionId string, userId UUID, sub Subscription) error {
+	mo.funcName = append(mo.funcName, "SubscriptionCreate")
+	return mo.retErr
+}
+func (mo *MockDataOperator) SubscriptionDelete(ctx context.Context, connectionId string, groupId UUID) error {
+	mo.funcName = append(mo.funcName, "SubscriptionDelete")
+	return mo.retErr
+}
+
 type MockDBInterface struct {
 	twi dynamodb.TransactWriteItemsInput
 	gii dynamodb.GetItemInput
 	qi  dynamodb.QueryInput
 
+	two dynamodb.TransactWriteItemsOutput
 	gio dynamodb.GetItemOutput
+	qo  dynamodb.QueryOutput
+
+	// every write transaction, most recent last
+	twis []dynamodb.TransactWriteItemsInput
 
 	retErr error
+
+	// errors for the next write transactions, before falling back to retErr
+	twErrs []error
+
+	// items by the objectUUID of their key, before falling back to gio
+	items map[string]map[string]*dynamodb.AttributeValue
+
+	// every batch read, and how many of the next ones leave their last key unprocessed
+	bgis        []dynamodb.BatchGetItemInput
+	unprocessed int
 }
 
-func (mo *MockDBInterface) TransactWriteItems(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+func (mo *MockDBInterface) TransactWriteItemsWithContext(ctx aws.Context, input *dynamodb.TransactWriteItemsInput, opts ...request.Option) (*dynamodb.TransactWriteItemsOutput, error) {
 	mo.twi = *input
-	return nil, mo.retErr
+	mo.twis = append(mo.twis, *input)
+
+	if len(mo.twErrs) > 0 {
+		err := mo.twErrs[0]
+		mo.twErrs = mo.twErrs[1:]
+		return nil, err
+	}
+
+	if mo.retErr != nil {
+		return nil, mo.retErr
+	}
+
+	return &mo.two, nil
 }
 
-func (mo *MockDBInterface) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+func (mo *MockDBInterface) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
 	mo.gii = *input
+
+	if key := input.Key[counterIdCol]; key != nil && key.S != nil && mo.items[*key.S] != nil {
+		return &dynamodb.GetItemOutput{Item: mo.items[*key.S]}, mo.retErr
+	}
+
 	return &mo.gio, mo.retErr
 }
 
-func (mo *MockDBInterface) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+// answers each key from items, or with gio's item under that key
+func (mo *MockDBInterface) BatchGetItemWithContext(ctx aws.Context, input *dynamodb.BatchGetItemInput, opts ...request.Option) (*dynamodb.BatchGetItemOutput, error) {
+	mo.bgis = append(mo.bgis, *input)
+
+	out := dynamodb.BatchGetItemOutput{
+		Responses:       map[string][]map[string]*dynamodb.AttributeValue{},
+		UnprocessedKeys: map[string]*dynamodb.KeysAndAttributes{},
+	}
+
+	for table, ka := range input.RequestItems {
+		keys := ka.Keys
+
+		if mo.unprocessed > 0 && len(keys) > 0 {
+			mo.unprocessed--
+			left := *ka
+			left.Keys = keys[len(keys)-1:]
+			out.UnprocessedKeys[table] = &left
+			keys = keys[:len(keys)-1]
+		}
+
+		for _, key := range keys {
+			item := mo.items[*key[counterIdCol].S]
+
+			if item == nil && mo.gio.Item != nil {
+				item = maps.Clone(mo.gio.Item)
+				maps.Copy(item, key)
+			}
+
+			if item != nil {
+				out.Responses[table] = append(out.Responses[table], item)
+			}
+		}
+	}
+
+	return &out, mo.retErr
+}
+
+func (mo *MockDBInterface) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
 	mo.qi = *input
-	return nil, mo.retErr
+	return &mo.qo, mo.retErr
+}
+
+type MockIdentityInterface struct {
+	// cognito user status reported by AdminGetUser, "" if the user doesn't exist
+	status string
+
+	createErr error
+	pwErr     error
+	authErr   error
+
+	// called when a user is made, so a test can cancel the request part way
+	created func()
+
+	funcName []string
+}
+
+func (mi *MockIdentityInterface) AdminCreateUserWithContext(ctx aws.Context, input *cognitoidentityprovider.AdminCreateUserInput, opts ...request.Option) (*cognitoidentityprovider.AdminCreateUserOutput, error) {
+	mi.funcName = append(mi.funcName, "AdminCreateUser")
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if mi.createErr == nil {
+		mi.status = cognitoidentityprovider.UserStatusTypeForceChangePassword
+	}
+	if mi.created != nil {
+		mi.created()
+	}
+	return &cognitoidentityprovider.AdminCreateUserOutput{}, mi.createErr
+}
+
+func (mi *MockIdentityInterface) AdminDeleteUserWithContext(ctx aws.Context, input *cognitoidentityprovider.AdminDeleteUserInput, opts ...request.Option) (*cognitoidentityprovider.AdminDeleteUserOutput, error) {
+	mi.funcName = append(mi.funcName, "AdminDeleteUser")
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	mi.status = ""
+	return &cognitoidentityprovider.AdminDeleteUserOutput{}, nil
+}
+
+func (mi *MockIdentityInterface) AdminGetUserWithContext(ctx aws.Context, input *cognitoidentityprovider.AdminGetUserInput, opts ...request.Option) (*cognitoidentityprovider.AdminGetUserOutput, error) {
+	mi.funcName = append(mi.funcName, "AdminGetUser")
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if mi.status == "" {
+		return nil, awserr.New(cognitoidentityprovider.ErrCodeUserNotFoundException, "User does not exist.", nil)
+	}
+	return &cognitoidentityprovider.AdminGetUserOutput{UserStatus: aws.String(mi.status)}, nil
+}
+
+func (mi *MockIdentityInterface) AdminInitiateAuthWithContext(ctx aws.Context, input *cognitoidentityprovider.AdminInitiateAuthInput, opts ...request.Option) (*cognitoidentityprovider.AdminInitiateAuthOutput, error) {
+	mi.funcName = append(mi.funcName, "AdminInitiateAuth")
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if mi.authErr != nil {
+		return nil, mi.authErr
+	}
+	return &cognitoidentityprovider.AdminInitiateAuthOutput{
+		AuthenticationResult: &cognitoidentityprovider.AuthenticationResultType{IdToken: aws.String("token")},
+	}, nil
+}
+
+func (mi *MockIdentityInterface) AdminSetUserPasswordWithContext(ctx aws.Context, input *cognitoidentityprovider.AdminSetUserPasswordInput, opts ...request.Option) (*cognitoidentityprovider.AdminSetUserPasswordOutput, error) {
+	mi.funcName = append(mi.funcName, "AdminSetUserPassword")
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if mi.pwErr == nil {
+		mi.status = cognitoidentityprovider.UserStatusTypeConfirmed
+	}
+	return &cognitoidentityprovider.AdminSetUserPasswordOutput{}, mi.pwErr
+}
+
+// MockMetricsSink keeps what was emitted
+type MockMetricsSink struct {
+	emitted []MockMetrics
+}
+
+type MockMetrics struct {
+	dims    map[string]string
+	metrics map[string]float64
+}
+
+func (ms *MockMetricsSink) Emit(ctx context.Context, dims map[string]string, metrics ...Metric) {
+	mm := MockMetrics{dims: dims, metrics: map[string]float64{}}
+
+	for _, m := range metrics {
+		mm.metrics[m.Name] = m.Value
+	}
+
+	ms.emitted = append(ms.emitted, mm)
 }
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JWTVerifier checks cognito tokens for connections which don't come through
+// the HTTP API's JWT authorizer, i.e. websockets and the standalone server.
+// The claims come out flattened to strings, the same shape the authorizer
+// hands to Create_APISession.
+type JWTVerifier struct {
+	issuer   string
+	audience string
+	client   *http.Client
+	now      func() time.Time
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+// a key which isn't in the key set fetched this recently is taken not to
+// exist, so that tokens with made up key ids can't have every request fetch
+// the key set
+const keyRefetch = time.Minute
+
+// cognito pool ids start with the region, e.g. eu-west-2_AbCdEf
+func cognito_issuer(pool string) string {
+	region, _, _ := strings.Cut(pool, "_")
+	return fmt.Sprintf("https://cognito-idp.%s.amazonaws.com/%s", region, pool)
+}
+
+func Create_JWTVerifier(pool string, clientId string) *JWTVerifier {
+	return &JWTVerifier{
+		issuer:   cognito_issuer(pool),
+		audience: clientId,
+		client:   &http.Client{Timeout: 5 * time.Second},
+		now:      time.Now,
+	}
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+func (jv *JWTVerifier) Verify(token string) (map[string]string, error) {
+	parts := strings.Split(token, ".")
+
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	var header jwtHeader
+
+	if err := decode_segment(parts[0], &header); err != nil {
+		return nil, err
+	}
+
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported token algorithm %s", header.Alg)
+	}
+
+	key, kerr := jv.key(header.Kid)
+
+	if kerr != nil {
+		return nil, kerr
+	}
+
+	sig, serr := base64.RawURLEncoding.DecodeString(parts[2])
+
+	if serr != nil {
+		return nil, fmt.Errorf("malformed token signature")
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return nil, fmt.Errorf("invalid token signature")
+	}
+
+	var raw map[string]any
+
+	if err := decode_segment(parts[1], &raw); err != nil {
+		return nil, err
+	}
+
+	claims := flatten_claims(raw)
+
+	return claims, jv.check_claims(claims)
+}
+
+// id tokens carry the client in aud, access tokens in client_id, and
+// token_use says which a token is
+func (jv *JWTVerifier) check_claims(claims map[string]string) error {
+	exp, eerr := strconv.ParseInt(claims["exp"], 10, 64)
+
+	if eerr != nil || jv.now().Unix() >= exp {
+		return fmt.Errorf("token has expired")
+	}
+
+	if claims["iss"] != jv.issuer {
+		return fmt.Errorf("token issuer is %s not %s", claims["iss"], jv.issuer)
+	}
+
+	var audience string
+
+	switch claims["token_use"] {
+	case "id":
+		audience = claims["aud"]
+	case "access":
+		audience = claims["client_id"]
+	default:
+		return fmt.Errorf("token is for %q, not an id or access token", claims["token_use"])
+	}
+
+	if audience != jv.audience {
+		return fmt.Errorf("token is not for client %s", jv.audience)
+	}
+
+	return nil
+}
+
+// looks up a signing key, fetching the pool's key set again if it isn't known
+// and hasn't just been fetched.  Cognito rotates keys rarely so a miss is
+// almost always a bad token.  Requests wait for a fetch in progress, which
+// is what they would have needed anyway.
+func (jv *JWTVerifier) key(kid string) (*rsa.PublicKey, error) {
+	jv.mu.Lock()
+	defer jv.mu.Unlock()
+
+	if key, found := jv.keys[kid]; found {
+		return key, nil
+	}
+
+	if jv.keys != nil && jv.now().Sub(jv.fetched) < keyRefetch {
+		return nil, fmt.Errorf("unknown token key %s", kid)
+	}
+
+	keys, err := jv.fetch_keys()
+
+	if err != nil {
+		return nil, err
+	}
+
+	jv.keys, jv.fetched = keys, jv.now()
+
+	if key, found := jv.keys[kid]; found {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unknown token key %s", kid)
+}
+
+type jwkSet struct {
+	Keys []struct {
+		Kid string `json:"kid"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+func (jv *JWTVerifier) fetch_keys() (map[string]*rsa.PublicKey, error) {
+	resp, err := jv.client.Get(jv.issuer + "/.well-known/jwks.json")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("key set fetch returned %s", resp.Status)
+	}
+
+	var set jwkSet
+
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+
+		n, nerr := base64.RawURLEncoding.DecodeString(k.N)
+		e, eerr := base64.RawURLEncoding.DecodeString(k.E)
+
+		if nerr != nil || eerr != nil {
+			return nil, fmt.Errorf("malformed key %s in key set", k.Kid)
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	return keys, nil
+}
+
+func decode_segment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+
+	if err != nil {
+		return fmt.Errorf("malformed token")
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("malformed token")
+	}
+
+	return nil
+}
+
+func flatten_claims(raw map[string]any) map[string]string {
+	claims := make(map[string]string, len(raw))
+
+	for k, v := range raw {
+		switch cv := v.(type) {
+		case string:
+			claims[k] = cv
+		case float64:
+			claims[k] = strconv.FormatFloat(cv, 'f', -1, 64)
+		default:
+			claims[k] = fmt.Sprint(cv)
+		}
+	}
+
+	return claims
+}
+
+// token from an Authorization header or, because browsers can't set headers
+// on websockets, a token query parameter.
+func bearer_token(header string, query string) string {
+	if token, found := strings.CutPrefix(header, "Bearer "); found {
+		return token
+	}
+
+	if header != "" {
+		return header
+	}
+
+	return query
+}
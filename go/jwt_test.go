@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testClient = "testclient"
+
+// signs tokens with a key which the verifier fetches from a local key set
+type testIssuer struct {
+	key     *rsa.PrivateKey
+	server  *httptest.Server
+	fetches atomic.Int32
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ti := &testIssuer{key: key}
+
+	ti.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/jwks.json" {
+			http.NotFound(w, r)
+			return
+		}
+		ti.fetches.Add(1)
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kid": "k1",
+				"kty": "RSA",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	}))
+
+	t.Cleanup(ti.server.Close)
+
+	return ti
+}
+
+func (ti *testIssuer) verifier() *JWTVerifier {
+	return &JWTVerifier{
+		issuer:   ti.server.URL,
+		audience: testClient,
+		client:   ti.server.Client(),
+		now:      time.Now,
+	}
+}
+
+func (ti *testIssuer) sign(t *testing.T, kid string, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid})
+	payload, _ := json.Marshal(claims)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signed))
+
+	sig, err := rsa.SignPKCS1v15(rand.Reader, ti.key, crypto.SHA256, digest[:])
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func (ti *testIssuer) token(t *testing.T, email string) string {
+	return ti.sign(t, "k1", map[string]any{
+		"iss":              ti.server.URL,
+		"aud":              testClient,
+		"exp":              time.Now().Add(time.Hour).Unix(),
+		"token_use":        "id",
+		"cognito:username": email,
+	})
+}
+
+func TestJWTVerify(t *testing.T) {
+	ti := newTestIssuer(t)
+	jv := ti.verifier()
+
+	claims, err := jv.Verify(ti.token(t, "foo@bar.com"))
+
+	checkError(t, err, nil)
+
+	if claims["cognito:username"] != "foo@bar.com" {
+		t.Errorf("Claims are %v", claims)
+	}
+
+	if strings.ContainsAny(claims["exp"], "e.") {
+		t.Errorf("Expiry claim %s is not a whole number", claims["exp"])
+	}
+
+	// access tokens name the client in client_id
+	access := ti.sign(t, "k1", map[string]any{
+		"iss":       ti.server.URL,
+		"client_id": testClient,
+		"exp":       time.Now().Add(time.Hour).Unix(),
+		"token_use": "access",
+	})
+
+	if _, err := jv.Verify(access); err != nil {
+		t.Errorf("Access token rejected: %s", err)
+	}
+}
+
+func TestJWTReject(t *testing.T) {
+	ti := newTestIssuer(t)
+	jv := ti.verifier()
+
+	good := ti.token(t, "foo@bar.com")
+	parts := strings.Split(good, ".")
+
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	forger := testIssuer{key: other, server: ti.server}
+
+	checks := map[string]string{
+		"expired": ti.sign(t, "k1", map[string]any{"iss": ti.server.URL, "aud": testClient, "token_use": "id", "exp": time.Now().Add(-time.Minute).Unix()}),
+		"issuer":  ti.sign(t, "k1", map[string]any{"iss": "https://elsewhere", "aud": testClient, "token_use": "id", "exp": time.Now().Add(time.Hour).Unix()}),
+		"client":  ti.sign(t, "k1", map[string]any{"iss": ti.server.URL, "aud": "other", "token_use": "id", "exp": time.Now().Add(time.Hour).Unix()}),
+		"key":     ti.sign(t, "k2", map[string]any{"iss": ti.server.URL, "aud": testClient, "token_use": "id", "exp": time.Now().Add(time.Hour).Unix()}),
+		"use":     ti.sign(t, "k1", map[string]any{"iss": ti.server.URL, "aud": testClient, "exp": time.Now().Add(time.Hour).Unix()}),
+		"access":  ti.sign(t, "k1", map[string]any{"iss": ti.server.URL, "aud": testClient, "token_use": "access", "exp": time.Now().Add(time.Hour).Unix()}),
+		"forged":  forger.token(t, "foo@bar.com"),
+		"payload": parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"cognito:username":"evil@bar.com"}`)) + "." + parts[2],
+		"none":    base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + ".",
+		"garbage": "not.a.token",
+		"empty":   "",
+	}
+
+	for name, token := range checks {
+		if _, err := jv.Verify(token); err == nil {
+			t.Errorf("%s token accepted", name)
+		}
+	}
+}
+
+func TestJWTKeyRefetch(t *testing.T) {
+	ti := newTestIssuer(t)
+	jv := ti.verifier()
+	now := time.Now()
+	jv.now = func() time.Time { return now }
+
+	unknown := ti.sign(t, "k2", map[string]any{"iss": ti.server.URL, "aud": testClient, "token_use": "id", "exp": now.Add(time.Hour).Unix()})
+
+	for i := 0; i < 3; i++ {
+		if _, err := jv.Verify(unknown); err == nil {
+			t.Fatal("Unknown key accepted")
+		}
+	}
+
+	if _, err := jv.Verify(ti.token(t, "foo@bar.com")); err != nil || ti.fetches.Load() != 1 {
+		t.Fatalf("Key set fetched %d times, then %v", ti.fetches.Load(), err)
+	}
+
+	now = now.Add(keyRefetch)
+	jv.Verify(unknown)
+
+	if ti.fetches.Load() != 2 {
+		t.Errorf("Key set fetched %d times", ti.fetches.Load())
+	}
+}
+
+func TestCognitoIssuer(t *testing.T) {
+	if iss := cognito_issuer("eu-west-2_AbCd"); iss != "https://cognito-idp.eu-west-2.amazonaws.com/eu-west-2_AbCd" {
+		t.Errorf("Issuer is %s", iss)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	checks := [][3]string{
+		{"Bearer abc", "", "abc"},
+		{"abc", "def", "abc"},
+		{"", "def", "def"},
+	}
+
+	for _, c := range checks {
+		if token := bearer_token(c[0], c[1]); token != c[2] {
+			t.Errorf("Token from %q %q is %s not %s", c[0], c[1], token, c[2])
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Counters can have key=value labels, which lists of counters can be
+// filtered by with a selector like env=prod,team=infra.  A selector's terms
+// are key=value, key!=value or just key for any counter with the label, and
+// a counter has to match them all.  The group's label index is worked out
+// from its counters when it is asked for, so it never has values which no
+// counter has any more.
+
+// a counter can have up to this many labels
+const maxLabels = 32
+
+var (
+	labelKeyPattern   = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]{0,62}$`)
+	labelValuePattern = regexp.MustCompile(`^[A-Za-z0-9._/-]{0,63}$`)
+)
+
+// one term of a selector.  op is =, != or empty for a key which is there.
+type labelTerm struct {
+	key   string
+	op    string
+	value string
+}
+
+type LabelSelector []labelTerm
+
+// every label key of the group's counters, with the values they have
+type LabelIndex struct {
+	Group  string              `json:"group"`
+	Labels map[string][]string `json:"labels"`
+}
+
+func check_label(key string, value string) error {
+	if !labelKeyPattern.MatchString(key) {
+		return bad_request("label key %q must be letters, digits, ., _, / or - and up to 63 long", key)
+	}
+
+	if !labelValuePattern.MatchString(value) {
+		return bad_request("label %s can't be %q, a value is letters, digits, ., _, / or - and up to 63 long", key, value)
+	}
+
+	return nil
+}
+
+// labels from key=value pairs
+func parse_labels(pairs []string) (map[string]string, error) {
+	labels := map[string]string{}
+
+	for _, pair := range pairs {
+		key, value, found := strings.Cut(pair, "=")
+
+		if !found {
+			return nil, bad_request("label %q isn't key=value", pair)
+		}
+
+		if err := check_label(key, value); err != nil {
+			return nil, err
+		}
+
+		if _, dup := labels[key]; dup {
+			return nil, bad_request("label %s is given more than once", key)
+		}
+
+		labels[key] = value
+	}
+
+	if len(labels) > maxLabels {
+		return nil, bad_request("a counter can have up to %d labels, not %d", maxLabels, len(labels))
+	}
+
+	return labels, nil
+}
+
+func parse_selector(terms []string) (LabelSelector, error) {
+	var selector LabelSelector
+
+	for _, term := range terms {
+		lt := labelTerm{key: term}
+
+		if key, value, found := strings.Cut(term, "!="); found {
+			lt = labelTerm{key: key, op: "!=", value: value}
+		} else if key, value, found := strings.Cut(term, "="); found {
+			lt = labelTerm{key: key, op: "=", value: value}
+		}
+
+		if err := check_label(lt.key, lt.value); err != nil {
+			return nil, err
+		}
+
+		selector = append(selector, lt)
+	}
+
+	return selector, nil
+}
+
+func (ls LabelSelector) matches(labels map[string]string) bool {
+	for _, lt := range ls {
+		value, found := labels[lt.key]
+
+		switch {
+		case lt.op == "" && !found:
+			return false
+		case lt.op == "=" && (!found || value != lt.value):
+			return false
+		case lt.op == "!=" && found && value == lt.value:
+			return false
+		}
+	}
+
+	return true
+}
+
+// the selector as it would be in a query
+func (ls LabelSelector) String() string {
+	var terms []string
+
+	for _, lt := range ls {
+		terms = append(terms, lt.key+lt.op+lt.value)
+	}
+
+	return strings.Join(terms, ",")
+}
+
+func label_index(groupId string, counters []CountData) LabelIndex {
+	index := LabelIndex{Group: groupId, Labels: map[string][]string{}}
+	seen := map[string]bool{}
+
+	for _, cd := range counters {
+		for key, value := range cd.Labels {
+			if !seen[key+"="+value] {
+				seen[key+"="+value] = true
+				index.Labels[key] = append(index.Labels[key], value)
+			}
+		}
+	}
+
+	for _, values := range index.Labels {
+		sort.Strings(values)
+	}
+
+	return index
+}
+
+func setCounterLabels(ctx context.Context, req Request, dbo DataOperator, s Session, p setCounterLabelsParams) (Response, error) {
+	labels, err := parse_labels(p.Labels)
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	return dbo.CounterLabels(ctx, s, p.Id, labels)
+}
+
+func listGroupLabels(ctx context.Context, req Request, dbo DataOperator, s Session, p listGroupLabelsParams) (Response, error) {
+	return dbo.GroupLabels(ctx, s)
+}
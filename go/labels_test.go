@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLabels(t *testing.T) {
+	labels, err := parse_labels([]string{"env=prod", "team=infra", "note="})
+
+	checkError(t, err, nil)
+
+	if !reflect.DeepEqual(labels, map[string]string{"env": "prod", "team": "infra", "note": ""}) {
+		t.Errorf("Labels are %v", labels)
+	}
+
+	for _, bad := range [][]string{{"env"}, {"=prod"}, {"env=prod", "env=dev"}, {"env=pr od"}, {"-env=prod"}} {
+		if _, err := parse_labels(bad); err == nil {
+			t.Errorf("%v was let through", bad)
+		}
+	}
+}
+
+func TestSelector(t *testing.T) {
+	selector, err := parse_selector([]string{"env=prod", "team!=infra", "owner"})
+
+	checkError(t, err, nil)
+
+	if selector.String() != "env=prod,team!=infra,owner" {
+		t.Errorf("Selector is %s", selector)
+	}
+
+	for _, c := range []struct {
+		labels map[string]string
+		exp    bool
+	}{
+		{map[string]string{"env": "prod", "owner": "ann"}, true},
+		{map[string]string{"env": "prod", "owner": "", "team": "web"}, true},
+		{map[string]string{"env": "prod", "owner": "ann", "team": "infra"}, false},
+		{map[string]string{"env": "dev", "owner": "ann"}, false},
+		{map[string]string{"env": "prod"}, false},
+	} {
+		if selector.matches(c.labels) != c.exp {
+			t.Errorf("%v matched is not %t", c.labels, c.exp)
+		}
+	}
+
+	if !LabelSelector(nil).matches(nil) {
+		t.Error("Empty selector doesn't match")
+	}
+
+	if _, err := parse_selector([]string{"env==prod"}); err == nil {
+		t.Error("env==prod was let through")
+	}
+}
+
+func TestLabelIndex(t *testing.T) {
+	index := label_index("g", []CountData{
+		{Labels: map[string]string{"env": "prod", "team": "infra"}},
+		{Labels: map[string]string{"env": "dev"}},
+		{Labels: map[string]string{"env": "prod"}},
+		{},
+	})
+
+	if !reflect.DeepEqual(index.Labels, map[string][]string{"env": {"dev", "prod"}, "team": {"infra"}}) {
+		t.Errorf("Index is %v", index.Labels)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+// Logs are JSON lines written with log/slog.  Each request gets a logger in
+// its context which picks up the request ID, route, user and group as they
+// become known, so every line about a request can be found from the
+// X-Request-Id it was given back.
+
+const requestIdHeader = "X-Request-Id"
+
+// the request's logger.  A pointer so that attributes added further down,
+// like the user once the session is known, show up on the final request line.
+// route is the route it was dispatched on, which for an API key request is
+// only known once the key's route has been resolved.
+type requestLog struct {
+	logger *slog.Logger
+	route  string
+}
+
+type requestLogKey struct{}
+
+// LOG_LEVEL is debug, info, warn or error.  Info if it is anything else.
+func Create_Logger(level string) *slog.Logger {
+	var lv slog.Level
+
+	if err := lv.UnmarshalText([]byte(strings.TrimSpace(level))); err != nil {
+		lv = slog.LevelInfo
+	}
+
+	return slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: lv}))
+}
+
+// starts a request's logging with the given attributes
+func with_logger(ctx context.Context, args ...any) context.Context {
+	return context.WithValue(ctx, requestLogKey{}, &requestLog{logger: slog.Default().With(args...)})
+}
+
+// adds attributes to every later line for the request
+func add_log_attrs(ctx context.Context, args ...any) {
+	if rl, found := ctx.Value(requestLogKey{}).(*requestLog); found {
+		rl.logger = rl.logger.With(args...)
+	}
+}
+
+func set_route(ctx context.Context, route string) {
+	if rl, found := ctx.Value(requestLogKey{}).(*requestLog); found {
+		rl.route = route
+	}
+}
+
+func request_route(ctx context.Context) string {
+	if rl, found := ctx.Value(requestLogKey{}).(*requestLog); found {
+		return rl.route
+	}
+	return ""
+}
+
+func logger(ctx context.Context) *slog.Logger {
+	if rl, found := ctx.Value(requestLogKey{}).(*requestLog); found {
+		return rl.logger
+	}
+	return slog.Default()
+}
+
+// the lambda invocation's ID, for handlers which don't have an API gateway one
+func invocation_id(ctx context.Context) string {
+	if lc, found := lambdacontext.FromContext(ctx); found {
+		return lc.AwsRequestID
+	}
+	return ""
+}
+
+// runs an API request with its own logger and span, gives the request ID back
+// in X-Request-Id and logs and measures how it went.
+func serve_logged(ctx context.Context, req Request, ms MetricsSink, handle func(context.Context, Request) (Response, error)) (Response, error) {
+	start := time.Now()
+	id := req.RequestContext.RequestID
+
+	ctx, span := start_request_span(ctx, req)
+
+	if sc := span.SpanContext(); sc.HasTraceID() {
+		ctx = with_logger(ctx, "requestId", id, "traceId", sc.TraceID().String())
+	} else {
+		ctx = with_logger(ctx, "requestId", id)
+	}
+
+	set_route(ctx, req.RouteKey)
+
+	res, err := handle(ctx, req)
+	latency := time.Since(start)
+
+	end_request_span(ctx, span, res, err)
+
+	emit(ctx, ms, request_metrics(res, err, latency)...)
+
+	if res.Headers == nil {
+		res.Headers = map[string]string{}
+	}
+
+	res.Headers[requestIdHeader] = id
+
+	attrs := []any{"route", request_route(ctx), "status", res.StatusCode, "latencyMs", latency.Milliseconds()}
+
+	switch {
+	case err != nil:
+		logger(ctx).Error("request", append(attrs, "error", err)...)
+	case res.StatusCode >= 500:
+		logger(ctx).Error("request", append(attrs, "error", res.Body)...)
+	default:
+		logger(ctx).Info("request", attrs...)
+	}
+
+	return res, err
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// captures the default logger's lines for the test
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+
+	old := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(old) })
+
+	return &buf
+}
+
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	var lines []map[string]any
+
+	for _, l := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var line map[string]any
+
+		if err := json.Unmarshal([]byte(l), &line); err != nil {
+			t.Fatalf("Log line %q is not JSON: %s", l, err)
+		}
+
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+func TestCreateLogger(t *testing.T) {
+	for level, exp := range map[string]slog.Level{"debug": slog.LevelDebug, "WARN": slog.LevelWarn, " error ": slog.LevelError, "": slog.LevelInfo, "chatty": slog.LevelInfo} {
+		l := Create_Logger(level)
+
+		if !l.Enabled(context.Background(), exp) || (exp > slog.LevelDebug && l.Enabled(context.Background(), exp-1)) {
+			t.Errorf("LOG_LEVEL %q is not %s", level, exp)
+		}
+	}
+}
+
+func TestRequestLog(t *testing.T) {
+	buf := captureLog(t)
+
+	group := MakeUUID()
+	user := MakeUUID()
+
+	dbo := MockDataOperator{
+		apiKey: APIKeyData{
+			UserId:  user.String(),
+			GroupId: group.String(),
+			Rights:  []string{perm_read},
+		},
+	}
+
+	api := APIHandler{dbo: &dbo}
+
+	req := keyRequest("GET", "/api/v1/group/"+group.String()+"/counter", "ocd_key")
+	req.RequestContext.RequestID = "req-1"
+
+	res, err := api.private_handler_gatewayv2(context.TODO(), req)
+
+	checkError(t, err, nil)
+
+	if res.StatusCode != 200 || res.Headers[requestIdHeader] != "req-1" {
+		t.Errorf("Request gave %d with ID %q", res.StatusCode, res.Headers[requestIdHeader])
+	}
+
+	lines := logLines(t, buf)
+	last := lines[len(lines)-1]
+
+	if last["msg"] != "request" || last["level"] != "INFO" {
+		t.Errorf("Last line is %v", last)
+	}
+
+	for attr, exp := range map[string]any{"requestId": "req-1", "route": "GET /api/v1/group/{group}/counter", "userId": user.String(), "groupId": group.String(), "status": float64(200)} {
+		if last[attr] != exp {
+			t.Errorf("Logged %s %v not %v", attr, last[attr], exp)
+		}
+	}
+
+	if _, found := last["latencyMs"]; !found {
+		t.Error("Latency not logged")
+	}
+}
+
+func TestRequestLogError(t *testing.T) {
+	buf := captureLog(t)
+
+	res, _ := serve_logged(context.Background(), Request{}, nil, func(ctx context.Context, req Request) (Response, error) {
+		return Response{StatusCode: 500, Body: "broken"}, nil
+	})
+
+	lines := logLines(t, buf)
+
+	if lines[0]["level"] != "ERROR" || lines[0]["error"] != "broken" {
+		t.Errorf("Failed request logged as %v", lines[0])
+	}
+
+	if _, found := res.Headers[requestIdHeader]; !found {
+		t.Error("No request ID header on a failure")
+	}
+}
+
+func TestTransactionLog(t *testing.T) {
+	buf := captureLog(t)
+
+	s, dbo, _ := mockEnv(expUser, expGroup, "foo@bar.com")
+
+	dbo.dbi = timedDB{dbi: &MockDBInterface{}}
+
+	dbo.CounterUpdate(context.Background(), s, expCounterUUID, dnquery(dq_current, dq_inc), 1)
+
+	for _, line := range logLines(t, buf) {
+		if line["msg"] == "dynamodb transaction" {
+			ops := line["ops"].([]any)
+			op := ops[0].(map[string]any)
+
+			if op["op"] != "update" || !strings.HasPrefix(op["update"].(string), dnquery(dq_current, dq_inc)) || op["key"] != expCounterUUID.String()+"/Counter" {
+				t.Errorf("Transaction logged as %v", op)
+			}
+
+			return
+		}
+	}
+
+	t.Error("Transaction not logged")
+}
+
+func TestGatewayRequestId(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/v1/counter", nil)
+
+	req, _ := gateway_request(r)
+
+	if len(req.RequestContext.RequestID) == 0 {
+		t.Error("No request ID made up")
+	}
+
+	r.Header.Set(requestIdHeader, "caller-id")
+
+	if req, _ := gateway_request(r); req.RequestContext.RequestID != "caller-id" {
+		t.Errorf("Caller's request ID replaced with %s", req.RequestContext.RequestID)
+	}
+}
@@ -1,10 +1,17 @@
 package main
 
+// the route tables in api_handlers.go, and the functions serverless.yaml includes
+//go:generate go run ../cmd/apigen -api ../api/api.yaml -go api_handlers.go -sls ../serverless/sls_api_handlers.yaml
+
 import (
 	"errors"
+	"log"
+	"log/slog"
+	"net/http"
 	"os"
 
 	"github.com/aws/aws-lambda-go/lambda"
+	"go.opentelemetry.io/otel"
 )
 
 func unknownHandler() error {
@@ -12,27 +19,79 @@ func unknownHandler() error {
 }
 
 func main() {
+	slog.SetDefault(Create_Logger(os.Getenv("LOG_LEVEL")))
+
+	metrics := Create_MetricsSink(os.Getenv("METRICS_NAMESPACE"))
+
+	tp, terr := Create_TracerProvider(os.Getenv("TRACE_EXPORTER"))
+
+	if terr != nil {
+		log.Fatal(terr)
+	}
+
+	if tp != nil {
+		otel.SetTracerProvider(tp)
+	}
+
+	dbo := DynamoOperator{
+		counterTable:     os.Getenv("COUNTER_TABLE"),
+		groupTable:       os.Getenv("GROUP_TABLE"),
+		userTable:        os.Getenv("USER_TABLE"),
+		permissionTable:  os.Getenv("PERMISSION_TABLE"),
+		apiKeyTable:      os.Getenv("APIKEY_TABLE"),
+		webhookTable:     os.Getenv("WEBHOOK_TABLE"),
+		socketTable:      os.Getenv("SOCKET_TABLE"),
+		idempotencyTable: os.Getenv("IDEMPOTENCY_TABLE"),
+		userEmailIndex:   os.Getenv("USER_EMAIL_LOOKUP"),
+
+		dbi:     Create_DynamoDBInterface(),
+		metrics: metrics,
+
+		counterType: "Counter",
+		userType:    "User",
+		groupType:   "Group",
+		emailType:   "UserEmail",
+		apiKeyType:  "APIKey",
+		connType:    "Connection",
+	}
+
 	api := APIHandler{
-		dbo: DynamoOperator{
-			counterTable:    os.Getenv("COUNTER_TABLE"),
-			groupTable:      os.Getenv("GROUP_TABLE"),
-			userTable:       os.Getenv("USER_TABLE"),
-			permissionTable: os.Getenv("PERMISSION_TABLE"),
-			userEmailIndex:  os.Getenv("USER_EMAIL_LOOKUP"),
-
-			dbi: Create_DynamoDBInterface(),
-
-			counterType: "Counter",
-			userType:    "User",
-			groupType:   "Group",
-		},
+		dbo:     dbo,
+		metrics: metrics,
 	}
 
+	jwt := Create_JWTVerifier(os.Getenv("USER_POOL"), os.Getenv("USER_POOL_CLIENT"))
+
 	switch os.Getenv("_HANDLER") {
 	case "apipublic":
 		lambda.Start(api.public_handler_gatewayv2)
 	case "apiprivate":
 		lambda.Start(api.private_handler_gatewayv2)
+	case "streams":
+		sinks, err := Create_EventSinks(os.Getenv("EVENT_SINKS"), dbo, os.Getenv("EVENT_QUEUE_URL"), os.Getenv("SOCKET_ENDPOINT"))
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		lambda.Start(StreamHandler{sinks: sinks, counter: dbo.read_counter}.stream_handler)
+	case "socket":
+		lambda.Start(SocketHandler{dbo: dbo, jwt: jwt}.socket_handler)
+	case "server":
+		// there's no stream, so counter changes made through the server are pushed
+		// to its websockets directly and its webhooks are delivered in the background
+		hub := Create_SocketHub()
+		dbo.hooks = Create_WebhookDispatcher()
+		dbo.notify = SinkList{hub, AsyncSink{WebhookSink{dbo: dbo}}}
+		api.dbo = dbo
+
+		addr := os.Getenv("LISTEN_ADDR")
+
+		if addr == "" {
+			addr = ":8080"
+		}
+
+		log.Fatal(http.ListenAndServe(addr, Create_Server(api, jwt, hub)))
 	default:
 		lambda.Start(unknownHandler)
 	}
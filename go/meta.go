@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"regexp"
+)
+
+// How a counter is shown: what it counts, in what unit, its colour or icon
+// and how its number is written.  None of it changes how the counter counts.
+// It can be given when the counter is made, and a PUT of it replaces the lot.
+
+type CounterMeta struct {
+	Description string `json:"description,omitempty"`
+	Unit        string `json:"unit,omitempty"`
+
+	// a #rrggbb colour, and the name of an icon for clients which have them
+	Color string `json:"color,omitempty"`
+	Icon  string `json:"icon,omitempty"`
+
+	// plain, grouped (1,234), compact (1.2k), percent or duration (ms)
+	NumberFormat string `json:"numberFormat,omitempty"`
+}
+
+var colorPattern = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
+// the metadata from a route's parameters, which have checked the lengths
+// and the number format already
+func counter_meta(description string, unit string, color string, icon string, numberFormat string) (CounterMeta, error) {
+	meta := CounterMeta{
+		Description:  description,
+		Unit:         unit,
+		Color:        color,
+		Icon:         icon,
+		NumberFormat: numberFormat,
+	}
+
+	if color != "" && !colorPattern.MatchString(color) {
+		return meta, bad_request("color %q isn't #rrggbb", color)
+	}
+
+	return meta, nil
+}
+
+func setCounterMeta(ctx context.Context, req Request, dbo DataOperator, s Session, p setCounterMetaParams) (Response, error) {
+	meta, err := counter_meta(p.Description, p.Unit, p.Color, p.Icon, p.NumberFormat)
+
+	if err != nil {
+		return makeerror(err)
+	}
+
+	return dbo.CounterMeta(ctx, s, p.Id, meta)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCounterMetaRoutes(t *testing.T) {
+	mo := newMemoryOperator()
+	c := newV2Client(t, mo)
+
+	var group groupResource
+
+	c.do("POST", "/api/v2/groups", `{"name": "ops"}`, &group)
+
+	var counter counterResource
+
+	c.do("POST", group.Links["counters"], `{"name": "pages", "unit": "incidents", "numberFormat": "grouped"}`, &counter)
+
+	if counter.Unit != "incidents" || counter.NumberFormat != "grouped" {
+		t.Errorf("Created counter is %+v", counter)
+	}
+
+	v1 := "/api/v1/group/" + group.Id + "/counter/" + counter.Id
+
+	if res := c.do("PUT", v1+"/meta?description=out+of+hours&color=%23cc0000", "", nil); res.StatusCode != 200 {
+		t.Fatalf("Meta gave %d", res.StatusCode)
+	}
+
+	var cd CountData
+
+	c.do("GET", v1, "", &cd)
+
+	if cd.Description != "out of hours" || cd.Color != "#cc0000" || cd.Unit != "" {
+		t.Errorf("Counter is %+v", cd)
+	}
+
+	for _, query := range []string{"color=red", "numberFormat=fancy", "unit=" + strings.Repeat("x", 33)} {
+		if res := c.do("PUT", v1+"/meta?"+query, "", nil); res.StatusCode != 400 {
+			t.Errorf("%s gave %d", query, res.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Metrics are written to stdout in CloudWatch's Embedded Metric Format, which
+// the Lambda log agent turns into CloudWatch metrics with no API calls.  Each
+// request gives a count, its latency and its error class per route, and each
+// transaction its retries and the write capacity it used.
+
+const (
+	unitCount        = "Count"
+	unitMilliseconds = "Milliseconds"
+)
+
+type Metric struct {
+	Name  string
+	Unit  string
+	Value float64
+}
+
+// MetricsSink takes metrics with the dimensions they are recorded against.
+type MetricsSink interface {
+	Emit(ctx context.Context, dims map[string]string, metrics ...Metric)
+}
+
+// EMFSink writes each Emit as one EMF line.
+type EMFSink struct {
+	namespace string
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// nil, so no metrics, without METRICS_NAMESPACE
+func Create_MetricsSink(namespace string) MetricsSink {
+	if namespace == "" {
+		return nil
+	}
+	return &EMFSink{namespace: namespace, w: os.Stdout}
+}
+
+type emfMetric struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit"`
+}
+
+type emfDirective struct {
+	Namespace  string      `json:"Namespace"`
+	Dimensions [][]string  `json:"Dimensions"`
+	Metrics    []emfMetric `json:"Metrics"`
+}
+
+type emfMetadata struct {
+	Timestamp         int64          `json:"Timestamp"`
+	CloudWatchMetrics []emfDirective `json:"CloudWatchMetrics"`
+}
+
+func (es *EMFSink) Emit(ctx context.Context, dims map[string]string, metrics ...Metric) {
+	line, err := emf_line(es.namespace, time.Now(), dims, metrics)
+
+	if err != nil {
+		logger(ctx).Warn("metrics not written", "error", err)
+		return
+	}
+
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	es.w.Write(append(line, '\n'))
+}
+
+// the metrics and dimensions are top level members of the line, the
+// directive under "_aws" says which is which
+func emf_line(namespace string, at time.Time, dims map[string]string, metrics []Metric) ([]byte, error) {
+	names := make([]string, 0, len(dims))
+	line := map[string]any{}
+
+	for k, v := range dims {
+		names = append(names, k)
+		line[k] = v
+	}
+
+	sort.Strings(names)
+
+	directive := emfDirective{Namespace: namespace, Dimensions: [][]string{names}}
+
+	for _, m := range metrics {
+		directive.Metrics = append(directive.Metrics, emfMetric{Name: m.Name, Unit: m.Unit})
+		line[m.Name] = m.Value
+	}
+
+	line["_aws"] = emfMetadata{
+		Timestamp:         at.UnixMilli(),
+		CloudWatchMetrics: []emfDirective{directive},
+	}
+
+	return json.Marshal(line)
+}
+
+// metrics for the request's route, if anyone is taking them
+func emit(ctx context.Context, ms MetricsSink, metrics ...Metric) {
+	if ms == nil {
+		return
+	}
+
+	dims := map[string]string{}
+
+	if route := request_route(ctx); route != "" {
+		dims["Route"] = route
+	}
+
+	ms.Emit(ctx, dims, metrics...)
+}
+
+func bool_count(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Requests counts every request.  ClientErrors, ServerErrors and Timeouts
+// count the ones in each error class, so their sums over Requests are rates.
+func request_metrics(res Response, err error, latency time.Duration) []Metric {
+	status := res.StatusCode
+
+	if err != nil {
+		status = http.StatusInternalServerError
+	}
+
+	return []Metric{
+		{Name: "Requests", Unit: unitCount, Value: 1},
+		{Name: "Latency", Unit: unitMilliseconds, Value: float64(latency.Milliseconds())},
+		{Name: "ClientErrors", Unit: unitCount, Value: bool_count(status >= 400 && status < 500)},
+		{Name: "ServerErrors", Unit: unitCount, Value: bool_count(status >= 500 && status != http.StatusGatewayTimeout)},
+		{Name: "Timeouts", Unit: unitCount, Value: bool_count(status == http.StatusGatewayTimeout)},
+	}
+}
+
+// Transactions counts commits, which may be retried within the one commit,
+// and TransactionFailures the ones which didn't go through in the end.
+// A failed condition counts as a failure.
+func transaction_metrics(retries int, capacity []*dynamodb.ConsumedCapacity, latency time.Duration, err error) []Metric {
+	units := 0.0
+
+	for _, c := range capacity {
+		if c != nil && c.CapacityUnits != nil {
+			units += *c.CapacityUnits
+		}
+	}
+
+	return []Metric{
+		{Name: "Transactions", Unit: unitCount, Value: 1},
+		{Name: "TransactionRetries", Unit: unitCount, Value: float64(retries)},
+		{Name: "TransactionFailures", Unit: unitCount, Value: bool_count(err != nil)},
+		{Name: "TransactionLatency", Unit: unitMilliseconds, Value: float64(latency.Milliseconds())},
+		{Name: "ConsumedWriteCapacity", Unit: unitCount, Value: units},
+	}
+}
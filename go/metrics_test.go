@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestEMFSink(t *testing.T) {
+	var buf bytes.Buffer
+
+	es := EMFSink{namespace: "dynamocount", w: &buf}
+
+	es.Emit(context.Background(), map[string]string{"Route": "GET /x"}, Metric{Name: "Requests", Unit: unitCount, Value: 1}, Metric{Name: "Latency", Unit: unitMilliseconds, Value: 12})
+
+	var line struct {
+		AWS struct {
+			Timestamp         int64
+			CloudWatchMetrics []emfDirective
+		} `json:"_aws"`
+		Route    string
+		Requests float64
+		Latency  float64
+	}
+
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("Line %s is not JSON: %s", buf.String(), err)
+	}
+
+	if line.Route != "GET /x" || line.Requests != 1 || line.Latency != 12 {
+		t.Errorf("Line is %s", buf.String())
+	}
+
+	if time.Since(time.UnixMilli(line.AWS.Timestamp)) > time.Minute {
+		t.Errorf("Timestamp is %d", line.AWS.Timestamp)
+	}
+
+	d := line.AWS.CloudWatchMetrics[0]
+
+	if d.Namespace != "dynamocount" || len(d.Dimensions) != 1 || len(d.Dimensions[0]) != 1 || d.Dimensions[0][0] != "Route" {
+		t.Errorf("Directive is %v", d)
+	}
+
+	if len(d.Metrics) != 2 || d.Metrics[1] != (emfMetric{Name: "Latency", Unit: unitMilliseconds}) {
+		t.Errorf("Metrics are %v", d.Metrics)
+	}
+
+	if Create_MetricsSink("") != nil {
+		t.Error("Metrics on without a namespace")
+	}
+}
+
+func TestRequestMetrics(t *testing.T) {
+	group := MakeUUID()
+	ms := MockMetricsSink{}
+
+	dbo := MockDataOperator{
+		apiKey: APIKeyData{
+			UserId:  MakeUUID().String(),
+			GroupId: group.String(),
+			Rights:  []string{perm_read},
+		},
+	}
+
+	api := APIHandler{dbo: &dbo, metrics: &ms}
+
+	api.private_handler_gatewayv2(context.TODO(), keyRequest("GET", "/api/v1/group/"+group.String()+"/counter", "ocd_key"))
+	api.private_handler_gatewayv2(context.TODO(), keyRequest("GET", "/api/v1/nothing", "ocd_key"))
+
+	if len(ms.emitted) != 2 {
+		t.Fatalf("Emitted %v", ms.emitted)
+	}
+
+	// counted against the key's real route
+	if route := ms.emitted[0].dims["Route"]; route == keyProxyRoute || route == "" {
+		t.Errorf("Key request counted against %q", route)
+	}
+
+	if m := ms.emitted[0].metrics; m["Requests"] != 1 || m["ClientErrors"] != 0 || m["ServerErrors"] != 0 {
+		t.Errorf("Request gave %v", m)
+	}
+
+	if m := ms.emitted[1].metrics; m["ClientErrors"] != 1 || ms.emitted[1].dims["Route"] != keyProxyRoute {
+		t.Errorf("Missing route gave %v %v", ms.emitted[1].dims, m)
+	}
+}
+
+func TestErrorClasses(t *testing.T) {
+	class := func(status int, err error) map[string]float64 {
+		m := map[string]float64{}
+
+		for _, metric := range request_metrics(Response{StatusCode: status}, err, 0) {
+			m[metric.Name] = metric.Value
+		}
+
+		return m
+	}
+
+	if m := class(504, nil); m["Timeouts"] != 1 || m["ServerErrors"] != 0 {
+		t.Errorf("Timeout is %v", m)
+	}
+
+	if m := class(0, context.Canceled); m["ServerErrors"] != 1 {
+		t.Errorf("Handler error is %v", m)
+	}
+
+	if m := class(412, nil); m["ClientErrors"] != 1 || m["ServerErrors"] != 0 {
+		t.Errorf("412 is %v", m)
+	}
+}
+
+func TestTransactionMetrics(t *testing.T) {
+	noSleep(t)
+
+	s, dbo, dbi := mockEnv(expUser, expGroup, "foo@bar.com")
+
+	ms := MockMetricsSink{}
+	dbo.metrics = &ms
+
+	dbi.twErrs = []error{cancelledWith("TransactionConflict")}
+	dbi.two.ConsumedCapacity = []*dynamodb.ConsumedCapacity{
+		{TableName: aws.String("CounterTable"), CapacityUnits: aws.Float64(2)},
+		{TableName: aws.String("GroupTable"), CapacityUnits: aws.Float64(2)},
+	}
+
+	dbo.CounterUpdate(context.Background(), s, expCounterUUID, dnquery(dq_current, dq_inc), 1)
+
+	if *dbi.twi.ReturnConsumedCapacity != dynamodb.ReturnConsumedCapacityTotal {
+		t.Error("Consumed capacity not asked for")
+	}
+
+	if len(ms.emitted) != 1 {
+		t.Fatalf("Emitted %v", ms.emitted)
+	}
+
+	if m := ms.emitted[0].metrics; m["Transactions"] != 1 || m["TransactionRetries"] != 1 || m["TransactionFailures"] != 0 || m["ConsumedWriteCapacity"] != 4 {
+		t.Errorf("Transaction gave %v", m)
+	}
+
+	dbi.twErrs = []error{cancelledWith("ConditionalCheckFailed")}
+
+	dbo.CounterUpdate(context.Background(), s, expCounterUUID, dnquery(dq_current, dq_inc), 1)
+
+	if m := ms.emitted[1].metrics; m["TransactionFailures"] != 1 || m["ConsumedWriteCapacity"] != 0 {
+		t.Errorf("Failed transaction gave %v", m)
+	}
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"myhello/ocdctl"
+)
+
+// groups and counters kept in maps, enough for ocdctl to be run against the
+// in-process routes
+type memoryOperator struct {
+	*MockDataOperator
+
+	groups   map[UUID]*GroupData
+	counters map[UUID]*CountData
+}
+
+func newMemoryOperator() *memoryOperator {
+	return &memoryOperator{
+		MockDataOperator: &MockDataOperator{userId: MakeUUID()},
+		groups:           map[UUID]*GroupData{},
+		counters:         map[UUID]*CountData{},
+	}
+}
+
+func (mo *memoryOperator) GroupCreate(ctx context.Context, s Session, name string) (Response, error) {
+	id := MakeUUID()
+	mo.groups[id] = &GroupData{GroupId: id.String(), GroupName: name, Counters: []string{}, Version: 1}
+	return makeresponse(opResult{Success: true, Result: "OK", Id: id.String()})
+}
+
+func (mo *memoryOperator) GroupList(ctx context.Context, s Session) (Response, error) {
+	items := []string{}
+
+	for _, gd := range mo.groups {
+		items = append(items, gd.GroupId)
+	}
+
+	return makeresponse(opResult{Success: true, Result: "OK", Items: items})
+}
+
+func (mo *memoryOperator) GroupRead(ctx context.Context, s Session) (Response, error) {
+	if gd, found := mo.groups[*s.GetGroupId()]; found {
+		res, err := makeresponse(gd)
+		return with_etag(res, err, gd.Version)
+	}
+	return makeerror(fmt.Errorf("group not found"))
+}
+
+func (mo *memoryOperator) GroupReadMany(ctx context.Context, s Session, groupIds []UUID) (Response, error) {
+	groups := []GroupData{}
+
+	for _, id := range groupIds {
+		gd, found := mo.groups[id]
+
+		if !found {
+			return makeerror(fmt.Errorf("group not found"))
+		}
+
+		groups = append(groups, *gd)
+	}
+
+	return makeresponse(groups)
+}
+
+func (mo *memoryOperator) CounterCreate(ctx context.Context, s Session, created CountData) (Response, error) {
+	gd := mo.groups[*s.GetGroupId()]
+	id := MakeUUID()
+
+	if created.Expression != "" {
+		_, names, _ := parse_expression(created.Expression)
+		refs, err := resolve_references(names, mo.group_counters(gd))
+
+		if err != nil {
+			return makeerror(err)
+		}
+
+		created.References = refs
+	}
+
+	created.CounterId, created.CounterGroup, created.Version = id.String(), gd.GroupId, 1
+	mo.counters[id] = &created
+	gd.Counters = append(gd.Counters, id.String())
+
+	return makeresponse(opResult{Success: true, Result: "OK", Id: id.String()})
+}
+
+func (mo *memoryOperator) group_counters(gd *GroupData) []CountData {
+	var counters []CountData
+
+	for _, id := range gd.Counters {
+		if counterId, _ := ToUUID(id); mo.counters[counterId] != nil {
+			counters = append(counters, *mo.counters[counterId])
+		}
+	}
+
+	return counters
+}
+
+func (mo *memoryOperator) CounterList(ctx context.Context, s Session, selector LabelSelector) (Response, error) {
+	ids := []string{}
+
+	for _, id := range mo.groups[*s.GetGroupId()].Counters {
+		if counterId, _ := ToUUID(id); mo.counters[counterId] != nil && selector.matches(mo.counters[counterId].Labels) {
+			ids = append(ids, id)
+		}
+	}
+
+	return makeresponse(opResult{Success: true, Result: "OK", Items: ids})
+}
+
+func (mo *memoryOperator) CounterLabels(ctx context.Context, s Session, id UUID, labels map[string]string) (Response, error) {
+	cd, found := mo.counters[id]
+
+	if !found {
+		return makeerror(fmt.Errorf("counter not found"))
+	}
+
+	cd.Labels = labels
+	cd.Version++
+
+	return makeresponse(opResult{Success: true, Result: "OK", Id: id.String()})
+}
+
+func (mo *memoryOperator) CounterMeta(ctx context.Context, s Session, id UUID, meta CounterMeta) (Response, error) {
+	cd, found := mo.counters[id]
+
+	if !found {
+		return makeerror(fmt.Errorf("counter not found"))
+	}
+
+	cd.CounterMeta = meta
+	cd.Version++
+
+	return makeresponse(opResult{Success: true, Result: "OK", Id: id.String()})
+}
+
+func (mo *memoryOperator) CounterRead(ctx context.Context, s Session, counterId UUID) (Response, error) {
+	if cd, found := mo.counters[counterId]; found {
+		read := *cd
+
+		if read.Expression != "" {
+			counter := func(id string) (CountData, error) {
+				ref, _ := ToUUID(id)
+
+				if found := mo.counters[ref]; found != nil {
+					return *found, nil
+				}
+
+				return CountData{}, nil
+			}
+
+			group := func(groupId string) ([]CountData, error) { return mo.group_counters(mo.groups[*s.GetGroupId()]), nil }
+
+			new_derivation(counter, group).set(&read)
+		}
+
+		res, err := makeresponse(read)
+		return with_etag(res, err, cd.Version)
+	}
+	return makeerror(fmt.Errorf("counter not found"))
+}
+
+func (mo *memoryOperator) CounterReadMany(ctx context.Context, s Session, counterIds []UUID) (Response, error) {
+	counters := []CountData{}
+
+	for _, id := range counterIds {
+		cd, res, ok := response_data[CountData](mo.CounterRead(ctx, s, id))
+
+		if !ok {
+			return res, nil
+		}
+
+		counters = append(counters, cd)
+	}
+
+	return makeresponse(counters)
+}
+
+func (mo *memoryOperator) CounterUpdate(ctx context.Context, s Session, id UUID, query string, stepVal int) (Response, error) {
+	cd, found := mo.counters[id]
+
+	if !found {
+		return makeerror(fmt.Errorf("counter not found"))
+	}
+
+	if cd.Expression != "" {
+		return makeerror(conflict("counter %s is derived from others, so it can't be changed", id.String()))
+	}
+
+	next := cd.CounterVal
+
+	switch query {
+	case dnquery(dq_current, dq_inc):
+		next += cd.StepVal
+	case dnquery(dq_current, dq_dec):
+		next -= cd.StepVal
+	}
+
+	if (cd.MinVal != nil && next < *cd.MinVal) || (cd.MaxVal != nil && next > *cd.MaxVal) {
+		return makeerror(conflict("the counter can't go past its bounds"))
+	}
+
+	switch query {
+	case dnquery(dq_current, dq_inc), dnquery(dq_current, dq_dec):
+		cd.CounterVal = next
+	case dnquery(dq_current, dq_base):
+		cd.CounterVal = cd.Baseline
+	case dnquery(dq_init, dq_current):
+		cd.StepVal = stepVal
+	}
+
+	cd.Version++
+
+	return makeresponse(opResult{Success: true, Result: "OK", Id: id.String()})
+}
+
+func (mo *memoryOperator) CounterDelete(ctx context.Context, s Session, counterId UUID) (Response, error) {
+	delete(mo.counters, counterId)
+	return makeresponse(opResult{Success: true, Result: "OK", Id: counterId.String()})
+}
+
+type ocdctlRun struct {
+	t      *testing.T
+	env    map[string]string
+	stdout bytes.Buffer
+	stderr bytes.Buffer
+}
+
+func newOcdctlRun(t *testing.T, dbo DataOperator) *ocdctlRun {
+	ti := newTestIssuer(t)
+	srv := httptest.NewServer(Create_Server(APIHandler{dbo: dbo}, ti.verifier(), Create_SocketHub()))
+
+	t.Cleanup(srv.Close)
+
+	return &ocdctlRun{t: t, env: map[string]string{
+		"OCD_URL":    srv.URL,
+		"OCD_TOKEN":  ti.token(t, "foo@bar.com"),
+		"OCD_CONFIG": filepath.Join(t.TempDir(), "config.json"),
+	}}
+}
+
+// what the command printed, failing the test if it didn't succeed
+func (r *ocdctlRun) run(args ...string) string {
+	r.t.Helper()
+
+	if status := r.status(args...); status != 0 {
+		r.t.Fatalf("ocdctl %s exited %d: %s", strings.Join(args, " "), status, r.stderr.String())
+	}
+
+	return r.stdout.String()
+}
+
+func (r *ocdctlRun) status(args ...string) int {
+	r.stdout.Reset()
+	r.stderr.Reset()
+
+	cli := ocdctl.CLI{
+		Stdout: &r.stdout,
+		Stderr: &r.stderr,
+		Getenv: func(k string) string { return r.env[k] },
+	}
+
+	return cli.Run(context.Background(), args)
+}
+
+func TestOcdctlCounters(t *testing.T) {
+	mo := newMemoryOperator()
+	r := newOcdctlRun(t, mo)
+
+	r.run("group", "create", "team")
+	r.run("counter", "create", "coffee", "-g", "team")
+	r.run("-g", "team", "counter", "inc", "coffee")
+	r.run("counter", "-g", "team", "step", "coffee", "5")
+	r.run("counter", "inc", "coffee", "-g", "team")
+
+	out := r.run("counter", "ls", "-g", "team")
+
+	if lines := strings.Split(strings.TrimSpace(out), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[0], "NAME") || strings.Join(strings.Fields(lines[1])[:3], " ") != "coffee 6 5" {
+		t.Errorf("Counters are\n%s", out)
+	}
+
+	var counters []struct {
+		Id    string `json:"objectUUID"`
+		Value int    `json:"countVal"`
+	}
+
+	if err := json.Unmarshal([]byte(r.run("counter", "ls", "-g", "team", "-json")), &counters); err != nil || len(counters) != 1 || counters[0].Value != 6 {
+		t.Fatalf("JSON counters are %v %s", counters, err)
+	}
+
+	// by ID as well as by name
+	r.run("counter", "dec", counters[0].Id, "-g", "team")
+
+	if out := r.run("counter", "watch", "coffee", "-g", "team", "-n", "1"); !strings.HasSuffix(out, "coffee  1\n") {
+		t.Errorf("Watch printed %q", out)
+	}
+
+	r.run("counter", "rm", "coffee", "-g", "team")
+
+	if len(mo.counters) != 0 {
+		t.Errorf("Counters left are %v", mo.counters)
+	}
+}
+
+// increments a counter every time one is read, without touching the others
+type bumpingOperator struct {
+	*memoryOperator
+	bump UUID
+}
+
+func (bo bumpingOperator) CounterRead(ctx context.Context, s Session, counterId UUID) (Response, error) {
+	bo.counters[bo.bump].CounterVal++
+	return bo.memoryOperator.CounterRead(ctx, s, counterId)
+}
+
+func TestOcdctlWatchDerived(t *testing.T) {
+	mo := newMemoryOperator()
+	r := newOcdctlRun(t, mo)
+
+	r.run("group", "create", "team")
+	r.run("counter", "create", "coffee", "-g", "team")
+	r.run("counter", "create", "double", "-g", "team")
+
+	var coffee, double UUID
+
+	for id, cd := range mo.counters {
+		if cd.CounterName == "coffee" {
+			coffee = id
+		} else {
+			double = id
+		}
+	}
+
+	mo.counters[double].Expression = "coffee * 2"
+	mo.counters[double].References = map[string]string{"coffee": coffee.String()}
+	r = newOcdctlRun(t, bumpingOperator{memoryOperator: mo, bump: coffee})
+
+	// the derived counter's version never moves on, but its value does
+	out := r.run("counter", "watch", "double", "-g", "team", "-n", "2", "-interval", "1ms")
+
+	if lines := strings.Split(strings.TrimSpace(out), "\n"); len(lines) != 2 || lines[0][strings.LastIndex(lines[0], " "):] == lines[1][strings.LastIndex(lines[1], " "):] {
+		t.Errorf("Watch printed %q", out)
+	}
+}
+
+func TestOcdctlNames(t *testing.T) {
+	r := newOcdctlRun(t, newMemoryOperator())
+
+	r.run("group", "create", "team")
+	r.run("group", "create", "team")
+
+	if r.status("counter", "ls", "-g", "team") != 1 || !strings.Contains(r.stderr.String(), "2 groups are called team") {
+		t.Errorf("Ambiguous group gave %q", r.stderr.String())
+	}
+
+	if r.status("counter", "ls", "-g", "nope") != 1 || !strings.Contains(r.stderr.String(), "no group nope") {
+		t.Errorf("Missing group gave %q", r.stderr.String())
+	}
+
+	if r.status("counter", "inc") != 2 || !strings.Contains(r.stderr.String(), "need a group") {
+		t.Errorf("No group gave %q", r.stderr.String())
+	}
+
+	delete(r.env, "OCD_TOKEN")
+
+	if r.status("group", "ls") != 1 || !strings.Contains(r.stderr.String(), "not logged in") {
+		t.Errorf("No token gave %q", r.stderr.String())
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The OpenAPI 3 document is put together from api_routes, which is generated
+// from api/api.yaml along with the handler maps, so it can't drift from the
+// routes actually served.  Response schemas come from the Go types the
+// handlers respond with.
+
+const openapiVersion = "3.0.3"
+
+type apiRoute struct {
+	method     string
+	path       string
+	endpoint   string
+	response   string
+	private    bool
+	authorized bool
+	params     []apiParam
+}
+
+type loginResult struct {
+	Result string
+	Token  string
+}
+
+type signupResult struct {
+	Result string
+	Id     string
+}
+
+// the types a route's response can name
+var api_schemas = map[string]any{
+	"CountData":    CountData{},
+	"opResult":     opResult{},
+	"APIKeyData":   APIKeyData{},
+	"apiKeyResult": apiKeyResult{},
+	"WebhookData":  WebhookData{},
+	"DeliveryData": DeliveryData{},
+	"GroupData":    GroupData{},
+	"loginResult":  loginResult{},
+	"signupResult": signupResult{},
+	"Counter":      counterResource{},
+	"Group":        groupResource{},
+	"CounterList":  collection[counterResource]{},
+	"GroupList":    collection[groupResource]{},
+	"ExportData":   ExportData{},
+	"ImportResult": ImportResult{},
+	"TemplateData": TemplateData{},
+	"LabelIndex":   LabelIndex{},
+	"OpenAPI":      map[string]any{},
+}
+
+var pathParamPattern = regexp.MustCompile(`\{([^}+]+)\+?\}`)
+
+func openapi(ctx context.Context, req Request, dbo DataOperator) (Response, error) {
+	return makeresponse(openapi_document())
+}
+
+func openapi_document() map[string]any {
+	paths := map[string]map[string]any{}
+	schemas := map[string]any{
+		"Error": map[string]any{
+			"type":        "string",
+			"description": "what went wrong, as plain text",
+		},
+	}
+
+	for _, r := range api_routes {
+		// the API key proxy takes any method, which OpenAPI has no way to say.
+		// Its routes are the JWT routes under /key.
+		if r.method == "ANY" {
+			continue
+		}
+
+		path := pathParamPattern.ReplaceAllString(r.path, "{$1}")
+
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+
+		paths[path][strings.ToLower(r.method)] = route_operation(r, schemas)
+	}
+
+	return map[string]any{
+		"openapi": openapiVersion,
+		"info": map[string]any{
+			"title":   "dynamocount",
+			"version": "1",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"jwt": map[string]any{
+					"type":         "http",
+					"scheme":       "bearer",
+					"bearerFormat": "JWT",
+				},
+			},
+		},
+	}
+}
+
+func route_operation(r apiRoute, schemas map[string]any) map[string]any {
+	var params []map[string]any
+
+	body := map[string]any{"type": "object", "properties": map[string]any{}}
+	var bodyRequired []string
+
+	for _, ap := range r.params {
+		if ap.in == "body" {
+			body["properties"].(map[string]any)[ap.name] = param_schema(ap)
+
+			if ap.required {
+				bodyRequired = append(bodyRequired, ap.name)
+			}
+
+			continue
+		}
+
+		p := map[string]any{
+			"name":     ap.name,
+			"in":       ap.in,
+			"required": ap.required,
+			"schema":   param_schema(ap),
+		}
+
+		// lists are comma separated
+		if ap.list {
+			p["style"] = "form"
+			p["explode"] = false
+		}
+
+		if ap.description != "" {
+			p["description"] = ap.description
+		}
+
+		params = append(params, p)
+	}
+
+	if r.private && r.method != "GET" {
+		params = append(params, map[string]any{
+			"name":        "Idempotency-Key",
+			"in":          "header",
+			"description": "a retry with the same key gets the first response back",
+			"schema":      map[string]any{"type": "string", "maxLength": maxIdempotencyKey},
+		})
+	}
+
+	response := r.response
+
+	if response == "" {
+		response = "opResult"
+	}
+
+	op := map[string]any{
+		"operationId": r.endpoint,
+		"responses": map[string]any{
+			"200": map[string]any{
+				"description": "OK",
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": response_schema(response, schemas)},
+				},
+			},
+			"400": map[string]any{
+				"description": "a parameter is missing or not what the route takes",
+				"content": map[string]any{
+					"text/plain": map[string]any{"schema": schema_ref("Error")},
+				},
+			},
+			"default": map[string]any{
+				"description": "an error",
+				"content": map[string]any{
+					"text/plain": map[string]any{"schema": schema_ref("Error")},
+				},
+			},
+		},
+	}
+
+	if params != nil {
+		op["parameters"] = params
+	}
+
+	if len(body["properties"].(map[string]any)) > 0 {
+		if bodyRequired != nil {
+			body["required"] = bodyRequired
+		}
+
+		op["requestBody"] = map[string]any{
+			"required": bodyRequired != nil,
+			"content":  map[string]any{"application/json": map[string]any{"schema": body}},
+		}
+	}
+
+	if r.authorized {
+		op["security"] = []map[string][]string{{"jwt": {}}}
+	}
+
+	return op
+}
+
+// the schema of a parameter's value, or of an array of them for a list
+func param_schema(ap apiParam) map[string]any {
+	var s map[string]any
+
+	switch ap.kind {
+	case "uuid":
+		s = map[string]any{"type": "string", "format": "uuid"}
+	case "integer":
+		s = map[string]any{"type": "integer"}
+		add_limits(s, ap, "minimum", "maximum")
+	case "enum":
+		s = map[string]any{"type": "string", "enum": ap.values}
+	default:
+		s = map[string]any{"type": "string"}
+		add_limits(s, ap, "minLength", "maxLength")
+	}
+
+	if ap.list {
+		return map[string]any{"type": "array", "items": s}
+	}
+
+	return s
+}
+
+func add_limits(s map[string]any, ap apiParam, min string, max string) {
+	if ap.min != nil {
+		s[min] = *ap.min
+	}
+
+	if ap.max != nil {
+		s[max] = *ap.max
+	}
+}
+
+func schema_ref(name string) map[string]any {
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+// a reference to the named schema, which is added to schemas if it isn't there
+func response_schema(name string, schemas map[string]any) map[string]any {
+	if item, isList := strings.CutPrefix(name, "[]"); isList {
+		return map[string]any{"type": "array", "items": response_schema(item, schemas)}
+	}
+
+	if _, found := schemas[name]; !found {
+		schemas[name] = type_schema(reflect.TypeOf(api_schemas[name]))
+	}
+
+	return schema_ref(name)
+}
+
+// the JSON schema of what encoding/json makes of a value of type t
+func type_schema(t reflect.Type) map[string]any {
+	if t == nil {
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := type_schema(t.Elem())
+		s["nullable"] = true
+		return s
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": type_schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": type_schema(t.Elem())}
+	case reflect.Struct:
+		props := map[string]any{}
+		var required []string
+
+		add_fields(t, props, &required)
+
+		s := map[string]any{"type": "object", "properties": props}
+
+		if len(required) > 0 {
+			sort.Strings(required)
+			s["required"] = required
+		}
+
+		return s
+	}
+
+	return map[string]any{}
+}
+
+// fields of embedded structs are the outer struct's, as encoding/json has them
+func add_fields(t reflect.Type, props map[string]any, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			add_fields(f.Type, props, required)
+			continue
+		}
+
+		if !f.IsExported() {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+
+		if name == "-" && opts == "" {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		props[name] = type_schema(f.Type)
+
+		if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// the document as a client would read it
+func openapiDoc(t *testing.T) map[string]any {
+	api := APIHandler{dbo: &MockDataOperator{}}
+
+	res, err := api.public_handler_gatewayv2(context.TODO(), Request{RouteKey: "GET /openapi.json"})
+
+	checkError(t, err, nil)
+
+	if res.StatusCode != 200 {
+		t.Fatalf("/openapi.json gave %d %s", res.StatusCode, res.Body)
+	}
+
+	var doc map[string]any
+
+	if jerr := json.Unmarshal([]byte(res.Body), &doc); jerr != nil {
+		t.Fatalf("Document is not JSON: %s", jerr)
+	}
+
+	return doc
+}
+
+func lookup(t *testing.T, v any, keys ...string) any {
+	for _, k := range keys {
+		m, isMap := v.(map[string]any)
+
+		if !isMap {
+			t.Fatalf("No %s in %v", k, v)
+		}
+
+		v = m[k]
+	}
+
+	return v
+}
+
+func TestOpenAPIRoutes(t *testing.T) {
+	doc := openapiDoc(t)
+
+	if doc["openapi"] != openapiVersion {
+		t.Errorf("Document is OpenAPI %v", doc["openapi"])
+	}
+
+	paths := doc["paths"].(map[string]any)
+
+	if _, found := paths["/key/{proxy}"]; found {
+		t.Error("API key proxy route documented")
+	}
+
+	step := lookup(t, paths, "/api/v1/group/{group}/counter/{id}/step", "post").(map[string]any)
+
+	if step["operationId"] != "setCounterStep" {
+		t.Errorf("Step is operation %v", step["operationId"])
+	}
+
+	params := map[string]map[string]any{}
+
+	for _, p := range step["parameters"].([]any) {
+		pm := p.(map[string]any)
+		params[pm["in"].(string)+" "+pm["name"].(string)] = pm
+	}
+
+	if p := params["query stepVal"]; p == nil || p["required"] != true || lookup(t, p, "schema", "type") != "integer" {
+		t.Errorf("stepVal is %v", p)
+	}
+
+	if p := params["path id"]; p == nil || lookup(t, p, "schema", "format") != "uuid" {
+		t.Errorf("id is %v", p)
+	}
+
+	if params["header Idempotency-Key"] == nil {
+		t.Error("No Idempotency-Key on a change")
+	}
+
+	if step["security"] == nil {
+		t.Error("Private route has no security")
+	}
+
+	for _, unsecured := range []string{"/loopua", "/login"} {
+		if op := lookup(t, paths, unsecured, "get").(map[string]any); op["security"] != nil {
+			t.Errorf("%s needs a JWT", unsecured)
+		}
+	}
+
+	if ref := lookup(t, paths, "/api/v1/group/{group}/counter/{id}", "get", "responses", "200", "content", "application/json", "schema", "$ref"); ref != "#/components/schemas/CountData" {
+		t.Errorf("Counter read responds with %v", ref)
+	}
+
+	if ref := lookup(t, paths, "/api/v1/group/{group}/counter/{id}", "delete", "responses", "default", "content", "text/plain", "schema", "$ref"); ref != "#/components/schemas/Error" {
+		t.Errorf("Counter delete fails with %v", ref)
+	}
+
+	for _, p := range lookup(t, paths, "/api/v1/apikey/{name}", "post", "parameters").([]any) {
+		pm := p.(map[string]any)
+
+		switch pm["name"] {
+		case "rights":
+			if pm["explode"] != false || lookup(t, pm, "schema", "type") != "array" || len(lookup(t, pm, "schema", "items", "enum").([]any)) != 7 {
+				t.Errorf("rights are %v", pm)
+			}
+		case "name":
+			if lookup(t, pm, "schema", "maxLength") != 128.0 {
+				t.Errorf("name is %v", pm)
+			}
+		}
+	}
+
+	if items := lookup(t, paths, "/api/v1/group/{group}/webhook/{id}/delivery", "get", "responses", "200", "content", "application/json", "schema", "items", "$ref"); items != "#/components/schemas/DeliveryData" {
+		t.Errorf("Deliveries are %v", items)
+	}
+}
+
+func TestOpenAPISchemas(t *testing.T) {
+	schemas := lookup(t, openapiDoc(t), "components", "schemas")
+
+	if ty := lookup(t, schemas, "CountData", "properties", "countVal", "type"); ty != "integer" {
+		t.Errorf("countVal is %v", ty)
+	}
+
+	// secrets aren't sent so aren't in the schema
+	if props := lookup(t, schemas, "WebhookData", "properties").(map[string]any); props["Secret"] != nil || props["secret"] != nil {
+		t.Errorf("Webhook schema has the secret: %v", props)
+	}
+
+	// embedded opResult
+	if props := lookup(t, schemas, "apiKeyResult", "properties").(map[string]any); props["Key"] == nil || props["Success"] == nil {
+		t.Errorf("API key result is %v", props)
+	}
+
+	if lookup(t, schemas, "WebhookData", "properties", "threshold", "nullable") != true {
+		t.Error("threshold not nullable")
+	}
+}
+
+func TestOpenAPIComplete(t *testing.T) {
+	documented := map[string]bool{}
+
+	for _, r := range api_routes {
+		key := r.method + " " + r.path
+
+		if documented[key] {
+			t.Errorf("%s is in the route table twice", key)
+		}
+
+		documented[key] = true
+
+		if _, found := api_schemas[strings.TrimPrefix(r.response, "[]")]; r.response != "" && !found {
+			t.Errorf("%s responds with unknown schema %s", key, r.response)
+		}
+	}
+
+	for route := range private_handlers {
+		if !documented[route] {
+			t.Errorf("%s not in the route table", route)
+		}
+	}
+
+	for route := range public_handlers {
+		if !documented[route] {
+			t.Errorf("%s not in the route table", route)
+		}
+	}
+}
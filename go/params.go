@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"unicode/utf8"
+)
+
+// The path, query and body parameters of each route are declared in
+// api/api.yaml.  The generated parse_<handler> funcs read them into a struct
+// with a paramReader, and with_params hands the handler the struct, so a bad
+// parameter is a 400 before the handler runs.
+
+type apiParam struct {
+	name string
+
+	// path, query or body
+	in string
+
+	// uuid, integer, string or enum
+	kind     string
+	required bool
+
+	// comma separated in a path or query, an array in the body
+	list bool
+
+	// bounds of an integer or a string's length
+	min *int
+	max *int
+
+	// an enum's values
+	values []string
+
+	description string
+}
+
+func limit(n int) *int {
+	return &n
+}
+
+func with_params[P any](parse func(Request) (P, error), handle func(context.Context, Request, DataOperator, Session, P) (Response, error)) func(context.Context, Request, DataOperator, Session) (Response, error) {
+	return func(ctx context.Context, req Request, dbo DataOperator, s Session) (Response, error) {
+		p, perr := parse(req)
+
+		if perr != nil {
+			return makeerror(perr)
+		}
+
+		return handle(ctx, req, dbo, s, p)
+	}
+}
+
+func with_public_params[P any](parse func(Request) (P, error), handle func(context.Context, Request, DataOperator, P) (Response, error)) func(context.Context, Request, DataOperator) (Response, error) {
+	return func(ctx context.Context, req Request, dbo DataOperator) (Response, error) {
+		p, perr := parse(req)
+
+		if perr != nil {
+			return makeerror(perr)
+		}
+
+		return handle(ctx, req, dbo, p)
+	}
+}
+
+// reads a request's parameters, keeping the first thing wrong with them
+type paramReader struct {
+	req Request
+
+	// the body's fields, read when the first body parameter is
+	body     map[string]json.RawMessage
+	bodyRead bool
+
+	first error
+}
+
+func new_param_reader(req Request) *paramReader {
+	return &paramReader{req: req}
+}
+
+func (pr *paramReader) err() error {
+	return pr.first
+}
+
+func (pr *paramReader) fail(ap apiParam, format string, args ...any) {
+	if pr.first == nil {
+		pr.first = bad_request("%s parameter %s %s", ap.in, ap.name, fmt.Sprintf(format, args...))
+	}
+}
+
+// the parameter's values as text, none if it isn't there
+func (pr *paramReader) values(ap apiParam) []string {
+	var values []string
+
+	switch ap.in {
+	case "path":
+		values = text_values(ap, pr.req.PathParameters[ap.name])
+	case "query":
+		values = text_values(ap, pr.req.QueryStringParameters[ap.name])
+	case "body":
+		values = pr.body_values(ap)
+	}
+
+	if len(values) == 0 && ap.required {
+		pr.fail(ap, "is missing")
+	}
+
+	return values
+}
+
+func text_values(ap apiParam, text string) []string {
+	if ap.list {
+		return split_list(text)
+	}
+
+	if text == "" {
+		return nil
+	}
+
+	return []string{text}
+}
+
+func (pr *paramReader) body_values(ap apiParam) []string {
+	if !pr.bodyRead {
+		pr.bodyRead = true
+		pr.read_body()
+	}
+
+	raw, found := pr.body[ap.name]
+
+	if !found || string(raw) == "null" {
+		return nil
+	}
+
+	var items []json.RawMessage
+
+	if ap.list {
+		if json.Unmarshal(raw, &items) != nil {
+			pr.fail(ap, "must be an array")
+			return nil
+		}
+	} else {
+		items = []json.RawMessage{raw}
+	}
+
+	var values []string
+
+	// strings are unquoted and numbers kept as they are written, for the
+	// same parsing as the query, but each has to be the JSON type its kind is
+	for _, item := range items {
+		var s string
+		var n float64
+
+		switch {
+		case ap.kind == "integer" && json.Unmarshal(item, &n) != nil:
+			pr.fail(ap, "must be a number, not %s", bytes.TrimSpace(item))
+			return nil
+		case ap.kind == "integer":
+			s = string(bytes.TrimSpace(item))
+		case json.Unmarshal(item, &s) != nil:
+			pr.fail(ap, "must be a string, not %s", bytes.TrimSpace(item))
+			return nil
+		}
+
+		values = append(values, s)
+	}
+
+	return values
+}
+
+func (pr *paramReader) read_body() {
+	data, derr := request_body(pr.req)
+
+	if derr != nil {
+		pr.first = derr
+		return
+	}
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		return
+	}
+
+	if json.Unmarshal(data, &pr.body) != nil && pr.first == nil {
+		pr.first = bad_request("request body isn't a JSON object")
+	}
+}
+
+func (pr *paramReader) strs(ap apiParam) []string {
+	values := pr.values(ap)
+
+	for _, v := range values {
+		switch {
+		case ap.kind == "enum" && !slices.Contains(ap.values, v):
+			pr.fail(ap, "can't be %s, it is one of %v", v, ap.values)
+		case ap.min != nil && utf8.RuneCountInString(v) < *ap.min:
+			pr.fail(ap, "must be at least %d characters", *ap.min)
+		case ap.max != nil && utf8.RuneCountInString(v) > *ap.max:
+			pr.fail(ap, "must be at most %d characters", *ap.max)
+		}
+	}
+
+	return values
+}
+
+func (pr *paramReader) str(ap apiParam) (string, bool) {
+	values := pr.strs(ap)
+
+	if len(values) == 0 {
+		return "", false
+	}
+
+	return values[0], true
+}
+
+func (pr *paramReader) uuids(ap apiParam) []UUID {
+	var ids []UUID
+
+	for _, v := range pr.values(ap) {
+		if id, err := ToUUID(v); err != nil {
+			pr.fail(ap, "must be a UUID, not %s", v)
+		} else {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+func (pr *paramReader) uuid(ap apiParam) (UUID, bool) {
+	ids := pr.uuids(ap)
+
+	if len(ids) == 0 {
+		return UUID{}, false
+	}
+
+	return ids[0], true
+}
+
+func (pr *paramReader) integers(ap apiParam) []int {
+	var ns []int
+
+	for _, v := range pr.values(ap) {
+		n, err := strconv.Atoi(v)
+
+		switch {
+		case err != nil:
+			pr.fail(ap, "must be an integer, not %s", v)
+		case ap.min != nil && n < *ap.min:
+			pr.fail(ap, "must be at least %d", *ap.min)
+		case ap.max != nil && n > *ap.max:
+			pr.fail(ap, "must be at most %d", *ap.max)
+		default:
+			ns = append(ns, n)
+		}
+	}
+
+	return ns
+}
+
+func (pr *paramReader) integer(ap apiParam) (int, bool) {
+	ns := pr.integers(ap)
+
+	if len(ns) == 0 {
+		return 0, false
+	}
+
+	return ns[0], true
+}
+
+// the request's body, which API gateway may have base64 encoded
+func request_body(req Request) ([]byte, error) {
+	if !req.IsBase64Encoded {
+		return []byte(req.Body), nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(req.Body)
+
+	if err != nil {
+		return nil, bad_request("request body isn't base64")
+	}
+
+	return data, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParamReader(t *testing.T) {
+	id := MakeUUID()
+
+	req := Request{
+		PathParameters:        map[string]string{"id": id.String()},
+		QueryStringParameters: map[string]string{"n": "7", "tags": "a, b,,c", "blank": ""},
+		Body:                  `{"name": "tea", "count": 3, "ids": ["` + id.String() + `"]}`,
+	}
+
+	pr := new_param_reader(req)
+
+	if got, found := pr.uuid(apiParam{name: "id", in: "path", kind: "uuid", required: true}); !found || got != id {
+		t.Errorf("id is %v", got)
+	}
+
+	if got, found := pr.integer(apiParam{name: "n", in: "query", kind: "integer", max: limit(10)}); !found || got != 7 {
+		t.Errorf("n is %d", got)
+	}
+
+	if got := pr.strs(apiParam{name: "tags", in: "query", kind: "enum", list: true, values: []string{"a", "b", "c"}}); !slices.Equal(got, []string{"a", "b", "c"}) {
+		t.Errorf("tags are %v", got)
+	}
+
+	if _, found := pr.str(apiParam{name: "blank", in: "query", kind: "string"}); found {
+		t.Error("Empty parameter found")
+	}
+
+	if got, _ := pr.str(apiParam{name: "name", in: "body", kind: "string"}); got != "tea" {
+		t.Errorf("Body name is %s", got)
+	}
+
+	if got, _ := pr.integer(apiParam{name: "count", in: "body", kind: "integer"}); got != 3 {
+		t.Errorf("Body count is %d", got)
+	}
+
+	if got := pr.uuids(apiParam{name: "ids", in: "body", kind: "uuid", list: true}); len(got) != 1 || got[0] != id {
+		t.Errorf("Body ids are %v", got)
+	}
+
+	checkError(t, pr.err(), nil)
+
+	req.Body = base64.StdEncoding.EncodeToString([]byte(`{"name": "coffee"}`))
+	req.IsBase64Encoded = true
+
+	if got, _ := new_param_reader(req).str(apiParam{name: "name", in: "body", kind: "string"}); got != "coffee" {
+		t.Errorf("Encoded body name is %s", got)
+	}
+}
+
+func TestParamErrors(t *testing.T) {
+	req := Request{
+		PathParameters:        map[string]string{"id": "nope"},
+		QueryStringParameters: map[string]string{"n": "11", "name": "", "kind": "x"},
+		Body:                  "[1]",
+	}
+
+	for _, tc := range []struct {
+		ap  apiParam
+		exp string
+	}{
+		{apiParam{name: "id", in: "path", kind: "uuid", required: true}, "path parameter id must be a UUID, not nope"},
+		{apiParam{name: "n", in: "query", kind: "integer", max: limit(10)}, "query parameter n must be at most 10"},
+		{apiParam{name: "name", in: "query", kind: "string", required: true}, "query parameter name is missing"},
+		{apiParam{name: "kind", in: "query", kind: "enum", values: []string{"a", "b"}}, "query parameter kind can't be x, it is one of [a b]"},
+		{apiParam{name: "kind", in: "query", kind: "string", min: limit(2)}, "query parameter kind must be at least 2 characters"},
+		{apiParam{name: "body", in: "body", kind: "string"}, "request body isn't a JSON object"},
+	} {
+		check_param_error(t, req, tc.ap, tc.exp)
+	}
+
+	// body values have to be the JSON type of their kind
+	req.Body = `{"name": 5, "count": "3", "ids": [true], "kind": {"a": 1}}`
+
+	for _, tc := range []struct {
+		ap  apiParam
+		exp string
+	}{
+		{apiParam{name: "name", in: "body", kind: "string"}, "body parameter name must be a string, not 5"},
+		{apiParam{name: "count", in: "body", kind: "integer"}, `body parameter count must be a number, not "3"`},
+		{apiParam{name: "ids", in: "body", kind: "uuid", list: true}, "body parameter ids must be a string, not true"},
+		{apiParam{name: "kind", in: "body", kind: "enum", values: []string{"a"}}, `body parameter kind must be a string, not {"a": 1}`},
+	} {
+		check_param_error(t, req, tc.ap, tc.exp)
+	}
+}
+
+func check_param_error(t *testing.T, req Request, ap apiParam, exp string) {
+	t.Helper()
+
+	pr := new_param_reader(req)
+
+	switch ap.kind {
+	case "uuid":
+		pr.uuids(ap)
+	case "integer":
+		pr.integers(ap)
+	default:
+		pr.strs(ap)
+	}
+
+	err := pr.err()
+
+	if err == nil || err.Error() != exp {
+		t.Errorf("%s gave %v not %s", ap.name, err, exp)
+		return
+	}
+
+	if res, _ := makeerror(err); res.StatusCode != 400 {
+		t.Errorf("%s is a %d", ap.name, res.StatusCode)
+	}
+}
+
+// a bad parameter is a 400 and the handler isn't run
+func TestRouteParams(t *testing.T) {
+	group := MakeUUID()
+	counter := MakeUUID()
+
+	dbo := MockDataOperator{
+		apiKey: APIKeyData{
+			UserId:  MakeUUID().String(),
+			GroupId: group.String(),
+			Rights:  []string{perm_config, perm_create},
+		},
+	}
+
+	api := APIHandler{dbo: &dbo}
+	prefix := "/api/v1/group/" + group.String() + "/counter/"
+
+	check := func(req Request, expCode int, expBody string, expCall string) {
+		dbo.funcName = nil
+
+		res, err := api.private_handler_gatewayv2(context.TODO(), req)
+
+		checkError(t, err, nil)
+
+		if res.StatusCode != expCode || !strings.Contains(res.Body, expBody) {
+			t.Errorf("%s gave %d %s", req.PathParameters["proxy"], res.StatusCode, res.Body)
+		}
+
+		if last := dbo.funcName[len(dbo.funcName)-1]; (expCall == "") != (last == "APIKeyVerify") || (expCall != "" && last != expCall) {
+			t.Errorf("%s called %v", req.PathParameters["proxy"], dbo.funcName)
+		}
+	}
+
+	step := keyRequest("POST", prefix+counter.String()+"/step", "ocd_key")
+	step.QueryStringParameters = map[string]string{"stepVal": "5"}
+
+	check(step, 200, "", "CounterUpdate")
+
+	step.QueryStringParameters["stepVal"] = "five"
+	check(step, 400, "query parameter stepVal must be an integer, not five", "")
+
+	check(keyRequest("POST", prefix+"c1/step", "ocd_key"), 400, "path parameter id must be a UUID, not c1", "")
+
+	create := keyRequest("POST", prefix+"coffee", "ocd_key")
+	create.QueryStringParameters = map[string]string{"shards": "33"}
+
+	check(create, 400, "query parameter shards must be at most 32", "")
+}
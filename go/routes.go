@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+)
+
+// finds the route in a handler map which matches a method and a concrete path,
+// and pulls out the path parameters.  API gateway does this for us in lambda, but
+// requests which arrive on a catch-all route or outside API gateway need it.
+// Literal path segments win over parameters when more than one route matches.
+func match_route[T any](handlers map[string]T, method string, path string) (string, map[string]string, bool) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	best := ""
+	bestScore := -1
+	var bestParams map[string]string
+
+	for route := range handlers {
+		rmethod, rpath, _ := strings.Cut(route, " ")
+
+		if rmethod != method && rmethod != "ANY" {
+			continue
+		}
+
+		params, score, ok := match_path(strings.Split(strings.Trim(rpath, "/"), "/"), segments)
+
+		if ok && (score > bestScore || (score == bestScore && route < best)) {
+			best, bestScore, bestParams = route, score, params
+		}
+	}
+
+	return best, bestParams, bestScore >= 0
+}
+
+func match_path(template []string, segments []string) (map[string]string, int, bool) {
+	params := map[string]string{}
+	score := 0
+
+	for i, t := range template {
+		if strings.HasPrefix(t, "{") && strings.HasSuffix(t, "+}") {
+			if i >= len(segments) {
+				return nil, 0, false
+			}
+			params[t[1:len(t)-2]] = strings.Join(segments[i:], "/")
+			return params, score, true
+		}
+
+		if i >= len(segments) {
+			return nil, 0, false
+		}
+
+		if strings.HasPrefix(t, "{") && strings.HasSuffix(t, "}") {
+			if segments[i] == "" {
+				return nil, 0, false
+			}
+			params[t[1:len(t)-1]] = segments[i]
+		} else if t == segments[i] {
+			score++
+		} else {
+			return nil, 0, false
+		}
+	}
+
+	if len(template) != len(segments) {
+		return nil, 0, false
+	}
+
+	return params, score, true
+}
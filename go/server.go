@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/gorilla/websocket"
+)
+
+// Server runs the API outside lambda, e.g. on a laptop or in a container.
+// Requests are turned into the API gateway events the handlers expect, with
+// the route matched and the JWT checked here instead of by API gateway.
+// Websocket clients connect to /ws.
+type Server struct {
+	api      APIHandler
+	jwt      *JWTVerifier
+	hub      *SocketHub
+	upgrader websocket.Upgrader
+}
+
+const socketPath = "/ws"
+
+func Create_Server(api APIHandler, jwt *JWTVerifier, hub *SocketHub) *Server {
+	return &Server{
+		api: api,
+		jwt: jwt,
+		hub: hub,
+		upgrader: websocket.Upgrader{
+			// clients authenticate with a token, not cookies, so any origin is fine
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+	}
+}
+
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == socketPath {
+		srv.serve_socket(w, r)
+		return
+	}
+
+	res, err := srv.serve_api(r)
+
+	if err != nil {
+		res = Response{StatusCode: http.StatusInternalServerError, Body: err.Error()}
+	}
+
+	write_response(w, res)
+}
+
+func (srv *Server) serve_api(r *http.Request) (Response, error) {
+	req, rerr := gateway_request(r)
+
+	if rerr != nil {
+		return Response{StatusCode: http.StatusBadRequest, Body: rerr.Error()}, nil
+	}
+
+	if route, params, found := match_route(public_handlers, r.Method, r.URL.Path); found {
+		req.RouteKey, req.PathParameters = route, params
+		return srv.api.public_handler_gatewayv2(r.Context(), req)
+	}
+
+	route, params, found := match_route(private_handlers, r.Method, r.URL.Path)
+
+	if !found {
+		return Response{StatusCode: http.StatusNotFound, Body: "route " + r.URL.Path + " not found"}, nil
+	}
+
+	req.RouteKey, req.PathParameters = route, params
+
+	// requests without a token can still use an API key
+	if token := bearer_token(r.Header.Get("Authorization"), ""); token != "" {
+		claims, verr := srv.jwt.Verify(token)
+
+		if verr != nil {
+			return Response{StatusCode: http.StatusUnauthorized, Body: verr.Error()}, nil
+		}
+
+		req.RequestContext.Authorizer = &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+			JWT: &events.APIGatewayV2HTTPRequestContextAuthorizerJWTDescription{Claims: claims},
+		}
+	}
+
+	return srv.api.private_handler_gatewayv2(r.Context(), req)
+}
+
+// the parts of the API gateway event the handlers use.  Header names are
+// lower cased, as API gateway does.
+func gateway_request(r *http.Request) (Request, error) {
+	body, err := io.ReadAll(r.Body)
+
+	if err != nil {
+		return Request{}, err
+	}
+
+	req := Request{
+		RawPath:               r.URL.Path,
+		RawQueryString:        r.URL.RawQuery,
+		Headers:               map[string]string{},
+		QueryStringParameters: map[string]string{},
+		Body:                  string(body),
+	}
+
+	// API gateway makes up a request ID, so do the same unless the caller has one
+	req.RequestContext.RequestID = r.Header.Get(requestIdHeader)
+
+	if req.RequestContext.RequestID == "" {
+		req.RequestContext.RequestID = MakeUUID().String()
+	}
+
+	req.RequestContext.HTTP.Method = r.Method
+	req.RequestContext.HTTP.Path = r.URL.Path
+
+	for k, v := range r.Header {
+		req.Headers[strings.ToLower(k)] = strings.Join(v, ",")
+	}
+
+	for k, v := range r.URL.Query() {
+		req.QueryStringParameters[k] = strings.Join(v, ",")
+	}
+
+	return req, nil
+}
+
+func write_response(w http.ResponseWriter, res Response) {
+	for k, v := range res.Headers {
+		w.Header().Set(k, v)
+	}
+
+	body := []byte(res.Body)
+
+	if res.IsBase64Encoded {
+		if decoded, err := base64.StdEncoding.DecodeString(res.Body); err == nil {
+			body = decoded
+		}
+	}
+
+	w.WriteHeader(res.StatusCode)
+	w.Write(body)
+}
+
+func (srv *Server) serve_socket(w http.ResponseWriter, r *http.Request) {
+	claims, verr := srv.jwt.Verify(bearer_token(r.Header.Get("Authorization"), r.URL.Query().Get("token")))
+
+	if verr != nil {
+		http.Error(w, verr.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	session, serr := claims_session(r.Context(), srv.api.dbo, claims, nil)
+
+	if serr != nil {
+		http.Error(w, serr.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	conn, uerr := srv.upgrader.Upgrade(w, r, nil)
+
+	if uerr != nil {
+		logger(r.Context()).Warn("websocket upgrade failed", "error", uerr)
+		return
+	}
+
+	c := srv.hub.join(session.userId)
+
+	go func() {
+		defer conn.Close()
+
+		for body := range c.send {
+			if err := conn.WriteMessage(websocket.TextMessage, body); err != nil {
+				srv.hub.leave(c)
+			}
+		}
+	}()
+
+	defer srv.hub.leave(c)
+
+	for {
+		_, data, err := conn.ReadMessage()
+
+		if err != nil {
+			return
+		}
+
+		srv.hub.message(r.Context(), srv.api.dbo, c, data)
+	}
+}
@@ -1,6 +1,9 @@
 package main
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+)
 
 // data type for the session.  Contains session specific parameters
 type APISession struct {
@@ -9,30 +12,76 @@ type APISession struct {
 	groupId       UUID
 	groupIdString *string
 	userEmail     *string
+
+	// set when the caller authenticated with an API key rather than a JWT
+	apiKey *APIKeyData
+
+	// version from the If-Match header
+	ifMatch *int
+
+	// from the Idempotency-Key header
+	idempotencyKey *IdempotencyKey
 }
 
-func Create_APISession(dbo DataOperator, req Request) (APISession, error) {
+func Create_APISession(ctx context.Context, dbo DataOperator, req Request) (s APISession, err error) {
+	ctx, span := tracer().Start(ctx, "Create_APISession")
+	defer func() { end_span(span, err) }()
+
+	s, err = request_session(ctx, dbo, req)
+
+	if err != nil {
+		return s, err
+	}
+
+	s.ifMatch, err = parse_etag(req.Headers["if-match"])
+
+	if err != nil {
+		return APISession{}, err
+	}
+
+	s.idempotencyKey, err = parse_idempotency_key(req.Headers["idempotency-key"], req)
+
+	if err != nil {
+		return APISession{}, err
+	}
+
+	return s, nil
+}
+
+func request_session(ctx context.Context, dbo DataOperator, req Request) (APISession, error) {
+	if key, haskey := api_key_header(req); haskey && req.RequestContext.Authorizer == nil {
+		return create_key_session(ctx, dbo, req, key)
+	}
+
 	if req.RequestContext.Authorizer == nil {
 		return APISession{}, fmt.Errorf("username is not in JWT claims")
 	}
 
-	email, hasemail := req.RequestContext.Authorizer.JWT.Claims["cognito:username"]
+	return claims_session(ctx, dbo, req.RequestContext.Authorizer.JWT.Claims, req.PathParameters)
+}
+
+// the session for a set of verified JWT claims, in the group from the path if there is one.
+// Websockets verify their own tokens and come in here too.
+func claims_session(ctx context.Context, dbo DataOperator, claims map[string]string, params map[string]string) (APISession, error) {
+	email, hasemail := claims["cognito:username"]
 
 	if !hasemail {
 		return APISession{}, fmt.Errorf("username is not in JWT claims")
 	}
 
-	uuid, uerror := dbo.LookupUserUUID(&email)
+	lctx, span := tracer().Start(ctx, "LookupUserUUID")
+	uuid, uerror := dbo.LookupUserUUID(lctx, &email)
+	end_span(span, uerror)
 
 	if uerror != nil {
 		return APISession{}, uerror
 	}
 
-	group, hasgrp := req.PathParameters["group"]
+	group, hasgrp := params["group"]
 
 	if hasgrp {
 		if groupId, gerr := ToUUID(group); gerr != nil {
-			return APISession{}, gerr
+			return APISession{}, bad_request("path parameter group must be a UUID, not %s", group)
 		} else {
 			return APISession{
 				userId:    uuid,
@@ -48,6 +97,42 @@ func Create_APISession(dbo DataOperator, req Request) (APISession, error) {
 	}, nil
 }
 
+// key sessions act as the key's owner but only ever in the key's group.
+func create_key_session(ctx context.Context, dbo DataOperator, req Request, key string) (APISession, error) {
+	kd, kerr := dbo.APIKeyVerify(ctx, key)
+
+	if kerr != nil {
+		return APISession{}, kerr
+	}
+
+	uuid, uerr := ToUUID(kd.UserId)
+
+	if uerr != nil {
+		return APISession{}, uerr
+	}
+
+	s := APISession{
+		userId: uuid,
+		apiKey: &kd,
+	}
+
+	if group, hasgrp := req.PathParameters["group"]; hasgrp {
+		if group != kd.GroupId {
+			return APISession{}, fmt.Errorf("API key is not valid for group %s", group)
+		}
+
+		groupId, gerr := ToUUID(group)
+
+		if gerr != nil {
+			return APISession{}, bad_request("path parameter group must be a UUID, not %s", group)
+		}
+
+		s.groupId = groupId
+	}
+
+	return s, nil
+}
+
 func (s APISession) GetUserId() *UUID {
 	return &s.userId
 }
@@ -71,3 +156,15 @@ func (s *APISession) GetGroupIdString() *string {
 	}
 	return s.groupIdString
 }
+
+func (s APISession) GetIfMatch() *int {
+	return s.ifMatch
+}
+
+func (s APISession) GetIdempotencyKey() *IdempotencyKey {
+	return s.idempotencyKey
+}
+
+func (s APISession) GetAPIKey() *APIKeyData {
+	return s.apiKey
+}
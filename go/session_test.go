@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
@@ -63,7 +64,7 @@ func TestCreateAPISessionFail1(t *testing.T) {
 
 	req := Request{}
 
-	_, err := Create_APISession(&dbo, req)
+	_, err := Create_APISession(context.Background(), &dbo, req)
 
 	if err == nil {
 		t.Fatalf("Expecting a fail.")
@@ -91,7 +92,7 @@ func TestCreateAPISessionFail1b(t *testing.T) {
 		},
 	}
 
-	_, err := Create_APISession(&dbo, req)
+	_, err := Create_APISession(context.Background(), &dbo, req)
 
 	if err == nil {
 		t.Fatalf("Expecting a fail.")
@@ -123,7 +124,7 @@ func TestCreateAPISessionFail2(t *testing.T) {
 		},
 	}
 
-	_, err := Create_APISession(&dbo, req)
+	_, err := Create_APISession(context.Background(), &dbo, req)
 
 	if err == nil {
 		t.Fatalf("Expecting a fail.")
@@ -155,7 +156,7 @@ func TestCreateAPISessionUIDOnly(t *testing.T) {
 		},
 	}
 
-	s, err := Create_APISession(&dbo, req)
+	s, err := Create_APISession(context.Background(), &dbo, req)
 
 	if err != nil {
 		t.Fatalf("Creation fail: %s", err.Error())
@@ -187,7 +188,7 @@ func TestCreateAPISessionUIDGIDFail(t *testing.T) {
 		},
 	}
 
-	_, err := Create_APISession(&dbo, req)
+	_, err := Create_APISession(context.Background(), &dbo, req)
 
 	if err == nil {
 		t.Fatalf("Expecting a fail.")
@@ -218,7 +219,7 @@ func TestCreateAPISessionUIDGID(t *testing.T) {
 		},
 	}
 
-	s, err := Create_APISession(&dbo, req)
+	s, err := Create_APISession(context.Background(), &dbo, req)
 
 	if err != nil {
 		t.Fatalf("Creation fail: %s", err.Error())
@@ -226,3 +227,40 @@ func TestCreateAPISessionUIDGID(t *testing.T) {
 
 	checkAPISession(t, &s, expUid, expGid)
 }
+
+func TestCreateAPISessionIfMatch(t *testing.T) {
+	dbo := MockDataOperator{
+		userId: MakeUUID(),
+	}
+
+	req := Request{
+		RequestContext: events.APIGatewayV2HTTPRequestContext{
+			Authorizer: &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+				JWT: &events.APIGatewayV2HTTPRequestContextAuthorizerJWTDescription{
+					Claims: map[string]string{
+						"cognito:username": "foo@bar.com",
+					},
+				},
+			},
+		},
+		Headers: map[string]string{
+			"if-match": `"5"`,
+		},
+	}
+
+	s, err := Create_APISession(context.Background(), &dbo, req)
+
+	if err != nil {
+		t.Fatalf("Creation fail: %s", err.Error())
+	}
+
+	if s.GetIfMatch() == nil || *s.GetIfMatch() != 5 {
+		t.Errorf("If-Match is %v", s.GetIfMatch())
+	}
+
+	req.Headers["if-match"] = "junk"
+
+	if _, err := Create_APISession(context.Background(), &dbo, req); err == nil {
+		t.Errorf("Bad If-Match accepted")
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// SocketHub keeps the standalone server's websocket clients and their
+// subscriptions in memory.  It is the server's event sink, so counter changes
+// made through the server are pushed straight to subscribers.
+type SocketHub struct {
+	mu      sync.Mutex
+	clients map[*hubClient]struct{}
+}
+
+// one websocket.  Everything sent to it goes through send so that only the
+// connection's writer goroutine touches the socket.
+type hubClient struct {
+	userId UUID
+	subs   map[string]Subscription
+	send   chan []byte
+}
+
+// pushes queued to a client which isn't reading.  Past this it is dropped.
+const hubClientBuffer = 64
+
+func Create_SocketHub() *SocketHub {
+	return &SocketHub{clients: map[*hubClient]struct{}{}}
+}
+
+func (h *SocketHub) join(userId UUID) *hubClient {
+	c := &hubClient{
+		userId: userId,
+		subs:   map[string]Subscription{},
+		send:   make(chan []byte, hubClientBuffer),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.clients[c] = struct{}{}
+
+	return c
+}
+
+// safe to call more than once.  Closing send stops the client's writer.
+func (h *SocketHub) leave(c *hubClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, found := h.clients[c]; found {
+		delete(h.clients, c)
+		close(c.send)
+	}
+}
+
+func (h *SocketHub) Publish(ctx context.Context, ev DomainEvent) error {
+	if ev.Counter == nil {
+		return nil
+	}
+
+	body, err := socket_push(*ev.Counter)
+
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for c := range h.clients {
+		if sub, found := c.subs[ev.Counter.GroupId]; found && sub.wants(*ev.Counter) {
+			h.deliver(c, body)
+		}
+	}
+
+	return nil
+}
+
+// call with the lock held
+func (h *SocketHub) deliver(c *hubClient, body []byte) {
+	select {
+	case c.send <- body:
+	default:
+		delete(h.clients, c)
+		close(c.send)
+	}
+}
+
+// handles a message from a client and queues the reply
+func (h *SocketHub) message(ctx context.Context, dbo DataOperator, c *hubClient, data []byte) {
+	var sm SocketMessage
+
+	err := json.Unmarshal(data, &sm)
+
+	if err == nil {
+		err = h.handle_message(ctx, dbo, c, sm)
+	}
+
+	reply, merr := json.Marshal(socket_reply(sm, err))
+
+	if merr != nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, found := h.clients[c]; found {
+		h.deliver(c, reply)
+	}
+}
+
+func (h *SocketHub) handle_message(ctx context.Context, dbo DataOperator, c *hubClient, sm SocketMessage) error {
+	sub, serr := sm.subscription()
+
+	if serr != nil {
+		return serr
+	}
+
+	switch sm.Action {
+	case sock_subscribe:
+		if err := dbo.SubscriptionCheck(ctx, c.userId, sub); err != nil {
+			return err
+		}
+
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		if _, found := c.subs[sub.GroupId]; !found && len(c.subs) >= maxSubscriptions {
+			return fmt.Errorf("connection already has %d subscriptions", len(c.subs))
+		}
+
+		c.subs[sub.GroupId] = sub
+
+		return nil
+
+	case sock_unsubscribe:
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		delete(c.subs, sub.GroupId)
+
+		return nil
+	}
+
+	return fmt.Errorf("unknown action %s", sm.Action)
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi"
+	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi/apigatewaymanagementapiiface"
+)
+
+// real API gateway management interface, for pushing to websocket connections.
+// The endpoint is the websocket API's https://<domain>/<stage> URL.
+
+func Create_SocketInterface(endpoint string) apigatewaymanagementapiiface.ApiGatewayManagementApiAPI {
+	sess := session.Must(session.NewSession())
+
+	svc := apigatewaymanagementapi.New(sess, aws.NewConfig().WithEndpoint(endpoint))
+
+	return apigatewaymanagementapiiface.ApiGatewayManagementApiAPI(svc)
+}
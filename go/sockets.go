@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi"
+)
+
+// Websocket clients, e.g. wall dashboards, subscribe to a group or to some of
+// its counters and get pushed counter events instead of polling.  The same
+// protocol runs over API gateway websockets and the standalone server's /ws.
+// Clients send
+//
+//	{"action": "subscribe", "group": "<uuid>", "counters": ["<uuid>", ...]}
+//	{"action": "unsubscribe", "group": "<uuid>"}
+//
+// and get a reply to each, then {"type": "counter", "counter": {...}} pushes.
+
+const (
+	sock_subscribe   = "subscribe"
+	sock_unsubscribe = "unsubscribe"
+)
+
+type SocketMessage struct {
+	Action   string   `json:"action"`
+	Group    string   `json:"group"`
+	Counters []string `json:"counters,omitempty"`
+}
+
+type SocketReply struct {
+	Type   string `json:"type"`
+	Action string `json:"action,omitempty"`
+	Group  string `json:"group,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type SocketPush struct {
+	Type    string       `json:"type"`
+	Counter CounterEvent `json:"counter"`
+}
+
+// counter events which are pushed to subscribers
+var socket_events = []string{ev_increment, ev_decrement, ev_reset, ev_delete}
+
+// a group, or just some counters in it if Counters is not empty
+type Subscription struct {
+	GroupId  string
+	Counters []string
+}
+
+func (sub Subscription) wants(ev CounterEvent) bool {
+	if ev.GroupId != sub.GroupId || !slices.Contains(socket_events, ev.Event) {
+		return false
+	}
+
+	return len(sub.Counters) == 0 || slices.Contains(sub.Counters, ev.CounterId)
+}
+
+func (sm SocketMessage) subscription() (Subscription, error) {
+	groupId, gerr := ToUUID(sm.Group)
+
+	if gerr != nil {
+		return Subscription{}, gerr
+	}
+
+	sub := Subscription{GroupId: groupId.String()}
+
+	for _, c := range sm.Counters {
+		counterId, cerr := ToUUID(c)
+
+		if cerr != nil {
+			return Subscription{}, cerr
+		}
+
+		sub.Counters = append(sub.Counters, counterId.String())
+	}
+
+	return sub, nil
+}
+
+func socket_reply(sm SocketMessage, err error) SocketReply {
+	reply := SocketReply{Type: "ack", Action: sm.Action, Group: sm.Group}
+
+	if err != nil {
+		reply.Type = "error"
+		reply.Error = err.Error()
+	}
+
+	return reply
+}
+
+func socket_push(ev CounterEvent) ([]byte, error) {
+	return json.Marshal(SocketPush{Type: "counter", Counter: ev})
+}
+
+// SocketHandler is the lambda for the API gateway websocket API.  Connections
+// and subscriptions are kept in dynamo, and the stream handler's websocket sink
+// does the pushing.
+type SocketHandler struct {
+	dbo DataOperator
+	jwt *JWTVerifier
+}
+
+type SocketRequest = events.APIGatewayWebsocketProxyRequest
+type SocketResponse = events.APIGatewayProxyResponse
+
+// replaced in tests
+var create_socket_interface = func(endpoint string) SocketInterface {
+	return Create_SocketInterface(endpoint)
+}
+
+func (sh SocketHandler) socket_handler(ctx context.Context, req SocketRequest) (SocketResponse, error) {
+	ctx = with_logger(ctx, "requestId", req.RequestContext.RequestID, "route", req.RequestContext.RouteKey, "connectionId", req.RequestContext.ConnectionID)
+
+	switch req.RequestContext.RouteKey {
+	case "$connect":
+		return sh.connect(ctx, req)
+	case "$disconnect":
+		if err := sh.dbo.ConnectionDelete(ctx, req.RequestContext.ConnectionID); err != nil {
+			logger(ctx).Warn("disconnect failed", "error", err)
+		}
+		return SocketResponse{StatusCode: 200}, nil
+	}
+
+	return sh.message(ctx, req)
+}
+
+// the token is checked once, here.  Subscriptions act as the connecting user.
+func (sh SocketHandler) connect(ctx context.Context, req SocketRequest) (SocketResponse, error) {
+	token := bearer_token(header_value(req.Headers, "Authorization"), req.QueryStringParameters["token"])
+
+	claims, verr := sh.jwt.Verify(token)
+
+	if verr != nil {
+		return SocketResponse{StatusCode: 401, Body: verr.Error()}, nil
+	}
+
+	session, serr := claims_session(ctx, sh.dbo, claims, nil)
+
+	if serr != nil {
+		return SocketResponse{StatusCode: 401, Body: serr.Error()}, nil
+	}
+
+	add_log_attrs(ctx, "userId", *session.GetUserIdString())
+
+	if err := sh.dbo.ConnectionCreate(ctx, req.RequestContext.ConnectionID, session.userId); err != nil {
+		return SocketResponse{StatusCode: 500, Body: err.Error()}, nil
+	}
+
+	return SocketResponse{StatusCode: 200}, nil
+}
+
+func (sh SocketHandler) message(ctx context.Context, req SocketRequest) (SocketResponse, error) {
+	var sm SocketMessage
+
+	err := json.Unmarshal([]byte(req.Body), &sm)
+
+	if err == nil {
+		err = sh.handle_message(ctx, req.RequestContext.ConnectionID, sm)
+	}
+
+	body, merr := json.Marshal(socket_reply(sm, err))
+
+	if merr != nil {
+		return SocketResponse{StatusCode: 500, Body: merr.Error()}, nil
+	}
+
+	endpoint := fmt.Sprintf("https://%s/%s", req.RequestContext.DomainName, req.RequestContext.Stage)
+
+	_, perr := create_socket_interface(endpoint).PostToConnection(&apigatewaymanagementapi.PostToConnectionInput{
+		ConnectionId: aws.String(req.RequestContext.ConnectionID),
+		Data:         body,
+	})
+
+	if perr != nil {
+		logger(ctx).Warn("socket reply failed", "error", perr)
+	}
+
+	return SocketResponse{StatusCode: 200}, nil
+}
+
+func (sh SocketHandler) handle_message(ctx context.Context, connectionId string, sm SocketMessage) error {
+	sub, serr := sm.subscription()
+
+	if serr != nil {
+		return serr
+	}
+
+	cd, cerr := sh.dbo.ConnectionRead(ctx, connectionId)
+
+	if cerr != nil {
+		return cerr
+	}
+
+	userId, uerr := ToUUID(cd.UserId)
+
+	if uerr != nil {
+		return uerr
+	}
+
+	switch sm.Action {
+	case sock_subscribe:
+		if err := sh.dbo.SubscriptionCheck(ctx, userId, sub); err != nil {
+			return err
+		}
+		return sh.dbo.SubscriptionCreate(ctx, connectionId, userId, sub)
+	case sock_unsubscribe:
+		groupId, _ := ToUUID(sub.GroupId)
+		return sh.dbo.SubscriptionDelete(ctx, connectionId, groupId)
+	}
+
+	return fmt.Errorf("unknown action %s", sm.Action)
+}
+
+// websocket event headers keep the client's capitalisation
+func header_value(headers map[string]string, name string) string {
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gorilla/websocket"
+)
+
+// remembers what was pushed to each connection.  Connections in gone have closed.
+type mockSockets struct {
+	posts map[string][]string
+	gone  map[string]bool
+}
+
+func (ms *mockSockets) PostToConnection(input *apigatewaymanagementapi.PostToConnectionInput) (*apigatewaymanagementapi.PostToConnectionOutput, error) {
+	if ms.gone[*input.ConnectionId] {
+		return nil, awserr.New(apigatewaymanagementapi.ErrCodeGoneException, "gone", nil)
+	}
+	if ms.posts == nil {
+		ms.posts = map[string][]string{}
+	}
+	ms.posts[*input.ConnectionId] = append(ms.posts[*input.ConnectionId], string(input.Data))
+	return &apigatewaymanagementapi.PostToConnectionOutput{}, nil
+}
+
+func mockSocketInterface(t *testing.T, ms *mockSockets) {
+	orig := create_socket_interface
+	create_socket_interface = func(endpoint string) SocketInterface { return ms }
+	t.Cleanup(func() { create_socket_interface = orig })
+}
+
+func TestSubscriptionWants(t *testing.T) {
+	c1, c2 := MakeUUID().String(), MakeUUID().String()
+
+	group := Subscription{GroupId: expGroup.String()}
+	one := Subscription{GroupId: expGroup.String(), Counters: []string{c1}}
+
+	checks := []struct {
+		sub    Subscription
+		ev     CounterEvent
+		expect bool
+	}{
+		{group, CounterEvent{Event: ev_increment, GroupId: expGroup.String(), CounterId: c2}, true},
+		{group, CounterEvent{Event: ev_reset, GroupId: MakeUUID().String(), CounterId: c2}, false},
+		{group, CounterEvent{Event: ev_step, GroupId: expGroup.String(), CounterId: c2}, false},
+		{one, CounterEvent{Event: ev_delete, GroupId: expGroup.String(), CounterId: c1}, true},
+		{one, CounterEvent{Event: ev_decrement, GroupId: expGroup.String(), CounterId: c2}, false},
+	}
+
+	for _, c := range checks {
+		if got := c.sub.wants(c.ev); got != c.expect {
+			t.Errorf("Subscription %v wants %v is %t", c.sub, c.ev, got)
+		}
+	}
+}
+
+func socketRequest(route string, body string) SocketRequest {
+	req := SocketRequest{Body: body}
+	req.RequestContext.RouteKey = route
+	req.RequestContext.ConnectionID = "conn1"
+	req.RequestContext.DomainName = "example.com"
+	req.RequestContext.Stage = "dev"
+	return req
+}
+
+func TestSocketHandler(t *testing.T) {
+	ti := newTestIssuer(t)
+
+	ms := mockSockets{}
+	mockSocketInterface(t, &ms)
+
+	dbo := MockDataOperator{userId: expUser}
+	sh := SocketHandler{dbo: &dbo, jwt: ti.verifier()}
+
+	req := socketRequest("$connect", "")
+	req.QueryStringParameters = map[string]string{"token": ti.token(t, "foo@bar.com")}
+
+	res, err := sh.socket_handler(context.TODO(), req)
+
+	checkError(t, err, nil)
+
+	if res.StatusCode != 200 || dbo.connection.UserId != expUser.String() {
+		t.Fatalf("Connect failed: %d %s", res.StatusCode, res.Body)
+	}
+
+	sub := `{"action":"subscribe","group":"` + expGroup.String() + `"}`
+
+	sh.socket_handler(context.TODO(), socketRequest("subscribe", sub))
+
+	checkCalls(t, dbo.funcName, []string{"LookupUserUUID", "ConnectionCreate", "ConnectionRead", "SubscriptionCheck", "SubscriptionCreate"})
+
+	if replies := ms.posts["conn1"]; len(replies) != 1 || !strings.Contains(replies[0], `"type":"ack"`) {
+		t.Errorf("Subscribe replies were %v", replies)
+	}
+
+	dbo.subErr = awserr.New("NOPE", "not in group", nil)
+	dbo.funcName = nil
+
+	sh.socket_handler(context.TODO(), socketRequest("subscribe", sub))
+
+	checkCalls(t, dbo.funcName, []string{"ConnectionRead", "SubscriptionCheck"})
+
+	if replies := ms.posts["conn1"]; len(replies) != 2 || !strings.Contains(replies[1], `"type":"error"`) {
+		t.Errorf("Refused subscribe replies were %v", replies)
+	}
+
+	dbo.funcName = nil
+
+	sh.socket_handler(context.TODO(), socketRequest("$disconnect", ""))
+
+	checkCalls(t, dbo.funcName, []string{"ConnectionDelete"})
+}
+
+func TestSocketConnectUnauthorized(t *testing.T) {
+	ti := newTestIssuer(t)
+
+	dbo := MockDataOperator{}
+	sh := SocketHandler{dbo: &dbo, jwt: ti.verifier()}
+
+	req := socketRequest("$connect", "")
+	req.Headers = map[string]string{"Authorization": "Bearer nonsense"}
+
+	res, _ := sh.socket_handler(context.TODO(), req)
+
+	if res.StatusCode != 401 {
+		t.Errorf("Connect with a bad token returned %d", res.StatusCode)
+	}
+
+	checkCalls(t, dbo.funcName, nil)
+}
+
+func TestSocketSink(t *testing.T) {
+	c1 := MakeUUID().String()
+
+	_, dbo, dbi := mockEnv(expUser, expGroup, "foo@bar.com")
+
+	for _, sd := range []SubscriptionData{
+		{GroupId: expGroup.String(), ConnectionId: "all"},
+		{GroupId: expGroup.String(), ConnectionId: "other", Counters: []string{MakeUUID().String()}},
+		{GroupId: expGroup.String(), ConnectionId: "closed"},
+	} {
+		item, _ := dynamodbattribute.MarshalMap(sd)
+		dbi.qo.Items = append(dbi.qo.Items, item)
+	}
+
+	dbi.gio.Item, _ = dynamodbattribute.MarshalMap(ConnectionData{ConnectionId: "closed", Groups: []string{expGroup.String()}})
+
+	ms := mockSockets{gone: map[string]bool{"closed": true}}
+
+	ss := SocketSink{dbo: dbo, sockets: &ms}
+
+	checkError(t, ss.Publish(context.Background(), DomainEvent{Kind: "Counter", Counter: &CounterEvent{Event: ev_increment, GroupId: expGroup.String(), CounterId: c1}}), nil)
+
+	if len(ms.posts["all"]) != 1 || len(ms.posts["other"]) != 0 {
+		t.Errorf("Pushes were %v", ms.posts)
+	}
+
+	if *dbi.qi.TableName != "SocketTable" || *dbi.qi.ExpressionAttributeValues[":prefix"].S != subscriptionTypePrefix {
+		t.Errorf("Wrong subscription query %v", dbi.qi)
+	}
+
+	// the closed connection and its subscription are removed
+	if len(dbi.twis) != 1 || len(dbi.twi.TransactItems) != 2 {
+		t.Fatalf("Closed connection not removed: %v", dbi.twis)
+	}
+
+	ms.posts = nil
+
+	ss.Publish(context.Background(), DomainEvent{Kind: "Counter", Counter: &CounterEvent{Event: ev_step, GroupId: expGroup.String(), CounterId: c1}})
+
+	if len(ms.posts) != 0 {
+		t.Errorf("Step change was pushed: %v", ms.posts)
+	}
+}
+
+func TestSubscriptionCheck(t *testing.T) {
+	_, dbo, dbi := mockEnv(expUser, expGroup, "foo@bar.com")
+
+	dbi.gio.Item, _ = dynamodbattribute.MarshalMap(UserData{UserId: expUser.String(), Groups: []string{expGroup.String()}})
+
+	checkError(t, dbo.SubscriptionCheck(context.Background(), expUser, Subscription{GroupId: expGroup.String()}), nil)
+
+	if err := dbo.SubscriptionCheck(context.Background(), expUser, Subscription{GroupId: MakeUUID().String()}); err == nil {
+		t.Error("Subscription to someone else's group allowed")
+	}
+
+	// the mock hands back the user record for the group read too, so the group has no counters
+	if err := dbo.SubscriptionCheck(context.Background(), expUser, Subscription{GroupId: expGroup.String(), Counters: []string{MakeUUID().String()}}); err == nil {
+		t.Error("Subscription to a counter outside the group allowed")
+	}
+}
+
+func TestSubscriptionCreate(t *testing.T) {
+	_, dbo, dbi := mockEnv(expUser, expGroup, "foo@bar.com")
+
+	dbi.gio.Item, _ = dynamodbattribute.MarshalMap(ConnectionData{ConnectionId: "conn1", UserId: expUser.String(), ExpiresAt: 1234})
+
+	checkError(t, dbo.SubscriptionCreate(context.Background(), "conn1", expUser, Subscription{GroupId: expGroup.String()}), nil)
+
+	checkOpsLen(t, dbi.twi.TransactItems, 2)
+
+	put := dbi.twi.TransactItems[0].Put
+
+	if *put.Item[objectTypeCol].S != subscription_type("conn1") || *put.Item[expiresAtCol].N != "1234" {
+		t.Errorf("Wrong subscription record %v", put.Item)
+	}
+
+	var groups []string
+
+	for i := 0; i < maxSubscriptions; i++ {
+		groups = append(groups, MakeUUID().String())
+	}
+
+	dbi.gio.Item, _ = dynamodbattribute.MarshalMap(ConnectionData{ConnectionId: "conn1", Groups: groups})
+
+	if err := dbo.SubscriptionCreate(context.Background(), "conn1", expUser, Subscription{GroupId: expGroup.String()}); err == nil {
+		t.Error("Subscription past the limit allowed")
+	}
+}
+
+func TestCounterNotify(t *testing.T) {
+	s, dbo, dbi := mockEnv(expUser, expGroup, "foo@bar.com")
+
+	ms := memorySink{}
+	dbo.notify = &ms
+
+	counterId := MakeUUID()
+
+	dbi.gio.Item, _ = dynamodbattribute.MarshalMap(CountData{CounterId: counterId.String(), CounterGroup: expGroup.String(), CounterVal: 3, StepVal: 1})
+
+	res, _ := dbo.CounterUpdate(context.Background(), s, counterId, dnquery(dq_current, dq_inc), 0)
+
+	if res.StatusCode != 200 || len(ms.events) != 1 || ms.events[0].Counter.Event != ev_increment {
+		t.Errorf("Notified of %v", ms.events)
+	}
+}
+
+func readSocket(t *testing.T, conn *websocket.Conn) map[string]any {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var msg map[string]any
+
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+
+	return msg
+}
+
+func TestServerSocket(t *testing.T) {
+	ti := newTestIssuer(t)
+
+	dbo := MockDataOperator{userId: expUser}
+	hub := Create_SocketHub()
+
+	srv := httptest.NewServer(Create_Server(APIHandler{dbo: &dbo}, ti.verifier(), hub))
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + socketPath
+
+	if _, _, err := websocket.DefaultDialer.Dial(url+"?token=nonsense", nil); err == nil {
+		t.Fatal("Websocket with a bad token connected")
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?token="+ti.token(t, "foo@bar.com"), nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	conn.WriteJSON(SocketMessage{Action: sock_subscribe, Group: expGroup.String()})
+
+	if reply := readSocket(t, conn); reply["type"] != "ack" {
+		t.Fatalf("Subscribe reply was %v", reply)
+	}
+
+	conn.WriteMessage(websocket.TextMessage, []byte("{"))
+
+	if reply := readSocket(t, conn); reply["type"] != "error" {
+		t.Fatalf("Garbage reply was %v", reply)
+	}
+
+	counterId := MakeUUID().String()
+
+	hub.Publish(context.Background(), DomainEvent{Kind: "Counter", Counter: &CounterEvent{Event: ev_step, GroupId: expGroup.String(), CounterId: counterId}})
+	hub.Publish(context.Background(), DomainEvent{Kind: "Counter", Counter: &CounterEvent{Event: ev_increment, GroupId: MakeUUID().String(), CounterId: counterId}})
+	hub.Publish(context.Background(), DomainEvent{Kind: "Counter", Counter: &CounterEvent{Event: ev_increment, GroupId: expGroup.String(), CounterId: counterId, NewVal: 4}})
+
+	push := readSocket(t, conn)
+
+	if counter, _ := push["counter"].(map[string]any); push["type"] != "counter" || counter["counterUUID"] != counterId || counter["newVal"] != float64(4) {
+		t.Errorf("Push was %v", push)
+	}
+}
+
+func TestServerAPI(t *testing.T) {
+	ti := newTestIssuer(t)
+
+	dbo := MockDataOperator{userId: expUser, expEmail: "foo@bar.com"}
+
+	srv := httptest.NewServer(Create_Server(APIHandler{dbo: &dbo}, ti.verifier(), Create_SocketHub()))
+	defer srv.Close()
+
+	counterId := MakeUUID()
+
+	req := httptest.NewRequest("POST", srv.URL+"/api/v1/group/"+expGroup.String()+"/counter/"+counterId.String()+"/increment", nil)
+	req.RequestURI = ""
+	req.Header.Set("Authorization", "Bearer "+ti.token(t, "foo@bar.com"))
+
+	resp, err := srv.Client().Do(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var res opResult
+
+	json.NewDecoder(resp.Body).Decode(&res)
+	resp.Body.Close()
+
+	if resp.StatusCode != 200 || res.Id != counterId.String() {
+		t.Errorf("Increment returned %d %v", resp.StatusCode, res)
+	}
+
+	checkCalls(t, dbo.funcName, []string{"LookupUserUUID", "CounterUpdate"})
+
+	resp, _ = srv.Client().Get(srv.URL + "/api/v1/group")
+	resp.Body.Close()
+
+	if resp.StatusCode == 200 {
+		t.Error("Request without a token succeeded")
+	}
+
+	resp, _ = srv.Client().Get(srv.URL + "/nowhere")
+	resp.Body.Close()
+
+	if resp.StatusCode != 404 {
+		t.Errorf("Unknown route returned %d", resp.StatusCode)
+	}
+}
+
+func TestConnectionDelete(t *testing.T) {
+	_, dbo, dbi := mockEnv(expUser, expGroup, "foo@bar.com")
+
+	other := MakeUUID().String()
+
+	dbi.gio.Item, _ = dynamodbattribute.MarshalMap(ConnectionData{ConnectionId: "conn1", Groups: []string{expGroup.String(), other}})
+
+	checkError(t, dbo.ConnectionDelete(context.Background(), "conn1"), nil)
+
+	checkOpsLen(t, dbi.twi.TransactItems, 3)
+
+	for i, group := range []string{expGroup.String(), other} {
+		key := dbi.twi.TransactItems[i].Delete.Key
+
+		if *key[groupIdCol].S != group || *key[objectTypeCol].S != subscription_type("conn1") {
+			t.Errorf("Wrong subscription deleted %v", key)
+		}
+	}
+
+	if key := dbi.twi.TransactItems[2].Delete.Key; *key[connectionIdCol].S != "conn1" || *key[objectTypeCol].S != "Connection" {
+		t.Errorf("Wrong connection deleted %v", key)
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// real SQS interface for the queue event sink.  Kept separate so the sink can be tested with a mock.
+
+func Create_SQSInterface() sqsiface.SQSAPI {
+	sess := session.Must(session.NewSession())
+
+	svc := sqs.New(sess)
+
+	return sqsiface.SQSAPI(svc)
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// The stream handler turns changes to the data table into domain events and
+// hands them to sinks, so that side effects happen outside the request path.
+// Delivery is at least once: a record which fails is retried, and every sink
+// sees it again.
+
+type GroupEvent struct {
+	Event           string   `json:"event"`
+	GroupId         string   `json:"groupUUID"`
+	GroupName       string   `json:"groupName"`
+	AddedCounters   []string `json:"addedCounters,omitempty"`
+	RemovedCounters []string `json:"removedCounters,omitempty"`
+	Time            string   `json:"time"`
+}
+
+type UserEvent struct {
+	Event         string   `json:"event"`
+	UserId        string   `json:"userUUID"`
+	UserName      string   `json:"userEmail"`
+	AddedGroups   []string `json:"addedGroups,omitempty"`
+	RemovedGroups []string `json:"removedGroups,omitempty"`
+	Time          string   `json:"time"`
+}
+
+// one change to the data table.  Exactly one of the pointers is set, matching Kind.
+type DomainEvent struct {
+	// the stream record's id, for receivers to drop the repeats a retry sends
+	Id      string        `json:"id,omitempty"`
+	Kind    string        `json:"kind"`
+	Counter *CounterEvent `json:"counter,omitempty"`
+	Group   *GroupEvent   `json:"group,omitempty"`
+	User    *UserEvent    `json:"user,omitempty"`
+}
+
+// EventSink receives the domain events decoded from the stream.
+type EventSink interface {
+	Publish(ctx context.Context, ev DomainEvent) error
+}
+
+type StreamHandler struct {
+	sinks []EventSink
+
+	// reads a counter with its whole count, for the events of sharded ones
+	counter func(ctx context.Context, counterId UUID) (CountData, error)
+}
+
+// Lambda retries from the first failed record, so there is no point carrying
+// on past one.  The records after it would only be published twice.  The
+// retried record goes to every sink again, even those it reached, so its
+// events carry the record's id for receivers to tell repeats by.
+func (sh StreamHandler) stream_handler(ctx context.Context, ev events.DynamoDBEvent) (events.DynamoDBEventResponse, error) {
+	var resp events.DynamoDBEventResponse
+
+	ctx = with_logger(ctx, "requestId", invocation_id(ctx))
+
+	for _, rec := range ev.Records {
+		if err := sh.handle_record(ctx, rec); err != nil {
+			logger(ctx).Error("stream record failed", "sequenceNumber", rec.Change.SequenceNumber, "error", err)
+			resp.BatchItemFailures = append(resp.BatchItemFailures, events.DynamoDBBatchItemFailure{
+				ItemIdentifier: rec.Change.SequenceNumber,
+			})
+			break
+		}
+	}
+
+	return resp, nil
+}
+
+func (sh StreamHandler) handle_record(ctx context.Context, rec events.DynamoDBEventRecord) error {
+	de, found, err := decode_record(rec)
+
+	// a record we can't decode will never decode, so retrying it would block the stream
+	if err != nil {
+		logger(ctx).Warn("stream record skipped", "sequenceNumber", rec.Change.SequenceNumber, "error", err)
+		return nil
+	}
+
+	if !found {
+		return nil
+	}
+
+	de.Id = rec.EventID
+
+	if de.Counter != nil {
+		de.Counter.Id = rec.EventID
+	}
+
+	if serr := sh.sharded(ctx, de.Counter); serr != nil {
+		return serr
+	}
+
+	var errs []error
+
+	for _, sink := range sh.sinks {
+		errs = append(errs, sink.Publish(ctx, de))
+	}
+
+	return errors.Join(errs...)
+}
+
+// the domain event for a stream record, or false for items which don't have
+// events, like API keys and webhooks.
+func decode_record(rec events.DynamoDBEventRecord) (DomainEvent, bool, error) {
+	oldImage, oerr := stream_image(rec.Change.OldImage)
+	newImage, nerr := stream_image(rec.Change.NewImage)
+
+	if err := errors.Join(oerr, nerr); err != nil {
+		return DomainEvent{}, false, err
+	}
+
+	at := rec.Change.ApproximateCreationDateTime.Time
+
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	switch image_type(oldImage, newImage) {
+	case "Counter":
+		var before, after *CountData
+
+		if err := decode_images(oldImage, newImage, &before, &after); err != nil {
+			return DomainEvent{}, false, err
+		}
+
+		ce := counter_stream_event(before, after)
+		ce.Time = at.UTC().Format(time.RFC3339Nano)
+
+		return DomainEvent{Kind: "Counter", Counter: &ce}, true, nil
+
+	case "Group":
+		var before, after *GroupData
+
+		if err := decode_images(oldImage, newImage, &before, &after); err != nil {
+			return DomainEvent{}, false, err
+		}
+
+		ge := GroupEvent{Event: change_event(before == nil, after == nil), Time: at.UTC().Format(time.RFC3339Nano)}
+
+		var oldCounters, newCounters []string
+
+		if before != nil {
+			ge.GroupId, ge.GroupName, oldCounters = before.GroupId, before.GroupName, before.Counters
+		}
+
+		if after != nil {
+			ge.GroupId, ge.GroupName, newCounters = after.GroupId, after.GroupName, after.Counters
+		}
+
+		ge.AddedCounters, ge.RemovedCounters = set_delta(oldCounters, newCounters)
+
+		return DomainEvent{Kind: "Group", Group: &ge}, true, nil
+
+	case "User":
+		var before, after *UserData
+
+		if err := decode_images(oldImage, newImage, &before, &after); err != nil {
+			return DomainEvent{}, false, err
+		}
+
+		ue := UserEvent{Event: change_event(before == nil, after == nil), Time: at.UTC().Format(time.RFC3339Nano)}
+
+		var oldGroups, newGroups []string
+
+		if before != nil {
+			ue.UserId, ue.UserName, oldGroups = before.UserId, before.UserName, before.Groups
+		}
+
+		if after != nil {
+			ue.UserId, ue.UserName, newGroups = after.UserId, after.UserName, after.Groups
+		}
+
+		ue.AddedGroups, ue.RemovedGroups = set_delta(oldGroups, newGroups)
+
+		return DomainEvent{Kind: "User", User: &ue}, true, nil
+	}
+
+	if strings.HasPrefix(image_type(oldImage, newImage), shardTypePrefix) {
+		var before, after *ShardData
+
+		if err := decode_images(oldImage, newImage, &before, &after); err != nil {
+			return DomainEvent{}, false, err
+		}
+
+		ce, found := shard_stream_event(before, after)
+		ce.Time = at.UTC().Format(time.RFC3339Nano)
+
+		return DomainEvent{Kind: "Counter", Counter: &ce}, found, nil
+	}
+
+	return DomainEvent{}, false, nil
+}
+
+// A lone increment or decrement of a sharded counter only writes one of its
+// shards, so the shard's record is the only sign of it.  Everything else
+// writes the counter item as well, and its record gives the event, so a
+// shard change which isn't one step either way is left out.  The event has
+// no name and only the shard's values until the handler reads the counter.
+func shard_stream_event(before *ShardData, after *ShardData) (CounterEvent, bool) {
+	if before == nil || after == nil || after.StepVal != before.StepVal || after.StepVal == 0 {
+		return CounterEvent{}, false
+	}
+
+	ce := CounterEvent{
+		GroupId:   after.CounterGroup,
+		CounterId: after.CounterId,
+		OldVal:    before.CounterVal,
+		NewVal:    after.CounterVal,
+		Delta:     after.CounterVal - before.CounterVal,
+		StepVal:   after.StepVal,
+	}
+
+	switch ce.Delta {
+	case after.StepVal:
+		ce.Event = ev_increment
+	case -after.StepVal:
+		ce.Event = ev_decrement
+	default:
+		return CounterEvent{}, false
+	}
+
+	return ce, true
+}
+
+// fills in the name and whole count of a sharded counter's event, which is
+// read after the change, so a later change can show up in it
+func (sh StreamHandler) sharded(ctx context.Context, ce *CounterEvent) error {
+	if ce == nil || ce.CounterName != "" || sh.counter == nil {
+		return nil
+	}
+
+	counterId, uerr := ToUUID(ce.CounterId)
+
+	if uerr != nil {
+		return uerr
+	}
+
+	cd, err := sh.counter(ctx, counterId)
+
+	if err != nil {
+		return err
+	}
+
+	ce.CounterName, ce.NewVal = cd.CounterName, cd.CounterVal
+	ce.OldVal = ce.NewVal - ce.Delta
+
+	return nil
+}
+
+func image_type(oldImage map[string]*dynamodb.AttributeValue, newImage map[string]*dynamodb.AttributeValue) string {
+	for _, image := range []map[string]*dynamodb.AttributeValue{newImage, oldImage} {
+		if ot, found := image[objectTypeCol]; found && ot.S != nil {
+			return *ot.S
+		}
+	}
+	return ""
+}
+
+// unmarshals whichever images are present into freshly allocated records
+func decode_images[T any](oldImage map[string]*dynamodb.AttributeValue, newImage map[string]*dynamodb.AttributeValue, before **T, after **T) error {
+	if oldImage != nil {
+		*before = new(T)
+
+		if err := dynamodbattribute.UnmarshalMap(oldImage, *before); err != nil {
+			return err
+		}
+	}
+
+	if newImage != nil {
+		*after = new(T)
+
+		if err := dynamodbattribute.UnmarshalMap(newImage, *after); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func change_event(created bool, deleted bool) string {
+	switch {
+	case created:
+		return ev_create
+	case deleted:
+		return ev_delete
+	}
+	return ev_update
+}
+
+// works out what happened to a counter from its old and new images.
+// A reset and a decrement which both land on the baseline look the same, and come out as a decrement.
+func counter_stream_event(before *CountData, after *CountData) CounterEvent {
+	switch {
+	case before == nil:
+		return counter_change(ev_create, *after, *after)
+	case after == nil:
+		return counter_change(ev_delete, *before, *before)
+	}
+
+	event := ev_update
+
+	switch {
+	case after.StepVal != before.StepVal:
+		event = ev_step
+	case after.CounterVal == before.CounterVal-before.StepVal:
+		event = ev_decrement
+	case after.CounterVal == after.Baseline && before.CounterVal != after.Baseline:
+		event = ev_reset
+	case after.CounterVal > before.CounterVal:
+		event = ev_increment
+	case after.CounterVal < before.CounterVal:
+		event = ev_decrement
+	}
+
+	return counter_change(event, *before, *after)
+}
+
+// what was added to and removed from a string set
+func set_delta(before []string, after []string) ([]string, []string) {
+	var added, removed []string
+
+	for _, s := range after {
+		if !slices.Contains(before, s) {
+			added = append(added, s)
+		}
+	}
+
+	for _, s := range before {
+		if !slices.Contains(after, s) {
+			removed = append(removed, s)
+		}
+	}
+
+	return added, removed
+}
+
+// stream records use the lambda library's attribute type.  Convert them to the
+// SDK's so that dynamodbattribute can unmarshal them into the usual records.
+func stream_image(image map[string]events.DynamoDBAttributeValue) (map[string]*dynamodb.AttributeValue, error) {
+	if len(image) == 0 {
+		return nil, nil
+	}
+
+	out := make(map[string]*dynamodb.AttributeValue, len(image))
+
+	for k, v := range image {
+		av, err := stream_attribute(v)
+
+		if err != nil {
+			return nil, err
+		}
+
+		out[k] = av
+	}
+
+	return out, nil
+}
+
+func stream_attribute(v events.DynamoDBAttributeValue) (*dynamodb.AttributeValue, error) {
+	av := dynamodb.AttributeValue{}
+
+	switch v.DataType() {
+	case events.DataTypeBinary:
+		av.B = v.Binary()
+	case events.DataTypeBoolean:
+		b := v.Boolean()
+		av.BOOL = &b
+	case events.DataTypeBinarySet:
+		av.BS = v.BinarySet()
+	case events.DataTypeList:
+		for _, item := range v.List() {
+			iv, err := stream_attribute(item)
+
+			if err != nil {
+				return nil, err
+			}
+
+			av.L = append(av.L, iv)
+		}
+	case events.DataTypeMap:
+		m, err := stream_image(v.Map())
+
+		if err != nil {
+			return nil, err
+		}
+
+		av.M = m
+	case events.DataTypeNumber:
+		n := v.Number()
+		av.N = &n
+	case events.DataTypeNumberSet:
+		for _, n := range v.NumberSet() {
+			n := n
+			av.NS = append(av.NS, &n)
+		}
+	case events.DataTypeNull:
+		null := true
+		av.NULL = &null
+	case events.DataTypeString:
+		s := v.String()
+		av.S = &s
+	case events.DataTypeStringSet:
+		for _, s := range v.StringSet() {
+			s := s
+			av.SS = append(av.SS, &s)
+		}
+	default:
+		return nil, events.UnsupportedDynamoDBTypeError{Type: "unknown"}
+	}
+
+	return &av, nil
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// sink which remembers what it was given, and can be told to fail
+type memorySink struct {
+	events []DomainEvent
+	retErr error
+}
+
+func (ms *memorySink) Publish(ctx context.Context, ev DomainEvent) error {
+	ms.events = append(ms.events, ev)
+	return ms.retErr
+}
+
+func counterImage(cd CountData) map[string]events.DynamoDBAttributeValue {
+	return map[string]events.DynamoDBAttributeValue{
+		counterIdCol:    events.NewStringAttribute(cd.CounterId),
+		objectTypeCol:   events.NewStringAttribute("Counter"),
+		counterNameCol:  events.NewStringAttribute(cd.CounterName),
+		counterGroupCol: events.NewStringAttribute(cd.CounterGroup),
+		counterCol:      events.NewNumberAttribute(fmt.Sprint(cd.CounterVal)),
+		stepCol:         events.NewNumberAttribute(fmt.Sprint(cd.StepVal)),
+		baselineCol:     events.NewNumberAttribute(fmt.Sprint(cd.Baseline)),
+	}
+}
+
+func streamRecord(seq string, oldImage map[string]events.DynamoDBAttributeValue, newImage map[string]events.DynamoDBAttributeValue) events.DynamoDBEventRecord {
+	return events.DynamoDBEventRecord{
+		EventID: "ev" + seq,
+		Change: events.DynamoDBStreamRecord{
+			SequenceNumber: seq,
+			OldImage:       oldImage,
+			NewImage:       newImage,
+		},
+	}
+}
+
+func TestStreamCounterEvents(t *testing.T) {
+	cd := CountData{CounterId: MakeUUID().String(), CounterName: "coffee", CounterGroup: expGroup.String(), CounterVal: 5, StepVal: 2}
+
+	with := func(val int, step int) CountData {
+		c := cd
+		c.CounterVal = val
+		c.StepVal = step
+		return c
+	}
+
+	based := func(val int, baseline int) CountData {
+		c := cd
+		c.CounterVal = val
+		c.Baseline = baseline
+		return c
+	}
+
+	checks := []struct {
+		before   *CountData
+		after    *CountData
+		expEvent string
+		expDelta int
+	}{
+		{nil, &cd, ev_create, 0},
+		{&cd, nil, ev_delete, 0},
+		{&cd, ptr(with(7, 2)), ev_increment, 2},
+		{&cd, ptr(with(3, 2)), ev_decrement, -2},
+		{&cd, ptr(with(0, 2)), ev_reset, -5},
+		{&cd, ptr(with(5, 9)), ev_step, 0},
+		{ptr(with(2, 2)), ptr(with(0, 2)), ev_decrement, -2},
+		{ptr(based(5, 10)), ptr(based(10, 10)), ev_reset, 5},
+		{ptr(based(5, 10)), ptr(based(0, 10)), ev_decrement, -5},
+	}
+
+	for _, c := range checks {
+		var oldImage, newImage map[string]events.DynamoDBAttributeValue
+
+		if c.before != nil {
+			oldImage = counterImage(*c.before)
+		}
+
+		if c.after != nil {
+			newImage = counterImage(*c.after)
+		}
+
+		de, found, err := decode_record(streamRecord("1", oldImage, newImage))
+
+		checkError(t, err, nil)
+
+		if !found || de.Kind != "Counter" || de.Counter == nil {
+			t.Fatalf("Counter change not decoded: %+v", de)
+		}
+
+		if de.Counter.Event != c.expEvent || de.Counter.Delta != c.expDelta {
+			t.Errorf("Event is %s delta %d not %s delta %d", de.Counter.Event, de.Counter.Delta, c.expEvent, c.expDelta)
+		}
+
+		if de.Counter.CounterId != cd.CounterId || de.Counter.GroupId != cd.CounterGroup || de.Counter.CounterName != "coffee" {
+			t.Errorf("Event has the wrong counter: %+v", de.Counter)
+		}
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestStreamGroupEvent(t *testing.T) {
+	c1, c2, c3 := MakeUUID().String(), MakeUUID().String(), MakeUUID().String()
+
+	image := func(counters ...string) map[string]events.DynamoDBAttributeValue {
+		return map[string]events.DynamoDBAttributeValue{
+			groupIdCol:     events.NewStringAttribute(expGroup.String()),
+			objectTypeCol:  events.NewStringAttribute("Group"),
+			groupNameCol:   events.NewStringAttribute("team"),
+			counterListCol: events.NewStringSetAttribute(counters),
+		}
+	}
+
+	de, found, err := decode_record(streamRecord("1", image(c1, c2), image(c2, c3)))
+
+	checkError(t, err, nil)
+
+	if !found || de.Group == nil {
+		t.Fatalf("Group change not decoded: %+v", de)
+	}
+
+	ge := de.Group
+
+	if ge.Event != ev_update || ge.GroupId != expGroup.String() || ge.GroupName != "team" {
+		t.Errorf("Group event is %+v", ge)
+	}
+
+	if !slices.Equal(ge.AddedCounters, []string{c3}) || !slices.Equal(ge.RemovedCounters, []string{c1}) {
+		t.Errorf("Counters added %v removed %v", ge.AddedCounters, ge.RemovedCounters)
+	}
+}
+
+func TestStreamShardEvents(t *testing.T) {
+	counterId := MakeUUID()
+
+	image := func(val int, step int) map[string]events.DynamoDBAttributeValue {
+		return map[string]events.DynamoDBAttributeValue{
+			counterIdCol:    events.NewStringAttribute(counterId.String()),
+			objectTypeCol:   events.NewStringAttribute(shard_type(3)),
+			counterGroupCol: events.NewStringAttribute(expGroup.String()),
+			counterCol:      events.NewNumberAttribute(fmt.Sprint(val)),
+			stepCol:         events.NewNumberAttribute(fmt.Sprint(step)),
+		}
+	}
+
+	sink := memorySink{}
+	sh := StreamHandler{
+		sinks: []EventSink{&sink},
+		counter: func(ctx context.Context, id UUID) (CountData, error) {
+			return CountData{CounterId: id.String(), CounterName: "coffee", CounterVal: 40, StepVal: 2}, nil
+		},
+	}
+
+	records := []events.DynamoDBEventRecord{
+		streamRecord("1", image(4, 2), image(6, 2)),
+		streamRecord("2", image(4, 2), image(2, 2)),
+		// resets and step changes come from the counter item
+		streamRecord("3", image(4, 2), image(0, 2)),
+		streamRecord("4", image(4, 2), image(4, 3)),
+		streamRecord("5", nil, image(0, 2)),
+	}
+
+	res, _ := sh.stream_handler(context.Background(), events.DynamoDBEvent{Records: records})
+
+	if len(res.BatchItemFailures) != 0 || len(sink.events) != 2 {
+		t.Fatalf("Shard changes gave %v %+v", res.BatchItemFailures, sink.events)
+	}
+
+	inc, dec := sink.events[0].Counter, sink.events[1].Counter
+
+	if inc.Event != ev_increment || inc.CounterId != counterId.String() || inc.GroupId != expGroup.String() || inc.CounterName != "coffee" || inc.OldVal != 38 || inc.NewVal != 40 {
+		t.Errorf("Increment is %+v", inc)
+	}
+
+	if dec.Event != ev_decrement || dec.Delta != -2 || dec.OldVal != 42 {
+		t.Errorf("Decrement is %+v", dec)
+	}
+}
+
+func TestStreamIgnoredTypes(t *testing.T) {
+	image := map[string]events.DynamoDBAttributeValue{
+		apiKeyIdCol:   events.NewStringAttribute(MakeUUID().String()),
+		objectTypeCol: events.NewStringAttribute("APIKey"),
+	}
+
+	_, found, err := decode_record(streamRecord("1", nil, image))
+
+	checkError(t, err, nil)
+
+	if found {
+		t.Error("API key change produced an event")
+	}
+}
+
+func TestStreamHandlerFailure(t *testing.T) {
+	cd := CountData{CounterId: MakeUUID().String(), CounterGroup: expGroup.String(), StepVal: 1}
+
+	good := memorySink{}
+	bad := memorySink{retErr: errors.New("NOPE")}
+
+	sh := StreamHandler{sinks: []EventSink{&good, &bad}}
+
+	resp, err := sh.stream_handler(context.TODO(), events.DynamoDBEvent{
+		Records: []events.DynamoDBEventRecord{
+			streamRecord("1", nil, counterImage(cd)),
+			streamRecord("2", counterImage(cd), nil),
+		},
+	})
+
+	checkError(t, err, nil)
+
+	if len(good.events) != 1 || len(bad.events) != 1 {
+		t.Errorf("Sinks got %d and %d events, expected 1", len(good.events), len(bad.events))
+	}
+
+	if len(resp.BatchItemFailures) != 1 || resp.BatchItemFailures[0].ItemIdentifier != "1" {
+		t.Errorf("Batch failures are %v", resp.BatchItemFailures)
+	}
+
+	bad.retErr = nil
+
+	resp, _ = sh.stream_handler(context.TODO(), events.DynamoDBEvent{
+		Records: []events.DynamoDBEventRecord{
+			streamRecord("1", nil, counterImage(cd)),
+			streamRecord("2", counterImage(cd), nil),
+		},
+	})
+
+	if len(resp.BatchItemFailures) != 0 || len(good.events) != 3 {
+		t.Errorf("Batch failures are %v after %d events", resp.BatchItemFailures, len(good.events))
+	}
+
+	// the retry sends the first record to the good sink again, which can tell
+	if first, again := good.events[0], good.events[1]; first.Id != "ev1" || again.Id != first.Id || again.Counter.Id != first.Id {
+		t.Errorf("Retried event is %s %s, first was %s", again.Id, again.Counter.Id, first.Id)
+	}
+}
+
+type mockQueue struct {
+	input sqs.SendMessageInput
+}
+
+func (mq *mockQueue) SendMessage(input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+	mq.input = *input
+	return &sqs.SendMessageOutput{}, nil
+}
+
+func TestQueueSink(t *testing.T) {
+	mq := mockQueue{}
+
+	qs := QueueSink{sqs: &mq, queueURL: "https://queue"}
+
+	checkError(t, qs.Publish(context.Background(), DomainEvent{Kind: "Counter", Counter: &CounterEvent{Event: ev_reset}}), nil)
+
+	if *mq.input.QueueUrl != "https://queue" || *mq.input.MessageAttributes["kind"].StringValue != "Counter" {
+		t.Errorf("Message sent was %v", mq.input)
+	}
+
+	if body := *mq.input.MessageBody; body == "" || body[0] != '{' {
+		t.Errorf("Message body is %s", body)
+	}
+}
+
+func TestSinkList(t *testing.T) {
+	first, second := memorySink{retErr: errors.New("full")}, memorySink{}
+
+	ev := DomainEvent{Kind: "Counter", Counter: &CounterEvent{Event: ev_reset}}
+
+	if err := (SinkList{&first, &second}).Publish(context.Background(), ev); err == nil || len(first.events) != 1 || len(second.events) != 1 {
+		t.Errorf("Published %v %v with %v", first.events, second.events, err)
+	}
+}
+
+func TestCreateEventSinks(t *testing.T) {
+	sinks, err := Create_EventSinks("log, webhook", DynamoOperator{}, "", "")
+
+	checkError(t, err, nil)
+
+	if len(sinks) != 2 {
+		t.Fatalf("%d sinks, expected 2", len(sinks))
+	}
+
+	if ws, ok := sinks[1].(WebhookSink); !ok || ws.dbo.hooks == nil {
+		t.Error("Webhook sink has no dispatcher")
+	}
+
+	if _, err := Create_EventSinks("queue", DynamoOperator{}, "", ""); err == nil {
+		t.Error("Queue sink with no queue created")
+	}
+
+	if _, err := Create_EventSinks("carrier-pigeon", DynamoOperator{}, "", ""); err == nil {
+		t.Error("Unknown sink created")
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+)
+
+// A group can be cloned into a new group of the caller's with the same
+// counters, or saved as a template which new groups are made from.  Either
+// way the new counters are configured as the old ones were, with the same
+// names, steps, shards, baselines, bounds, labels and metadata.  A template's
+// counters start at their baselines, and a clone's keep their counts unless
+// it is asked to zero them, which takes them back to their baselines too.
+// Derived counters name the new counters with the names the old ones had.
+
+// a counter of a template, without a count
+type CounterSpec struct {
+	Name   string `dynamodbav:"name" json:"name"`
+	Step   int    `dynamodbav:"step" json:"step"`
+	Shards int    `dynamodbav:"shards,omitempty" json:"shards,omitempty"`
+
+	Baseline int  `dynamodbav:"baseline,omitempty" json:"baseline,omitempty"`
+	Min      *int `dynamodbav:"minVal,omitempty" json:"minVal,omitempty"`
+	Max      *int `dynamodbav:"maxVal,omitempty" json:"maxVal,omitempty"`
+
+	Labels map[string]string `dynamodbav:"labels,omitempty" json:"labels,omitempty"`
+
+	Expression string `dynamodbav:"expression,omitempty" json:"expression,omitempty"`
+
+	CounterMeta
+}
+
+type TemplateData struct {
+	TemplateId   string        `dynamodbav:"objectUUID" json:"objectUUID"`
+	ObjectType   string        `dynamodbav:"objectType" json:"-"`
+	TemplateName string        `dynamodbav:"templateName" json:"templateName"`
+	UserId       string        `dynamodbav:"userUUID" json:"userUUID"`
+	Counters     []CounterSpec `dynamodbav:"counters" json:"counters"`
+}
+
+func counter_spec(cd CountData) CounterSpec {
+	return CounterSpec{
+		Name:        cd.CounterName,
+		Step:        cd.StepVal,
+		Shards:      cd.Shards,
+		Baseline:    cd.Baseline,
+		Min:         cd.MinVal,
+		Max:         cd.MaxVal,
+		Labels:      cd.Labels,
+		Expression:  cd.Expression,
+		CounterMeta: cd.CounterMeta,
+	}
+}
+
+// a new counter as the spec says, at value
+func spec_counter(spec CounterSpec, value int) CountData {
+	return CountData{
+		CounterId:   MakeUUID().String(),
+		CounterName: spec.Name,
+		CounterVal:  value,
+		StepVal:     spec.Step,
+		Shards:      spec.Shards,
+		Baseline:    spec.Baseline,
+		MinVal:      spec.Min,
+		MaxVal:      spec.Max,
+		Labels:      spec.Labels,
+		Expression:  spec.Expression,
+		CounterMeta: spec.CounterMeta,
+	}
+}
+
+func cloneGroup(ctx context.Context, req Request, dbo DataOperator, s Session, p cloneGroupParams) (Response, error) {
+	return dbo.GroupClone(ctx, s, p.Name, p.Values == "zero")
+}
+
+func createTemplate(ctx context.Context, req Request, dbo DataOperator, s Session, p createTemplateParams) (Response, error) {
+	return dbo.TemplateCreate(ctx, s, p.Name)
+}
+
+func listTemplates(ctx context.Context, req Request, dbo DataOperator, s Session) (Response, error) {
+	return dbo.TemplateList(ctx, s)
+}
+
+func getTemplate(ctx context.Context, req Request, dbo DataOperator, s Session, p getTemplateParams) (Response, error) {
+	return dbo.TemplateRead(ctx, s, p.Id)
+}
+
+func deleteTemplate(ctx context.Context, req Request, dbo DataOperator, s Session, p deleteTemplateParams) (Response, error) {
+	return dbo.TemplateDelete(ctx, s, p.Id)
+}
+
+func createGroupFromTemplate(ctx context.Context, req Request, dbo DataOperator, s Session, p createGroupFromTemplateParams) (Response, error) {
+	return dbo.GroupFromTemplate(ctx, s, p.Id, p.Name)
+}
@@ -2,10 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"os"
+	"slices"
 	"strconv"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"gopkg.in/yaml.v3"
 )
 
 // checker routines to help with testing
@@ -351,6 +354,84 @@ func checkNewUser(t *testing.T, input *dynamodb.TransactWriteItem,
 	}
 }
 
+func checkUserReserve(t *testing.T, input *dynamodb.TransactWriteItem,
+	expUserTable string,
+	expUser UUID,
+	expUserName string) {
+	if input.Delete != nil {
+		t.Error("Unexpected delete request")
+	}
+
+	if input.Update != nil {
+		t.Error("Unexpected Update request")
+	}
+
+	if input.Put == nil {
+		t.Fatal("Expected put request was not present")
+	}
+
+	put := input.Put
+
+	if *put.TableName != expUserTable {
+		t.Errorf("Table name is %s not %s", *put.TableName, expUserTable)
+	}
+
+	if kval := *put.Item[userIdCol].S; kval != expUserName {
+		t.Errorf("Key is %s not %s", kval, expUserName)
+	}
+
+	if *put.Item[objectTypeCol].S != "UserEmail" {
+		t.Error("Object type not correct in e-mail reservation")
+	}
+
+	if uval := *put.Item[reservedUserCol].S; uval != expUser.String() {
+		t.Errorf("Reserved for %s not %s", uval, expUser.String())
+	}
+
+	if _, present := put.Item[emailCol]; present {
+		t.Error("E-mail column in reservation would show up in the e-mail index")
+	}
+
+	if put.ConditionExpression == nil || *put.ConditionExpression != "attribute_not_exists(objectUUID)" {
+		t.Error("Reservation is not conditional on the address being free")
+	}
+}
+
+func checkUserRelease(t *testing.T, input *dynamodb.TransactWriteItem,
+	expUserTable string,
+	expUser UUID,
+	expUserName string) {
+	if input.Update != nil {
+		t.Error("Unexpected Update request")
+	}
+
+	if input.Put != nil {
+		t.Error("Unexpected Put request")
+	}
+
+	if input.Delete == nil {
+		t.Fatal("Expected Delete request was not present")
+	}
+
+	dd := input.Delete
+
+	if *dd.TableName != expUserTable {
+		t.Errorf("Table name is %s not %s", *dd.TableName, expUserTable)
+	}
+
+	if kval := *dd.Key[userIdCol].S; kval != expUserName {
+		t.Errorf("Key is %s not %s", kval, expUserName)
+	}
+
+	if *dd.Key[objectTypeCol].S != "UserEmail" {
+		t.Error("Object type not correct in e-mail release")
+	}
+
+	if uval := *dd.ExpressionAttributeValues[":"+userIdVal].S; uval != expUser.String() {
+		t.Errorf("Released for %s not %s", uval, expUser.String())
+	}
+}
+
 func checkUserUpdate(t *testing.T, input *dynamodb.TransactWriteItem, expVal1 UUID, expQuery string,
 	expUserTable string,
 	expUser UUID) {
@@ -396,3 +477,56 @@ func checkUserUpdate(t *testing.T, input *dynamodb.TransactWriteItem, expVal1 UU
 		t.Errorf("Query is %s not %s", query, expQuery)
 	}
 }
+
+// the mock takes any transaction, so check that the lambda role in
+// serverless.yaml is allowed every op in it
+func checkGranted(t *testing.T, ops []*dynamodb.TransactWriteItem) {
+	t.Helper()
+
+	data, err := os.ReadFile("../serverless.yaml")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var config struct {
+		Provider struct {
+			Iam struct {
+				Role struct {
+					Statements []struct {
+						Action []string `yaml:"Action"`
+					} `yaml:"statements"`
+				} `yaml:"role"`
+			} `yaml:"iam"`
+		} `yaml:"provider"`
+	}
+
+	if yerr := yaml.Unmarshal(data, &config); yerr != nil {
+		t.Fatal(yerr)
+	}
+
+	var granted []string
+
+	for _, st := range config.Provider.Iam.Role.Statements {
+		granted = append(granted, st.Action...)
+	}
+
+	for _, op := range ops {
+		action := ""
+
+		switch {
+		case op.Put != nil:
+			action = "dynamodb:PutItem"
+		case op.Update != nil:
+			action = "dynamodb:UpdateItem"
+		case op.Delete != nil:
+			action = "dynamodb:DeleteItem"
+		case op.ConditionCheck != nil:
+			action = "dynamodb:ConditionCheckItem"
+		}
+
+		if !slices.Contains(granted, action) {
+			t.Errorf("The lambda role isn't allowed %s", action)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Spans go to otel's tracer provider, which does nothing unless TRACE_EXPORTER
+// names an exporter.  A request's trace carries on from the caller's
+// traceparent header if it sent one.
+
+const tracerName = "dynamocount"
+
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// TRACE_EXPORTER is "stdout" for spans as JSON on stdout, or empty for no tracing
+func Create_TracerProvider(exporter string) (*sdktrace.TracerProvider, error) {
+	switch exporter {
+	case "", "none":
+		return nil, nil
+	case "stdout":
+		exp, err := stdouttrace.New()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return tracer_provider(exp), nil
+	}
+
+	return nil, fmt.Errorf("unknown trace exporter %s", exporter)
+}
+
+// spans are exported as they end.  A lambda can be frozen before a batch would go.
+func tracer_provider(exp sdktrace.SpanExporter) *sdktrace.TracerProvider {
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithSyncer(exp),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", tracerName))),
+	)
+}
+
+// the caller's trace from the traceparent and tracestate headers.
+// Request headers are always lower case.
+func trace_context(ctx context.Context, headers map[string]string) context.Context {
+	return propagation.TraceContext{}.Extract(ctx, propagation.MapCarrier(headers))
+}
+
+// the span for a request's dispatch, in the caller's trace if it has one
+func start_request_span(ctx context.Context, req Request) (context.Context, trace.Span) {
+	return tracer().Start(trace_context(ctx, req.Headers), req.RouteKey, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+		attribute.String("http.request.method", req.RequestContext.HTTP.Method),
+		attribute.String("url.path", req.RawPath),
+		attribute.String("requestId", req.RequestContext.RequestID),
+	))
+}
+
+// names the span after the route the request went to, which for an API key
+// is only known after dispatch
+func end_request_span(ctx context.Context, span trace.Span, res Response, err error) {
+	route := request_route(ctx)
+
+	span.SetName(route)
+	span.SetAttributes(
+		attribute.String("http.route", route),
+		attribute.Int("http.response.status_code", res.StatusCode),
+	)
+
+	if err == nil && res.StatusCode >= 500 {
+		span.SetStatus(codes.Error, res.Body)
+	}
+
+	end_span(span, err)
+}
+
+func end_span(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// the code of each op in a cancelled transaction, "None" for the ones which
+// were fine
+func cancellation_reasons(err error) []string {
+	var tce *dynamodb.TransactionCanceledException
+
+	if !errors.As(err, &tce) {
+		return nil
+	}
+
+	var reasons []string
+
+	for _, r := range tce.CancellationReasons {
+		if r == nil {
+			reasons = append(reasons, "None")
+		} else {
+			reasons = append(reasons, aws.StringValue(r.Code))
+		}
+	}
+
+	return reasons
+}
+
+// a span for a DynamoDB call, named like the SDK's operation
+func start_db_span(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return tracer().Start(ctx, "DynamoDB."+operation, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("db.system", "dynamodb"),
+		attribute.String("db.operation", operation),
+	))
+}
+
+// the distinct tables a transaction writes to
+func op_tables(ops []*dynamodb.TransactWriteItem) []string {
+	var tables []string
+
+	for _, d := range describe_ops(ops) {
+		if !slices.Contains(tables, d["table"]) {
+			tables = append(tables, d["table"])
+		}
+	}
+
+	return tables
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// spans go to memory for the test
+func captureSpans(t *testing.T) *tracetest.InMemoryExporter {
+	exp := tracetest.NewInMemoryExporter()
+
+	old := otel.GetTracerProvider()
+	otel.SetTracerProvider(tracer_provider(exp))
+	t.Cleanup(func() { otel.SetTracerProvider(old) })
+
+	return exp
+}
+
+func spanNamed(t *testing.T, spans tracetest.SpanStubs, name string) tracetest.SpanStub {
+	for _, s := range spans {
+		if s.Name == name {
+			return s
+		}
+	}
+
+	t.Fatalf("No %s span in %d", name, len(spans))
+	return tracetest.SpanStub{}
+}
+
+func spanAttr(s tracetest.SpanStub, key string) attribute.Value {
+	for _, kv := range s.Attributes {
+		if string(kv.Key) == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestRequestTrace(t *testing.T) {
+	exp := captureSpans(t)
+
+	api := APIHandler{dbo: &MockDataOperator{userId: MakeUUID()}}
+
+	req := Request{
+		RouteKey: "GET /api/v1/group",
+		Headers: map[string]string{
+			"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		},
+		RequestContext: events.APIGatewayV2HTTPRequestContext{
+			RequestID: "req-1",
+			Authorizer: &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+				JWT: &events.APIGatewayV2HTTPRequestContextAuthorizerJWTDescription{
+					Claims: map[string]string{"cognito:username": "foo@bar.com"},
+				},
+			},
+		},
+	}
+
+	res, _ := api.private_handler_gatewayv2(context.TODO(), req)
+
+	spans := exp.GetSpans()
+
+	dispatch := spanNamed(t, spans, "GET /api/v1/group")
+	session := spanNamed(t, spans, "Create_APISession")
+	lookup := spanNamed(t, spans, "LookupUserUUID")
+
+	if dispatch.SpanContext.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || dispatch.Parent.SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("Request not in the caller's trace: %s", dispatch.SpanContext.TraceID())
+	}
+
+	if session.Parent.SpanID() != dispatch.SpanContext.SpanID() || lookup.Parent.SpanID() != session.SpanContext.SpanID() {
+		t.Error("Session spans not nested under the request")
+	}
+
+	if spanAttr(dispatch, "http.response.status_code").AsInt64() != int64(res.StatusCode) || spanAttr(dispatch, "requestId").AsString() != "req-1" {
+		t.Errorf("Request span has %v", dispatch.Attributes)
+	}
+}
+
+func TestSessionSpanError(t *testing.T) {
+	exp := captureSpans(t)
+
+	Create_APISession(context.Background(), &MockDataOperator{}, Request{})
+
+	if s := spanNamed(t, exp.GetSpans(), "Create_APISession"); s.Status.Code != codes.Error {
+		t.Errorf("Failed session has status %v", s.Status)
+	}
+}
+
+func TestDBSpans(t *testing.T) {
+	exp := captureSpans(t)
+
+	s, dbo, dbi := mockEnv(expUser, expGroup, "foo@bar.com")
+
+	dbo.dbi = timedDB{dbi: dbi}
+	dbi.twErrs = []error{cancelledWith("ConditionalCheckFailed")}
+
+	dbo.CounterUpdate(context.Background(), s, expCounterUUID, dnquery(dq_current, dq_inc), 1)
+
+	tw := spanNamed(t, exp.GetSpans(), "DynamoDB.TransactWriteItems")
+
+	if spanAttr(tw, "db.operation").AsString() != "TransactWriteItems" || spanAttr(tw, "aws.dynamodb.item_count").AsInt64() != 1 {
+		t.Errorf("Transaction span has %v", tw.Attributes)
+	}
+
+	if tables := spanAttr(tw, "aws.dynamodb.table_names").AsStringSlice(); len(tables) != 1 || tables[0] != expCounterTable {
+		t.Errorf("Transaction tables are %v", tables)
+	}
+
+	if reasons := spanAttr(tw, "aws.dynamodb.cancellation_reasons").AsStringSlice(); len(reasons) != 1 || reasons[0] != "ConditionalCheckFailed" {
+		t.Errorf("Cancellation reasons are %v", reasons)
+	}
+
+	if tw.Status.Code != codes.Error {
+		t.Errorf("Failed transaction has status %v", tw.Status)
+	}
+
+	exp.Reset()
+
+	dbi.qo.Items = nil
+	dbo.read_shards(context.Background(), expCounterUUID)
+
+	q := spanNamed(t, exp.GetSpans(), "DynamoDB.Query")
+
+	if spanAttr(q, "aws.dynamodb.item_count").AsInt64() != 0 || q.Status.Code == codes.Error {
+		t.Errorf("Query span has %v %v", q.Attributes, q.Status)
+	}
+}
+
+func TestCreateTracerProvider(t *testing.T) {
+	if tp, err := Create_TracerProvider(""); tp != nil || err != nil {
+		t.Errorf("No exporter gave %v %s", tp, err)
+	}
+
+	if _, err := Create_TracerProvider("carrier-pigeon"); err == nil {
+		t.Error("Unknown exporter accepted")
+	}
+
+	if tp, err := Create_TracerProvider("stdout"); tp == nil || err != nil {
+		t.Errorf("stdout exporter gave %v %s", tp, err)
+	}
+}
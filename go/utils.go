@@ -2,14 +2,87 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 )
 
+// errors which go back with a status other than the usual 404
+type statusError struct {
+	status int
+	err    error
+}
+
+func (se statusError) Error() string {
+	return se.err.Error()
+}
+
+func (se statusError) Unwrap() error {
+	return se.err
+}
+
+func precondition_failed(format string, a ...any) error {
+	return statusError{status: http.StatusPreconditionFailed, err: fmt.Errorf(format, a...)}
+}
+
+// a change which the data as it is now won't take
+func conflict(format string, a ...any) error {
+	return statusError{status: http.StatusConflict, err: fmt.Errorf(format, a...)}
+}
+
+// a request which can't be right, whatever the data
+func bad_request(format string, a ...any) error {
+	return statusError{status: http.StatusBadRequest, err: fmt.Errorf(format, a...)}
+}
+
+// a change which clashed with others or was throttled every time it was
+// tried.  It can be tried again shortly.
+func busy(err error) error {
+	return statusError{status: http.StatusServiceUnavailable, err: err}
+}
+
+// how many seconds a busy request is asked to wait before it is tried again
+const busyRetryAfter = "1"
+
+// true if a call ran out of time, its own or the request's
+func timed_out(err error) bool {
+	var ae awserr.Error
+
+	if errors.As(err, &ae) && ae.Code() == request.CanceledErrorCode {
+		return errors.Is(ae.OrigErr(), context.DeadlineExceeded)
+	}
+
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
 func makeerror(err error) (Response, error) {
-	return Response{
-		StatusCode: 404,
+	status := 404
+
+	var se statusError
+
+	if errors.As(err, &se) {
+		status = se.status
+	} else if timed_out(err) {
+		status = http.StatusGatewayTimeout
+	}
+
+	res := Response{
+		StatusCode: status,
 		Body:       err.Error(),
-	}, nil
+	}
+
+	if status == http.StatusServiceUnavailable {
+		res.Headers = map[string]string{"Retry-After": busyRetryAfter}
+	}
+
+	return res, nil
 }
 
 func makeresponse(data any) (Response, error) {
@@ -28,10 +101,61 @@ func makeresponse(data any) (Response, error) {
 		Body:            buf.String(),
 		IsBase64Encoded: false,
 		Headers: map[string]string{
-			"Content-Type":           "application/json",
-			"X-MyCompany-Func-Reply": "hello-handler",
+			"Content-Type": "application/json",
 		},
 	}
 
 	return res, nil
 }
+
+// splits a comma separated query string parameter, dropping empty entries
+func split_list(param string) []string {
+	var items []string
+
+	for _, item := range strings.Split(param, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// versions go out as strong ETags, e.g. "3"
+func make_etag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+// the version an If-Match header asks for, or nil if there isn't one or it
+// is "*".  Anything which isn't one of our ETags can never match, and If-Match
+// compares strongly, so that includes weak ones.
+func parse_etag(header string) (*int, error) {
+	header = strings.TrimSpace(header)
+
+	if header == "" || header == "*" {
+		return nil, nil
+	}
+
+	unquoted, err := strconv.Unquote(header)
+
+	if err != nil {
+		return nil, precondition_failed("If-Match %s is not a version", header)
+	}
+
+	version, verr := strconv.Atoi(unquoted)
+
+	if verr != nil || version < 1 {
+		return nil, precondition_failed("If-Match %s is not a version", header)
+	}
+
+	return &version, nil
+}
+
+// adds an ETag header to a successful response
+func with_etag(res Response, err error, version int) (Response, error) {
+	if err == nil && res.StatusCode == 200 && version > 0 {
+		res.Headers["ETag"] = make_etag(version)
+	}
+
+	return res, err
+}
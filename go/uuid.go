@@ -28,6 +28,13 @@ func MakeUUID() UUID {
 	}
 }
 
+// the same UUID every time for the same name
+func NameUUID(name string) UUID {
+	return UUID{
+		uuid: googleUUID.NewSHA1(googleUUID.NameSpaceURL, []byte(name)),
+	}
+}
+
 func ToUUID(uuid string) (UUID, error) {
 	u, err := googleUUID.Parse(uuid)
 
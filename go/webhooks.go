@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// counter events which webhooks can subscribe to
+const (
+	ev_create    = "create"
+	ev_increment = "increment"
+	ev_decrement = "decrement"
+	ev_reset     = "reset"
+	ev_step      = "step"
+	ev_update    = "update"
+	ev_delete    = "delete"
+	ev_threshold = "threshold"
+	ev_all       = "*"
+)
+
+var webhook_events = []string{ev_create, ev_increment, ev_decrement, ev_reset, ev_step, ev_update, ev_delete, ev_threshold, ev_all}
+
+// payload sent to webhooks
+type CounterEvent struct {
+	Event       string `json:"event"`
+	GroupId     string `json:"groupUUID"`
+	CounterId   string `json:"counterUUID"`
+	CounterName string `json:"counterName"`
+	OldVal      int    `json:"oldVal"`
+	NewVal      int    `json:"newVal"`
+	Delta       int    `json:"delta"`
+	StepVal     int    `json:"stepVal"`
+	Threshold   *int   `json:"threshold,omitempty"`
+	Time        string `json:"time"`
+
+	// the same each time the stream record behind it is retried
+	Id string `json:"id,omitempty"`
+}
+
+// works out which event a counter update query is
+func counter_event(query string) string {
+	switch query {
+	case dnquery(dq_current, dq_inc):
+		return ev_increment
+	case dnquery(dq_current, dq_dec):
+		return ev_decrement
+	case dnquery(dq_current, dq_base), dnquery(dq_current, dq_init):
+		return ev_reset
+	case dnquery(dq_init, dq_current):
+		return ev_step
+	}
+	return ev_update
+}
+
+// true if the value went from one side of the threshold to the other
+func crossed(threshold int, oldVal int, newVal int) bool {
+	return (oldVal < threshold) != (newVal < threshold)
+}
+
+// the event as a particular webhook should see it, or false if the hook doesn't want it.
+func (wd WebhookData) match(ev CounterEvent) (CounterEvent, bool) {
+	if wd.CounterId != "" && wd.CounterId != ev.CounterId {
+		return ev, false
+	}
+
+	if wd.Threshold != nil && slices.Contains(wd.Events, ev_threshold) && ev.Event != ev_create && ev.Event != ev_delete && crossed(*wd.Threshold, ev.OldVal, ev.NewVal) {
+		ev.Event = ev_threshold
+		ev.Threshold = wd.Threshold
+		return ev, true
+	}
+
+	return ev, slices.Contains(wd.Events, ev.Event) || slices.Contains(wd.Events, ev_all)
+}
+
+func make_webhook_secret() (string, error) {
+	secret := make([]byte, 32)
+
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(secret), nil
+}
+
+// receivers check the signature by computing the same HMAC over the timestamp header, a dot and the body.
+func sign_webhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+const (
+	webhookSignatureHeader = "X-OCD-Signature"
+	webhookTimestampHeader = "X-OCD-Timestamp"
+	webhookEventHeader     = "X-OCD-Event"
+	webhookIdHeader        = "X-OCD-Event-Id"
+)
+
+// WebhookDispatcher posts events to webhooks, retrying with exponential backoff.
+// The client is pluggable so that tests can deliver to a local receiver.
+type WebhookDispatcher struct {
+	client   *http.Client
+	attempts int
+	backoff  time.Duration
+}
+
+// The dispatcher only connects to public addresses.  They are checked after
+// DNS lookup, on each connection, so a name which later resolves somewhere
+// private can't reach inside.  Redirects aren't followed for the same reason.
+func Create_WebhookDispatcher() *WebhookDispatcher {
+	dialer := &net.Dialer{Timeout: 2 * time.Second, Control: public_only}
+
+	return &WebhookDispatcher{
+		client: &http.Client{
+			Timeout:   2 * time.Second,
+			Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: 2 * time.Second},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		attempts: 3,
+		backoff:  100 * time.Millisecond,
+	}
+}
+
+// carrier grade NAT's shared addresses and the benchmarking networks, which
+// net.IP has no test for
+var unroutableNets = parse_nets("100.64.0.0/10", "198.18.0.0/15")
+
+func parse_nets(cidrs ...string) []*net.IPNet {
+	var nets []*net.IPNet
+
+	for _, cidr := range cidrs {
+		_, ipnet, err := net.ParseCIDR(cidr)
+
+		if err != nil {
+			panic(err)
+		}
+
+		nets = append(nets, ipnet)
+	}
+
+	return nets
+}
+
+// false for loopback, private, link local and other addresses which aren't on the internet
+func public_address(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || ip.Equal(net.IPv4bcast) {
+		return false
+	}
+
+	return !slices.ContainsFunc(unroutableNets, func(n *net.IPNet) bool { return n.Contains(ip) })
+}
+
+// refuses connections to addresses which aren't public
+func public_only(network string, address string, conn syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+
+	if err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(host); ip == nil || !public_address(ip) {
+		return fmt.Errorf("webhook address %s is not public", host)
+	}
+
+	return nil
+}
+
+// delivers one event to one webhook, giving up when the context is done.
+// Never fails, the outcome is in the delivery record.
+func (wh *WebhookDispatcher) Deliver(ctx context.Context, wd WebhookData, ev CounterEvent) DeliveryData {
+	dd := DeliveryData{
+		HookId:    wd.HookId,
+		Event:     ev.Event,
+		CounterId: ev.CounterId,
+	}
+
+	body, merr := json.Marshal(ev)
+
+	if merr != nil {
+		dd.Error = merr.Error()
+		return dd
+	}
+
+	delay := wh.backoff
+
+	for dd.Attempts < wh.attempts {
+		if dd.Attempts > 0 {
+			select {
+			case <-ctx.Done():
+				dd.Error = fmt.Sprintf("%s, then gave up: %s", dd.Error, ctx.Err())
+				return dd
+			case <-time.After(delay):
+			}
+
+			delay *= 2
+		}
+
+		dd.Attempts++
+
+		status, err := wh.post(ctx, wd, ev, body)
+
+		dd.Status = status
+
+		if err == nil {
+			dd.Error = ""
+			return dd
+		}
+
+		dd.Error = err.Error()
+
+		// the receiver understood us and said no.  Trying again won't help.
+		if status >= 400 && status < 500 && status != http.StatusTooManyRequests {
+			return dd
+		}
+	}
+
+	return dd
+}
+
+func (wh *WebhookDispatcher) post(ctx context.Context, wd WebhookData, ev CounterEvent, body []byte) (int, error) {
+	req, rerr := http.NewRequestWithContext(ctx, http.MethodPost, wd.URL, bytes.NewReader(body))
+
+	if rerr != nil {
+		return 0, rerr
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, ev.Event)
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, sign_webhook(wd.Secret, timestamp, body))
+
+	// a retried stream record delivers the event again, with the same id
+	if ev.Id != "" {
+		req.Header.Set(webhookIdHeader, ev.Id)
+	}
+
+	resp, err := wh.client.Do(req)
+
+	if err != nil {
+		return 0, err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// returned on creation.  The secret can't be read again after this.
+type webhookResult struct {
+	opResult
+	Secret string
+}
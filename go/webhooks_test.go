@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// local webhook receiver which answers with the given status codes in turn,
+// the last one repeating.  It checks every signature it is sent.
+type testReceiver struct {
+	t        *testing.T
+	secret   string
+	statuses []int
+
+	mu     sync.Mutex
+	events []CounterEvent
+}
+
+func (tr *testReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	if sig := sign_webhook(tr.secret, r.Header.Get(webhookTimestampHeader), body); sig != r.Header.Get(webhookSignatureHeader) {
+		tr.t.Errorf("Bad signature %s, expected %s", r.Header.Get(webhookSignatureHeader), sig)
+	}
+
+	var ev CounterEvent
+
+	if err := json.Unmarshal(body, &ev); err != nil {
+		tr.t.Errorf("Cannot unmarshal webhook body: %s", err)
+	}
+
+	if r.Header.Get(webhookEventHeader) != ev.Event {
+		tr.t.Errorf("Event header %s does not match body %s", r.Header.Get(webhookEventHeader), ev.Event)
+	}
+
+	if r.Header.Get(webhookIdHeader) != ev.Id {
+		tr.t.Errorf("Id header %s does not match body %s", r.Header.Get(webhookIdHeader), ev.Id)
+	}
+
+	tr.mu.Lock()
+	tr.events = append(tr.events, ev)
+	status := tr.statuses[min(len(tr.events), len(tr.statuses))-1]
+	tr.mu.Unlock()
+
+	w.WriteHeader(status)
+}
+
+func testDispatcher() *WebhookDispatcher {
+	return &WebhookDispatcher{
+		client:   &http.Client{Timeout: time.Second},
+		attempts: 3,
+		backoff:  time.Millisecond,
+	}
+}
+
+func TestCounterEventFromQuery(t *testing.T) {
+	checks := map[string]string{
+		dnquery(dq_current, dq_inc):  ev_increment,
+		dnquery(dq_current, dq_dec):  ev_decrement,
+		dnquery(dq_current, dq_init): ev_reset,
+		dnquery(dq_current, dq_base): ev_reset,
+		dnquery(dq_init, dq_current): ev_step,
+		"SET foo=:bar":               ev_update,
+	}
+
+	for q, exp := range checks {
+		if ev := counter_event(q); ev != exp {
+			t.Errorf("Query %s is event %s not %s", q, ev, exp)
+		}
+	}
+}
+
+func TestWebhookMatch(t *testing.T) {
+	counter := MakeUUID().String()
+	threshold := 100
+
+	ev := CounterEvent{Event: ev_increment, CounterId: counter, OldVal: 99, NewVal: 100}
+
+	check := func(wd WebhookData, ev CounterEvent, expWanted bool, expEvent string) {
+		hev, wanted := wd.match(ev)
+
+		if wanted != expWanted || (wanted && hev.Event != expEvent) {
+			t.Errorf("Hook %v on %v gave %t %s not %t %s", wd, ev, wanted, hev.Event, expWanted, expEvent)
+		}
+	}
+
+	check(WebhookData{Events: []string{ev_increment}}, ev, true, ev_increment)
+	check(WebhookData{Events: []string{ev_all}}, ev, true, ev_increment)
+	check(WebhookData{Events: []string{ev_reset}}, ev, false, "")
+	check(WebhookData{Events: []string{ev_increment}, CounterId: counter}, ev, true, ev_increment)
+	check(WebhookData{Events: []string{ev_increment}, CounterId: MakeUUID().String()}, ev, false, "")
+
+	check(WebhookData{Events: []string{ev_threshold}, Threshold: &threshold}, ev, true, ev_threshold)
+	check(WebhookData{Events: []string{ev_threshold}, Threshold: &threshold}, CounterEvent{Event: ev_reset, OldVal: 150, NewVal: 0}, true, ev_threshold)
+	check(WebhookData{Events: []string{ev_threshold}, Threshold: &threshold}, CounterEvent{Event: ev_increment, OldVal: 100, NewVal: 101}, false, "")
+	check(WebhookData{Events: []string{ev_threshold, ev_increment}, Threshold: &threshold}, CounterEvent{Event: ev_increment, OldVal: 100, NewVal: 101}, true, ev_increment)
+}
+
+func TestWebhookDeliver(t *testing.T) {
+	tr := testReceiver{t: t, secret: "sssh", statuses: []int{500, 503, 200}}
+	srv := httptest.NewServer(&tr)
+	defer srv.Close()
+
+	wd := WebhookData{HookId: MakeUUID().String(), URL: srv.URL, Secret: tr.secret}
+
+	dd := testDispatcher().Deliver(context.Background(), wd, CounterEvent{Event: ev_reset, CounterId: "c1", Id: "e1"})
+
+	if dd.Attempts != 3 || dd.Status != 200 || dd.Error != "" {
+		t.Errorf("Delivery was %+v", dd)
+	}
+
+	if len(tr.events) != 3 || tr.events[2].CounterId != "c1" {
+		t.Errorf("Receiver got %v", tr.events)
+	}
+
+	if dd.HookId != wd.HookId || dd.Event != ev_reset || dd.CounterId != "c1" {
+		t.Errorf("Delivery record is %+v", dd)
+	}
+}
+
+func TestWebhookDeliverGiveUp(t *testing.T) {
+	tr := testReceiver{t: t, secret: "sssh", statuses: []int{500}}
+	srv := httptest.NewServer(&tr)
+	defer srv.Close()
+
+	dd := testDispatcher().Deliver(context.Background(), WebhookData{URL: srv.URL, Secret: tr.secret}, CounterEvent{Event: ev_reset})
+
+	if dd.Attempts != 3 || dd.Status != 500 || dd.Error == "" {
+		t.Errorf("Delivery was %+v", dd)
+	}
+
+	// client errors are not retried
+	tr4 := testReceiver{t: t, secret: "sssh", statuses: []int{410}}
+	srv4 := httptest.NewServer(&tr4)
+	defer srv4.Close()
+
+	dd = testDispatcher().Deliver(context.Background(), WebhookData{URL: srv4.URL, Secret: tr4.secret}, CounterEvent{Event: ev_reset})
+
+	if dd.Attempts != 1 || dd.Status != 410 {
+		t.Errorf("Delivery was %+v", dd)
+	}
+}
+
+func TestWebhookDeliverCancel(t *testing.T) {
+	tr := testReceiver{t: t, secret: "sssh", statuses: []int{500}}
+	srv := httptest.NewServer(&tr)
+	defer srv.Close()
+
+	wh := testDispatcher()
+	wh.backoff = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	dd := wh.Deliver(ctx, WebhookData{URL: srv.URL, Secret: tr.secret}, CounterEvent{Event: ev_reset})
+
+	if dd.Attempts != 1 || !strings.Contains(dd.Error, "deadline exceeded") {
+		t.Errorf("Delivery was %+v", dd)
+	}
+}
+
+func TestWebhookAddresses(t *testing.T) {
+	for address, public := range map[string]bool{
+		"203.0.113.9":     true,
+		"2001:db8::1":     true,
+		"127.0.0.1":       false,
+		"10.1.2.3":        false,
+		"192.168.0.1":     false,
+		"169.254.169.254": false,
+		"::1":             false,
+		"fe80::1":         false,
+		"0.0.0.0":         false,
+		"100.64.0.1":      false,
+		"100.127.255.254": false,
+		"100.128.0.1":     true,
+		"198.18.0.1":      false,
+		"198.19.255.254":  false,
+		"198.20.0.1":      true,
+	} {
+		if public_address(net.ParseIP(address)) != public {
+			t.Errorf("%s public is %v", address, !public)
+		}
+	}
+
+	for url, status := range map[string]int{
+		"https://hooks.example.com/ocd": 200,
+		"http://hooks.example.com/ocd":  400,
+		"https://127.0.0.1/ocd":         400,
+		"https://[fd00::1]:8443/ocd":    400,
+	} {
+		mo := MockDataOperator{}
+
+		if res, _ := createWebhook(context.Background(), Request{}, &mo, &APISession{}, createWebhookParams{Url: url, Events: []string{ev_all}}); res.StatusCode != status {
+			t.Errorf("%s gave %d %s", url, res.StatusCode, res.Body)
+		}
+	}
+
+	// the real dispatcher won't connect to the local receiver
+	tr := testReceiver{t: t, secret: "sssh", statuses: []int{200}}
+	srv := httptest.NewServer(&tr)
+	defer srv.Close()
+
+	wh := Create_WebhookDispatcher()
+	wh.backoff = time.Millisecond
+
+	if dd := wh.Deliver(context.Background(), WebhookData{URL: srv.URL, Secret: tr.secret}, CounterEvent{Event: ev_reset}); !strings.Contains(dd.Error, "not public") || len(tr.events) != 0 {
+		t.Errorf("Delivery was %+v", dd)
+	}
+}
@@ -0,0 +1,458 @@
+package ocdctl
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"myhello/client"
+)
+
+func (cli *CLI) run(ctx context.Context, opts options, words []string) error {
+	if len(words) == 0 {
+		return usage_error("no command")
+	}
+
+	switch words[0] {
+	case "login":
+		return cli.login(ctx, opts, words[1:])
+	case "logout":
+		return cli.logout()
+	case "group":
+		return cli.group(ctx, opts, words[1:])
+	case "counter":
+		return cli.counter(ctx, opts, words[1:])
+	}
+
+	return usage_error("unknown command %s", words[0])
+}
+
+// the arguments after the subcommand, which have to number n
+func args_of(words []string, n int, names string) ([]string, error) {
+	if len(words)-1 != n {
+		return nil, usage_error("%s takes %s", words[0], names)
+	}
+	return words[1:], nil
+}
+
+func (cli *CLI) login(ctx context.Context, opts options, words []string) error {
+	if len(words) != 1 {
+		return usage_error("login takes EMAIL")
+	}
+
+	cfg, err := cli.load_config()
+
+	if err != nil {
+		return err
+	}
+
+	cfg.URL = first(opts.url, cfg.URL)
+
+	if cfg.URL == "" {
+		return usage_error("no API URL, give -url or set OCD_URL")
+	}
+
+	password, err := cli.password()
+
+	if err != nil {
+		return err
+	}
+
+	c := client.New(cfg.URL, client.WithHTTPClient(cli.http_client()))
+
+	if err := c.Login(ctx, words[0], password); err != nil {
+		return err
+	}
+
+	cfg.Email, cfg.Token = words[0], c.Token()
+
+	if err := cli.save_config(cfg); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(cli.Stdout, "logged in as", cfg.Email)
+
+	return nil
+}
+
+// OCD_PASSWORD, or the first line of stdin
+func (cli *CLI) password() (string, error) {
+	if pw := cli.Getenv("OCD_PASSWORD"); pw != "" {
+		return pw, nil
+	}
+
+	if cli.Stdin == nil {
+		return "", errors.New("no password, set OCD_PASSWORD")
+	}
+
+	line, err := bufio.NewReader(cli.Stdin).ReadString('\n')
+
+	if line = strings.TrimRight(line, "\r\n"); line == "" {
+		return "", fmt.Errorf("no password on stdin: %w", err)
+	}
+
+	return line, nil
+}
+
+// the URL is kept, so that the next login doesn't need it
+func (cli *CLI) logout() error {
+	cfg, err := cli.load_config()
+
+	if err != nil {
+		return err
+	}
+
+	cfg.Email, cfg.Token = "", ""
+
+	return cli.save_config(cfg)
+}
+
+func (cli *CLI) group(ctx context.Context, opts options, words []string) error {
+	if len(words) == 0 {
+		return usage_error("group takes ls, get or create")
+	}
+
+	c, err := cli.client(opts)
+
+	if err != nil {
+		return err
+	}
+
+	switch words[0] {
+	case "ls":
+		if _, err := args_of(words, 0, "nothing"); err != nil {
+			return err
+		}
+
+		groups, err := list_groups(ctx, c)
+
+		if err != nil {
+			return err
+		}
+
+		return cli.print(opts, groups, groupHeader, group_rows(groups))
+	case "get":
+		args, err := args_of(words, 1, "GROUP")
+
+		if err != nil {
+			return err
+		}
+
+		gd, err := resolve_group(ctx, c, args[0])
+
+		if err != nil {
+			return err
+		}
+
+		return cli.print(opts, gd, groupHeader, group_rows([]*client.Group{gd}))
+	case "create":
+		args, err := args_of(words, 1, "NAME")
+
+		if err != nil {
+			return err
+		}
+
+		id, err := c.CreateGroup(ctx, args[0])
+
+		if err != nil {
+			return err
+		}
+
+		return cli.print(opts, map[string]string{"id": id}, nil, [][]string{{id}})
+	}
+
+	return usage_error("unknown group command %s", words[0])
+}
+
+func (cli *CLI) counter(ctx context.Context, opts options, words []string) error {
+	if len(words) == 0 {
+		return usage_error("counter takes ls, get, create, inc, dec, reset, step, rm or watch")
+	}
+
+	if opts.group == "" {
+		return usage_error("counter commands need a group, give -g or set OCD_GROUP")
+	}
+
+	c, err := cli.client(opts)
+
+	if err != nil {
+		return err
+	}
+
+	gd, err := resolve_group(ctx, c, opts.group)
+
+	if err != nil {
+		return err
+	}
+
+	group := gd.Id
+
+	if words[0] == "ls" {
+		if _, err := args_of(words, 0, "nothing"); err != nil {
+			return err
+		}
+
+		counters, err := list_counters(ctx, c, group)
+
+		if err != nil {
+			return err
+		}
+
+		return cli.print(opts, counters, counterHeader, counter_rows(counters))
+	}
+
+	if words[0] == "create" {
+		args, err := args_of(words, 1, "NAME")
+
+		if err != nil {
+			return err
+		}
+
+		id, err := c.CreateCounter(ctx, group, args[0], client.CounterOptions{Shards: opts.shards})
+
+		if err != nil {
+			return err
+		}
+
+		return cli.print(opts, map[string]string{"id": id}, nil, [][]string{{id}})
+	}
+
+	if words[0] == "step" {
+		args, err := args_of(words, 2, "NAME and N")
+
+		if err != nil {
+			return err
+		}
+
+		step, err := strconv.Atoi(args[1])
+
+		if err != nil {
+			return usage_error("step %s isn't a number", args[1])
+		}
+
+		cd, err := resolve_counter(ctx, c, group, args[0])
+
+		if err != nil {
+			return err
+		}
+
+		return c.SetStep(ctx, group, cd.Id, step)
+	}
+
+	args, err := args_of(words, 1, "NAME")
+
+	if err != nil {
+		return err
+	}
+
+	cd, err := resolve_counter(ctx, c, group, args[0])
+
+	if err != nil {
+		return err
+	}
+
+	switch words[0] {
+	case "get":
+		return cli.print(opts, cd, counterHeader, counter_rows([]*client.Counter{cd}))
+	case "inc":
+		return c.Increment(ctx, group, cd.Id)
+	case "dec":
+		return c.Decrement(ctx, group, cd.Id)
+	case "reset":
+		return c.Reset(ctx, group, cd.Id)
+	case "rm":
+		return c.DeleteCounter(ctx, group, cd.Id)
+	case "watch":
+		return cli.watch(ctx, opts, c, cd)
+	}
+
+	return usage_error("unknown counter command %s", words[0])
+}
+
+// polls the counter, printing it each time it changes, until ctx is done or
+// -n values have been printed.  A derived counter's value changes without
+// its version moving on, so the values are compared as well.
+func (cli *CLI) watch(ctx context.Context, opts options, c *client.Client, cd *client.Counter) error {
+	enc := json.NewEncoder(cli.Stdout)
+	printed := 0
+	var last *client.Counter
+
+	for {
+		if last == nil || watch_changed(last, cd) {
+			last = cd
+			printed++
+
+			if opts.json {
+				enc.Encode(cd)
+			} else {
+				fmt.Fprintf(cli.Stdout, "%s  %s  %d\n", time.Now().Format(time.TimeOnly), cd.Name, cd.Value)
+			}
+
+			if opts.count > 0 && printed >= opts.count {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(opts.interval):
+		}
+
+		next, err := c.GetCounter(ctx, cd.Group, cd.Id)
+
+		if ctx.Err() != nil {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		cd = next
+	}
+}
+
+func watch_changed(before *client.Counter, after *client.Counter) bool {
+	if after.Version != before.Version || after.Value != before.Value || after.DerivedError != before.DerivedError {
+		return true
+	}
+
+	if before.Derived == nil || after.Derived == nil {
+		return before.Derived != after.Derived
+	}
+
+	return *after.Derived != *before.Derived
+}
+
+func list_groups(ctx context.Context, c *client.Client) ([]*client.Group, error) {
+	ids, err := c.ListGroups(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]*client.Group, 0, len(ids))
+
+	for _, id := range ids {
+		gd, err := c.GetGroup(ctx, id)
+
+		if err != nil {
+			return nil, err
+		}
+
+		groups = append(groups, gd)
+	}
+
+	return groups, nil
+}
+
+func list_counters(ctx context.Context, c *client.Client, group string) ([]*client.Counter, error) {
+	ids, err := c.ListCounters(ctx, group)
+
+	if err != nil {
+		return nil, err
+	}
+
+	counters := make([]*client.Counter, 0, len(ids))
+
+	for _, id := range ids {
+		cd, err := c.GetCounter(ctx, group, id)
+
+		if err != nil {
+			return nil, err
+		}
+
+		counters = append(counters, cd)
+	}
+
+	return counters, nil
+}
+
+// the group with this ID or name.  Names don't have to be unique, so one
+// which is used more than once has to be given by ID.
+func resolve_group(ctx context.Context, c *client.Client, name string) (*client.Group, error) {
+	groups, err := list_groups(ctx, c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var found []*client.Group
+
+	for _, gd := range groups {
+		if gd.Id == name {
+			return gd, nil
+		}
+
+		if gd.Name == name {
+			found = append(found, gd)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no group %s", name)
+	case 1:
+		return found[0], nil
+	}
+
+	return nil, fmt.Errorf("%d groups are called %s, give its ID", len(found), name)
+}
+
+func resolve_counter(ctx context.Context, c *client.Client, group string, name string) (*client.Counter, error) {
+	counters, err := list_counters(ctx, c, group)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var found []*client.Counter
+
+	for _, cd := range counters {
+		if cd.Id == name {
+			return cd, nil
+		}
+
+		if cd.Name == name {
+			found = append(found, cd)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no counter %s", name)
+	case 1:
+		return found[0], nil
+	}
+
+	return nil, fmt.Errorf("%d counters are called %s, give its ID", len(found), name)
+}
+
+var (
+	groupHeader   = []string{"NAME", "COUNTERS", "ID"}
+	counterHeader = []string{"NAME", "VALUE", "STEP", "ID"}
+)
+
+func group_rows(groups []*client.Group) [][]string {
+	var rows [][]string
+
+	for _, gd := range groups {
+		rows = append(rows, []string{gd.Name, strconv.Itoa(len(gd.Counters)), gd.Id})
+	}
+
+	return rows
+}
+
+func counter_rows(counters []*client.Counter) [][]string {
+	var rows [][]string
+
+	for _, cd := range counters {
+		rows = append(rows, []string{cd.Name, strconv.Itoa(cd.Value), strconv.Itoa(cd.Step), cd.Id})
+	}
+
+	return rows
+}
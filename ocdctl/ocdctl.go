@@ -0,0 +1,273 @@
+// Package ocdctl is the command-line client for the counter API.  The
+// command itself is in cmd/ocdctl, this has everything else so that it can
+// be run against a local server in tests.
+package ocdctl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"myhello/client"
+)
+
+const usage = `usage: ocdctl [flags] command [args]
+
+commands:
+  login EMAIL               log in, with the password from OCD_PASSWORD or stdin
+  logout                    forget the cached token
+  group ls                  the groups you are in
+  group get GROUP
+  group create NAME
+  counter ls                the counters in the group
+  counter get NAME
+  counter create NAME       -shards N spreads a busy counter over N items
+  counter inc NAME
+  counter dec NAME
+  counter reset NAME
+  counter step NAME N       sets how much inc and dec change the counter by
+  counter rm NAME
+  counter watch NAME        prints the counter whenever it changes
+
+Groups and counters can be given by name or ID.
+
+flags:
+`
+
+// the token and server a login is cached with
+type config struct {
+	URL   string `json:"url"`
+	Email string `json:"email,omitempty"`
+	Token string `json:"token,omitempty"`
+}
+
+// CLI is one run of ocdctl.  Its fields are what it would otherwise take
+// from the process.
+type CLI struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// OCD_URL, OCD_TOKEN, OCD_GROUP, OCD_PASSWORD and OCD_CONFIG
+	Getenv func(string) string
+
+	// http.DefaultClient if nil
+	HTTP *http.Client
+}
+
+// the flags any command can have, wherever they are on the command line
+type options struct {
+	url      string
+	group    string
+	json     bool
+	shards   int
+	interval time.Duration
+	count    int
+}
+
+// errors which are the command line's fault, shown with the usage
+var errUsage = errors.New("usage")
+
+func usage_error(format string, args ...any) error {
+	return fmt.Errorf("%w: "+format, append([]any{errUsage}, args...)...)
+}
+
+// Run runs the command in args, which don't include the program name, and
+// gives back the exit status.
+func (cli *CLI) Run(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("ocdctl", flag.ContinueOnError)
+	fs.SetOutput(cli.Stderr)
+
+	var opts options
+
+	fs.StringVar(&opts.url, "url", cli.Getenv("OCD_URL"), "where the API is")
+	fs.StringVar(&opts.group, "g", cli.Getenv("OCD_GROUP"), "the group, by name or ID")
+	fs.BoolVar(&opts.json, "json", false, "print JSON instead of a table")
+	fs.IntVar(&opts.shards, "shards", 0, "shards for counter create")
+	fs.DurationVar(&opts.interval, "interval", 2*time.Second, "how often counter watch looks")
+	fs.IntVar(&opts.count, "n", 0, "counter watch stops after printing this many values, 0 for never")
+
+	fs.Usage = func() {
+		fmt.Fprint(cli.Stderr, usage)
+		fs.PrintDefaults()
+	}
+
+	words, perr := parse_interleaved(fs, args)
+
+	if errors.Is(perr, flag.ErrHelp) {
+		return 0
+	} else if perr != nil {
+		return 2
+	}
+
+	err := cli.run(ctx, opts, words)
+
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errUsage):
+		fmt.Fprintln(cli.Stderr, "ocdctl:", strings.TrimPrefix(err.Error(), errUsage.Error()+": "))
+		fs.Usage()
+		return 2
+	default:
+		fmt.Fprintln(cli.Stderr, "ocdctl:", err)
+		return 1
+	}
+}
+
+// flag stops at the first word which isn't one, so e.g. "counter inc coffee
+// -g team" is parsed a piece at a time.  Everything after -- is a word, e.g.
+// a negative step.
+func parse_interleaved(fs *flag.FlagSet, args []string) ([]string, error) {
+	var words []string
+
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+
+		rest := fs.Args()
+
+		if used := len(args) - len(rest); used > 0 && args[used-1] == "--" {
+			return append(words, rest...), nil
+		}
+
+		args = rest
+
+		if len(args) == 0 {
+			return words, nil
+		}
+
+		words = append(words, args[0])
+		args = args[1:]
+	}
+}
+
+func (cli *CLI) config_path() (string, error) {
+	if path := cli.Getenv("OCD_CONFIG"); path != "" {
+		return path, nil
+	}
+
+	dir, err := os.UserConfigDir()
+
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "ocdctl", "config.json"), nil
+}
+
+// an empty config if there isn't one yet
+func (cli *CLI) load_config() (config, error) {
+	var cfg config
+
+	path, err := cli.config_path()
+
+	if err != nil {
+		return cfg, err
+	}
+
+	data, err := os.ReadFile(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	} else if err != nil {
+		return cfg, err
+	}
+
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// only readable by the user, as it has a token in it
+func (cli *CLI) save_config(cfg config) error {
+	path, err := cli.config_path()
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(cfg, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(data, '\n'), 0600)
+}
+
+func (cli *CLI) http_client() *http.Client {
+	if cli.HTTP != nil {
+		return cli.HTTP
+	}
+	return http.DefaultClient
+}
+
+// a client for the API with the cached token, or the one in OCD_TOKEN
+func (cli *CLI) client(opts options) (*client.Client, error) {
+	cfg, err := cli.load_config()
+
+	if err != nil {
+		return nil, err
+	}
+
+	url := first(opts.url, cfg.URL)
+
+	if url == "" {
+		return nil, usage_error("no API URL, give -url or set OCD_URL")
+	}
+
+	token := first(cli.Getenv("OCD_TOKEN"), cfg.Token)
+
+	if token == "" {
+		return nil, errors.New("not logged in, run ocdctl login")
+	}
+
+	return client.New(url, client.WithHTTPClient(cli.http_client()), client.WithToken(token)), nil
+}
+
+func first(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// rows as a table with the header, or v as JSON with -json
+func (cli *CLI) print(opts options, v any, header []string, rows [][]string) error {
+	if opts.json {
+		enc := json.NewEncoder(cli.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	tw := tabwriter.NewWriter(cli.Stdout, 0, 4, 2, ' ', 0)
+
+	if header != nil {
+		fmt.Fprintln(tw, strings.Join(header, "\t"))
+	}
+
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+
+	return tw.Flush()
+}